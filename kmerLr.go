@@ -22,6 +22,7 @@ import   "fmt"
 import   "io"
 import   "log"
 import   "os"
+import   "time"
 
 import   "github.com/pbenner/threadpool"
 
@@ -31,8 +32,19 @@ import   "github.com/pborman/getopt"
 
 type Config struct {
   Balance         bool
+  ClassWeights  []float64
+  WeightsFile     string
+  WeightsField    int
+  WeightsColumn   string
+  Regression      string
+  ResponseField   int
+  ResponseFile    string
+  ResponseColumn  string
   Copreselection  int
   Lambda          float64
+  Alpha           float64
+  GroupLasso      string
+  GroupFile       string
   LambdaAuto    []int
   MaxFeatures     int
   EnsembleSize    int
@@ -42,6 +54,13 @@ type Config struct {
   EpsilonLoss     float64
   Header          bool
   KFoldCV         int
+  CVScheme        string
+  CVGroupField    int
+  CVGroupsFile    string
+  CVBedFile       string
+  CVChromosomes [][]string
+  NestedCV        int
+  NestedCVMetric  string
   ValidationSize  float64
   AdaptStepSize   bool
   StepSizeFactor  float64
@@ -52,12 +71,23 @@ type Config struct {
   MaxEpochs       int
   MaxIterations   int
   MaxSamples      int
+  MinQuality      int
+  AggregateReads  bool
+  Optimizer       string
   PenaltyFree     bool
+  Checkpoint      time.Duration
+  Resume          bool
+  MaxTime         time.Duration
   DataTransform   string
+  Compress        bool
+  OutputScale     string
+  OutputFormat    string
+  Threshold       float64
   Pool            threadpool.ThreadPool
   PoolCV          threadpool.ThreadPool
   PoolSaga        threadpool.ThreadPool
   PoolLR          threadpool.ThreadPool
+  PoolPath        threadpool.ThreadPool
   Verbose         int
 }
 
@@ -82,20 +112,31 @@ func main() {
   config  := Config{}
   options := getopt.New()
 
-  optType    := options. StringLong("type",     0 ,  "kmerLr", "classifier type [kmerLr, scoresLr]")
-  optThreads := options.    IntLong("threads",  0 ,         1, "number of threads")
-  optSeed    := options.    IntLong("seed",     0 ,         1, "seed for the random number generator")
-  optHelp    := options.   BoolLong("help",    'h',            "print help")
-  optVerbose := options.CounterLong("verbose", 'v',            "verbose level [-v or -vv]")
-  optVersion := options.   BoolLong("version",  0 ,            "print version")
+  optType     := options. StringLong("type",     0 ,  "kmerLr", "classifier type [kmerLr, scoresLr]")
+  optThreads  := options.    IntLong("threads",  0 ,         1, "number of threads")
+  optCompress := options.   BoolLong("compress", 0 ,            "gzip compress output files (predictions, exports, traces, ...) and append suffix `.gz'; outputs are also compressed if the filename has suffix `.gz'")
+  optSeed     := options.    IntLong("seed",     0 ,         1, "seed for the random number generator")
+  optHelp     := options.   BoolLong("help",    'h',            "print help")
+  optVerbose  := options.CounterLong("verbose", 'v',            "verbose level [-v or -vv]")
+  optVersion  := options.   BoolLong("version",  0 ,            "print version")
 
   options.SetParameters("<COMMAND>\n\n" +
     " Commands:\n" +
     "     learn          - estimate logistic regression parameters\n" +
+    "     learn-multinomial - estimate multinomial logistic regression parameters for more than two classes\n" +
     "     loss           - compute logistic loss\n" +
+    "     evaluate       - compute classification metrics (ROC-AUC, PR-AUC, ...)\n" +
     "     predict        - use an estimated model to predict labels\n" +
+    "     attribute      - per-base contributions of k-mers to predictions\n" +
+    "     mutagenesis    - effect of all single-base substitutions on predictions\n" +
+    "     predict-variants - score reference and alternative alleles of variants (VCF)\n" +
     "     combine        - combine estimated models\n" +
-    "     coefficients   - pretty-print coefficients\n")
+    "     coefficients   - pretty-print coefficients\n" +
+    "     motifs         - cluster k-mers into motifs (MEME, JASPAR, TRANSFAC)\n" +
+    "     match-motifs   - compare k-mers or motifs with a motif database (MEME, JASPAR)\n" +
+    "     count          - count k-mers and save counts to a cache\n" +
+    "     merge-counts   - merge k-mer count caches\n" +
+    "     export         - export data matrix (table, mtx, libsvm, npz)\n")
   options.Parse(os.Args)
 
   // command options
@@ -116,7 +157,8 @@ func main() {
   if *optThreads > 1 {
     config.Pool = threadpool.New(*optThreads, 100)
   }
-  config.Seed = int64(*optSeed)
+  config.Seed     = int64(*optSeed)
+  config.Compress = *optCompress
   // command arguments
   if len(options.Args()) == 0 {
     options.PrintUsage(os.Stderr)
@@ -129,18 +171,36 @@ func main() {
     switch command {
     case "learn":
       main_learn(config, options.Args())
+    case "learn-multinomial":
+      main_learn_multinomial(config, options.Args())
     case "loss":
       main_loss(config, options.Args())
+    case "evaluate":
+      main_evaluate(config, options.Args())
     case "predict":
       main_predict(config, options.Args())
+    case "attribute":
+      main_attribute(config, options.Args())
+    case "mutagenesis":
+      main_mutagenesis(config, options.Args())
+    case "predict-variants":
+      main_predict_variants(config, options.Args())
     case "predict-genomic":
       main_predict_genomic(config, options.Args())
     case "combine":
       main_combine(config, options.Args())
     case "coefficients":
       main_coefficients(config, options.Args())
+    case "motifs":
+      main_motifs(config, options.Args())
+    case "match-motifs":
+      main_match_motifs(config, options.Args())
+    case "count":
+      main_count(config, options.Args())
     case "count-features":
       main_count_features(config, options.Args())
+    case "merge-counts":
+      main_merge_counts(config, options.Args())
     case "export":
       main_export(config, options.Args())
     case "similarity":
@@ -153,10 +213,14 @@ func main() {
     switch command {
     case "expand":
       main_expand_scores(config, options.Args())
+    case "export":
+      main_export_scores(config, options.Args())
     case "learn":
       main_learn_scores(config, options.Args())
     case "loss":
       main_loss_scores(config, options.Args())
+    case "evaluate":
+      main_evaluate_scores(config, options.Args())
     case "predict":
       main_predict_scores(config, options.Args())
     case "combine":
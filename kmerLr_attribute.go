@@ -0,0 +1,301 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "io"
+import   "log"
+import   "os"
+
+import . "github.com/pbenner/autodiff"
+import . "github.com/pbenner/gonetics"
+import   "github.com/pbenner/threadpool"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+// Per-base contributions to the linear predictor of a sequence. The sum of
+// all scores plus the bias (intercept and contributions of absent k-mers due
+// to offsets of the transform) equals the linear predictor.
+type sequenceAttribution struct {
+  Name     string
+  Index    int
+  // genomic coordinates of the first base
+  Chrom    string
+  Start    int
+  Sequence string
+  Bias     float64
+  Scores []float64
+}
+
+func (obj sequenceAttribution) LinearPredictor() float64 {
+  r := obj.Bias
+  for _, s := range obj.Scores {
+    r += s
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Coefficients of the linear predictor averaged over ensemble members
+func attribution_coefficients(classifier *KmerLrEnsemble) []float64 {
+  if len(classifier.Theta) == 0 {
+    log.Fatal("classifier has no coefficients")
+  }
+  r := make([]float64, len(classifier.Theta[0]))
+  for _, theta := range classifier.Theta {
+    for j := 0; j < len(theta); j++ {
+      r[j] += theta[j]/float64(len(classifier.Theta))
+    }
+  }
+  return r
+}
+
+// Distribute the contribution of each feature (coefficient times transformed
+// k-mer count) evenly over all occurrences of its k-mer and each occurrence
+// evenly over its bases. Co-occurrence features are split in equal parts
+// between both k-mers.
+func attribute_sequence(config Config, classifier *KmerLrEnsemble, theta []float64, counter *KmerCounter, scanner *kmerScanner, sequence []byte) ([]float64, float64) {
+  // do not report normalization of each sequence
+  config.Verbose = 0
+  counts := scan_sequence(config, counter, classifier.Binarize, sequence, nil)
+  counts.SetKmers(classifier.Kmers)
+  data   := []ConstVector{convert_counts(config, counts, classifier.Features, false)}
+  classifier.Transform.Apply(config, data)
+
+  occurrences := scanner.Scan(sequence)
+  n           := scanner.Counts(occurrences)
+  // contribution of a single occurrence of each k-mer
+  share  := make([]float64, len(classifier.Kmers))
+  eta    := 0.0
+  total  := 0.0
+  x      := data[0].(SparseConstFloat64Vector)
+  for k, i := range x.GetSparseIndices() {
+    c := theta[i]*x.GetSparseValues()[k]
+    eta += c
+    if i == 0 {
+      continue
+    }
+    i1, i2 := classifier.Features[i-1][0], classifier.Features[i-1][1]
+    if n[i1] == 0 || n[i2] == 0 {
+      continue
+    }
+    if i1 == i2 {
+      share[i1] += c/float64(n[i1])
+    } else {
+      share[i1] += 0.5*c/float64(n[i1])
+      share[i2] += 0.5*c/float64(n[i2])
+    }
+    total += c
+  }
+  scores := make([]float64, len(sequence))
+  for p, list := range occurrences {
+    for _, o := range list {
+      v := share[o.Index]*float64(o.Count)/float64(o.K)
+      for q := p; q < p+o.K; q++ {
+        scores[q] += v
+      }
+    }
+  }
+  return scores, eta - total
+}
+
+func attribute_sequences(config Config, classifier *KmerLrEnsemble, sequences []string) ([][]float64, []float64) {
+  scores   := make([][]float64, len(sequences))
+  biases   := make(  []float64, len(sequences))
+  theta    := attribution_coefficients(classifier)
+  // create one counter and scanner for each thread
+  counters := make([]*KmerCounter, config.Pool.NumberOfThreads())
+  scanners := make([]*kmerScanner, config.Pool.NumberOfThreads())
+  for i := 0; i < len(counters); i++ {
+    counters[i] = classifier.GetKmerCounter()
+    scanners[i] = newKmerScanner(classifier.KmerLrFeatures)
+  }
+  PrintStderr(config, 1, "Computing attributions... ")
+  if err := config.Pool.RangeJob(0, len(sequences), func(i int, pool threadpool.ThreadPool, erf func() error) error {
+    config := config; config.Pool = pool
+    scores[i], biases[i] = attribute_sequence(config, classifier, theta, counters[pool.GetThreadId()], scanners[pool.GetThreadId()], []byte(sequences[i]))
+    return nil
+  }); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+  return scores, biases
+}
+
+/* -------------------------------------------------------------------------- */
+
+type attributionRecord struct {
+  Name             string       `json:"name,omitempty"`
+  Index            int          `json:"index"`
+  Chrom            string       `json:"chrom,omitempty"`
+  Start            int          `json:"start"`
+  LinearPredictor  jsonFloat64  `json:"linear_predictor"`
+  Bias             jsonFloat64  `json:"bias"`
+  Scores         []jsonFloat64  `json:"scores"`
+}
+
+func saveAttributions(config Config, filename, format, track_name string, attributions []sequenceAttribution) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  switch format {
+  case "", "table":
+    fmt.Fprintf(writer, "%8s\t%s\t%8s\t%4s\t%15s\n", "index", "name", "position", "base", "score")
+    for _, a := range attributions {
+      for j, s := range a.Scores {
+        fmt.Fprintf(writer, "%8d\t%s\t%8d\t%4c\t%15e\n", a.Index, a.Name, j, a.Sequence[j], s)
+      }
+    }
+  case "json":
+    records := make([]attributionRecord, len(attributions))
+    for i, a := range attributions {
+      records[i].Name            = a.Name
+      records[i].Index           = a.Index
+      records[i].Chrom           = a.Chrom
+      records[i].Start           = a.Start
+      records[i].LinearPredictor = jsonFloat64(a.LinearPredictor())
+      records[i].Bias            = jsonFloat64(a.Bias)
+      records[i].Scores          = make([]jsonFloat64, len(a.Scores))
+      for j, s := range a.Scores {
+        records[i].Scores[j] = jsonFloat64(s)
+      }
+    }
+    writePredictionsJson(writer, records)
+  case "bedgraph":
+    fmt.Fprintf(writer, "track type=bedGraph name=%s\n", track_name)
+    for _, a := range attributions {
+      // join consecutive bases with identical scores
+      for j, k := 0, 0; j < len(a.Scores); j = k {
+        for k = j+1; k < len(a.Scores) && a.Scores[k] == a.Scores[j]; k++ {}
+        fmt.Fprintf(writer, "%s\t%d\t%d\t%e\n", a.Chrom, a.Start+j, a.Start+k, a.Scores[j])
+      }
+    }
+  case "wiggle":
+    fmt.Fprintf(writer, "track type=wiggle_0 name=%s\n", track_name)
+    for _, a := range attributions {
+      // wiggle coordinates are one-based
+      fmt.Fprintf(writer, "fixedStep chrom=%s start=%d step=1 span=1\n", a.Chrom, a.Start+1)
+      for _, s := range a.Scores {
+        fmt.Fprintf(writer, "%e\n", s)
+      }
+    }
+  default:
+    panic("internal error")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func attribute(config Config, filename_json, filename_in, filename_bed, filename_out, format, track_name string) {
+  if is_multinomial_model(filename_json) {
+    log.Fatal("attributions are not supported for multinomial models")
+  }
+  classifier := ImportKmerLrEnsemble(config, filename_json)
+
+  attributions := []sequenceAttribution{}
+  sequences    := []string{}
+  if filename_bed != "" {
+    regions  := importBed3  (config, filename_bed)
+    sequences = extractFasta(config, filename_in, regions)
+    for i := 0; i < regions.Length(); i++ {
+      a := sequenceAttribution{}
+      a.Name  = fmt.Sprintf("%s:%d-%d", regions.Seqnames[i], regions.Ranges[i].From, regions.Ranges[i].To)
+      a.Index = i
+      a.Chrom = regions.Seqnames[i]
+      a.Start = regions.Ranges[i].From
+      attributions = append(attributions, a)
+    }
+  } else {
+    var headers []string
+    headers, sequences = import_fasta(config, filename_in)
+    for i, header := range headers {
+      a := sequenceAttribution{}
+      a.Name  = fasta_header_name(header)
+      a.Index = i
+      a.Chrom = a.Name
+      attributions = append(attributions, a)
+    }
+  }
+  scores, biases := attribute_sequences(config, classifier, sequences)
+  for i := 0; i < len(attributions); i++ {
+    attributions[i].Sequence = sequences[i]
+    attributions[i].Scores   = scores[i]
+    attributions[i].Bias     = biases[i]
+  }
+  saveAttributions(config, compress_filename(config, filename_out), format, track_name, attributions)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_attribute(config Config, args []string) {
+  options := getopt.New()
+
+  optOutputFormat := options.StringLong("output-format",  0 ,  "table", "output format [table (default), json, bedgraph, wiggle]")
+  optRegions      := options.StringLong("regions",        0 ,       "", "bed file with regions of the genomic sequences in SEQUENCES.fa")
+  optTrackName    := options.StringLong("track-name",     0 , "kmerLr", "name of bedgraph and wiggle tracks")
+  optHelp         := options.  BoolLong("help",          'h',           "print help")
+
+  options.SetParameters("<MODEL.json> <SEQUENCES.fa> [RESULT]")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  switch *optOutputFormat {
+  case "table":
+  case "json":
+  case "bedgraph":
+  case "wiggle":
+  default:
+    log.Fatalf("invalid output format `%s'", *optOutputFormat)
+  }
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) != 2 && len(options.Args()) != 3 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  filename_json := options.Args()[0]
+  filename_in   := options.Args()[1]
+  filename_out  := ""
+  if len(options.Args()) == 3 {
+    filename_out = options.Args()[2]
+  }
+  attribute(config, filename_json, filename_in, *optRegions, filename_out, *optOutputFormat, *optTrackName)
+}
@@ -0,0 +1,389 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "bufio"
+import   "compress/gzip"
+import   "encoding/binary"
+import   "encoding/json"
+import   "fmt"
+import   "io"
+import   "log"
+import   "os"
+import   "sort"
+import   "strings"
+
+import . "github.com/pbenner/gonetics"
+
+/* -------------------------------------------------------------------------- */
+
+const kmerCacheMagic = "kmerLr-counts 1\n"
+
+// Sparse k-mer counts of a set of sequences. A cache created from foreground
+// and background sequences is labelled, i.e. each sample has a label and its
+// index within the foreground or background file.
+type KmerCache struct {
+  KmerEquivalence
+  Binarize   bool
+  Labelled   bool
+  Kmers      KmerClassList
+  Headers  []string
+  Labels   []bool
+  Seqindex []int
+  Counts   []KmerCounts
+}
+
+type kmerCacheHeader struct {
+  N, M           int
+  Complement     bool
+  Reverse        bool
+  Revcomp        bool
+  MaxAmbiguous []int
+  Alphabet       string
+  Binarize       bool
+  Labelled       bool
+}
+
+/* -------------------------------------------------------------------------- */
+
+func NewKmerCache(kmersCounter *KmerCounter, binarize, labelled bool) KmerCache {
+  r := KmerCache{}
+  r.KmerEquivalence = kmersCounter.KmerEquivalence
+  r.Binarize        = binarize
+  r.Labelled        = labelled
+  r.Headers         = []string{}
+  r.Labels          = []bool{}
+  r.Seqindex        = []int{}
+  r.Counts          = []KmerCounts{}
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj KmerCache) Len() int {
+  return len(obj.Counts)
+}
+
+// Check that counts in the cache were obtained with the given k-mer
+// equivalence relation and binarization
+func (obj KmerCache) Matches(kmersCounter *KmerCounter, binarize bool) error {
+  if !obj.KmerEquivalence.Equals(kmersCounter.KmerEquivalence) {
+    return fmt.Errorf("k-mer equivalence relation of cache does not match model")
+  }
+  if  obj.Binarize != binarize {
+    return fmt.Errorf("data binarization of cache does not match model")
+  }
+  return nil
+}
+
+// Append samples of another cache. Sequence indices of b are shifted by the
+// number of samples with the same label, i.e. caches are expected to be given
+// in the order of the chunks of the original fasta files.
+func (obj *KmerCache) Append(b KmerCache) error {
+  if !obj.KmerEquivalence.Equals(b.KmerEquivalence) {
+    return fmt.Errorf("k-mer equivalence relation is not consistent across caches")
+  }
+  if  obj.Binarize != b.Binarize {
+    return fmt.Errorf("data binarization is not consistent across caches")
+  }
+  if  obj.Labelled != b.Labelled {
+    return fmt.Errorf("caches must either all be labelled or unlabelled")
+  }
+  n := [2]int{}
+  for i := 0; i < obj.Len(); i++ {
+    if obj.Labels[i] {
+      n[1]++
+    } else {
+      n[0]++
+    }
+  }
+  for i := 0; i < b.Len(); i++ {
+    j := 0
+    if b.Labels[i] {
+      j = 1
+    }
+    obj.Headers  = append(obj.Headers , b.Headers[i])
+    obj.Labels   = append(obj.Labels  , b.Labels [i])
+    obj.Seqindex = append(obj.Seqindex, b.Seqindex[i]+n[j])
+    obj.Counts   = append(obj.Counts  , b.Counts [i])
+  }
+  obj.Kmers = obj.Kmers.Union(b.Kmers)
+  return nil
+}
+
+// Returns all samples with the given label (1: foreground, 0: background)
+// or all samples if the label is negative
+func (obj KmerCache) Select(label int) KmerCache {
+  if label < 0 || !obj.Labelled {
+    return obj
+  }
+  r := obj
+  r.Headers  = []string{}
+  r.Labels   = []bool{}
+  r.Seqindex = []int{}
+  r.Counts   = []KmerCounts{}
+  for i := 0; i < obj.Len(); i++ {
+    if obj.Labels[i] == (label == 1) {
+      r.Headers  = append(r.Headers , obj.Headers [i])
+      r.Labels   = append(r.Labels  , obj.Labels  [i])
+      r.Seqindex = append(r.Seqindex, obj.Seqindex[i])
+      r.Counts   = append(r.Counts  , obj.Counts  [i])
+    }
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj KmerCache) Write(writer io.Writer) error {
+  w := bufio.NewWriter(writer)
+  b := make([]byte, binary.MaxVarintLen64)
+  writeUint := func(x uint64) {
+    w.Write(b[0:binary.PutUvarint(b, x)])
+  }
+  writeString := func(s string) {
+    writeUint(uint64(len(s)))
+    w.WriteString(s)
+  }
+  header := kmerCacheHeader{}
+  header.N            = obj.N
+  header.M            = obj.M
+  header.Complement   = obj.Complement
+  header.Reverse      = obj.Reverse
+  header.Revcomp      = obj.Revcomp
+  header.MaxAmbiguous = obj.MaxAmbiguous
+  header.Alphabet     = obj.Alphabet.String()
+  header.Binarize     = obj.Binarize
+  header.Labelled     = obj.Labelled
+  if h, err := json.Marshal(header); err != nil {
+    return err
+  } else {
+    w.WriteString(kmerCacheMagic)
+    writeString(string(h))
+  }
+  // global list of k-mers
+  kmers := obj.Kmers.Clone()
+  kmers.Sort()
+  index := make(map[KmerClassId]int)
+  writeUint(uint64(len(kmers)))
+  for i, kmer := range kmers {
+    index[kmer.KmerClassId] = i
+    writeString(kmer.String())
+  }
+  // samples, where k-mer indices are delta-coded
+  writeUint(uint64(obj.Len()))
+  for i := 0; i < obj.Len(); i++ {
+    writeString(obj.Headers[i])
+    if obj.Labels[i] {
+      writeUint(1)
+    } else {
+      writeUint(0)
+    }
+    writeUint(uint64(obj.Seqindex[i]))
+    idx := make([]int, 0, len(obj.Counts[i].Counts))
+    for id, c := range obj.Counts[i].Counts {
+      if c == 0 {
+        continue
+      }
+      if j, ok := index[id]; !ok {
+        return fmt.Errorf("k-mer of sample %d is missing in k-mer list", i)
+      } else {
+        idx = append(idx, j)
+      }
+    }
+    sort.Ints(idx)
+    writeUint(uint64(len(idx)))
+    for j, k := range idx {
+      if j == 0 {
+        writeUint(uint64(k))
+      } else {
+        writeUint(uint64(k-idx[j-1]))
+      }
+      writeUint(uint64(obj.Counts[i].Counts[kmers[k].KmerClassId]))
+    }
+  }
+  return w.Flush()
+}
+
+func (obj *KmerCache) Read(reader io.Reader) error {
+  r := bufio.NewReader(reader)
+  readUint := func() (int, error) {
+    x, err := binary.ReadUvarint(r)
+    return int(x), err
+  }
+  readString := func() (string, error) {
+    if n, err := readUint(); err != nil {
+      return "", err
+    } else {
+      b := make([]byte, n)
+      if _, err := io.ReadFull(r, b); err != nil {
+        return "", err
+      }
+      return string(b), nil
+    }
+  }
+  if magic, err := r.ReadString('\n'); err != nil || magic != kmerCacheMagic {
+    return fmt.Errorf("invalid k-mer count cache")
+  }
+  header := kmerCacheHeader{}
+  if h, err := readString(); err != nil {
+    return err
+  } else {
+    if err := json.Unmarshal([]byte(h), &header); err != nil {
+      return err
+    }
+  }
+  alphabet, err := alphabet_from_string(header.Alphabet); if err != nil {
+    return err
+  }
+  rel, err := NewKmerEquivalenceRelation(header.N, header.M, header.Complement, header.Reverse, header.Revcomp, header.MaxAmbiguous, alphabet); if err != nil {
+    return err
+  }
+  *obj = KmerCache{}
+  obj.KmerEquivalence = rel.KmerEquivalence
+  obj.Binarize        = header.Binarize
+  obj.Labelled        = header.Labelled
+  // global list of k-mers
+  if n, err := readUint(); err != nil {
+    return err
+  } else {
+    obj.Kmers = make(KmerClassList, n)
+    for i := 0; i < n; i++ {
+      if s, err := readString(); err != nil {
+        return err
+      } else {
+        obj.Kmers[i] = rel.EquivalenceClass(strings.Split(s, "|")[0])
+      }
+    }
+  }
+  // samples
+  n, err := readUint(); if err != nil {
+    return err
+  }
+  obj.Headers  = make([]string    , n)
+  obj.Labels   = make([]bool      , n)
+  obj.Seqindex = make([]int       , n)
+  obj.Counts   = make([]KmerCounts, n)
+  for i := 0; i < n; i++ {
+    if obj.Headers[i], err = readString(); err != nil {
+      return err
+    }
+    if label, err := readUint(); err != nil {
+      return err
+    } else {
+      obj.Labels[i] = label == 1
+    }
+    if obj.Seqindex[i], err = readUint(); err != nil {
+      return err
+    }
+    m, err := readUint(); if err != nil {
+      return err
+    }
+    kmers  := make(KmerClassList, m)
+    counts := make(map[KmerClassId]int, m)
+    for j, k := 0, 0; j < m; j++ {
+      d, err := readUint(); if err != nil {
+        return err
+      }
+      c, err := readUint(); if err != nil {
+        return err
+      }
+      if k += d; k >= len(obj.Kmers) {
+        return fmt.Errorf("invalid k-mer index in sample %d", i)
+      }
+      kmers[j] = obj.Kmers[k]
+      counts[obj.Kmers[k].KmerClassId] = c
+    }
+    obj.Counts[i] = KmerCounts{Kmers: kmers, Counts: counts}
+  }
+  return nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj KmerCache) Export(filename string) error {
+  f, err := os.Create(filename)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  w := gzip.NewWriter(f)
+  if err := obj.Write(w); err != nil {
+    return err
+  }
+  return w.Close()
+}
+
+func (obj *KmerCache) Import(filename string) error {
+  f, err := os.Open(filename)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  g, err := gzip.NewReader(f)
+  if err != nil {
+    return err
+  }
+  defer g.Close()
+  return obj.Read(g)
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Check if a file is a k-mer count cache
+func is_kmer_cache(filename string) bool {
+  if filename == "" {
+    return false
+  }
+  f, err := os.Open(filename)
+  if err != nil {
+    return false
+  }
+  defer f.Close()
+  g, err := gzip.NewReader(f)
+  if err != nil {
+    return false
+  }
+  defer g.Close()
+  b := make([]byte, len(kmerCacheMagic))
+  if _, err := io.ReadFull(g, b); err != nil {
+    return false
+  }
+  return string(b) == kmerCacheMagic
+}
+
+func ImportKmerCache(config Config, filename string) KmerCache {
+  r := KmerCache{}
+  PrintStderr(config, 1, "Importing k-mer counts from `%s'... ", filename)
+  if err := r.Import(filename); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatalf("reading k-mer count cache `%s' failed: %v", filename, err)
+  }
+  PrintStderr(config, 1, "done\n")
+  return r
+}
+
+func SaveKmerCache(config Config, filename string, cache KmerCache) {
+  PrintStderr(config, 1, "Exporting k-mer counts to `%s'... ", filename)
+  if err := cache.Export(filename); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+}
@@ -0,0 +1,262 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "encoding/json"
+import   "fmt"
+import   "io/ioutil"
+import   "log"
+import   "os"
+import   "os/signal"
+import   "sync"
+import   "sync/atomic"
+import   "syscall"
+import   "time"
+
+import . "github.com/pbenner/autodiff/statistics"
+
+/* -------------------------------------------------------------------------- */
+
+// State of an estimator for a single value of --lambda-auto
+type CheckpointState struct {
+  // current estimate (Theta, Features, Kmers)
+  Model    ConfigDistribution
+  // final classifier, which is set once the estimation is complete
+  Result  *ConfigDistribution
+  L1Reg    float64
+  L2Reg    float64
+  Epoch    int
+  Trace    Trace
+  Path     json.RawMessage
+}
+
+// Checkpoint of a single cross-validation fold, where Members[k][i]
+// is the state of ensemble member k for the i-th value of --lambda-auto
+type Checkpoint struct {
+  LambdaAuto   []int
+  EnsembleSize   int
+  // selected lambda of all exported models, which is set once
+  // all models of this fold are exported
+  Lambda       []float64
+  Done           bool
+  Members      []map[int]*CheckpointState
+  filename       string
+  interval       time.Duration
+  saved          time.Time
+  mutex          sync.Mutex
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Create a new checkpoint or, if --resume is given, continue from an
+// existing one; nil is returned if checkpoints are disabled
+func NewCheckpoint(config Config, filename string) *Checkpoint {
+  if config.Checkpoint == 0 {
+    return nil
+  }
+  r := (*Checkpoint)(nil)
+  if _, err := os.Stat(filename); err == nil && config.Resume {
+    r = ImportCheckpoint(config, filename)
+    if !r.matches(config) {
+      log.Fatalf("checkpoint `%s' does not match current options", filename)
+    }
+  } else {
+    r = &Checkpoint{}
+    r.LambdaAuto   = config.LambdaAuto
+    r.EnsembleSize = config.EnsembleSize
+    r.Members      = make([]map[int]*CheckpointState, config.EnsembleSize)
+  }
+  for k := 0; k < len(r.Members); k++ {
+    if r.Members[k] == nil {
+      r.Members[k] = make(map[int]*CheckpointState)
+    }
+  }
+  r.filename = filename
+  r.interval = config.Checkpoint
+  r.saved    = time.Now()
+  return r
+}
+
+func ImportCheckpoint(config Config, filename string) *Checkpoint {
+  PrintStderr(config, 1, "Importing checkpoint from `%s'... ", filename)
+  r := &Checkpoint{}
+  if b, err := ioutil.ReadFile(filename); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  } else {
+    if err := json.Unmarshal(b, r); err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("invalid checkpoint `%s': %v", filename, err)
+    }
+  }
+  PrintStderr(config, 1, "done\n")
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj *Checkpoint) matches(config Config) bool {
+  if obj.EnsembleSize != config.EnsembleSize {
+    return false
+  }
+  if len(obj.LambdaAuto) != len(config.LambdaAuto) {
+    return false
+  }
+  for i := 0; i < len(obj.LambdaAuto); i++ {
+    if obj.LambdaAuto[i] != config.LambdaAuto[i] {
+      return false
+    }
+  }
+  return true
+}
+
+// Get state of ensemble member k for the i-th value of --lambda-auto
+func (obj *Checkpoint) Get(k, i int) *CheckpointState {
+  if obj == nil || i < 0 {
+    return nil
+  }
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  return obj.Members[k][i]
+}
+
+// Update state of ensemble member k for the i-th value of --lambda-auto
+// and save the checkpoint if the checkpoint interval has elapsed or if
+// force is true
+func (obj *Checkpoint) Update(config Config, k, i int, state *CheckpointState, force bool) {
+  if obj == nil || i < 0 {
+    return
+  }
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  obj.Members[k][i] = state
+  if force || time.Since(obj.saved) >= obj.interval {
+    obj.save(config)
+  }
+}
+
+// Mark fold as complete, the states of all estimators are dropped since
+// the exported models are used for resuming
+func (obj *Checkpoint) Finish(config Config, lambda []float64) {
+  if obj == nil {
+    return
+  }
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  obj.Lambda  = lambda
+  obj.Done    = true
+  obj.Members = make([]map[int]*CheckpointState, obj.EnsembleSize)
+  obj.save(config)
+}
+
+// Discard all states, i.e. start estimation from scratch
+func (obj *Checkpoint) Reset(config Config) {
+  if obj == nil {
+    return
+  }
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  obj.LambdaAuto = config.LambdaAuto
+  obj.Lambda     = nil
+  obj.Done       = false
+  obj.Members    = make([]map[int]*CheckpointState, obj.EnsembleSize)
+  for k := 0; k < len(obj.Members); k++ {
+    obj.Members[k] = make(map[int]*CheckpointState)
+  }
+}
+
+func (obj *Checkpoint) Save(config Config) {
+  if obj == nil {
+    return
+  }
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  obj.save(config)
+}
+
+func (obj *Checkpoint) save(config Config) {
+  PrintStderr(config, 1, "Exporting checkpoint to `%s'... ", obj.filename)
+  if err := obj.export(obj.filename); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+  obj.saved = time.Now()
+}
+
+func (obj *Checkpoint) export(filename string) error {
+  b, err := json.Marshal(obj)
+  if err != nil {
+    return err
+  }
+  // write to temporary file first so that an existing checkpoint
+  // is not corrupted if the program is killed while writing
+  tmp := fmt.Sprintf("%s.tmp", filename)
+  if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+    return err
+  }
+  return os.Rename(tmp, filename)
+}
+
+/* -------------------------------------------------------------------------- */
+
+var checkpointStop int32
+
+// Returns true if estimators should save their state and stop
+func checkpointStopRequested() bool {
+  return atomic.LoadInt32(&checkpointStop) != 0
+}
+
+func checkpointRequestStop() {
+  atomic.StoreInt32(&checkpointStop, 1)
+}
+
+// Request all estimators to stop on SIGINT/SIGTERM or once the time budget
+// given by --max-time is exhausted. The returned function removes the signal
+// handler and timer and resets the stop request, it must be called once the
+// estimation is finished.
+func checkpointHandleSignals(config Config) func() {
+  c    := make(chan os.Signal, 1)
+  done := make(chan struct{})
+  signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+  go func() {
+    select {
+    case s := <-c:
+      signal.Stop(c)
+      PrintStderr(config, 0, "Received signal `%v', saving current models...\n", s)
+      checkpointRequestStop()
+    case <-done:
+    }
+  }()
+  var timer *time.Timer
+  if config.MaxTime > 0 {
+    timer = time.AfterFunc(config.MaxTime, func() {
+      PrintStderr(config, 0, "Time budget of %v exhausted, saving current models...\n", config.MaxTime)
+      checkpointRequestStop()
+    })
+  }
+  return func() {
+    signal.Stop(c)
+    close(done)
+    if timer != nil {
+      timer.Stop()
+    }
+    atomic.StoreInt32(&checkpointStop, 0)
+  }
+}
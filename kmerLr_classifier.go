@@ -22,6 +22,7 @@ package main
 import   "log"
 
 import . "github.com/pbenner/autodiff"
+import . "github.com/pbenner/autodiff/statistics"
 import . "github.com/pbenner/gonetics"
 
 /* -------------------------------------------------------------------------- */
@@ -30,6 +31,10 @@ type KmerLr struct {
   KmerLrFeatures
   Theta     []float64
   Transform   Transform
+  Penalty     Penalty
+  // regression model (linear or poisson), empty for
+  // logistic regression
+  Regression  string
 }
 
 /* -------------------------------------------------------------------------- */
@@ -48,11 +53,33 @@ func (obj *KmerLr) Clone() *KmerLr {
   }
   r.KmerLrFeatures = obj.KmerLrFeatures.Clone()
   r.Transform      = obj.Transform     .Clone()
+  r.Penalty        = obj.Penalty
+  r.Regression     = obj.Regression
   return &r
 }
 
 /* -------------------------------------------------------------------------- */
 
+// Export classifier as an ensemble with a single component, which is
+// used for storing estimates in checkpoints
+func (obj *KmerLr) exportCheckpoint() ConfigDistribution {
+  r := NewKmerLrEnsemble("")
+  if err := r.AddKmerLr(obj); err != nil {
+    log.Fatal(err)
+  }
+  return r.ExportConfig()
+}
+
+func importKmerLrCheckpoint(config ConfigDistribution) *KmerLr {
+  r := NewKmerLrEnsemble("")
+  if err := r.ImportConfig(config, Float64Type); err != nil {
+    log.Fatal(err)
+  }
+  return r.GetComponent(0)
+}
+
+/* -------------------------------------------------------------------------- */
+
 func (obj *KmerLr) Predict(config Config, data []ConstVector) []float64 {
   lr := logisticRegression{}
   lr.Theta  = obj.Theta
@@ -60,22 +87,23 @@ func (obj *KmerLr) Predict(config Config, data []ConstVector) []float64 {
   lr.Pool   = config.PoolLR
   r := make([]float64, len(data))
   for i, _ := range data {
-    r[i] = lr.LogPdf(data[i].(SparseConstFloat64Vector))
+    if obj.Regression != "" {
+      r[i] = regression_mean(obj.Regression, lr.LinearPdf(data[i].(SparseConstFloat64Vector)))
+    } else {
+      r[i] = lr.LogPdf(data[i].(SparseConstFloat64Vector))
+    }
   }
   return r
 }
 
-func (obj *KmerLr) Loss(config Config, data []ConstVector, c []bool) float64 {
+func (obj *KmerLr) Loss(config Config, data []ConstVector, c []bool, weights []float64) float64 {
   lr := logisticRegression{}
   lr.Theta  = obj.Theta
-  lr.Lambda = config.Lambda
+  lr.Lambda  = NewPenalty(config, config.Lambda).L1()
+  lr.Lambda2 = NewPenalty(config, config.Lambda).L2()
   lr.Pool   = config.PoolLR
-  if config.Balance {
-    lr.ClassWeights = compute_class_weights(c)
-  } else {
-    lr.ClassWeights[0] = 1.0
-    lr.ClassWeights[1] = 1.0
-  }
+  lr.ClassWeights = class_weights(config, c)
+  lr.Weights      = weights
   return lr.Loss(data, c)
 }
 
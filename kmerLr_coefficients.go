@@ -180,6 +180,66 @@ func coefficients(config Config, filename, filename_fg, filename_bg string, rela
   }
 }
 
+// Print coefficients of a multinomial model separately for each class. If
+// training data is given, the abundance of each k-mer in the class and in
+// all remaining classes is printed as well
+func coefficients_multinomial(config Config, filename string, filenames []string, related bool) {
+  classifier := ImportKmerLrMultinomial(config, filename)
+  kmers      := classifier.Kmers
+  features   := classifier.Features
+  graph      := KmerGraph{}
+
+  if len(filenames) != 0 && len(filenames) != len(classifier.Classes) {
+    log.Fatalf("model has %d classes, but %d data files are given", len(classifier.Classes), len(filenames))
+  }
+  data := KmerDataSet{}
+  if len(filenames) > 0 {
+    counter := classifier.GetKmerCounter()
+    data     = compile_training_data_multinomial(config, counter, classifier.Kmers, classifier.Features, false, classifier.Binarize, filenames)
+  }
+  if related {
+    if rel, err := NewKmerEquivalenceRelation(classifier.M, classifier.N, classifier.Complement, classifier.Reverse, classifier.Revcomp, classifier.MaxAmbiguous, classifier.Alphabet); err != nil {
+      log.Fatal(err)
+    } else {
+      graph = NewKmerGraph(kmers, rel)
+    }
+  }
+  for c, theta := range classifier.Theta {
+    coefficients := NewAbsFloatInt(len(theta)-1)
+    coeffmap     := make(map[KmerClassId]float64)
+    for i, v := range theta[1:] {
+      coefficients.a[i] = v
+      coefficients.b[i] = i
+      coeffmap[kmers[features[i][0]].KmerClassId] = v
+    }
+    coefficients.SortReverse()
+
+    labels := make([]bool, len(data.Classes))
+    for i, k := range data.Classes {
+      labels[i] = k == c
+    }
+    format := coefficients_format(kmers, features, coefficients)
+
+    fmt.Printf("Class %s:\n", classifier.Classes[c])
+    for i := 0; i < coefficients.Len(); i++ {
+      v := coefficients.a[i]
+      k := coefficients.b[i]
+      if v == 0.0 {
+        break
+      }
+      if len(data.Data) > 0 {
+        fmt.Printf("%6.2f%% ", kmer_abundance(data.Data, labels, k, true )*100.0)
+        fmt.Printf("%6.2f%% ", kmer_abundance(data.Data, labels, k, false)*100.0)
+      }
+      fmt.Printf(format, i+1, v, kmers[features[k][0]])
+      if related {
+        coefficients_print_related(kmers[features[k][0]], graph, coeffmap)
+      }
+      fmt.Println()
+    }
+  }
+}
+
 /* -------------------------------------------------------------------------- */
 
 func main_coefficients(config Config, args []string) {
@@ -189,7 +249,7 @@ func main_coefficients(config Config, args []string) {
   optRescale := options.BoolLong("rescale",   0 ,  "rescale coefficients to untransformed data")
   optHelp    := options.BoolLong("help",     'h',  "print help")
 
-  options.SetParameters("<MODEL.json> [<FOREGROUND.fa> <BACKGROUND.fa>]")
+  options.SetParameters("<MODEL.json> [<FOREGROUND.fa> <BACKGROUND.fa>|<CLASS_1.fa> <CLASS_2.fa>...]")
   options.Parse(args)
 
   // parse options
@@ -200,6 +260,10 @@ func main_coefficients(config Config, args []string) {
   }
   // parse arguments
   //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) >= 1 && is_multinomial_model(options.Args()[0]) {
+    coefficients_multinomial(config, options.Args()[0], options.Args()[1:], *optRelated)
+    return
+  }
   if len(options.Args()) != 1 && len(options.Args()) != 3 {
     options.PrintUsage(os.Stdout)
     os.Exit(0)
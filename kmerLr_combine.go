@@ -31,7 +31,7 @@ import   "github.com/pborman/getopt"
 func stability_analysis(config Config, kmerLr *KmerLrEnsemble, filename string) {
   x, y := kmerLr.Stability()
 
-  f, err := os.Create(filename)
+  f, err := create_file(filename)
   if err != nil {
     panic(err)
   }
@@ -0,0 +1,184 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "log"
+import   "os"
+import   "strconv"
+import   "strings"
+
+import . "github.com/pbenner/gonetics"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+func count_kmers(config Config, kmersCounter *KmerCounter, binarize, labelled, label bool, filename string) KmerCache {
+  r := NewKmerCache(kmersCounter, binarize, labelled)
+  samples := import_samples(config, kmersCounter, binarize, filename, -1)
+  counts  := samples.Scan(config, kmersCounter, binarize)
+  for i := 0; i < len(counts); i++ {
+    r.Headers  = append(r.Headers , samples.Headers[i])
+    r.Labels   = append(r.Labels  , label)
+    r.Seqindex = append(r.Seqindex, i)
+  }
+  r.Counts = counts
+  r.Kmers  = NewKmerCountsList(counts...).Kmers
+  return r
+}
+
+func count(config Config, classifier *KmerLrEnsemble, filename_fg, filename_bg, filename_out string) {
+  kmersCounter, err := NewKmerCounter(classifier.M, classifier.N, classifier.Complement, classifier.Reverse, classifier.Revcomp, classifier.MaxAmbiguous, classifier.Alphabet); if err != nil {
+    log.Fatal(err)
+  }
+  cache := count_kmers(config, kmersCounter, classifier.Binarize, filename_bg != "", true, filename_fg)
+  if filename_bg != "" {
+    if err := cache.Append(count_kmers(config, kmersCounter, classifier.Binarize, true, false, filename_bg)); err != nil {
+      log.Fatal(err)
+    }
+  }
+  SaveKmerCache(config, filename_out, cache)
+}
+
+func merge_counts(config Config, filename_out string, filenames []string) {
+  cache := ImportKmerCache(config, filenames[0])
+  for _, filename := range filenames[1:] {
+    if err := cache.Append(ImportKmerCache(config, filename)); err != nil {
+      log.Fatalf("merging k-mer count cache `%s' failed: %v", filename, err)
+    }
+  }
+  SaveKmerCache(config, filename_out, cache)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_count(config Config, args []string) {
+  options := getopt.New()
+
+  optAlphabet        := options. StringLong("alphabet",         0 , "nucleotide", "nucleotide, gapped-nucleotide, or iupac-nucleotide")
+  optBinarize        := options.   BoolLong("binarize",         0 ,               "binarize k-mer counts")
+  optComplement      := options.   BoolLong("complement",       0 ,               "consider complement sequences")
+  optMaxAmbiguous    := options. StringLong("max-ambiguous",    0 ,         "-1", "maxum number of ambiguous positions (either a scalar to set a global maximum or a comma separated list of length MAX-K-MER-LENGTH-MIN-K-MER-LENGTH+1)")
+  optReverse         := options.   BoolLong("reverse",          0 ,               "consider reverse sequences")
+  optRevcomp         := options.   BoolLong("revcomp",          0 ,               "consider reverse complement sequences")
+  optMinQuality      := options.    IntLong("min-quality",      0 ,            0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optAggregateReads  := options.   BoolLong("aggregate-reads",  0 ,               "each fasta/fastq file is a single sample with k-mer counts aggregated over all reads, where several files are joined with `+' (e.g. a.fq+b.fq)")
+  optHelp            := options.   BoolLong("help",            'h',               "print help")
+
+  options.SetParameters("<M> <N> <FOREGROUND.fa> [BACKGROUND.fa] <RESULT>\n\n" +
+    " The k-mer counts are saved to a cache, which can be used by all commands\n" +
+    " in place of fasta files. A cache that is created from foreground and\n" +
+    " background sequences may be given as both FOREGROUND and BACKGROUND\n" +
+    " argument.\n")
+  options.Parse(args)
+
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if len(options.Args()) != 4 && len(options.Args()) != 5 {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  classifier   := &KmerLrEnsemble{}
+  filename_fg  := ""
+  filename_bg  := ""
+  filename_out := ""
+  if m, err := strconv.ParseInt(options.Args()[0], 10, 64); err != nil {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    classifier.M = int(m)
+  }
+  if n, err := strconv.ParseInt(options.Args()[1], 10, 64); err != nil {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    classifier.N = int(n)
+  }
+  if classifier.M < 1 || classifier.N < classifier.M {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  if len(options.Args()) == 4 {
+    filename_fg  = options.Args()[2]
+    filename_out = options.Args()[3]
+  } else {
+    filename_fg  = options.Args()[2]
+    filename_bg  = options.Args()[3]
+    filename_out = options.Args()[4]
+  }
+  // parse classifier options
+  //////////////////////////////////////////////////////////////////////////////
+  classifier.Binarize     = *optBinarize
+  classifier.Complement   = *optComplement
+  classifier.Reverse      = *optReverse
+  classifier.Revcomp      = *optRevcomp
+  if alphabet, err := alphabet_from_string(*optAlphabet); err != nil {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    classifier.Alphabet = alphabet
+  }
+  if fields := strings.Split(*optMaxAmbiguous, ","); len(fields) == 1 || len(fields) == int(classifier.N-classifier.M+1) {
+    classifier.MaxAmbiguous = make([]int, len(fields))
+    for i := 0; i < len(fields); i++ {
+      if t, err := strconv.ParseInt(fields[i], 10, 64); err != nil {
+        options.PrintUsage(os.Stderr)
+        os.Exit(1)
+      } else {
+        classifier.MaxAmbiguous[i] = int(t)
+      }
+    }
+  } else {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  config.MinQuality     = *optMinQuality
+  config.AggregateReads = *optAggregateReads
+
+  count(config, classifier, filename_fg, filename_bg, filename_out)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_merge_counts(config Config, args []string) {
+  options := getopt.New()
+
+  optHelp := options.BoolLong("help", 'h', "print help")
+
+  options.SetParameters("<RESULT> <CACHE1> [CACHE2]...\n\n" +
+    " Merge k-mer count caches of several chunks of the same fasta files. Caches\n" +
+    " must be given in the order of the chunks.\n")
+  options.Parse(args)
+
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if len(options.Args()) < 2 {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  merge_counts(config, options.Args()[0], options.Args()[1:])
+}
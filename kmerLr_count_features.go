@@ -55,6 +55,8 @@ func main_count_features(config Config, args []string) {
   optMaxAmbiguous    := options. StringLong("max-ambiguous",    0 ,         "-1", "maxum number of ambiguous positions (either a scalar to set a global maximum or a comma separated list of length MAX-K-MER-LENGTH-MIN-K-MER-LENGTH+1)")
   optReverse         := options.   BoolLong("reverse",          0 ,               "consider reverse sequences")
   optRevcomp         := options.   BoolLong("revcomp",          0 ,               "consider reverse complement sequences")
+  optMinQuality      := options.    IntLong("min-quality",      0 ,            0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optAggregateReads  := options.   BoolLong("aggregate-reads",  0 ,               "each fasta/fastq file is a single sample with k-mer counts aggregated over all reads, where several files are joined with `+' (e.g. a.fq+b.fq)")
   optHelp            := options.   BoolLong("help",            'h',               "print help")
 
   options.SetParameters("<M> <N> <FOREGROUND.fa> [BACKGROUND.fa]")
@@ -119,5 +121,8 @@ func main_count_features(config Config, args []string) {
     options.PrintUsage(os.Stdout)
     os.Exit(0)
   }
+  config.MinQuality     = *optMinQuality
+  config.AggregateReads = *optAggregateReads
+
   count_features(config, classifier, filename_fg, filename_bg)
 }
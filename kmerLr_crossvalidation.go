@@ -22,19 +22,30 @@ import   "fmt"
 import   "bufio"
 import   "log"
 import   "math/rand"
-import   "os"
+import   "sort"
 
-import . "github.com/pbenner/autodiff"
 import   "github.com/pbenner/threadpool"
 
 /* -------------------------------------------------------------------------- */
 
 type CVResult struct {
+  // results for each test sample
   Predictions []float64
   Labels      []bool
+  // responses of regression models
+  Responses   []float64
+  Groups      []string
+  Folds       []int
+  Seqnames    []string
+  Seqindex    []int
+  // results for each fold
   LambdaAuto  []int
   LossTest    []float64
   LossTrain   []float64
+  Nonzero     []int
+  Lambda      []float64
+  // regression model, empty for logistic regression
+  Regression    string
 }
 
 /* -------------------------------------------------------------------------- */
@@ -53,7 +64,7 @@ func shuffleInts(vals1, vals2 []int, seed int64) {
 
 /* -------------------------------------------------------------------------- */
 
-func getCvGroups(n, fold int, val_ratio float64, seed int64) ([]int, []int) {
+func getCvGroupsRandom(n, fold int, val_ratio float64, seed int64) ([]int, []int) {
   if n < fold {
     log.Fatalf("not enough training samples (%d) for %d-fold cross-validation", n, fold)
   }
@@ -92,37 +103,247 @@ func getCvGroups(n, fold int, val_ratio float64, seed int64) ([]int, []int) {
   return groups, validation
 }
 
+// Stratified k-fold cross-validation, i.e. each class is split separately
+// so that all folds (and the validation set) have the same class ratio
+func getCvGroupsStratified(labels []bool, fold int, val_ratio float64, seed int64) ([]int, []int) {
+  classes := make([]int, len(labels))
+  for i, label := range labels {
+    if label {
+      classes[i] = 1
+    }
+  }
+  return getCvGroupsStratifiedClasses(classes, 2, fold, val_ratio, seed)
+}
+
+// Stratified k-fold cross-validation for n classes
+func getCvGroupsStratifiedClasses(classes []int, n, fold int, val_ratio float64, seed int64) ([]int, []int) {
+  index := make([][]int, n)
+  for i, k := range classes {
+    index[k] = append(index[k], i)
+  }
+  groups     := make([]int, len(classes))
+  validation := make([]int, len(classes))
+  offset     := 0
+  for k := 0; k < n; k++ {
+    if len(index[k]) == 0 {
+      continue
+    }
+    groups_k, validation_k := getCvGroupsRandom(len(index[k]), fold, val_ratio, seed)
+    for j, i := range index[k] {
+      groups    [i] = groups_k    [j]
+      validation[i] = validation_k[j]
+      // rotate fold ids of subsequent classes so that folds which received
+      // an additional sample of a previous class are not preferred again
+      if fold > 1 {
+        groups[i] = (groups[i] + offset) % fold
+      }
+    }
+    offset += len(index[k])
+  }
+  return groups, validation
+}
+
+// Grouped k-fold cross-validation, i.e. samples with the same group id are
+// always assigned to the same fold. Groups are assigned (largest first) to
+// the fold with the smallest number of samples. Validation samples are
+// selected group-wise as well.
+func getCvGroupsGrouped(ids []string, fold int, val_ratio float64, seed int64) ([]int, []int) {
+  n := len(ids)
+  // collect groups
+  group_map   := make(map[string]int)
+  group_index := [][]int{}
+  for i, id := range ids {
+    if j, ok := group_map[id]; ok {
+      group_index[j] = append(group_index[j], i)
+    } else {
+      group_map[id] = len(group_index)
+      group_index   = append(group_index, []int{i})
+    }
+  }
+  if len(group_index) < fold {
+    log.Fatalf("not enough groups (%d) for %d-fold cross-validation", len(group_index), fold)
+  }
+  if seed != -1 {
+    r := rand.New(rand.NewSource(seed))
+    r.Shuffle(len(group_index), func(i, j int) {
+      group_index[i], group_index[j] = group_index[j], group_index[i]
+    })
+  }
+  sort.SliceStable(group_index, func(i, j int) bool {
+    return len(group_index[i]) > len(group_index[j])
+  })
+  groups     := make([]int, n)
+  validation := make([]int, n)
+  if fold <= 1 {
+    n_val := 0
+    for _, index := range group_index {
+      v := 0
+      if float64(n_val) < val_ratio*float64(n) && n_val+len(index) < n {
+        v = 1; n_val += len(index)
+      }
+      for _, i := range index {
+        groups    [i] = -1
+        validation[i] = v
+      }
+    }
+  } else {
+    fold_size := make([]int, fold)
+    fold_val  := make([]int, fold)
+    for _, index := range group_index {
+      // find smallest fold
+      k := 0
+      for j := 1; j < fold; j++ {
+        if fold_size[j] < fold_size[k] {
+          k = j
+        }
+      }
+      fold_size[k] += len(index)
+      for _, i := range index {
+        groups[i] = k
+      }
+    }
+    for _, index := range group_index {
+      k := groups[index[0]]
+      v := 0
+      if float64(fold_val[k]) < val_ratio*float64(fold_size[k]) && fold_val[k]+len(index) < fold_size[k] {
+        v = 1; fold_val[k] += len(index)
+      }
+      for _, i := range index {
+        validation[i] = v
+      }
+    }
+  }
+  return groups, validation
+}
+
+// Chromosome groups for leave-one-chromosome-out cross-validation, either
+// specified by the user or one group for each chromosome
+func getCvChromosomeGroups(config Config, ids []string) [][]string {
+  if len(config.CVChromosomes) > 0 {
+    return config.CVChromosomes
+  }
+  chromosomes := []string{}
+  chromosome_map := make(map[string]bool)
+  for _, id := range ids {
+    if !chromosome_map[id] {
+      chromosome_map[id] = true
+      chromosomes = append(chromosomes, id)
+    }
+  }
+  sort.Strings(chromosomes)
+  r := make([][]string, len(chromosomes))
+  for i, chromosome := range chromosomes {
+    r[i] = []string{chromosome}
+  }
+  return r
+}
+
+// Leave-one-chromosome-out cross-validation, i.e. fold k contains all samples
+// located on chromosomes of the k-th chromosome group. Samples on chromosomes
+// that are not part of any group are always used for training. Validation
+// samples are selected chromosome-wise to prevent leakage between neighboring
+// regions.
+func getCvGroupsChromosome(ids []string, chromosomes [][]string, val_ratio float64, seed int64) ([]int, []int) {
+  chromosome_map := make(map[string]int)
+  for k, group := range chromosomes {
+    for _, chromosome := range group {
+      if _, ok := chromosome_map[chromosome]; ok {
+        log.Fatalf("chromosome `%s' is member of multiple chromosome groups", chromosome)
+      }
+      chromosome_map[chromosome] = k
+    }
+  }
+  _, validation := getCvGroupsGrouped(ids, 1, val_ratio, seed)
+  groups := make([]int, len(ids))
+  for i, id := range ids {
+    if k, ok := chromosome_map[id]; ok {
+      groups[i] = k
+    } else {
+      groups[i] = -1
+    }
+  }
+  return groups, validation
+}
+
+// Assign samples to folds using the cross-validation scheme specified in the
+// config. The validation vector marks samples that are used for validation
+// instead of training.
+func getCvGroups(config Config, labels []bool, ids []string, fold int, val_ratio float64, seed int64) ([]int, []int) {
+  switch config.CVScheme {
+  case "", "random":
+    return getCvGroupsRandom(len(labels), fold, val_ratio, seed)
+  case "stratified":
+    return getCvGroupsStratified(labels, fold, val_ratio, seed)
+  case "grouped":
+    if len(ids) != len(labels) {
+      log.Fatal("grouped cross-validation requires a group id for each sample")
+    }
+    return getCvGroupsGrouped(ids, fold, val_ratio, seed)
+  case "chromosome":
+    if len(ids) != len(labels) {
+      log.Fatal("leave-one-chromosome-out cross-validation requires a chromosome for each sample")
+    }
+    // no cross-validation (e.g. a single ensemble member), samples are only
+    // split into training and validation sets
+    if fold <= 1 {
+      return getCvGroupsGrouped(ids, fold, val_ratio, seed)
+    }
+    if chromosomes := getCvChromosomeGroups(config, ids); len(chromosomes) == fold {
+      return getCvGroupsChromosome(ids, chromosomes, val_ratio, seed)
+    }
+    // number of folds does not match the number of chromosome groups (e.g.
+    // when splitting data for ensemble estimation), fall back to grouping
+    // samples by chromosome
+    PrintStderr(config, 1, "Warning: number of folds (%d) does not match number of chromosome groups, grouping samples by chromosome\n", fold)
+    return getCvGroupsGrouped(ids, fold, val_ratio, seed)
+  default:
+    log.Fatalf("invalid cross-validation scheme `%s'", config.CVScheme)
+    panic("internal error")
+  }
+}
+
 func filterCvGroup(data KmerDataSet, groups []int, validation []int, i int) (KmerDataSet, KmerDataSet, KmerDataSet) {
-  r_train        := []ConstVector{}
-  r_train_labels := []bool{}
-  r_val          := []ConstVector{}
-  r_val_labels   := []bool{}
-  r_test         := []ConstVector{}
-  r_test_labels  := []bool{}
-  for j := 0; j < len(data.Data); j++ {
+  i_train, i_val, i_test := filterCvGroupIndex(groups, validation, i)
+  return data.Subset(i_train), data.Subset(i_val), data.Subset(i_test)
+}
+
+func filterCvGroupIndex(groups []int, validation []int, i int) ([]int, []int, []int) {
+  r_train := []int{}
+  r_val   := []int{}
+  r_test  := []int{}
+  for j := 0; j < len(groups); j++ {
     if groups[j] == i {
-      r_test        = append(r_test        , data.Data  [j])
-      r_test_labels = append(r_test_labels , data.Labels[j])
+      r_test = append(r_test, j)
     } else {
       if len(validation) > 0 && validation[j] == 1 {
-        r_val        = append(r_val       , data.Data  [j])
-        r_val_labels = append(r_val_labels, data.Labels[j])
+        r_val   = append(r_val  , j)
       } else {
-        r_train        = append(r_train       , data.Data  [j])
-        r_train_labels = append(r_train_labels, data.Labels[j])
+        r_train = append(r_train, j)
       }
     }
   }
-  data_train := KmerDataSet{r_train, r_train_labels, data.Kmers}
-  data_val   := KmerDataSet{r_val  , r_val_labels  , data.Kmers}
-  data_test  := KmerDataSet{r_test , r_test_labels , data.Kmers}
-  return data_train, data_val, data_test
+  return r_train, r_val, r_test
+}
+
+// The number of folds for leave-one-chromosome-out cross-validation is given
+// by the number of chromosome groups
+func getCvNumberOfFolds(config Config, ids []string) int {
+  if config.CVScheme != "chromosome" {
+    return config.KFoldCV
+  }
+  if n := len(getCvChromosomeGroups(config, ids)); n < 2 {
+    log.Fatal("leave-one-chromosome-out cross-validation requires at least two chromosome groups")
+    panic("internal error")
+  } else {
+    PrintStderr(config, 1, "Performing leave-one-chromosome-out cross-validation with %d folds\n", n)
+    return n
+  }
 }
 
 /* -------------------------------------------------------------------------- */
 
 func saveCrossvalidation(filename string, cvr CVResult) error {
-  f, err := os.Create(filename)
+  f, err := create_file(filename)
   if err != nil {
     return err
   }
@@ -131,13 +352,34 @@ func saveCrossvalidation(filename string, cvr CVResult) error {
   w := bufio.NewWriter(f)
   defer w.Flush()
 
-  fmt.Fprintf(w, "%15s\t%6s\n", "prediction", "labels")
+  if cvr.Regression != "" {
+    fmt.Fprintf(w, "%15s\t%15s\t%4s\t%8s", "prediction", "response", "fold", "index")
+  } else {
+    fmt.Fprintf(w, "%15s\t%6s\t%4s\t%6s\t%8s", "prediction", "labels", "fold", "origin", "index")
+  }
+  if len(cvr.Seqnames) > 0 {
+    fmt.Fprintf(w, "\t%s", "name")
+  }
+  if len(cvr.Groups) > 0 {
+    fmt.Fprintf(w, "\t%s", "group")
+  }
+  fmt.Fprintf(w, "\n")
   for i := 0; i < len(cvr.Predictions); i++ {
+    if cvr.Regression != "" {
+      fmt.Fprintf(w, "%15e\t%15e\t%4d\t%8d", cvr.Predictions[i], cvr.Responses[i], cvr.Folds[i], cvr.Seqindex[i])
+    } else
     if cvr.Labels[i] {
-      fmt.Fprintf(w, "%15e\t%6d\n", cvr.Predictions[i], 1)
+      fmt.Fprintf(w, "%15e\t%6d\t%4d\t%6s\t%8d", cvr.Predictions[i], 1, cvr.Folds[i], "fg", cvr.Seqindex[i])
     } else {
-      fmt.Fprintf(w, "%15e\t%6d\n", cvr.Predictions[i], 0)
+      fmt.Fprintf(w, "%15e\t%6d\t%4d\t%6s\t%8d", cvr.Predictions[i], 0, cvr.Folds[i], "bg", cvr.Seqindex[i])
+    }
+    if len(cvr.Seqnames) > 0 {
+      fmt.Fprintf(w, "\t%s", cvr.Seqnames[i])
+    }
+    if len(cvr.Groups) > 0 {
+      fmt.Fprintf(w, "\t%s", cvr.Groups[i])
     }
+    fmt.Fprintf(w, "\n")
   }
   return nil
 }
@@ -146,7 +388,7 @@ func saveCrossvalidationLoss(filename string, cvr CVResult) error {
   if len(cvr.LossTrain) == 0 {
     return nil
   }
-  f, err := os.Create(filename)
+  f, err := create_file(filename)
   if err != nil {
     return err
   }
@@ -162,35 +404,102 @@ func saveCrossvalidationLoss(filename string, cvr CVResult) error {
   return nil
 }
 
+func saveCrossvalidationFolds(filename string, cvr CVResult) error {
+  if len(cvr.LossTrain) == 0 {
+    return nil
+  }
+  f, err := create_file(filename)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  w := bufio.NewWriter(f)
+  defer w.Flush()
+
+  fmt.Fprintf(w, "%4s\t%15s\t%15s\t%8s\t%15s", "fold", "train", "test", "nonzero", "lambda")
+  if len(cvr.LambdaAuto) > 0 {
+    fmt.Fprintf(w, "\t%11s", "lambda-auto")
+  }
+  if cvr.Regression != "" {
+    fmt.Fprintf(w, "\t%15s\t%15s\t%15s", "r2", "correlation", "deviance")
+  }
+  fmt.Fprintf(w, "\n")
+  for i := 0; i < len(cvr.LossTrain); i++ {
+    fmt.Fprintf(w, "%4d\t%15e\t%15e\t%8d\t%15e", i+1, cvr.LossTrain[i], cvr.LossTest[i], cvr.Nonzero[i], cvr.Lambda[i])
+    if len(cvr.LambdaAuto) > 0 {
+      fmt.Fprintf(w, "\t%11d", cvr.LambdaAuto[i])
+    }
+    if cvr.Regression != "" {
+      // evaluate predictions on the test set of this fold
+      predictions := []float64{}
+      responses   := []float64{}
+      for j := 0; j < len(cvr.Predictions); j++ {
+        if cvr.Folds[j] == i+1 {
+          predictions = append(predictions, cvr.Predictions[j])
+          responses   = append(responses  , cvr.Responses  [j])
+        }
+      }
+      fmt.Fprintf(w, "\t%15e\t%15e\t%15e",
+        eval_r2(predictions, responses),
+        eval_correlation(predictions, responses),
+        eval_deviance(cvr.Regression, predictions, responses))
+    }
+    fmt.Fprintf(w, "\n")
+  }
+  return nil
+}
+
 /* -------------------------------------------------------------------------- */
 
 func crossvalidation(config Config, data KmerDataSet,
-  learnAndTestClassifiers func(i int, data_train, data_val, data_test KmerDataSet) ([][]float64, []float64, []float64)) []CVResult {
-  groups, validation := getCvGroups(len(data.Data), config.KFoldCV, config.ValidationSize, config.Seed)
+  learnAndTestClassifiers func(i int, data_train, data_val, data_test KmerDataSet) ([][]float64, []float64, []float64, []int, []float64)) []CVResult {
+  config.KFoldCV = getCvNumberOfFolds(config, data.Groups)
+
+  groups, validation := getCvGroups(config, data.cvLabels(), data.Groups, config.KFoldCV, config.ValidationSize, config.Seed)
 
   r_predictions := make([][][]float64, config.KFoldCV)
   r_labels      := make(  [][]bool,    config.KFoldCV)
+  r_responses   := make(  [][]float64, config.KFoldCV)
+  r_groups      := make(  [][]string,  config.KFoldCV)
+  r_seqnames    := make(  [][]string,  config.KFoldCV)
+  r_seqindex    := make(  [][]int,     config.KFoldCV)
   r_loss_train  := make(  [][]float64, config.KFoldCV)
   r_loss_test   := make(  [][]float64, config.KFoldCV)
+  r_nonzero     := make(  [][]int,     config.KFoldCV)
+  r_lambda      := make(  [][]float64, config.KFoldCV)
 
   config.PoolCV.RangeJob(0, config.KFoldCV, func(i int, pool threadpool.ThreadPool, erf func() error) error {
     config := config; config.PoolCV = pool
     i_     := i
 
+    if checkpointStopRequested() {
+      return nil
+    }
+
     if config.KFoldCV <= 1 {
       i_ = -1
     }
 
     data_train, data_val, data_test := filterCvGroup(data, groups, validation, i)
 
-    predictions, loss_train, loss_test := learnAndTestClassifiers(i_, data_train, data_val, data_test)
+    predictions, loss_train, loss_test, nonzero, lambda := learnAndTestClassifiers(i_, data_train, data_val, data_test)
 
     r_labels     [i] = data_test.Labels
+    r_responses  [i] = data_test.Responses
+    r_groups     [i] = data_test.Groups
+    r_seqnames   [i] = data_test.Seqnames
+    r_seqindex   [i] = data_test.Seqindex
     r_predictions[i] = predictions
     r_loss_train [i] = loss_train
     r_loss_test  [i] = loss_test
+    r_nonzero    [i] = nonzero
+    r_lambda     [i] = lambda
     return nil
   })
+  if checkpointStopRequested() {
+    return nil
+  }
   // join results
   result := []CVResult{}
   for i := 0; config.KFoldCV > 1 && i < config.KFoldCV; i++ {
@@ -203,8 +512,18 @@ func crossvalidation(config Config, data KmerDataSet,
     for j := 0; j < len(r_predictions[i]); j++ {
       result[j].Predictions = append(result[j].Predictions, r_predictions[i][j]...)
       result[j].Labels      = append(result[j].Labels     , r_labels     [i]   ...)
-      result[j].LossTrain   = append(result[j].LossTrain  , r_loss_train [i]   ...)
-      result[j].LossTest    = append(result[j].LossTest   , r_loss_test  [i]   ...)
+      result[j].Responses   = append(result[j].Responses  , r_responses  [i]   ...)
+      result[j].Regression  = config.Regression
+      result[j].Groups      = append(result[j].Groups     , r_groups     [i]   ...)
+      result[j].Seqnames    = append(result[j].Seqnames   , r_seqnames   [i]   ...)
+      result[j].Seqindex    = append(result[j].Seqindex   , r_seqindex   [i]   ...)
+      result[j].LossTrain   = append(result[j].LossTrain  , r_loss_train [i][j])
+      result[j].LossTest    = append(result[j].LossTest   , r_loss_test  [i][j])
+      result[j].Nonzero     = append(result[j].Nonzero    , r_nonzero    [i][j])
+      result[j].Lambda      = append(result[j].Lambda     , r_lambda     [i][j])
+      for k := 0; k < len(r_predictions[i][j]); k++ {
+        result[j].Folds = append(result[j].Folds, i+1)
+      }
     }
   }
   return result
@@ -0,0 +1,139 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "log"
+import   "math"
+
+import   "github.com/pbenner/threadpool"
+
+/* -------------------------------------------------------------------------- */
+
+// Config for the inner cross-validation loop of nested cross-validation
+func nestedCvInnerConfig(config Config) Config {
+  config.KFoldCV        = config.NestedCV
+  config.ValidationSize = 0.0
+  config.SaveTrace      = false
+  config.SavePath       = false
+  // inner folds run sequentially within each outer fold
+  config.PoolCV         = threadpool.Nil()
+  config.Verbose        = config.Verbose-1
+  // chromosome groups of the outer loop contain held-out chromosomes,
+  // use one fold for each remaining chromosome instead
+  config.CVChromosomes  = nil
+  return config
+}
+
+// Select the model size (i.e. the index of a --lambda-auto value) from
+// the results of the inner cross-validation loop, where cvrs contains
+// one result for each --lambda-auto value
+func nestedCvSelect(config Config, cvrs []CVResult) int {
+  if len(cvrs) != len(config.LambdaAuto) {
+    panic("internal error")
+  }
+  mean := make([]float64, len(cvrs))
+  serr := make([]float64, len(cvrs))
+  for i, cvr := range cvrs {
+    n  := 0.0
+    m1 := 0.0
+    m2 := 0.0
+    for _, v := range cvr.LossTest {
+      if math.IsNaN(v) {
+        continue
+      }
+      n  += 1.0
+      m1 += v
+      m2 += v*v
+    }
+    if n == 0.0 {
+      // exclude model sizes without any valid loss from the selection
+      PrintStderr(config, 1, "Warning: inner cross-validation loss of model with %d features is undefined in all folds\n", config.LambdaAuto[i])
+      mean[i] = math.NaN()
+      serr[i] = math.NaN()
+      continue
+    }
+    mean[i] = m1/n
+    if n > 1.0 {
+      serr[i] = math.Sqrt(math.Max(m2/n - mean[i]*mean[i], 0.0)*n/(n-1.0)/n)
+    }
+  }
+  // index of model with smallest mean loss
+  i_min := -1
+  for i := 0; i < len(cvrs); i++ {
+    if !math.IsNaN(mean[i]) && (i_min == -1 || mean[i] < mean[i_min]) {
+      i_min = i
+    }
+  }
+  if i_min == -1 && config.NestedCVMetric != "auc" {
+    log.Fatal("inner cross-validation failed: loss is undefined for all model sizes")
+  }
+  i_best := 0
+  switch config.NestedCVMetric {
+  case "loss":
+    i_best = i_min
+  case "auc":
+    i_best  = -1
+    v_best := math.Inf(-1)
+    for i, cvr := range cvrs {
+      if v := eval_roc_auc(cvr.Predictions, cvr.Labels); !math.IsNaN(v) && v > v_best {
+        i_best, v_best = i, v
+      }
+    }
+    if i_best == -1 {
+      // the AUC is undefined if inner test sets contain a single class
+      if i_min == -1 {
+        log.Fatal("inner cross-validation failed: AUC and loss are undefined for all model sizes")
+      }
+      PrintStderr(config, 1, "Warning: inner cross-validation AUC is undefined for all model sizes, selecting model by loss\n")
+      i_best = i_min
+    }
+  case "1se":
+    // select the smallest model whose loss is within one standard
+    // error of the best model
+    i_best = i_min
+    for i := 0; i < len(cvrs); i++ {
+      if mean[i] <= mean[i_min] + serr[i_min] && config.LambdaAuto[i] < config.LambdaAuto[i_best] {
+        i_best = i
+      }
+    }
+  default:
+    log.Fatalf("invalid nested cross-validation metric `%s'", config.NestedCVMetric)
+  }
+  for i, _ := range cvrs {
+    PrintStderr(config, 1, "> Inner cross-validation: %d features have loss %e (standard error %e)\n", config.LambdaAuto[i], mean[i], serr[i])
+  }
+  PrintStderr(config, 1, "> Inner cross-validation: selecting %d features\n", config.LambdaAuto[i_best])
+  return i_best
+}
+
+// The consensus model size is the most frequently selected size, where
+// ties are resolved in favor of smaller models
+func nestedCvConsensus(selected []int) int {
+  counts := make(map[int]int)
+  for _, n := range selected {
+    counts[n]++
+  }
+  r := -1
+  for n, c := range counts {
+    if r == -1 || c > counts[r] || c == counts[r] && n < r {
+      r = n
+    }
+  }
+  return r
+}
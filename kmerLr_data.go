@@ -24,6 +24,8 @@ import   "io"
 import   "bufio"
 import   "bytes"
 import   "os"
+import   "strings"
+import   "unicode"
 
 import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/gonetics"
@@ -34,7 +36,77 @@ import   "github.com/pbenner/threadpool"
 type KmerDataSet struct {
   Data   []ConstVector
   Labels []bool
+  // class of each sample for multinomial models
+  Classes  []int
   Kmers    KmerClassList
+  Groups []string
+  // sequence names and index of each sample within its input file
+  Seqnames []string
+  Seqindex []int
+  // optional sample weights
+  Weights  []float64
+  // response of each sample for regression models
+  Responses []float64
+}
+
+func (obj KmerDataSet) Subset(index []int) KmerDataSet {
+  r := KmerDataSet{Kmers: obj.Kmers}
+  r.Data   = make([]ConstVector, len(index))
+  for i, j := range index {
+    r.Data[i] = obj.Data[j]
+  }
+  if len(obj.Labels) > 0 {
+    r.Labels = make([]bool, len(index))
+    for i, j := range index {
+      r.Labels[i] = obj.Labels[j]
+    }
+  }
+  if len(obj.Classes) > 0 {
+    r.Classes = make([]int, len(index))
+    for i, j := range index {
+      r.Classes[i] = obj.Classes[j]
+    }
+  }
+  if len(obj.Groups) > 0 {
+    r.Groups = make([]string, len(index))
+    for i, j := range index {
+      r.Groups[i] = obj.Groups[j]
+    }
+  }
+  if len(obj.Seqnames) > 0 {
+    r.Seqnames = make([]string, len(index))
+    for i, j := range index {
+      r.Seqnames[i] = obj.Seqnames[j]
+    }
+  }
+  if len(obj.Seqindex) > 0 {
+    r.Seqindex = make([]int, len(index))
+    for i, j := range index {
+      r.Seqindex[i] = obj.Seqindex[j]
+    }
+  }
+  if len(obj.Weights) > 0 {
+    r.Weights = make([]float64, len(index))
+    for i, j := range index {
+      r.Weights[i] = obj.Weights[j]
+    }
+  }
+  if len(obj.Responses) > 0 {
+    r.Responses = make([]float64, len(index))
+    for i, j := range index {
+      r.Responses[i] = obj.Responses[j]
+    }
+  }
+  return r
+}
+
+// Labels used for assigning samples to cross-validation folds, regression
+// data sets have no labels
+func (obj KmerDataSet) cvLabels() []bool {
+  if obj.Labels == nil {
+    return make([]bool, len(obj.Data))
+  }
+  return obj.Labels
 }
 
 func (obj KmerDataSet) String() string {
@@ -98,79 +170,251 @@ func (obj KmerDataSet) String() string {
 
 /* -------------------------------------------------------------------------- */
 
-func import_fasta(config Config, filename string) []string {
-  s := OrderedStringSet{}
-  if filename == "" {
-    PrintStderr(config, 1, "Reading fasta file from stdin... ")
-    if err := s.ReadFasta(os.Stdin); err != nil {
-      PrintStderr(config, 1, "failed\n")
-      log.Fatal(err)
+// Read sequences from a fasta file. In contrast to OrderedStringSet.ReadFasta,
+// the full header line is retained so that additional fields (e.g. group ids)
+// can be extracted
+func read_fasta(reader io.Reader) ([]string, []string, error) {
+  scanner := bufio.NewScanner(reader)
+  scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+
+  headers   := []string{}
+  sequences := []string{}
+  seq       := []byte{}
+  for scanner.Scan() {
+    line := scanner.Bytes()
+    if len(line) == 0 {
+      continue
     }
-    PrintStderr(config, 1, "done\n")
-  } else {
-    PrintStderr(config, 1, "Reading fasta file `%s'... ", filename)
-    if err := s.ImportFasta(filename); err != nil {
-      PrintStderr(config, 1, "failed\n")
-      log.Fatal(err)
+    if line[0] == '>' {
+      if len(headers) > 0 {
+        sequences = append(sequences, string(seq))
+      }
+      headers = append(headers, strings.TrimSpace(string(line[1:])))
+      seq     = seq[:0]
+    } else {
+      if len(headers) == 0 {
+        return nil, nil, fmt.Errorf("invalid fasta file")
+      }
+      seq = append(seq, line...)
     }
-    PrintStderr(config, 1, "done\n")
   }
-  r := make([]string, len(s.Seqnames))
-  for i, name := range s.Seqnames {
-    r[i] = string(s.Sequences[name])
+  if err := scanner.Err(); err != nil {
+    return nil, nil, err
   }
-  return r
+  if len(headers) > 0 {
+    sequences = append(sequences, string(seq))
+  }
+  return headers, sequences, nil
 }
 
-/* -------------------------------------------------------------------------- */
+// Read reads from a fastq file with four lines per record. Quality strings
+// are returned as they are, i.e. with Phred+33 encoding.
+func read_fastq(reader io.Reader) ([]string, []string, []string, error) {
+  scanner := bufio.NewScanner(reader)
+  scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+
+  headers   := []string{}
+  sequences := []string{}
+  qualities := []string{}
+  for i := 0; scanner.Scan(); {
+    line := scanner.Bytes()
+    if len(line) == 0 && i % 4 == 0 {
+      continue
+    }
+    switch i % 4 {
+    case 0:
+      if line[0] != '@' {
+        return nil, nil, nil, fmt.Errorf("invalid fastq file")
+      }
+      headers   = append(headers, strings.TrimSpace(string(line[1:])))
+    case 1:
+      sequences = append(sequences, string(line))
+    case 2:
+      if len(line) == 0 || line[0] != '+' {
+        return nil, nil, nil, fmt.Errorf("invalid fastq file")
+      }
+    case 3:
+      if len(line) != len(sequences[len(sequences)-1]) {
+        return nil, nil, nil, fmt.Errorf("fastq record `%s' has invalid quality string", headers[len(headers)-1])
+      }
+      qualities = append(qualities, string(line))
+    }
+    i++
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, nil, nil, err
+  }
+  if len(qualities) != len(headers) {
+    return nil, nil, nil, fmt.Errorf("fastq file is truncated")
+  }
+  return headers, sequences, qualities, nil
+}
+
+// Read sequences from a fasta or fastq file, where the format is detected
+// from the first character. Qualities are nil for fasta files.
+func read_sequences(reader io.Reader) ([]string, []string, []string, error) {
+  r := bufio.NewReader(reader)
+  for {
+    if b, err := r.Peek(1); err != nil {
+      if err == io.EOF {
+        break
+      }
+      return nil, nil, nil, err
+    } else if unicode.IsSpace(rune(b[0])) {
+      r.ReadByte()
+    } else if b[0] == '@' {
+      return read_fastq(r)
+    } else {
+      break
+    }
+  }
+  headers, sequences, err := read_fasta(r)
+  return headers, sequences, nil, err
+}
 
-func export_kmers(config Config, filename string, data KmerDataSet) {
-  var writer io.Writer
+func import_sequences_file(filename string) ([]string, []string, []string, error) {
+  f, err := open_file(filename)
+  if err != nil {
+    return nil, nil, nil, err
+  }
+  defer f.Close()
+  return read_sequences(f)
+}
+
+// Returns the header lines, sequences and qualities of a fasta or fastq file
+func import_sequences(config Config, filename string) ([]string, []string, []string) {
+  var headers   []string
+  var sequences []string
+  var qualities []string
+  var err         error
   if filename == "" {
-    writer = os.Stdout
+    PrintStderr(config, 1, "Reading sequences from stdin... ")
+    headers, sequences, qualities, err = read_sequences(os.Stdin)
+  } else {
+    if is_kmer_cache(filename) {
+      log.Fatalf("`%s' is a k-mer count cache, but this command requires sequences", filename)
+    }
+    PrintStderr(config, 1, "Reading sequences from `%s'... ", filename)
+    headers, sequences, qualities, err = import_sequences_file(filename)
+  }
+  if err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+  return headers, sequences, qualities
+}
+
+// Returns the header lines and the sequences of a fasta or fastq file
+func import_fasta(config Config, filename string) ([]string, []string) {
+  headers, sequences, _ := import_sequences(config, filename)
+  return headers, sequences
+}
+
+// Fields of fasta headers are separated by white space or `|'. The first
+// field is the sequence name.
+func fasta_header_field(header string, i int) (string, error) {
+  fields := strings.FieldsFunc(header, func(c rune) bool {
+    return unicode.IsSpace(c) || c == '|'
+  })
+  if i < 1 || i > len(fields) {
+    return "", fmt.Errorf("fasta header `%s' has no field %d", header, i)
+  }
+  return fields[i-1], nil
+}
+
+func fasta_header_name(header string) string {
+  if name, err := fasta_header_field(header, 1); err != nil {
+    return ""
   } else {
-    f, err := os.Create(filename)
+    return name
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Import group ids for cross-validation, either from a file with one id per
+// line (foreground samples first), from a bed file with one region per sample,
+// or from a field of the fasta headers. For leave-one-chromosome-out
+// cross-validation, the group id is the chromosome, which is parsed from
+// sequence names of the form `chr:from-to'.
+func import_cv_groups(config Config, headers []string, n int) []string {
+  if config.CVGroupsFile != "" {
+    f, err := open_file(config.CVGroupsFile)
     if err != nil {
-      panic(err)
+      log.Fatal(err)
     }
     defer f.Close()
 
-    w := bufio.NewWriter(f)
-    defer w.Flush()
-
-    writer = w
+    PrintStderr(config, 1, "Reading group ids from `%s'... ", config.CVGroupsFile)
+    groups  := []string{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+      if line := strings.TrimSpace(scanner.Text()); line != "" {
+        groups = append(groups, line)
+      }
+    }
+    if err := scanner.Err(); err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal(err)
+    }
+    if len(groups) != n {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("number of group ids (%d) does not match number of samples (%d)", len(groups), n)
+    }
+    PrintStderr(config, 1, "done\n")
+    return groups
   }
-  for j := 0; j < len(data.Kmers); j++ {
-    if j == 0 {
-      fmt.Fprintf(writer,  "%s", data.Kmers[j])
-    } else {
-      fmt.Fprintf(writer, ",%s", data.Kmers[j])
+  if config.CVBedFile != "" {
+    granges := importBed3(config, config.CVBedFile)
+    if granges.Length() != n {
+      log.Fatalf("number of regions (%d) in `%s' does not match number of samples (%d)", granges.Length(), config.CVBedFile, n)
     }
+    return granges.Seqnames
   }
-  fmt.Fprintf(writer, "\n")
-  if config.DataTransform != "" {
-    for i := 0; i < len(data.Data); i++ {
-      for j := 1; j < data.Data[i].Dim(); j++ {
-        if j == 1 {
-          fmt.Fprintf(writer,  "%e", data.Data[i].Float64At(j))
-        } else {
-          fmt.Fprintf(writer, ",%e", data.Data[i].Float64At(j))
-        }
-      }
-      fmt.Fprintf(writer, "\n")
+  if config.CVGroupField > 0 || config.CVScheme == "chromosome" {
+    if len(headers) != n {
+      log.Fatal("group ids can only be extracted from fasta headers")
     }
-  } else {
-    for i := 0; i < len(data.Data); i++ {
-      for j := 1; j < data.Data[i].Dim(); j++ {
-        if j == 1 {
-          fmt.Fprintf(writer,  "%d", data.Data[i].IntAt(j))
-        } else {
-          fmt.Fprintf(writer, ",%d", data.Data[i].IntAt(j))
+    field := config.CVGroupField
+    if field == 0 {
+      field = 1
+    }
+    groups := make([]string, n)
+    for i, header := range headers {
+      if g, err := fasta_header_field(header, field); err != nil {
+        log.Fatal(err)
+      } else {
+        if config.CVScheme == "chromosome" {
+          // strip region from `chr:from-to'
+          if j := strings.LastIndex(g, ":"); j > 0 {
+            g = g[0:j]
+          }
         }
+        groups[i] = g
       }
-      fmt.Fprintf(writer, "\n")
     }
+    return groups
   }
+  return nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Export k-mer counts, where columns are either k-mers or, if features are
+// given, the features of a model
+func export_kmers(config Config, filename, format string, data KmerDataSet, features FeatureIndices) {
+  m := ExportMatrix{Data: data.Data, Labels: data.Labels}
+  if len(features) == 0 {
+    m.Columns = make([]string, len(data.Kmers))
+    for j := 0; j < len(data.Kmers); j++ {
+      m.Columns[j] = data.Kmers[j].String()
+    }
+  } else {
+    m.Columns = features.Names(data.Kmers)
+  }
+  m.Integer = config.DataTransform == ""
+  export_matrix(config, filename, format, m)
 }
 
 /* -------------------------------------------------------------------------- */
@@ -254,7 +498,24 @@ func convert_counts_list(config Config, countsList *KmerCountsList, features Fea
 
 /* -------------------------------------------------------------------------- */
 
-func scan_sequence(config Config, kmersCounter *KmerCounter, binarize bool, sequence []byte) KmerCounts {
+// Count k-mers in a sequence. If base qualities are given and a minimum
+// quality is set, k-mers covering low-quality bases are skipped
+func scan_sequence(config Config, kmersCounter *KmerCounter, binarize bool, sequence, quality []byte) KmerCounts {
+  if config.MinQuality > 0 && quality != nil {
+    counts := []KmerCounts{}
+    for i, j := 0, 0; j <= len(sequence); j++ {
+      if j == len(sequence) || int(quality[j]) - 33 < config.MinQuality {
+        // scan segment of high-quality bases, which must contain at least
+        // one k-mer of minimal length (gonetics uses N for the minimal and
+        // M for the maximal k-mer length)
+        if j-i >= kmersCounter.N {
+          counts = append(counts, scan_sequence(config, kmersCounter, binarize, sequence[i:j], nil))
+        }
+        i = j+1
+      }
+    }
+    return merge_kmer_counts(binarize, counts...)
+  }
   if binarize {
     return kmersCounter.IdentifyKmers(sequence)
   } else {
@@ -262,7 +523,7 @@ func scan_sequence(config Config, kmersCounter *KmerCounter, binarize bool, sequ
   }
 }
 
-func scan_sequences(config Config, kmersCounter *KmerCounter, binarize bool, sequences []string) []KmerCounts {
+func scan_sequences(config Config, kmersCounter *KmerCounter, binarize bool, sequences, qualities []string) []KmerCounts {
   r := make([]KmerCounts, len(sequences))
   // create one counter for each thread
   counters := make([]*KmerCounter, config.Pool.NumberOfThreads())
@@ -273,7 +534,11 @@ func scan_sequences(config Config, kmersCounter *KmerCounter, binarize bool, seq
   if err := config.Pool.RangeJob(0, len(sequences), func(i int, pool threadpool.ThreadPool, erf func() error) error {
     config := config; config.Pool = pool
 
-    r[i] = scan_sequence(config, counters[pool.GetThreadId()], binarize, []byte(sequences[i]))
+    if qualities != nil {
+      r[i] = scan_sequence(config, counters[pool.GetThreadId()], binarize, []byte(sequences[i]), []byte(qualities[i]))
+    } else {
+      r[i] = scan_sequence(config, counters[pool.GetThreadId()], binarize, []byte(sequences[i]), nil)
+    }
     return nil
   }); err != nil {
     PrintStderr(config, 1, "failed\n")
@@ -283,14 +548,112 @@ func scan_sequences(config Config, kmersCounter *KmerCounter, binarize bool, seq
   return r
 }
 
+// Sum k-mer counts, or compute the union of k-mers if counts are binarized
+func merge_kmer_counts(binarize bool, counts ...KmerCounts) KmerCounts {
+  if len(counts) == 1 {
+    return counts[0]
+  }
+  kmers := make([]KmerClassList, len(counts))
+  r     := make(map[KmerClassId]int)
+  for i, c := range counts {
+    kmers[i] = c.Kmers
+    for id, n := range c.Counts {
+      if binarize {
+        r[id] = 1
+      } else {
+        r[id] += n
+      }
+    }
+  }
+  return KmerCounts{Kmers: KmerClassList{}.Union(kmers...), Counts: r}
+}
+
 /* -------------------------------------------------------------------------- */
 
-func reduce_samples(config Config, fg, bg []string) ([]string, []string) {
-  if config.MaxSamples == 0 || len(fg) + len(bg) <= config.MaxSamples {
+// Samples are either sequences read from a fasta/fastq file or k-mer counts
+// imported from a cache or aggregated over all reads of a file
+type kmerSamples struct {
+  Headers   []string
+  Seqindex  []int
+  Sequences []string
+  Qualities []string
+  Counts    []KmerCounts
+}
+
+// Import samples from a fasta/fastq file or a k-mer count cache. If the cache
+// was created from foreground and background sequences, only samples with the
+// given label are returned (1: foreground, 0: background, -1: all). If reads
+// are aggregated, filename is a list of files separated by `+' and each file
+// is a single sample.
+func import_samples(config Config, kmersCounter *KmerCounter, binarize bool, filename string, label int) kmerSamples {
+  r := kmerSamples{}
+  if config.AggregateReads && filename != "" {
+    r.Counts = []KmerCounts{}
+    for _, filename := range strings.Split(filename, "+") {
+      _, sequences, qualities := import_sequences(config, filename)
+      r.Headers = append(r.Headers, filename)
+      r.Counts  = append(r.Counts , merge_kmer_counts(binarize, scan_sequences(config, kmersCounter, binarize, sequences, qualities)...))
+    }
+  } else
+  if is_kmer_cache(filename) {
+    cache := ImportKmerCache(config, filename)
+    if err := cache.Matches(kmersCounter, binarize); err != nil {
+      log.Fatalf("k-mer count cache `%s': %v", filename, err)
+    }
+    cache = cache.Select(label)
+    r.Headers = cache.Headers
+    r.Counts  = cache.Counts
+    if cache.Labelled {
+      r.Seqindex = cache.Seqindex
+    }
+  } else {
+    r.Headers, r.Sequences, r.Qualities = import_sequences(config, filename)
+  }
+  if r.Seqindex == nil {
+    r.Seqindex = make([]int, len(r.Headers))
+    for i := 0; i < len(r.Seqindex); i++ {
+      r.Seqindex[i] = i
+    }
+  }
+  return r
+}
+
+func (obj kmerSamples) Len() int {
+  return len(obj.Headers)
+}
+
+func (obj kmerSamples) Slice(i, j int) kmerSamples {
+  r := kmerSamples{}
+  r.Headers  = obj.Headers [i:j]
+  r.Seqindex = obj.Seqindex[i:j]
+  if obj.Counts != nil {
+    r.Counts    = obj.Counts   [i:j]
+  } else {
+    r.Sequences = obj.Sequences[i:j]
+  }
+  if obj.Qualities != nil {
+    r.Qualities = obj.Qualities[i:j]
+  }
+  return r
+}
+
+// Returns k-mer counts, sequences are only scanned if counts were not
+// imported from a cache
+func (obj kmerSamples) Scan(config Config, kmersCounter *KmerCounter, binarize bool) []KmerCounts {
+  if obj.Counts != nil {
+    return obj.Counts
+  }
+  return scan_sequences(config, kmersCounter, binarize, obj.Sequences, obj.Qualities)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func reduce_samples(config Config, fg, bg kmerSamples) (kmerSamples, kmerSamples) {
+  if config.MaxSamples == 0 || fg.Len() + bg.Len() <= config.MaxSamples {
     return fg, bg
   }
-  n1 := len(fg)
-  n2 := len(bg)
+  n1 := fg.Len()
+  n2 := bg.Len()
   m1 := int(float64(n1)/float64(n1+n2)*float64(config.MaxSamples))
   m2 := config.MaxSamples - m1
   if m1 <= 0 || m2 <= 0 {
@@ -298,52 +661,138 @@ func reduce_samples(config Config, fg, bg []string) ([]string, []string) {
   } else {
     PrintStderr(config, 1, "Reduced training data from (%d,%d) to (%d,%d) samples\n", n1, n2, m1, m2)
   }
-  return fg[0:m1], bg[0:m2]
+  return fg.Slice(0, m1), bg.Slice(0, m2)
 }
 
 /* -------------------------------------------------------------------------- */
 
 func compile_training_data(config Config, kmersCounter *KmerCounter, kmers KmerClassList, features FeatureIndices, generate_features bool, binarize bool, filename_fg, filename_bg string) KmerDataSet {
-  fg := import_fasta(config, filename_fg)
-  bg := import_fasta(config, filename_bg)
+  fg     := import_samples(config, kmersCounter, binarize, filename_fg, 1)
+  bg     := import_samples(config, kmersCounter, binarize, filename_bg, 0)
+  n_fg   := fg.Len()
+  groups := import_cv_groups(config, append(append([]string{}, fg.Headers...), bg.Headers...), fg.Len()+bg.Len())
+  weights := import_sample_weights(config, append(append([]string{}, fg.Headers...), bg.Headers...), fg.Len()+bg.Len())
   fg, bg  = reduce_samples(config, fg, bg)
-  labels := make([]bool, len(fg)+len(bg))
-  for i := 0; i < len(fg); i++ {
+  if groups != nil {
+    groups = append(append([]string{}, groups[0:fg.Len()]...), groups[n_fg:n_fg+bg.Len()]...)
+  }
+  if weights != nil {
+    weights = append(append([]float64{}, weights[0:fg.Len()]...), weights[n_fg:n_fg+bg.Len()]...)
+  }
+  seqnames := make([]string, fg.Len()+bg.Len())
+  seqindex := make([]int   , fg.Len()+bg.Len())
+  for i := 0; i < fg.Len(); i++ {
+    seqnames[i] = fasta_header_name(fg.Headers[i])
+    seqindex[i] = fg.Seqindex[i]
+  }
+  for i := 0; i < bg.Len(); i++ {
+    seqnames[fg.Len()+i] = fasta_header_name(bg.Headers[i])
+    seqindex[fg.Len()+i] = bg.Seqindex[i]
+  }
+  labels := make([]bool, fg.Len()+bg.Len())
+  for i := 0; i < fg.Len(); i++ {
     labels[i] = true
   }
-  counts_fg   := scan_sequences(config, kmersCounter, binarize, fg)
-  counts_bg   := scan_sequences(config, kmersCounter, binarize, bg)
+  counts_fg   := fg.Scan(config, kmersCounter, binarize)
+  counts_bg   := bg.Scan(config, kmersCounter, binarize)
   counts_list := NewKmerCountsList(append(counts_fg, counts_bg...)...)
   if len(kmers) != 0 {
     counts_list.SetKmers(kmers)
   }
-  counts_list_fg := counts_list.Slice(      0, len(fg))
-  counts_list_bg := counts_list.Slice(len(fg), len(fg)+len(bg))
+  counts_list_fg := counts_list.Slice(       0, fg.Len())
+  counts_list_bg := counts_list.Slice(fg.Len(), fg.Len()+bg.Len())
   r_fg := convert_counts_list(config, &counts_list_fg, features, generate_features)
   r_bg := convert_counts_list(config, &counts_list_bg, features, generate_features)
-  return KmerDataSet{append(r_fg, r_bg...), labels, counts_list.Kmers}
+  return KmerDataSet{Data: append(r_fg, r_bg...), Labels: labels, Kmers: counts_list.Kmers, Groups: groups, Seqnames: seqnames, Seqindex: seqindex, Weights: weights}
+}
+
+// Compile training data for multinomial models, where each file contains the
+// samples of one class
+func compile_training_data_multinomial(config Config, kmersCounter *KmerCounter, kmers KmerClassList, features FeatureIndices, generate_features bool, binarize bool, filenames []string) KmerDataSet {
+  samples := make([]kmerSamples, len(filenames))
+  headers := []string{}
+  for k, filename := range filenames {
+    samples[k] = import_samples(config, kmersCounter, binarize, filename, -1)
+    headers    = append(headers, samples[k].Headers...)
+  }
+  groups   := import_cv_groups(config, headers, len(headers))
+  weights  := import_sample_weights(config, headers, len(headers))
+  classes  := make([]int   , len(headers))
+  seqnames := make([]string, len(headers))
+  seqindex := make([]int   , len(headers))
+  counts   := []KmerCounts{}
+  for k, i := 0, 0; k < len(samples); k++ {
+    for j := 0; j < samples[k].Len(); j, i = j+1, i+1 {
+      classes [i] = k
+      seqnames[i] = fasta_header_name(samples[k].Headers[j])
+      seqindex[i] = samples[k].Seqindex[j]
+    }
+    counts = append(counts, samples[k].Scan(config, kmersCounter, binarize)...)
+  }
+  counts_list := NewKmerCountsList(counts...)
+  if len(kmers) != 0 {
+    counts_list.SetKmers(kmers)
+  }
+  data := convert_counts_list(config, &counts_list, features, generate_features)
+  return KmerDataSet{Data: data, Classes: classes, Kmers: counts_list.Kmers, Groups: groups, Seqnames: seqnames, Seqindex: seqindex, Weights: weights}
+}
+
+// Compile training data for regression models, where responses are
+// extracted from the fasta headers or a separate file
+func compile_training_data_regression(config Config, kmersCounter *KmerCounter, kmers KmerClassList, features FeatureIndices, generate_features bool, binarize bool, filename string) KmerDataSet {
+  samples   := import_samples(config, kmersCounter, binarize, filename, -1)
+  groups    := import_cv_groups(config, samples.Headers, samples.Len())
+  weights   := import_sample_weights(config, samples.Headers, samples.Len())
+  responses := import_responses(config, samples.Headers, samples.Len())
+  if config.MaxSamples != 0 && samples.Len() > config.MaxSamples {
+    PrintStderr(config, 1, "Reduced training data from %d to %d samples\n", samples.Len(), config.MaxSamples)
+    samples   = samples.Slice(0, config.MaxSamples)
+    responses = responses[0:config.MaxSamples]
+    if groups != nil {
+      groups  = groups [0:config.MaxSamples]
+    }
+    if weights != nil {
+      weights = weights[0:config.MaxSamples]
+    }
+  }
+  seqnames := make([]string, samples.Len())
+  for i := 0; i < samples.Len(); i++ {
+    seqnames[i] = fasta_header_name(samples.Headers[i])
+  }
+  counts_list := NewKmerCountsList(samples.Scan(config, kmersCounter, binarize)...)
+  if len(kmers) != 0 {
+    counts_list.SetKmers(kmers)
+  }
+  data := convert_counts_list(config, &counts_list, features, generate_features)
+  return KmerDataSet{Data: data, Kmers: counts_list.Kmers, Groups: groups, Seqnames: seqnames, Seqindex: samples.Seqindex, Weights: weights, Responses: responses}
 }
 
 func compile_test_data(config Config, kmersCounter *KmerCounter, kmers KmerClassList, features FeatureIndices, generate_features bool, binarize bool, filename string) KmerDataSet {
-  sequences   := import_fasta(config, filename)
-  counts      := scan_sequences(config, kmersCounter, binarize, sequences)
+  samples     := import_samples(config, kmersCounter, binarize, filename, -1)
+  counts      := samples.Scan(config, kmersCounter, binarize)
   counts_list := NewKmerCountsList(counts...)
   // set counts_list.Kmers to the set of kmers on which the
   // classifier was trained on
   counts_list.SetKmers(kmers)
-  return KmerDataSet{convert_counts_list(config, &counts_list, features, generate_features), nil, counts_list.Kmers}
+  seqnames := make([]string, samples.Len())
+  for i := 0; i < samples.Len(); i++ {
+    seqnames[i] = fasta_header_name(samples.Headers[i])
+  }
+  return KmerDataSet{Data: convert_counts_list(config, &counts_list, features, generate_features), Kmers: counts_list.Kmers, Seqnames: seqnames, Seqindex: samples.Seqindex}
 }
 
 /* -------------------------------------------------------------------------- */
 
+// Samples of the first file are labeled as foreground and samples of all other
+// files as background
 func compile_data(config Config, kmersCounter *KmerCounter, kmers KmerClassList, features FeatureIndices, generate_features bool, binarize bool, filenames []string) []KmerDataSet {
   r := make([]KmerDataSet, len(filenames))
   c := make([]KmerCounts , 0)
   k := make([]int        , len(filenames)+1)
   for i, filename := range filenames {
-    seq   := import_fasta(config, filename)
-    c      = append(c, scan_sequences(config, kmersCounter, binarize, seq)...)
-    k[i+1] = len(c)
+    samples := import_samples(config, kmersCounter, binarize, filename, -1)
+    c        = append(c, samples.Scan(config, kmersCounter, binarize)...)
+    k[i+1]   = len(c)
   }
   counts_list := NewKmerCountsList(c...)
   if len(kmers) != 0 {
@@ -351,7 +800,11 @@ func compile_data(config Config, kmersCounter *KmerCounter, kmers KmerClassList,
   }
   for i, _ := range filenames {
     tmp := counts_list.Slice(k[i], k[i+1])
-    r[i] = KmerDataSet{convert_counts_list(config, &tmp, features, generate_features), nil, counts_list.Kmers}
+    r[i] = KmerDataSet{Data: convert_counts_list(config, &tmp, features, generate_features), Kmers: counts_list.Kmers}
+    r[i].Labels = make([]bool, len(r[i].Data))
+    for j := 0; j < len(r[i].Labels); j++ {
+      r[i].Labels[j] = i == 0
+    }
   }
   return r
 }
@@ -18,6 +18,7 @@ package main
 
 /* -------------------------------------------------------------------------- */
 
+import   "encoding/json"
 import   "fmt"
 import   "log"
 import   "math"
@@ -26,6 +27,7 @@ import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/autodiff/statistics"
 import   "github.com/pbenner/autodiff/statistics/vectorDistribution"
 import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
+import   "github.com/pbenner/threadpool"
 
 import . "github.com/pbenner/gonetics"
 
@@ -37,7 +39,16 @@ type KmerLrEstimator struct {
   EpsilonLoss  float64
   reduced_data KmerDataSet
   trace        Trace
+  // selected lambda for each estimated classifier
+  lambda     []float64
+  // groups of selected features (group lasso)
+  groups       featureGroups
+  // cross-validation fold
+  icv          int
   path         KmerRegularizationPath
+  // checkpoint and index of this estimator within the ensemble
+  checkpoint  *Checkpoint
+  member       int
 }
 
 /* -------------------------------------------------------------------------- */
@@ -56,6 +67,7 @@ func NewKmerLrEstimator(config Config, classifier *KmerLr, icv int) *KmerLrEstim
     r.LogisticRegression.Epsilon        = config.Epsilon
     r.LogisticRegression.StepSizeFactor = config.StepSizeFactor
     r.LogisticRegression.Hook           = NewHook(config, icv, r)
+    r.icv                               = icv
     if config.MaxIterations != 0 {
       r.LogisticRegression.MaxIterations = config.MaxIterations
     }
@@ -85,6 +97,7 @@ func (obj *KmerLrEstimator) Reset() {
     obj.LogisticRegression = *estimator
     obj.Features           = FeatureIndices{}
     obj.Kmers              = KmerClassList {}
+    obj.groups             = featureGroups {}
   }
 }
 
@@ -115,6 +128,9 @@ func (obj *KmerLrEstimator) estimate_debug_gradient(config Config, data KmerData
     } else {
       s.Mul(s, ConstFloat64(obj.ClassWeights[0]))
     }
+    if data.Weights != nil {
+      s.Mul(s, ConstFloat64(data.Weights[i]))
+    }
     r.Add(r, s)
   }
   r.Neg(r)
@@ -128,8 +144,22 @@ func (obj *KmerLrEstimator) reestimate(config Config, transform Transform, coocc
   // reestimate parameters without penalty
   estimator      := obj.LogisticRegression.Clone()
   estimator.L1Reg = 0.0
-  if err := estimator.Estimate(nil, config.PoolSaga); err != nil {
-    log.Fatal(err)
+  estimator.L2Reg = 0.0
+  switch {
+  case config.Regression != "":
+    estimator.Theta = NewDenseFloat64Vector(estimate_proximal_regression(config, estimator, obj.reduced_data.Data, obj.reduced_data.Responses, obj.reduced_data.Weights, featureGroups{}))
+  case config.Optimizer == "coordinate":
+    estimator.Theta = NewDenseFloat64Vector(estimate_coordinate(config, estimator, obj.reduced_data.Data, obj.reduced_data.Labels, obj.reduced_data.Weights))
+  case config.Optimizer == "proximal":
+    estimator.Theta = NewDenseFloat64Vector(estimate_proximal  (config, estimator, obj.reduced_data.Data, obj.reduced_data.Labels, obj.reduced_data.Weights, featureGroups{}))
+  default:
+    if obj.reduced_data.Weights != nil {
+      estimator.Theta = NewDenseFloat64Vector(estimate_saga(config, estimator, obj.reduced_data.Data, obj.reduced_data.Labels, obj.reduced_data.Weights, featureGroups{}))
+    } else {
+      if err := estimator.Estimate(nil, config.PoolSaga); err != nil {
+        log.Fatal(err)
+      }
+    }
   }
   if r_, err := estimator.GetEstimate(); err != nil {
     log.Fatal(err)
@@ -140,6 +170,8 @@ func (obj *KmerLrEstimator) reestimate(config Config, transform Transform, coocc
     r.KmerLrFeatures = obj.KmerLrFeatures
     r.Cooccurrence   = cooccurrence
     r.Transform      = transform
+    r.Penalty        = NewPenalty(config, 0.0)
+    r.Regression     = config.Regression
     return r
   }
 }
@@ -149,11 +181,24 @@ func (obj *KmerLrEstimator) estimate(config Config, data KmerDataSet, transform
   if debug {
     obj.estimate_debug_gradient(config, data)
   } else {
-    if err := obj.LogisticRegression.SetSparseData(data.Data, data.Labels, len(data.Data)); err != nil {
-      log.Fatal(err)
-    }
-    if err := obj.LogisticRegression.Estimate(nil, config.PoolSaga); err != nil {
-      log.Fatal(err)
+    switch {
+    case config.Regression != "":
+      obj.Theta = NewDenseFloat64Vector(estimate_proximal_regression(config, &obj.LogisticRegression, data.Data, data.Responses, data.Weights, obj.groups))
+    case config.Optimizer == "coordinate":
+      obj.Theta = NewDenseFloat64Vector(estimate_coordinate(config, &obj.LogisticRegression, data.Data, data.Labels, data.Weights))
+    case config.Optimizer == "proximal":
+      obj.Theta = NewDenseFloat64Vector(estimate_proximal  (config, &obj.LogisticRegression, data.Data, data.Labels, data.Weights, obj.groups))
+    default:
+      if obj.L1Reg != 0.0 && (obj.L2Reg != 0.0 || !obj.groups.Nil()) || data.Weights != nil {
+        obj.Theta = NewDenseFloat64Vector(estimate_saga(config, &obj.LogisticRegression, data.Data, data.Labels, data.Weights, obj.groups))
+      } else {
+        if err := obj.LogisticRegression.SetSparseData(data.Data, data.Labels, len(data.Data)); err != nil {
+          log.Fatal(err)
+        }
+        if err := obj.LogisticRegression.Estimate(nil, config.PoolSaga); err != nil {
+          log.Fatal(err)
+        }
+      }
     }
   }
   if r_, err := obj.LogisticRegression.GetEstimate(); err != nil {
@@ -165,6 +210,8 @@ func (obj *KmerLrEstimator) estimate(config Config, data KmerDataSet, transform
     r.KmerLrFeatures = obj.KmerLrFeatures
     r.Cooccurrence   = cooccurrence
     r.Transform      = transform
+    r.Penalty        = NewPenaltyL1(config, obj.L1Reg/float64(len(data.Data)))
+    r.Regression     = config.Regression
     if config.SavePath {
       obj.path.Append(-1, obj.L1Reg/float64(len(data.Data)), r.KmerLrFeatures.Kmers, r.Theta[1:])
     }
@@ -181,48 +228,94 @@ func (obj *KmerLrEstimator) estimate_fixed(config Config, data KmerDataSet, tran
   }
   m, _ := obj.n_params(config, data.Data, 0, cooccurrence)
   // compute class weights
-  obj.LogisticRegression.SetLabels(data.Labels)
+  set_class_weights(config, &obj.LogisticRegression, data.Labels)
   // create a copy of data arrays, from which to select subsets
-  obj.reduced_data.Data   = make([]ConstVector, len(data.Data))
-  obj.reduced_data.Labels = data.Labels
+  obj.reduced_data.Data      = make([]ConstVector, len(data.Data))
+  obj.reduced_data.Labels    = data.Labels
+  obj.reduced_data.Weights   = data.Weights
+  obj.reduced_data.Responses = data.Responses
   s := newFeatureSelector(config, data.Kmers, nil, nil, cooccurrence, data.Labels, transform, obj.ClassWeights, m, 0, config.EpsilonLambda)
-  r := (*KmerLr)(nil)
-  for epoch := 0; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
-    selection, ok := s.SelectFixed(data.Data, AsDenseFloat64Vector(obj.Theta), obj.Features, obj.Kmers, nil, nil, config.Lambda, config.MaxFeatures)
+  s.Groups    = kmer_groups(config, obj.KmerLrEquivalence, data.Kmers)
+  s.Weights   = data.Weights
+  s.Responses = data.Responses
+  r, epoch := obj.checkpointRestore(config, 0)
+  if r != nil {
+    return r
+  }
+  penalty := NewPenalty(config, config.Lambda)
+  s.Lambda2 = penalty.L2()
+  for ; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+    if checkpointStopRequested() {
+      break
+    }
+    selection, ok := s.SelectFixed(data.Data, AsDenseFloat64Vector(obj.Theta), obj.Features, obj.Kmers, nil, nil, penalty.L1(), config.MaxFeatures)
     if !ok && r != nil {
       break
     }
-    obj.L1Reg    = config.Lambda*float64(len(data.Data))
+    obj.L1Reg    = penalty.L1()*float64(len(data.Data))
+    obj.L2Reg    = penalty.L2()*float64(len(data.Data))
     obj.Features = selection.Features()
     obj.Kmers    = selection.Kmers()
     obj.Theta    = selection.Theta()
+    obj.groups   = selection.Groups()
     // create actual training data set
     selection.Data(config, obj.reduced_data.Data, data.Data)
 
     PrintStderr(config, 1, "Estimating parameters with lambda=%e and %d features...\n", config.Lambda, len(obj.Features))
     r = obj.estimate(config, obj.reduced_data, selection.Transform(), cooccurrence, false)
+    obj.checkpointSave(config, 0, epoch+1, r, nil, false)
+  }
+  if checkpointStopRequested() {
+    obj.checkpointSave(config, 0, epoch, r, nil, true)
+  } else {
+    obj.checkpointSave(config, 0, epoch, r, r, true)
   }
   obj.reduced_data = KmerDataSet{}
   return r
 }
 
+func (obj *KmerLrEstimator) newFeatureSelector(config Config, data KmerDataSet, transform TransformFull, cooccurrence bool) featureSelector {
+  m, _ := obj.n_params(config, data.Data, 0, cooccurrence)
+  // compute class weights
+  set_class_weights(config, &obj.LogisticRegression, data.Labels)
+  s := newFeatureSelector(config, data.Kmers, nil, nil, cooccurrence, data.Labels, transform, obj.ClassWeights, m, 0, config.EpsilonLambda)
+  s.Groups    = kmer_groups(config, obj.KmerLrEquivalence, data.Kmers)
+  s.Weights   = data.Weights
+  s.Responses = data.Responses
+  return s
+}
+
 func (obj *KmerLrEstimator) estimate_loop(config Config, data KmerDataSet, transform TransformFull, lambdaAuto int, cooccurrence bool) *KmerLr {
   if len(data.Data) == 0 {
     return nil
   }
+  return obj.estimate_loop_(config, data, obj.newFeatureSelector(config, data, transform, cooccurrence), -1, lambdaAuto, cooccurrence)
+}
+
+// Estimate classifier with lambdaAuto non-zero coefficients, where i is the
+// index of lambdaAuto in the checkpoint (-1 if no checkpoint is used)
+func (obj *KmerLrEstimator) estimate_loop_(config Config, data KmerDataSet, s featureSelector, i, lambdaAuto int, cooccurrence bool) *KmerLr {
   if len(data.Kmers) != data.Data[0].Dim()-1 {
     panic("internal error")
   }
+  r, epoch := obj.checkpointRestore(config, i)
+  if r != nil {
+    return r
+  }
   debug := false
-  m, n := obj.n_params(config, data.Data, lambdaAuto, cooccurrence)
+  _, n  := obj.n_params(config, data.Data, lambdaAuto, cooccurrence)
   // compute class weights
-  obj.LogisticRegression.SetLabels(data.Labels)
+  set_class_weights(config, &obj.LogisticRegression, data.Labels)
   // create a copy of data arrays, from which to select subsets
-  obj.reduced_data.Data   = make([]ConstVector, len(data.Data))
-  obj.reduced_data.Labels = data.Labels
-  s := newFeatureSelector(config, data.Kmers, nil, nil, cooccurrence, data.Labels, transform, obj.ClassWeights, m, n, config.EpsilonLambda)
-  r := (*KmerLr)(nil)
-  for epoch := 0; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+  obj.reduced_data.Data      = make([]ConstVector, len(data.Data))
+  obj.reduced_data.Labels    = data.Labels
+  obj.reduced_data.Weights   = data.Weights
+  obj.reduced_data.Responses = data.Responses
+  s.N = n
+  for ; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+    if checkpointStopRequested() {
+      break
+    }
     // select features on the initial data set
     if r != nil {
       d := r.Nonzero()
@@ -232,39 +325,202 @@ func (obj *KmerLrEstimator) estimate_loop(config Config, data KmerDataSet, trans
         PrintStderr(config, 1, "Estimated classifier has %d non-zero coefficients, selecting %d new features...\n", d, n-d)
       }
     }
+    s.Lambda2 = obj.L2Reg/float64(len(data.Data))
     selection, lambda, ok := s.Select(data.Data, obj.Theta, obj.Features, obj.Kmers, nil, nil, obj.L1Reg, debug)
     if !ok && r != nil {
       break
     }
-    obj.L1Reg    = lambda*float64(len(data.Data))
+    penalty     := NewPenaltyL1(config, lambda)
+    obj.L1Reg    = penalty.L1()*float64(len(data.Data))
+    obj.L2Reg    = penalty.L2()*float64(len(data.Data))
     obj.Features = selection.Features()
     obj.Kmers    = selection.Kmers()
     obj.Theta    = selection.Theta()
+    obj.groups   = selection.Groups()
     // create actual training data set
     selection.Data(config, obj.reduced_data.Data, data.Data)
 
-    PrintStderr(config, 1, "Estimating parameters with lambda=%e...\n", lambda)
+    PrintStderr(config, 1, "Estimating parameters with lambda=%e...\n", penalty.Lambda)
     r = obj.estimate(config, obj.reduced_data, selection.Transform(), cooccurrence, debug)
+    obj.checkpointSave(config, i, epoch+1, r, nil, false)
+  }
+  if checkpointStopRequested() {
+    // return current estimate without re-estimation
+    obj.checkpointSave(config, i, epoch, r, nil, true)
+    obj.reduced_data = KmerDataSet{}
+    return r
   }
+  result := r
   if config.PenaltyFree && r != nil {
     PrintStderr(config, 1, "Re-estimating parameters without penalty...\n")
-    r = obj.reestimate(config, r.Transform, cooccurrence)
+    result = obj.reestimate(config, r.Transform, cooccurrence)
   }
+  obj.checkpointSave(config, i, epoch, r, result, true)
   obj.reduced_data = KmerDataSet{}
-  return r
+  return result
 }
 
 func (obj *KmerLrEstimator) Estimate(config Config, data KmerDataSet, transform TransformFull) []*KmerLr {
   if !math.IsNaN(config.Lambda) {
     classifiers   := make([]*KmerLr, 1)
     classifiers[0] = obj.estimate_fixed(config, data, transform, obj.Cooccurrence)
+    obj.lambda     = []float64{config.Lambda}
     return classifiers
   } else {
-    classifiers := make([]*KmerLr, len(config.LambdaAuto))
-    for i, lambdaAuto := range config.LambdaAuto {
-      PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", lambdaAuto)
-      classifiers[i] = obj.estimate_loop(config, data, transform, lambdaAuto, obj.Cooccurrence)
+    if config.PoolPath.NumberOfThreads() > 1 {
+      return obj.estimate_path_parallel(config, data, transform)
+    } else {
+      return obj.estimate_path(config, data, transform)
     }
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Estimate classifiers for all values of --lambda-auto by continuation, i.e.
+// sizes are processed in increasing order and each estimation is warm-started
+// with the solution and active set of the previous one; the feature selector
+// is shared so that the gradient on the full data set at the previous solution
+// is not recomputed
+func (obj *KmerLrEstimator) estimate_path(config Config, data KmerDataSet, transform TransformFull) []*KmerLr {
+  classifiers := make([]*KmerLr, len(config.LambdaAuto))
+  obj.lambda   = make([]float64, len(config.LambdaAuto))
+  if len(data.Data) == 0 {
     return classifiers
   }
+  s := obj.newFeatureSelector(config, data, transform, obj.Cooccurrence)
+  // --lambda-auto values are sorted in increasing order
+  for i, _ := range config.LambdaAuto {
+    if len(obj.Features) > 0 {
+      PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients (warm-start with %d features)...\n", config.LambdaAuto[i], len(obj.Features))
+    } else {
+      PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", config.LambdaAuto[i])
+    }
+    classifiers[i] = obj.estimate_loop_(config, data, s, i, config.LambdaAuto[i], obj.Cooccurrence)
+    obj.lambda [i] = NewPenaltyL1(config, obj.L1Reg/float64(len(data.Data))).Lambda
+    if checkpointStopRequested() {
+      break
+    }
+  }
+  return classifiers
+}
+
+// Estimate classifiers for all values of --lambda-auto independently and in
+// parallel, where only the gradient at the initial solution is shared
+func (obj *KmerLrEstimator) estimate_path_parallel(config Config, data KmerDataSet, transform TransformFull) []*KmerLr {
+  classifiers := make([]*KmerLr, len(config.LambdaAuto))
+  estimators  := make([]*KmerLrEstimator, len(config.LambdaAuto))
+  obj.lambda   = make([]float64, len(config.LambdaAuto))
+  if len(data.Data) == 0 {
+    return classifiers
+  }
+  s := obj.newFeatureSelector(config, data, transform, obj.Cooccurrence)
+  // evaluate gradient at the initial solution, which is
+  // the same for all estimators
+  s.Lambda2   = obj.L2Reg/float64(len(data.Data))
+  t, _, _    := s.restoreNonzero(obj.Theta, obj.Features, obj.Kmers, nil)
+  s.gradient(data.Data, t)
+  for i := 0; i < len(estimators); i++ {
+    estimators[i] = obj.fork(config)
+  }
+  config.PoolPath.RangeJob(0, len(estimators), func(i int, pool threadpool.ThreadPool, erf func() error) error {
+    config := config; config.PoolPath = pool
+
+    PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", config.LambdaAuto[i])
+    classifiers[i] = estimators[i].estimate_loop_(config, data, s, i, config.LambdaAuto[i], obj.Cooccurrence)
+    return nil
+  })
+  for i, estimator := range estimators {
+    obj.lambda[i] = NewPenaltyL1(config, estimator.L1Reg/float64(len(data.Data))).Lambda
+    obj.trace.AppendTrace(estimator.trace)
+    obj.path .AppendPath (-1, estimator.path)
+  }
+  // continue with the solution of the largest model
+  if n := len(estimators); n > 0 {
+    obj.join(estimators[n-1])
+  }
+  return classifiers
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Create an independent copy of the estimator, which shares no state with
+// the original estimator
+func (obj *KmerLrEstimator) fork(config Config) *KmerLrEstimator {
+  r := &KmerLrEstimator{}
+  r.KmerLrFeatures          = obj.KmerLrFeatures.Clone()
+  r.EpsilonLoss             = obj.EpsilonLoss
+  r.LogisticRegression      = *obj.LogisticRegression.Clone()
+  r.LogisticRegression.Hook = NewHook(config, obj.icv, r)
+  r.groups                  = obj.groups
+  r.icv                     = obj.icv
+  r.checkpoint              = obj.checkpoint
+  r.member                  = obj.member
+  return r
+}
+
+// Copy the current solution of a forked estimator
+func (obj *KmerLrEstimator) join(estimator *KmerLrEstimator) {
+  obj.Theta          = estimator.Theta
+  obj.L1Reg          = estimator.L1Reg
+  obj.L2Reg          = estimator.L2Reg
+  obj.KmerLrFeatures = estimator.KmerLrFeatures
+  obj.groups         = estimator.groups
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Save state of the estimator for the i-th value of --lambda-auto, where r
+// is the current estimate and result the final classifier, which is nil if
+// the estimation is not yet complete
+func (obj *KmerLrEstimator) checkpointSave(config Config, i, epoch int, r, result *KmerLr, force bool) {
+  if obj.checkpoint == nil || i < 0 || r == nil {
+    return
+  }
+  state := CheckpointState{}
+  state.Model = r.exportCheckpoint()
+  state.L1Reg = obj.L1Reg
+  state.L2Reg = obj.L2Reg
+  state.Epoch = epoch
+  state.Trace = obj.trace
+  if result != nil {
+    t := result.exportCheckpoint()
+    state.Result = &t
+  }
+  if b, err := json.Marshal(obj.path); err != nil {
+    log.Fatal(err)
+  } else {
+    state.Path = b
+  }
+  obj.checkpoint.Update(config, obj.member, i, &state, force)
+}
+
+// Restore state of the estimator for the i-th value of --lambda-auto, returns
+// the final classifier if the estimation is already complete and otherwise the
+// epoch at which the estimation should continue
+func (obj *KmerLrEstimator) checkpointRestore(config Config, i int) (*KmerLr, int) {
+  state := obj.checkpoint.Get(obj.member, i)
+  if state == nil {
+    return nil, 0
+  }
+  r := importKmerLrCheckpoint(state.Model)
+  path := KmerRegularizationPath{}
+  if err := json.Unmarshal(state.Path, &path); err != nil {
+    log.Fatal(err)
+  }
+  obj.Theta    = DenseFloat64Vector(r.Theta)
+  obj.Features = r.Features
+  obj.Kmers    = r.Kmers
+  obj.L1Reg    = state.L1Reg
+  obj.L2Reg    = state.L2Reg
+  obj.trace    = state.Trace
+  obj.path     = KmerRegularizationPath{}
+  obj.path.AppendPath(-1, path)
+  if state.Result != nil {
+    PrintStderr(config, 1, "Restored classifier with %d features from checkpoint\n", len(r.Features))
+    return importKmerLrCheckpoint(*state.Result), state.Epoch
+  } else {
+    PrintStderr(config, 1, "Restored estimate with %d features from checkpoint (epoch %d)\n", len(r.Features), state.Epoch)
+    return nil, state.Epoch
+  }
 }
@@ -19,24 +19,27 @@ package main
 /* -------------------------------------------------------------------------- */
 
 //import   "fmt"
-import   "log"
 import   "math"
 
 import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/autodiff/logarithmetic"
-import   "github.com/pbenner/autodiff/statistics/vectorDistribution"
+import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
 
 /* -------------------------------------------------------------------------- */
 
-func (obj *KmerLrEstimator) estimate_coordinate_loop(config Config, data_train KmerDataSet, y, w, theta0, theta1 []float64, iter int) int {
-  inner_xy := make(  []float64, len(theta0))
-  inner_xx := make([][]float64, len(theta0))
-  norm     := make(  []float64, len(theta0))
-  for j := 0; j < len(theta0); j++ {
-    inner_xx[j] = make([]float64, len(theta0))
+// Minimize the penalized weighted least squares problem
+//   1/2 sum_i w_i (y_i - <x_i, theta>)^2 + L1Reg |theta|_1 + L2Reg/2 |theta|_2^2
+// with cyclic coordinate descent, where the intercept is not penalized
+func estimate_coordinate_loop(estimator *vectorEstimator.LogisticRegression, data []ConstVector, y, w, theta1 []float64, iter int) int {
+  theta0   := make(  []float64, len(theta1))
+  inner_xy := make(  []float64, len(theta1))
+  inner_xx := make([][]float64, len(theta1))
+  norm     := make(  []float64, len(theta1))
+  for j := 0; j < len(theta1); j++ {
+    inner_xx[j] = make([]float64, len(theta1))
   }
   // initialize variables
-  for i_, xi := range data_train.Data {
+  for i_, xi := range data {
     for it := xi.ConstIterator(); it.Ok(); it.Next() {
       j1 := it.Index()
       // compute inner product between response y and feature vectors <y, x_j>
@@ -50,32 +53,39 @@ func (obj *KmerLrEstimator) estimate_coordinate_loop(config Config, data_train K
       }
     }
   }
-  for ; iter < obj.LogisticRegression.MaxIterations; iter++ {
+  for ; iter < estimator.MaxIterations; iter++ {
     // coordinate descent step
-    for j := 0; j < len(theta0); j++ {
+    for j := 0; j < len(theta1); j++ {
       theta1_j := inner_xy[j] + norm[j]*theta1[j]
-      for k := 0; k < len(theta0); k++ {
+      for k := 0; k < len(theta1); k++ {
         theta1_j -= inner_xx[j][k]*theta1[k]
       }
+      theta0[j] = theta1[j]
       if j > 0 {
         // apply proximal operator
         if theta1_j >= 0.0 {
-          theta1_j =  math.Max(math.Abs(theta1_j) - obj.L1Reg, 0.0)
+          theta1_j =  math.Max(math.Abs(theta1_j) - estimator.L1Reg, 0.0)
         } else {
-          theta1_j = -math.Max(math.Abs(theta1_j) - obj.L1Reg, 0.0)
+          theta1_j = -math.Max(math.Abs(theta1_j) - estimator.L1Reg, 0.0)
+        }
+        // normalize
+        if theta1_j != 0.0 {
+          theta1_j /= norm[j] + estimator.L2Reg
+        }
+      } else {
+        // normalize
+        if norm[j] != 0.0 {
+          theta1_j /= norm[j]
         }
       }
-      // normalize
-      theta1_j /= (norm[j] + obj.L2Reg)
-      theta0[j] = theta1[j]
       theta1[j] = theta1_j
     }
     // check convergence
-    if stop, delta := obj.eval_stopping(theta0, theta1); stop {
+    if stop, delta := eval_stopping(estimator.Epsilon, theta0, theta1); stop {
       break
     } else {
       // execute hook if available
-      if obj.LogisticRegression.Hook != nil && obj.LogisticRegression.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(obj.L1Reg), iter) {
+      if estimator.Hook != nil && estimator.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(estimator.L1Reg), iter) {
         break
       }
     }
@@ -83,57 +93,54 @@ func (obj *KmerLrEstimator) estimate_coordinate_loop(config Config, data_train K
   return iter
 }
 
-func (obj *KmerLrEstimator) estimate_coordinate(config Config, data_train KmerDataSet, transform Transform) *KmerLr {
-  obj.estimate_step_size(data_train.Data)
-  class_weights := compute_class_weights(data_train.Labels)
-  theta0  := []float64(obj.Theta)
-  theta0_ := []float64(obj.Theta)
-  theta1  := []float64(obj.Theta)
-  lr      := logisticRegression{theta1, obj.ClassWeights, 0.0, false, TransformFull{}, config.Pool}
-  w := make([]float64, len(data_train.Data))
-  z := make([]float64, len(data_train.Data))
-  for iter := 0; iter < obj.LogisticRegression.MaxIterations; iter++ {
+// Iteratively reweighted least squares, where each weighted least
+// squares problem is solved with coordinate descent
+func estimate_coordinate(config Config, estimator *vectorEstimator.LogisticRegression, data []ConstVector, labels []bool, weights []float64) []float64 {
+  // minimum probability, required to bound the weights away from zero
+  // for perfectly separated samples
+  const p_min = 1e-5
+  theta0 := make([]float64, estimator.Theta.Dim())
+  theta1 := make([]float64, estimator.Theta.Dim())
+  for k := 0; k < len(theta1); k++ {
+    theta1[k] = estimator.Theta.Float64At(k)
+  }
+  lr := logisticRegression{}
+  lr.Theta        = theta1
+  lr.ClassWeights = estimator.ClassWeights
+  lr.Weights      = weights
+  lr.Pool         = config.PoolLR
+  w := make([]float64, len(data))
+  z := make([]float64, len(data))
+  for iter := 0; iter < estimator.MaxIterations; iter++ {
     // compute linear approximation
-    for i := 0; i < len(data_train.Data); i++ {
-      r   := lr.LinearPdf(data_train.Data[i].(SparseConstFloat64Vector))
+    for i := 0; i < len(data); i++ {
+      r   := lr.LinearPdf(data[i].(SparseConstFloat64Vector))
       p   := math.Exp(-LogAdd(0.0, -r))
+      p    = math.Min(math.Max(p, p_min), 1.0 - p_min)
       w[i] = p*(1.0 - p)
-      if data_train.Labels[i] {
+      if labels[i] {
         z[i] = r + (1.0 - p)/w[i]
       } else {
         z[i] = r + (0.0 - p)/w[i]
       }
-      if data_train.Labels[i] {
-        w[i] *= class_weights[1]
-      } else {
-        w[i] *= class_weights[0]
-      }
+      w[i] *= lr.weight(i, labels[i])
     }
     // copy theta
     for j := 0; j < len(theta0); j++ {
       theta0[j] = theta1[j]
     }
-    iter = obj.estimate_coordinate_loop(config, data_train, z, w, theta0_, theta1, iter)
+    iter = estimate_coordinate_loop(estimator, data, z, w, theta1, iter)
 
     // check convergence
-    if stop, delta := obj.eval_stopping(theta0, theta1); stop {
+    if stop, delta := eval_stopping(estimator.Epsilon, theta0, theta1); stop {
       break
     } else {
       // execute hook if available
-      if obj.LogisticRegression.Hook != nil && obj.LogisticRegression.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(obj.L1Reg), iter) {
+      if estimator.Hook != nil && estimator.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(estimator.L1Reg), iter) {
         break
       }
     }
   }
-  obj.Theta = NewDenseFloat64Vector(theta1)
-  if r_, err := obj.LogisticRegression.GetEstimate(); err != nil {
-    log.Fatal(err)
-    return nil
-  } else {
-    r := &KmerLr{}
-    r.Theta          = r_.(*vectorDistribution.LogisticRegression).Theta.(DenseFloat64Vector)
-    r.KmerLrFeatures = obj.KmerLrFeatures
-    r.Transform      = transform
-    return r
-  }
+  return theta1
 }
+
@@ -37,6 +37,7 @@ func NewKmerLrEnsembleEstimator(config Config, classifier *KmerLrEnsemble, icv i
   estimators := make([]*KmerLrEstimator, config.EnsembleSize)
   for i, _ := range estimators {
     estimators[i] = NewKmerLrEstimator(config, classifier.GetComponent(0).Clone(), icv)
+    estimators[i].member = i
   }
   return KmerLrEstimatorEnsemble{estimators, classifier.Summary}
 }
@@ -53,6 +54,14 @@ func (obj KmerLrEstimatorEnsemble) CloneVectorEstimator() VectorEstimator {
 
 /* -------------------------------------------------------------------------- */
 
+func (obj KmerLrEstimatorEnsemble) SetCheckpoint(checkpoint *Checkpoint) {
+  for _, estimator := range obj.Estimators {
+    estimator.checkpoint = checkpoint
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
 func (obj KmerLrEstimatorEnsemble) GetTrace() Trace {
   trace := Trace{}
   for _, estimator := range obj.Estimators {
@@ -69,10 +78,22 @@ func (obj KmerLrEstimatorEnsemble) GetPath() KmerRegularizationPath {
   return path
 }
 
+// Selected lambda for each estimated classifier averaged over all members
+// of the ensemble
+func (obj KmerLrEstimatorEnsemble) GetLambda() []float64 {
+  r := make([]float64, len(obj.Estimators[0].lambda))
+  for _, estimator := range obj.Estimators {
+    for i, lambda := range estimator.lambda {
+      r[i] += lambda/float64(len(obj.Estimators))
+    }
+  }
+  return r
+}
+
 /* -------------------------------------------------------------------------- */
 
 func (obj KmerLrEstimatorEnsemble) estimate_ensemble(config Config, data_train KmerDataSet, transform TransformFull) []*KmerLrEnsemble {
-  groups, _ := getCvGroups(len(data_train.Data), config.EnsembleSize, config.ValidationSize, config.Seed)
+  groups, _ := getCvGroups(config, data_train.cvLabels(), data_train.Groups, config.EnsembleSize, config.ValidationSize, config.Seed)
   result    := make([]*KmerLrEnsemble, len(config.LambdaAuto))
   for i := 0; i < len(result); i++ {
     result[i] = NewKmerLrEnsemble(obj.Summary)
@@ -87,6 +108,10 @@ func (obj KmerLrEstimatorEnsemble) estimate_ensemble(config Config, data_train K
   })
   for k := 0; k < len(classifiers); k++ {
     for i, classifier := range classifiers[k] {
+      // classifiers are missing if the estimation was interrupted
+      if classifier == nil {
+        continue
+      }
       if err := result[i].AddKmerLr(classifier); err != nil {
         panic("internal error")
       }
@@ -95,7 +120,7 @@ func (obj KmerLrEstimatorEnsemble) estimate_ensemble(config Config, data_train K
   return result
 }
 
-func (obj KmerLrEstimatorEnsemble) Estimate(config Config, data_train, data_val, data_test KmerDataSet) ([]*KmerLrEnsemble, [][]float64, []float64, []float64) {
+func (obj KmerLrEstimatorEnsemble) Estimate(config Config, data_train, data_val, data_test KmerDataSet) ([]*KmerLrEnsemble, [][]float64, []float64, []float64, []float64) {
   if obj.Estimators[0].Cooccurrence && config.Copreselection != 0 {
     transform := TransformFull{}
     // estimate transform on full data set so that all estimated
@@ -103,6 +128,9 @@ func (obj KmerLrEstimatorEnsemble) Estimate(config Config, data_train, data_val,
     transform.Fit(config, append(data_train.Data, data_test.Data...), false)
     // reduce data_train and data_test to pre-selected features
     r := obj.Estimators[0].estimate_loop(config, data_train, transform, config.Copreselection, false)
+    if checkpointStopRequested() {
+      return nil, nil, nil, nil, nil
+    }
     r.Transform      = Transform{}
     data_train.Data  = r.SelectData(config, data_train)
     data_train.Kmers = r.Kmers
@@ -116,13 +144,23 @@ func (obj KmerLrEstimatorEnsemble) Estimate(config Config, data_train, data_val,
   transform := TransformFull{}
   transform.Fit(config, append(data_train.Data, data_test.Data...), obj.Estimators[0].Cooccurrence)
   classifiers := obj.estimate_ensemble(config, data_train, transform)
+  lambda      := obj.GetLambda()
+  if checkpointStopRequested() {
+    return classifiers, nil, nil, nil, lambda
+  }
+  return evaluate_classifiers(config, classifiers, lambda, data_train, data_val, data_test)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func evaluate_classifiers(config Config, classifiers []*KmerLrEnsemble, lambda []float64, data_train, data_val, data_test KmerDataSet) ([]*KmerLrEnsemble, [][]float64, []float64, []float64, []float64) {
   // if validation data is available, select best classifier...
   if len(data_val.Data) > 0 {
     i_best := 0
     v_best := math.Inf(1)
     for i, _ := range classifiers {
       d := classifiers[i].SelectData(config, data_val)
-      v := classifiers[i].Loss(config, d, data_val.Labels)
+      v := classifiers[i].LossDataSet(config, d, data_val)
       if v < v_best {
         i_best = i
         v_best = v
@@ -131,6 +169,7 @@ func (obj KmerLrEstimatorEnsemble) Estimate(config Config, data_train, data_val,
     }
     PrintStderr(config, 1, "> Selecting classifier %d\n", i_best)
     classifiers = []*KmerLrEnsemble{classifiers[i_best]}
+    lambda      = []float64{lambda[i_best]}
   }
   // evaluate classifier(s) on test data
   predictions := make([][]float64, len(classifiers))
@@ -139,9 +178,9 @@ func (obj KmerLrEstimatorEnsemble) Estimate(config Config, data_train, data_val,
   for i, _ := range classifiers {
     data_test_    := classifiers[i].SelectData(config, data_test)
     data_train_   := classifiers[i].SelectData(config, data_train)
-    predictions[i] = classifiers[i].Predict   (config, data_test_)
-    loss_train [i] = classifiers[i].Loss      (config, data_train_, data_train.Labels)
-    loss_test  [i] = classifiers[i].Loss      (config, data_test_ , data_test .Labels)
+    predictions[i] = classifiers[i].Predict    (config, data_test_)
+    loss_train [i] = classifiers[i].LossDataSet(config, data_train_, data_train)
+    loss_test  [i] = classifiers[i].LossDataSet(config, data_test_ , data_test )
   }
-  return classifiers, predictions, loss_train, loss_test
+  return classifiers, predictions, loss_train, loss_test, lambda
 }
@@ -37,7 +37,12 @@ func NewHook(config Config, icv int, estimator *KmerLrEstimator) HookType {
     lr := logisticRegression{}
     lr.Theta        = x.(DenseFloat64Vector)
     lr.Lambda       = lambda
+    lr.Lambda2      = estimator.L2Reg/float64(len(estimator.reduced_data.Data))
+    lr.Groups       = estimator.groups
     lr.ClassWeights = estimator.ClassWeights
+    lr.Weights      = estimator.reduced_data.Weights
+    lr.Regression   = config.Regression
+    lr.Responses    = estimator.reduced_data.Responses
     return lr.Loss(estimator.reduced_data.Data, estimator.reduced_data.Labels)
   }
   t := time.Now()
@@ -91,6 +96,9 @@ func NewHook(config Config, icv int, estimator *KmerLrEstimator) HookType {
       fmt.Printf("time: %-12v }\n", time.Since(t))
     }
     t = time.Now()
+    if checkpointStopRequested() {
+      return true
+    }
     if estimator.EpsilonLoss == 0.0 {
       return false
     } else {
@@ -21,7 +21,6 @@ package main
 import   "fmt"
 import   "bufio"
 import   "math"
-import   "os"
 
 import . "github.com/pbenner/gonetics"
 
@@ -33,13 +32,13 @@ type KmerRegularizationPath struct {
   Norm      []float64
   Theta   [][]float64
   Kmers     KmerClassList
-  Index     map[KmerClassId]int
+  Index     map[KmerClassId]int `json:"-"`
 }
 
 /* -------------------------------------------------------------------------- */
 
 func (obj KmerRegularizationPath) Export(filename string) error {
-  f, err := os.Create(filename)
+  f, err := create_file(filename)
   if err != nil {
     return err
   }
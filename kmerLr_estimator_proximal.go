@@ -19,15 +19,14 @@ package main
 /* -------------------------------------------------------------------------- */
 
 //import   "fmt"
-import   "log"
 import   "math"
 
 import . "github.com/pbenner/autodiff"
-import   "github.com/pbenner/autodiff/statistics/vectorDistribution"
+import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
 
 /* -------------------------------------------------------------------------- */
 
-func (obj *KmerLrEstimator) eval_stopping(xs, x1 []float64) (bool, float64) {
+func eval_stopping(epsilon float64, xs, x1 []float64) (bool, float64) {
   // evaluate stopping criterion
   max_x     := 0.0
   max_delta := 0.0
@@ -44,16 +43,23 @@ func (obj *KmerLrEstimator) eval_stopping(xs, x1 []float64) (bool, float64) {
   } else {
     delta = max_delta
   }
-  if max_x != 0.0 && max_delta/max_x <= obj.LogisticRegression.Epsilon ||
+  if max_x != 0.0 && max_delta/max_x <= epsilon ||
     (max_x == 0.0 && max_delta == 0.0) {
     return true, delta
   }
   return false, delta
 }
 
-func (obj *KmerLrEstimator) estimate_step_size(x []ConstVector) {
+func estimate_step_size(estimator *vectorEstimator.LogisticRegression, x []ConstVector, weights []float64) {
   max_squared_sum := 0.0
-  max_weight      := 1.0
+  max_weight      := math.Max(estimator.ClassWeights[0], estimator.ClassWeights[1])
+  if weights != nil {
+    max_sample_weight := 0.0
+    for _, w := range weights {
+      max_sample_weight = math.Max(max_sample_weight, w)
+    }
+    max_weight *= max_sample_weight
+  }
   for _, x := range x {
     r  := 0.0
     it := x.ConstIterator()
@@ -68,53 +74,64 @@ func (obj *KmerLrEstimator) estimate_step_size(x []ConstVector) {
       max_squared_sum = r
     }
   }
-  L := (0.25*(max_squared_sum + 1.0) + obj.L2Reg/float64(len(x)))
+  L := (0.25*(max_squared_sum + 1.0) + estimator.L2Reg/float64(len(x)))
   L *= max_weight
-  stepSize := 1.0/(2.0*L + math.Min(2.0*obj.L2Reg, L))
-  stepSize *= obj.StepSizeFactor
-  obj.SetStepSize(stepSize)
+  stepSize := 1.0/(2.0*L + math.Min(2.0*estimator.L2Reg, L))
+  stepSize *= estimator.StepSizeFactor
+  estimator.SetStepSize(stepSize)
 }
 
-func (obj *KmerLrEstimator) estimate_proximal(config Config, data_train KmerDataSet, transform Transform) *KmerLr {
-  obj.estimate_step_size(data_train.Data)
-  theta0 := []float64(obj.Theta)
-  theta1 := []float64(obj.Theta)
-  lr     := logisticRegression{theta1, obj.ClassWeights, 0.0, false, TransformFull{}, config.Pool}
-  for i := 0; i < obj.LogisticRegression.MaxIterations; i++ {
+// Proximal gradient descent (ISTA), where the gradient of the
+// logistic loss is averaged over samples and the regularization
+// strengths (L1Reg, L2Reg) are scaled by the number of samples; if
+// groups are given, the L1 penalty is replaced by the group lasso
+func estimate_proximal(config Config, estimator *vectorEstimator.LogisticRegression, data []ConstVector, labels []bool, weights []float64, groups featureGroups) []float64 {
+  estimate_step_size(estimator, data, weights)
+  n      := float64(len(data))
+  theta0 := make([]float64, estimator.Theta.Dim())
+  theta1 := make([]float64, estimator.Theta.Dim())
+  for k := 0; k < len(theta1); k++ {
+    theta1[k] = estimator.Theta.Float64At(k)
+  }
+  lr := logisticRegression{}
+  lr.Theta        = theta1
+  lr.ClassWeights = estimator.ClassWeights
+  lr.Weights      = weights
+  lr.Pool         = config.PoolLR
+  g  := make([]float64, len(theta1))
+  for i := 0; i < estimator.MaxIterations; i++ {
     // receive step size during each iteration, since the hook
     // might modify it
-    s := obj.GetStepSize()
-    g := lr .Gradient(nil, data_train.Data, data_train.Labels)
+    s := estimator.GetStepSize()
+    g  = lr.Gradient(g, data, labels)
     for k := 0; k < len(theta1); k++ {
       theta0[k] = theta1[k]
       theta1[k] = theta1[k] - s*g[k]
-      if k > 0 {
+      if k > 0 && groups.Nil() {
         if theta1[k] >= 0.0 {
-          theta1[k] =  math.Max(math.Abs(theta1[k]) - s*obj.L1Reg, 0.0)
+          theta1[k] =  math.Max(math.Abs(theta1[k]) - s*estimator.L1Reg/n, 0.0)
         } else {
-          theta1[k] = -math.Max(math.Abs(theta1[k]) - s*obj.L1Reg, 0.0)
+          theta1[k] = -math.Max(math.Abs(theta1[k]) - s*estimator.L1Reg/n, 0.0)
         }
+        theta1[k] /= 1.0 + s*estimator.L2Reg/n
+      }
+    }
+    if !groups.Nil() {
+      groups.Shrink(theta1, s*estimator.L1Reg/n)
+      for k := 1; k < len(theta1); k++ {
+        theta1[k] /= 1.0 + s*estimator.L2Reg/n
       }
     }
     // check convergence
-    if stop, delta := obj.eval_stopping(theta0, theta1); stop {
+    if stop, delta := eval_stopping(estimator.Epsilon, theta0, theta1); stop {
       break
     } else {
       // execute hook if available
-      if obj.LogisticRegression.Hook != nil && obj.LogisticRegression.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(obj.L1Reg), i) {
+      if estimator.Hook != nil && estimator.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(estimator.L1Reg), i) {
         break
       }
     }
   }
-  obj.Theta = NewDenseFloat64Vector(theta1)
-  if r_, err := obj.LogisticRegression.GetEstimate(); err != nil {
-    log.Fatal(err)
-    return nil
-  } else {
-    r := &KmerLr{}
-    r.Theta          = r_.(*vectorDistribution.LogisticRegression).Theta.(DenseFloat64Vector)
-    r.KmerLrFeatures = obj.KmerLrFeatures
-    r.Transform      = transform
-    return r
-  }
+  return theta1
 }
+
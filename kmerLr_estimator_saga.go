@@ -0,0 +1,116 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+//import   "fmt"
+import   "log"
+import   "math"
+
+import . "github.com/pbenner/autodiff"
+import   "github.com/pbenner/autodiff/algorithm/saga"
+import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
+
+/* -------------------------------------------------------------------------- */
+
+// Proximal operator of the elastic-net penalty, where the ratio between
+// the L2 and L1 penalty is fixed so that saga can rescale lambda; if
+// groups are given, the L1 penalty is replaced by the group lasso
+type proximalElasticNet struct {
+  Lambda float64
+  Ratio  float64
+  Groups featureGroups
+}
+
+func (obj *proximalElasticNet) GetLambda() float64 {
+  return obj.Lambda
+}
+
+func (obj *proximalElasticNet) SetLambda(lambda float64) {
+  obj.Lambda = lambda
+}
+
+func (obj *proximalElasticNet) Eval(x DenseFloat64Vector, w DenseFloat64Vector) {
+  if !obj.Groups.Nil() {
+    copy(x, w)
+    obj.Groups.Shrink(x, obj.Lambda)
+    for i := 1; i < x.Dim(); i++ {
+      x[i] /= 1.0 + obj.Ratio*obj.Lambda
+    }
+    return
+  }
+  // do not regularize intercept
+  x[0] = w[0]
+  for i := 1; i < x.Dim(); i++ {
+    // sign(wi)*max{|wi| - lambda}/(1 + ratio*lambda)
+    if wi := w[i]; wi < 0.0 {
+      x[i] = -math.Max(-wi - obj.Lambda, 0.0)
+    } else {
+      x[i] =  math.Max( wi - obj.Lambda, 0.0)
+    }
+    x[i] /= 1.0 + obj.Ratio*obj.Lambda
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// SAGA with elastic-net or group lasso penalty or with sample weights, which
+// are not supported by the logistic regression estimator of autodiff
+func estimate_saga(config Config, estimator *vectorEstimator.LogisticRegression, data []ConstVector, labels []bool, weights []float64, groups featureGroups) []float64 {
+  ratio := 0.0
+  if estimator.L1Reg != 0.0 {
+    ratio = estimator.L2Reg/estimator.L1Reg
+  } else
+  if estimator.L2Reg != 0.0 {
+    panic("internal error")
+  }
+  estimate_step_size(estimator, data, weights)
+  lr := logisticRegression{}
+  lr.ClassWeights = estimator.ClassWeights
+  lr.Weights      = weights
+  f  := func(i int, theta DenseFloat64Vector) (float64, float64, SparseConstFloat64Vector, error) {
+    x := data[i].(SparseConstFloat64Vector)
+    y := 0.0
+    w := 0.0
+    if len(theta) == 0 {
+      return y, w, x, nil
+    }
+    lr.Theta = theta
+    y = lr.LogPdf(x)
+    if labels[i] {
+      w = lr.weight(i, true )*(math.Exp(y) - 1.0)
+    } else {
+      w = lr.weight(i, false)*(math.Exp(y))
+    }
+    return y, w, x, nil
+  }
+  proxop := &proximalElasticNet{estimator.L1Reg, ratio, groups}
+  if r, s, err := saga.Run(saga.Objective1Sparse(f), len(data), estimator.Theta,
+    saga.Hook            {Value: estimator.Hook},
+    saga.Gamma           {Value: estimator.GetStepSize()},
+    saga.Epsilon         {Value: estimator.Epsilon},
+    saga.MaxIterations   {Value: estimator.MaxIterations},
+    saga.Seed            {Value: estimator.Seed},
+    saga.ProximalOperator{Value: proxop}); err != nil {
+    log.Fatal(err)
+    return nil
+  } else {
+    estimator.Seed = s
+    return r.(DenseFloat64Vector)
+  }
+}
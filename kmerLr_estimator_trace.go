@@ -21,7 +21,6 @@ package main
 import   "fmt"
 import   "bufio"
 import   "math"
-import   "os"
 import   "time"
 
 /* -------------------------------------------------------------------------- */
@@ -47,7 +46,7 @@ type Trace struct {
 }
 
 func (obj Trace) Export(filename string) error {
-  f, err := os.Create(filename)
+  f, err := create_file(filename)
   if err != nil {
     return err
   }
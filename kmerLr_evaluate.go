@@ -0,0 +1,499 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "io"
+import   "log"
+import   "math"
+import   "math/rand"
+import   "os"
+import   "sort"
+import   "strconv"
+import   "strings"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+type EvaluationResult struct {
+  Names  []string
+  Values []float64
+  Lower  []float64
+  Upper  []float64
+}
+
+func (obj *EvaluationResult) Add(name string, value float64) {
+  obj.Names  = append(obj.Names , name)
+  obj.Values = append(obj.Values, value)
+  obj.Lower  = append(obj.Lower , math.NaN())
+  obj.Upper  = append(obj.Upper , math.NaN())
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Predictions are log-probabilities log p(y=1|x) as returned by the
+// classifiers. All curves are computed by decreasing the threshold
+// on the prediction, where ties are processed jointly.
+func eval_sort_predictions(predictions []float64, labels []bool) ([]float64, []int) {
+  a := make([]float64, len(predictions))
+  b := make([]int    , len(predictions))
+  for i := 0; i < len(predictions); i++ {
+    a[i] = predictions[i]
+    b[i] = i
+  }
+  FloatInt{a, b}.SortReverse()
+  return a, b
+}
+
+func eval_roc_curve(predictions []float64, labels []bool) ([]float64, []float64, []float64) {
+  a, b := eval_sort_predictions(predictions, labels)
+  n1   := 0
+  n0   := 0
+  for _, label := range labels {
+    if label {
+      n1++
+    } else {
+      n0++
+    }
+  }
+  fpr := []float64{0.0}
+  tpr := []float64{0.0}
+  thr := []float64{math.Inf(1)}
+  tp  := 0
+  fp  := 0
+  for i := 0; i < len(a); i++ {
+    if labels[b[i]] {
+      tp++
+    } else {
+      fp++
+    }
+    if i+1 < len(a) && a[i+1] == a[i] {
+      continue
+    }
+    fpr = append(fpr, float64(fp)/float64(n0))
+    tpr = append(tpr, float64(tp)/float64(n1))
+    thr = append(thr, a[i])
+  }
+  return fpr, tpr, thr
+}
+
+func eval_pr_curve(predictions []float64, labels []bool) ([]float64, []float64, []float64) {
+  a, b := eval_sort_predictions(predictions, labels)
+  n1   := 0
+  for _, label := range labels {
+    if label {
+      n1++
+    }
+  }
+  recall    := []float64{}
+  precision := []float64{}
+  thr       := []float64{}
+  tp        := 0
+  fp        := 0
+  for i := 0; i < len(a); i++ {
+    if labels[b[i]] {
+      tp++
+    } else {
+      fp++
+    }
+    if i+1 < len(a) && a[i+1] == a[i] {
+      continue
+    }
+    recall    = append(recall   , float64(tp)/float64(n1))
+    precision = append(precision, float64(tp)/float64(tp+fp))
+    thr       = append(thr, a[i])
+  }
+  return recall, precision, thr
+}
+
+/* -------------------------------------------------------------------------- */
+
+func eval_roc_auc(predictions []float64, labels []bool) float64 {
+  fpr, tpr, _ := eval_roc_curve(predictions, labels)
+  r := 0.0
+  for i := 1; i < len(fpr); i++ {
+    r += (fpr[i]-fpr[i-1])*(tpr[i]+tpr[i-1])/2.0
+  }
+  return r
+}
+
+// PR-AUC is computed as average precision, which avoids the overly
+// optimistic linear interpolation between points of the PR curve
+func eval_pr_auc(predictions []float64, labels []bool) float64 {
+  recall, precision, _ := eval_pr_curve(predictions, labels)
+  r := 0.0
+  for i := 0; i < len(recall); i++ {
+    if i == 0 {
+      r += recall[i]*precision[i]
+    } else {
+      r += (recall[i]-recall[i-1])*precision[i]
+    }
+  }
+  return r
+}
+
+func eval_log_loss(predictions []float64, labels []bool) float64 {
+  r := 0.0
+  for i := 0; i < len(predictions); i++ {
+    if labels[i] {
+      r -= predictions[i]
+    } else {
+      r -= math.Log1p(-math.Exp(predictions[i]))
+    }
+  }
+  return r/float64(len(predictions))
+}
+
+func eval_brier_score(predictions []float64, labels []bool) float64 {
+  r := 0.0
+  for i := 0; i < len(predictions); i++ {
+    if labels[i] {
+      r += math.Pow(math.Exp(predictions[i]) - 1.0, 2.0)
+    } else {
+      r += math.Pow(math.Exp(predictions[i]) - 0.0, 2.0)
+    }
+  }
+  return r/float64(len(predictions))
+}
+
+// Compute accuracy, precision, recall and F1 score, where samples with
+// a probability greater or equal to the threshold are called positive. Ratios
+// with a zero denominator (e.g. precision if no sample is called positive)
+// are set to zero
+func eval_confusion(predictions []float64, labels []bool, threshold float64) (float64, float64, float64, float64) {
+  tp, fp, tn, fn := 0, 0, 0, 0
+  for i := 0; i < len(predictions); i++ {
+    if math.Exp(predictions[i]) >= threshold {
+      if labels[i] {
+        tp++
+      } else {
+        fp++
+      }
+    } else {
+      if labels[i] {
+        fn++
+      } else {
+        tn++
+      }
+    }
+  }
+  ratio := func(a, b int) float64 {
+    if b == 0 {
+      return 0.0
+    }
+    return float64(a)/float64(b)
+  }
+  accuracy  := ratio(tp+tn, len(predictions))
+  precision := ratio(tp, tp+fp)
+  recall    := ratio(tp, tp+fn)
+  f1        := ratio(2*tp, 2*tp+fp+fn)
+  return accuracy, precision, recall, f1
+}
+
+/* -------------------------------------------------------------------------- */
+
+func eval_bootstrap(config Config, predictions []float64, labels []bool, n int, level float64, f func([]float64, []bool) float64) (float64, float64) {
+  r := rand.New(rand.NewSource(config.Seed))
+  x := make([]float64, 0, n)
+  p := make([]float64, len(predictions))
+  l := make([]bool   , len(predictions))
+  for k := 0; k < n; k++ {
+    n0, n1 := 0, 0
+    for i := 0; i < len(predictions); i++ {
+      j   := r.Intn(len(predictions))
+      p[i] = predictions[j]
+      l[i] = labels     [j]
+      if l[i] {
+        n1++
+      } else {
+        n0++
+      }
+    }
+    // skip samples where the metric is undefined
+    if n0 == 0 || n1 == 0 {
+      continue
+    }
+    x = append(x, f(p, l))
+  }
+  if len(x) == 0 {
+    return math.NaN(), math.NaN()
+  }
+  sort.Float64s(x)
+  i := int(math.Floor((1.0-level)/2.0*float64(len(x)-1)))
+  j := int(math.Ceil ((1.0+level)/2.0*float64(len(x)-1)))
+  return x[i], x[j]
+}
+
+/* -------------------------------------------------------------------------- */
+
+func evaluate_predictions(config Config, predictions []float64, labels []bool, threshold float64, bootstrap int, level float64) EvaluationResult {
+  if len(predictions) != len(labels) {
+    log.Fatal("number of predictions does not match number of labels")
+  }
+  n1 := 0
+  for _, label := range labels {
+    if label {
+      n1++
+    }
+  }
+  if n1 == 0 || n1 == len(labels) {
+    log.Fatal("evaluation requires samples from both classes")
+  }
+  accuracy, precision, recall, f1 := eval_confusion(predictions, labels, threshold)
+
+  r := EvaluationResult{}
+  r.Add("samples"   , float64(len(labels)))
+  r.Add("positives" , float64(n1))
+  r.Add("roc-auc"   , eval_roc_auc(predictions, labels))
+  r.Add("pr-auc"    , eval_pr_auc (predictions, labels))
+  r.Add("threshold" , threshold)
+  r.Add("accuracy"  , accuracy)
+  r.Add("precision" , precision)
+  r.Add("recall"    , recall)
+  r.Add("f1"        , f1)
+  r.Add("log-loss"  , eval_log_loss   (predictions, labels))
+  r.Add("brier"     , eval_brier_score(predictions, labels))
+  if bootstrap > 0 {
+    r.Lower[2], r.Upper[2] = eval_bootstrap(config, predictions, labels, bootstrap, level, eval_roc_auc)
+    r.Lower[3], r.Upper[3] = eval_bootstrap(config, predictions, labels, bootstrap, level, eval_pr_auc)
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func saveEvaluation(filename string, result EvaluationResult, bootstrap bool) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  if bootstrap {
+    fmt.Fprintf(writer, "%10s\t%15s\t%15s\t%15s\n", "metric", "value", "lower", "upper")
+  } else {
+    fmt.Fprintf(writer, "%10s\t%15s\n", "metric", "value")
+  }
+  for i := 0; i < len(result.Names); i++ {
+    if bootstrap {
+      fmt.Fprintf(writer, "%10s\t%15e\t%15e\t%15e\n", result.Names[i], result.Values[i], result.Lower[i], result.Upper[i])
+    } else {
+      fmt.Fprintf(writer, "%10s\t%15e\n", result.Names[i], result.Values[i])
+    }
+  }
+}
+
+func saveEvaluationCurves(filename string, predictions []float64, labels []bool) error {
+  f, err := create_file(filename)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  w := bufio.NewWriter(f)
+  defer w.Flush()
+
+  fmt.Fprintf(w, "%5s\t%15s\t%15s\t%15s\n", "curve", "threshold", "x", "y")
+  if x, y, t := eval_roc_curve(predictions, labels); true {
+    for i := 0; i < len(x); i++ {
+      fmt.Fprintf(w, "%5s\t%15e\t%15e\t%15e\n", "roc", math.Exp(t[i]), x[i], y[i])
+    }
+  }
+  if x, y, t := eval_pr_curve(predictions, labels); true {
+    for i := 0; i < len(x); i++ {
+      fmt.Fprintf(w, "%5s\t%15e\t%15e\t%15e\n", "pr", math.Exp(t[i]), x[i], y[i])
+    }
+  }
+  return nil
+}
+
+func SaveEvaluationCurves(config Config, filename string, predictions []float64, labels []bool) {
+  PrintStderr(config, 1, "Exporting curves to `%s'... ", filename)
+  if err := saveEvaluationCurves(filename, predictions, labels); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Read predictions and labels from a cross-validation table as written
+// by saveCrossvalidation. Columns are identified by their header so that
+// additional columns are ignored.
+func read_cv_table(config Config, filename string) ([]float64, []bool) {
+  f, err := open_file(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer f.Close()
+
+  PrintStderr(config, 1, "Reading cross-validation results from `%s'... ", filename)
+  predictions := []float64{}
+  labels      := []bool{}
+  reader      := bufio.NewReader(f)
+  i_p         := -1
+  i_l         := -1
+  for i_ := 1;; i_++ {
+    l, err := bufioReadLine(reader)
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal(err)
+    }
+    fields := strings.Fields(l)
+    if len(fields) == 0 {
+      continue
+    }
+    if i_p == -1 {
+      for j, field := range fields {
+        switch field {
+        case "prediction": i_p = j
+        case "labels"    : i_l = j
+        }
+      }
+      if i_p == -1 || i_l == -1 {
+        PrintStderr(config, 1, "failed\n")
+        log.Fatalf("table `%s' has no `prediction' or `labels' column", filename)
+      }
+      continue
+    }
+    if len(fields) <= i_p || len(fields) <= i_l {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("invalid table `%s' at line `%d'", filename, i_)
+    }
+    if v, err := strconv.ParseFloat(fields[i_p], 64); err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("parsing prediction failed at line `%d': %v", i_, err)
+    } else {
+      predictions = append(predictions, v)
+    }
+    switch fields[i_l] {
+    case "1": labels = append(labels, true)
+    case "0": labels = append(labels, false)
+    default:
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("invalid label at line `%d'", i_)
+    }
+  }
+  PrintStderr(config, 1, "done\n")
+  return predictions, labels
+}
+
+/* -------------------------------------------------------------------------- */
+
+func evaluate_predict_(config Config, filename_json, filename_fg, filename_bg string) ([]float64, []bool) {
+  classifier := ImportKmerLrEnsemble(config, filename_json)
+  counter    := classifier.GetKmerCounter()
+  data       := compile_training_data(config, counter, classifier.Kmers, classifier.Features, false, classifier.Binarize, filename_fg, filename_bg)
+  classifier.Transform.Apply(config, data.Data)
+
+  return classifier.Predict(config, data.Data), data.Labels
+}
+
+func evaluate(config Config, predictions []float64, labels []bool, filename_out, filename_curves string, threshold float64, bootstrap int, level float64) {
+  if filename_curves != "" {
+    SaveEvaluationCurves(config, filename_curves, predictions, labels)
+  }
+  saveEvaluation(filename_out, evaluate_predictions(config, predictions, labels, threshold, bootstrap, level), bootstrap > 0)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func parse_evaluate_options(optThreshold, optLevel *string, optBootstrap *int) (float64, float64) {
+  threshold := 0.0
+  level     := 0.0
+  if v, err := strconv.ParseFloat(*optThreshold, 64); err != nil {
+    log.Fatalf("Parsing option `--threshold' failed: %v", err)
+  } else {
+    if v < 0.0 || v > 1.0 {
+      log.Fatal("option `--threshold' must be a probability")
+    }
+    threshold = v
+  }
+  if v, err := strconv.ParseFloat(*optLevel, 64); err != nil {
+    log.Fatalf("Parsing option `--confidence-level' failed: %v", err)
+  } else {
+    if v <= 0.0 || v >= 1.0 {
+      log.Fatal("option `--confidence-level' must be in (0,1)")
+    }
+    level = v
+  }
+  if *optBootstrap < 0 {
+    log.Fatal("option `--bootstrap' must be non-negative")
+  }
+  return threshold, level
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_evaluate(config Config, args []string) {
+  options := getopt.New()
+
+  optThreshold := options.StringLong("threshold",        0 ,  "0.5", "probability threshold for computing accuracy and F1 score [default: 0.5]")
+  optCurves    := options.StringLong("curves",           0 ,     "", "write ROC and PR curve points to file")
+  optBootstrap := options.   IntLong("bootstrap",        0 ,      0, "number of bootstrap samples for computing AUC confidence intervals [default: 0]")
+  optLevel     := options.StringLong("confidence-level", 0 , "0.95", "level of bootstrap confidence intervals [default: 0.95]")
+  optHelp      := options.  BoolLong("help",            'h',         "print help")
+
+  options.SetParameters("<<CV.table>|<MODEL.json> <FOREGROUND.fa> <BACKGROUND.fa>> [RESULT.table]")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  threshold, level := parse_evaluate_options(optThreshold, optLevel, optBootstrap)
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  predictions  := []float64{}
+  labels       := []bool{}
+  filename_out := ""
+  switch len(options.Args()) {
+  case 1, 2:
+    predictions, labels = read_cv_table(config, options.Args()[0])
+    if len(options.Args()) == 2 {
+      filename_out = options.Args()[1]
+    }
+  case 3, 4:
+    predictions, labels = evaluate_predict_(config, options.Args()[0], options.Args()[1], options.Args()[2])
+    if len(options.Args()) == 4 {
+      filename_out = options.Args()[3]
+    }
+  default:
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  evaluate(config, predictions, labels, filename_out, *optCurves, threshold, *optBootstrap, level)
+}
@@ -31,16 +31,21 @@ import   "github.com/pborman/getopt"
 
 /* -------------------------------------------------------------------------- */
 
-func export(config Config, classifier *KmerLrEnsemble, filename_json string, filename_seq, filename_out []string) {
+func export(config Config, classifier *KmerLrEnsemble, filename_json string, model_features bool, format string, filename_seq, filename_out []string) {
   if filename_json != "" {
     classifier = ImportKmerLrEnsemble(config, filename_json)
   }
-  // do not use classifier.GetKmerCounter() since we do not want to fix the set of kmers!
-  kmersCounter, err := NewKmerCounter(classifier.M, classifier.N, classifier.Complement, classifier.Reverse, classifier.Revcomp, classifier.MaxAmbiguous, classifier.Alphabet); if err != nil {
-    log.Fatal(err)
+  var data []KmerDataSet
+  if model_features {
+    // restrict data to the features of the model
+    data = compile_data(config, classifier.GetKmerCounter(), classifier.Kmers, classifier.Features, false, classifier.Binarize, filename_seq)
+  } else {
+    // do not use classifier.GetKmerCounter() since we do not want to fix the set of kmers!
+    kmersCounter, err := NewKmerCounter(classifier.M, classifier.N, classifier.Complement, classifier.Reverse, classifier.Revcomp, classifier.MaxAmbiguous, classifier.Alphabet); if err != nil {
+      log.Fatal(err)
+    }
+    data = compile_data(config, kmersCounter, nil, nil, true, classifier.Binarize, filename_seq)
   }
-  data := compile_data(config, kmersCounter, nil, nil, true, classifier.Binarize, filename_seq)
-  kmersCounter = nil
   // transform data
   if config.DataTransform != "" {
     data_full := []ConstVector{}
@@ -54,7 +59,11 @@ func export(config Config, classifier *KmerLrEnsemble, filename_json string, fil
     }
   }
   for i, _ := range data {
-    export_kmers(config, filename_out[i], data[i])
+    if model_features {
+      export_kmers(config, filename_out[i], format, data[i], classifier.Features)
+    } else {
+      export_kmers(config, filename_out[i], format, data[i], nil)
+    }
   }
 }
 
@@ -71,10 +80,17 @@ func main_export(config Config, args []string) {
   optReverse         := options.   BoolLong("reverse",          0 ,               "consider reverse sequences")
   optRevcomp         := options.   BoolLong("revcomp",          0 ,               "consider reverse complement sequences")
   optDataTransform   := options. StringLong("data-transform",   0 ,          "",  "transform data before training classifier [none (default), standardizer (preferred for dense data), variance-scaler (preferred for sparse data), max-abs-scaler, mean-scaler]")
+  optMinQuality      := options.    IntLong("min-quality",      0 ,            0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optAggregateReads  := options.   BoolLong("aggregate-reads",  0 ,               "each fasta/fastq file is a single sample with k-mer counts aggregated over all reads, where several files are joined with `+' (e.g. a.fq+b.fq)")
+  // output options
+  optFormat          := options. StringLong("format",           0 ,      "table", "output format [table (dense comma separated table), mtx (MatrixMarket coordinate format), libsvm, npz (scipy.sparse CSR matrix)]; column names of sparse formats are written to OUTPUT.columns")
+  optModelFeatures   := options.   BoolLong("model-features",   0 ,               "restrict output to the features of MODEL.json")
   // other options
   optHelp            := options.   BoolLong("help",            'h',               "print help")
 
-  options.SetParameters("<<M> <N>|<MODEL.json>> <SEQUENCES_1.fa,SEQUENCES_2.fa,...> <OUPTUT_1.table,OUPTUT_2.table,...>")
+  options.SetParameters("<<M> <N>|<MODEL.json>> <SEQUENCES_1.fa,SEQUENCES_2.fa,...> <OUTPUT_1,OUTPUT_2,...>\n\n" +
+    " Samples of the first file are labeled as foreground (1) and all others as\n" +
+    " background (0) in libsvm and npz output.\n")
   options.Parse(args)
 
   // parse arguments
@@ -143,7 +159,9 @@ func main_export(config Config, args []string) {
     options.PrintUsage(os.Stdout)
     os.Exit(0)
   }
-  config.DataTransform = *optDataTransform
+  config.DataTransform  = *optDataTransform
+  config.MinQuality     = *optMinQuality
+  config.AggregateReads = *optAggregateReads
   switch strings.ToLower(config.DataTransform) {
   case "":
   case "none":
@@ -155,11 +173,17 @@ func main_export(config Config, args []string) {
     log.Fatal("invalid data transform")
     panic("internal error")
   }
+  if !export_format_valid(*optFormat) {
+    log.Fatalf("invalid output format `%s'", *optFormat)
+  }
+  if *optModelFeatures && filename_in == "" {
+    log.Fatal("option --model-features requires a model")
+  }
   filenames_1 := strings.Split(filename_seq, ",")
   filenames_2 := strings.Split(filename_out, ",")
   if len(filenames_1) != len(filenames_2) {
     options.PrintUsage(os.Stdout)
     os.Exit(0)    
   }
-  export(config, classifier, filename_in, filenames_1, filenames_2)
+  export(config, classifier, filename_in, *optModelFeatures, *optFormat, filenames_1, filenames_2)
 }
@@ -0,0 +1,272 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "archive/zip"
+import   "bufio"
+import   "bytes"
+import   "encoding/binary"
+import   "fmt"
+import   "io"
+import   "log"
+import   "os"
+import   "strings"
+
+import . "github.com/pbenner/autodiff"
+
+/* -------------------------------------------------------------------------- */
+
+// Data matrix that is exported by the export commands. The first component
+// of each data vector is the intercept, which is not exported.
+type ExportMatrix struct {
+  Data    []ConstVector
+  // optional labels of each sample
+  Labels  []bool
+  Columns []string
+  // export values as integers (i.e. k-mer counts)
+  Integer   bool
+}
+
+/* -------------------------------------------------------------------------- */
+
+func export_format_valid(format string) bool {
+  switch strings.ToLower(format) {
+  case "table":
+  case "mtx":
+  case "libsvm":
+  case "npz":
+  default:
+    return false
+  }
+  return true
+}
+
+func (obj ExportMatrix) formatValue(v float64) string {
+  if obj.Integer {
+    return fmt.Sprintf("%d", int(v))
+  } else {
+    return fmt.Sprintf("%e", v)
+  }
+}
+
+func (obj ExportMatrix) nonZero() int {
+  n := 0
+  for i := 0; i < len(obj.Data); i++ {
+    for it := obj.Data[i].ConstIteratorFrom(1); it.Ok(); it.Next() {
+      if it.GetConst().GetFloat64() != 0.0 {
+        n++
+      }
+    }
+  }
+  return n
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Dense comma separated table with column names in the first line
+func (obj ExportMatrix) WriteTable(writer io.Writer) error {
+  w := bufio.NewWriter(writer)
+  fmt.Fprintf(w, "%s\n", strings.Join(obj.Columns, ","))
+  for i := 0; i < len(obj.Data); i++ {
+    for j := 1; j < obj.Data[i].Dim(); j++ {
+      if j == 1 {
+        fmt.Fprintf(w,  "%s", obj.formatValue(obj.Data[i].Float64At(j)))
+      } else {
+        fmt.Fprintf(w, ",%s", obj.formatValue(obj.Data[i].Float64At(j)))
+      }
+    }
+    fmt.Fprintf(w, "\n")
+  }
+  return w.Flush()
+}
+
+// MatrixMarket coordinate format with one-based indices
+func (obj ExportMatrix) WriteMatrixMarket(writer io.Writer) error {
+  w := bufio.NewWriter(writer)
+  if obj.Integer {
+    fmt.Fprintf(w, "%%%%MatrixMarket matrix coordinate integer general\n")
+  } else {
+    fmt.Fprintf(w, "%%%%MatrixMarket matrix coordinate real general\n")
+  }
+  fmt.Fprintf(w, "%d %d %d\n", len(obj.Data), len(obj.Columns), obj.nonZero())
+  for i := 0; i < len(obj.Data); i++ {
+    for it := obj.Data[i].ConstIteratorFrom(1); it.Ok(); it.Next() {
+      if v := it.GetConst().GetFloat64(); v != 0.0 {
+        fmt.Fprintf(w, "%d %d %s\n", i+1, it.Index(), obj.formatValue(v))
+      }
+    }
+  }
+  return w.Flush()
+}
+
+// libsvm/svmlight format with one-based feature indices, samples without
+// labels are exported with label 0
+func (obj ExportMatrix) WriteLibsvm(writer io.Writer) error {
+  w := bufio.NewWriter(writer)
+  for i := 0; i < len(obj.Data); i++ {
+    if len(obj.Labels) != 0 && obj.Labels[i] {
+      fmt.Fprintf(w, "1")
+    } else {
+      fmt.Fprintf(w, "0")
+    }
+    for it := obj.Data[i].ConstIteratorFrom(1); it.Ok(); it.Next() {
+      if v := it.GetConst().GetFloat64(); v != 0.0 {
+        fmt.Fprintf(w, " %d:%s", it.Index(), obj.formatValue(v))
+      }
+    }
+    fmt.Fprintf(w, "\n")
+  }
+  return w.Flush()
+}
+
+// Compressed sparse row matrix in the layout of scipy.sparse.save_npz, labels
+// are stored as an additional array `labels'
+func (obj ExportMatrix) WriteNpz(writer io.Writer) error {
+  data    := make([]float64, 0, obj.nonZero())
+  indices := make([]int64  , 0, obj.nonZero())
+  indptr  := make([]int64  , 1, len(obj.Data)+1)
+  for i := 0; i < len(obj.Data); i++ {
+    for it := obj.Data[i].ConstIteratorFrom(1); it.Ok(); it.Next() {
+      if v := it.GetConst().GetFloat64(); v != 0.0 {
+        data    = append(data   , v)
+        indices = append(indices, int64(it.Index()-1))
+      }
+    }
+    indptr = append(indptr, int64(len(data)))
+  }
+  shape := []int64{int64(len(obj.Data)), int64(len(obj.Columns))}
+  z := zip.NewWriter(writer)
+  arrays := []struct{
+    name  string
+    descr string
+    shape string
+    data  interface{}
+  }{
+    {"format" , "|S3", "()", []byte("csr")},
+    {"shape"  , "<i8", fmt.Sprintf("(%d,)", len(shape  )), shape  },
+    {"data"   , "<f8", fmt.Sprintf("(%d,)", len(data   )), data   },
+    {"indices", "<i8", fmt.Sprintf("(%d,)", len(indices)), indices},
+    {"indptr" , "<i8", fmt.Sprintf("(%d,)", len(indptr )), indptr },
+  }
+  if len(obj.Labels) != 0 {
+    arrays = append(arrays, struct{
+      name  string
+      descr string
+      shape string
+      data  interface{}
+    }{"labels", "|b1", fmt.Sprintf("(%d,)", len(obj.Labels)), obj.Labels})
+  }
+  for _, a := range arrays {
+    if w, err := z.Create(a.name+".npy"); err != nil {
+      return err
+    } else {
+      if err := write_npy(w, a.descr, a.shape, a.data); err != nil {
+        return err
+      }
+    }
+  }
+  return z.Close()
+}
+
+// Write array in NumPy format (version 1.0)
+func write_npy(writer io.Writer, descr, shape string, data interface{}) error {
+  header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': %s, }", descr, shape)
+  // magic string, version and header length take 10 bytes, the header
+  // is padded such that the data is aligned to 64 bytes
+  n := 10 + len(header) + 1
+  if n % 64 != 0 {
+    header += strings.Repeat(" ", 64 - n % 64)
+  }
+  header += "\n"
+  var buffer bytes.Buffer
+  buffer.WriteString("\x93NUMPY\x01\x00")
+  binary.Write(&buffer, binary.LittleEndian, uint16(len(header)))
+  buffer.WriteString(header)
+  if err := binary.Write(&buffer, binary.LittleEndian, data); err != nil {
+    return err
+  }
+  _, err := writer.Write(buffer.Bytes())
+  return err
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj ExportMatrix) Write(writer io.Writer, format string) error {
+  switch strings.ToLower(format) {
+  case "", "table":
+    return obj.WriteTable(writer)
+  case "mtx":
+    return obj.WriteMatrixMarket(writer)
+  case "libsvm":
+    return obj.WriteLibsvm(writer)
+  case "npz":
+    return obj.WriteNpz(writer)
+  default:
+    return fmt.Errorf("invalid output format `%s'", format)
+  }
+}
+
+// Export data matrix in the given format. For sparse formats, column
+// names are written to a separate file `<filename>.columns'.
+func export_matrix(config Config, filename, format string, m ExportMatrix) {
+  // npz files are already compressed
+  if strings.ToLower(format) != "npz" {
+    filename = compress_filename(config, filename)
+  }
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer f.Close()
+
+    writer = f
+  }
+  PrintStderr(config, 1, "Exporting data to `%s'... ", filename)
+  if err := m.Write(writer, format); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+  if format != "" && strings.ToLower(format) != "table" && filename != "" {
+    filename_columns := fmt.Sprintf("%s.columns", strings.TrimSuffix(filename, ".gz"))
+    PrintStderr(config, 1, "Exporting column names to `%s'... ", filename_columns)
+    if err := export_column_names(filename_columns, m.Columns); err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal(err)
+    }
+    PrintStderr(config, 1, "done\n")
+  }
+}
+
+func export_column_names(filename string, columns []string) error {
+  f, err := create_file(filename)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  w := bufio.NewWriter(f)
+  for _, name := range columns {
+    fmt.Fprintf(w, "%s\n", name)
+  }
+  return w.Flush()
+}
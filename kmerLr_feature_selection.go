@@ -20,6 +20,7 @@ package main
 
 import   "fmt"
 import   "math"
+import   "sync"
 
 import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/gonetics"
@@ -30,6 +31,11 @@ import   "github.com/pbenner/threadpool"
 type featureSelector struct {
   ClassWeights [2]float64
   Labels        []bool
+  // optional sample weights
+  Weights       []float64
+  // regression model and responses
+  Regression      string
+  Responses     []float64
   Kmers           KmerClassList
   KmersMap        map[KmerClassId]int
   Index         []int
@@ -40,7 +46,47 @@ type featureSelector struct {
   N               int
   M               int
   Epsilon         float64
+  // strength of the L2 penalty
+  Lambda2         float64
+  // select groups of features instead of single
+  // features (group lasso)
+  Groups          featureGroups
   Pool            threadpool.ThreadPool
+  // last gradient evaluated on the full data set, which is shared
+  // across all selections along the regularization path
+  cache          *gradientCache
+}
+
+/* -------------------------------------------------------------------------- */
+
+type gradientCache struct {
+  mutex   sync.Mutex
+  theta []float64
+  lambda2 float64
+  g     []float64
+}
+
+func (obj *gradientCache) Get(theta []float64, lambda2 float64) []float64 {
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  if obj.g == nil || obj.lambda2 != lambda2 || len(obj.theta) != len(theta) {
+    return nil
+  }
+  for j := 0; j < len(theta); j++ {
+    if obj.theta[j] != theta[j] {
+      return nil
+    }
+  }
+  // callers may modify the gradient
+  return append([]float64{}, obj.g...)
+}
+
+func (obj *gradientCache) Set(theta []float64, lambda2 float64, g []float64) {
+  obj.mutex.Lock()
+  defer obj.mutex.Unlock()
+  obj.theta   = append([]float64{}, theta...)
+  obj.lambda2 = lambda2
+  obj.g       = append([]float64{}, g...)
 }
 
 /* -------------------------------------------------------------------------- */
@@ -69,7 +115,9 @@ func newFeatureSelector(config Config, kmers KmerClassList, index []int, names [
     // data dimension (without co-occurrences)
     M           : m,
     Epsilon     : epsilon,
-    Pool        : config.PoolLR }
+    Regression  : config.Regression,
+    Pool        : config.PoolLR,
+    cache       : &gradientCache{} }
   return r
 }
 
@@ -85,6 +133,12 @@ func (obj featureSelector) Select(data []ConstVector, theta []float64, features
   t, c, b := obj.restoreNonzero(theta, features, kmers, index)
   // compute gradient for selecting new features
   g_ := obj.gradient(data, t)[1:]
+  if !obj.Groups.Nil() {
+    c, l, ok := obj.selectGroups(b, c, g_)
+    k, x, s, f := obj.selectKmers(b)
+    tr         := obj.Transform.Select(b)
+    return &featureSelection{obj, k, x, s, f, t, tr, b, c}, l, ok || (obj.Epsilon > 0.0 && math.Abs(lambda - l) >= obj.Epsilon)
+  }
   if debug {
     gd = make([]float64, len(g_))
     copy(gd, g_)
@@ -142,6 +196,12 @@ func (obj featureSelector) SelectFixed(data []ConstVector, theta []float64, feat
   t, c, b := obj.restoreNonzero(theta, features, kmers, index)
   // compute gradient for selecting new features
   g := obj.gradient(data, t)
+  if !obj.Groups.Nil() {
+    c, ok = obj.selectGroupsFixed(b, c, g[1:], l, max_features)
+    k, x, s, f := obj.selectKmers(b)
+    tr         := obj.Transform.Select(b)
+    return &featureSelection{obj, k, x, s, f, t, tr, b, c}, ok
+  }
   // add new features
   for k := 1; k < len(g); k++ {
     if math.Abs(g[k]) >= l && b[k] == false {
@@ -191,6 +251,92 @@ func (obj featureSelector) computeLambda(b []bool, g, g_ []float64) float64 {
   return (v+w)/2.0
 }
 
+// Compute the score ||g_k||_2/sqrt(|k|) of each group k, which must be
+// smaller than lambda if all coefficients of group k are zero, and activate
+// groups that have at least one non-zero coefficient
+func (obj featureSelector) groupScores(b []bool, c int, g []float64) ([]float64, []bool, int) {
+  s := make([]float64, len(obj.Groups.Sizes))
+  a := make([]bool,    len(obj.Groups.Sizes))
+  for k := 0; k < len(g); k++ {
+    s[obj.Groups.Groups[k]] += g[k]*g[k]
+    if b[k+1] {
+      a[obj.Groups.Groups[k]] = true
+    }
+  }
+  for i := 0; i < len(s); i++ {
+    s[i] = math.Sqrt(s[i])/obj.Groups.Weight(i)
+  }
+  for k := 0; k < len(g); k++ {
+    if a[obj.Groups.Groups[k]] && !b[k+1] {
+      b[k+1] = true
+      c     += 1
+    }
+  }
+  return s, a, c
+}
+
+func (obj featureSelector) activateGroup(b []bool, c int, group int) int {
+  for k := 0; k < len(obj.Groups.Groups); k++ {
+    if obj.Groups.Groups[k] == group && !b[k+1] {
+      b[k+1] = true
+      c     += 1
+    }
+  }
+  return c
+}
+
+// Select the smallest number of groups with largest scores so that at
+// least N features are selected
+func (obj featureSelector) selectGroups(b []bool, c int, g []float64) (int, float64, bool) {
+  ok      := false
+  s, a, c := obj.groupScores(b, c, g)
+  r := NewAbsFloatInt(len(s))
+  for i := 0; i < len(s); i++ {
+    r.a[i] = s[i]
+    r.b[i] = i
+  }
+  r.SortReverse()
+  n := 0
+  for k := 0; n < r.Len() && k < obj.N; n++ {
+    k += obj.Groups.Sizes[r.b[n]]
+  }
+  // add new groups
+  for i := 0; i < n; i++ {
+    if !a[r.b[i]] && r.a[i] != 0.0 {
+      ok        = true
+      a[r.b[i]] = true
+      c         = obj.activateGroup(b, c, r.b[i])
+    }
+  }
+  // active groups that are not required must be removed
+  for i := n; i < r.Len(); i++ {
+    if a[r.b[i]] {
+      ok = true
+    }
+  }
+  if n == 0 || n >= r.Len() {
+    return c, 0.0, ok
+  }
+  return c, (r.a[n-1]+r.a[n])/2.0, ok
+}
+
+// Add all groups that violate the optimality condition for a fixed lambda
+func (obj featureSelector) selectGroupsFixed(b []bool, c int, g []float64, l float64, max_features int) (int, bool) {
+  ok      := false
+  s, a, c := obj.groupScores(b, c, g)
+  for i := 0; i < len(s); i++ {
+    if s[i] >= l && !a[i] {
+      ok   = true
+      a[i] = true
+      c    = obj.activateGroup(b, c, i)
+    }
+    if max_features > 0 && c >= max_features && ok {
+      break
+    }
+  }
+  return c, ok
+}
+
 func (obj featureSelector) restoreNonzero(theta []float64, features FeatureIndices, kmers KmerClassList, index []int) ([]float64, int, []bool) {
   b, t := obj.alloc(theta)
   c    := 0
@@ -219,9 +365,22 @@ func (obj featureSelector) restoreNonzero(theta []float64, features FeatureIndic
 }
 
 func (obj featureSelector) gradient(data []ConstVector, theta []float64) []float64 {
+  if g := obj.cache.Get(theta, obj.Lambda2); g != nil {
+    return g
+  }
+  g := obj.gradient_(data, theta)
+  obj.cache.Set(theta, obj.Lambda2, g)
+  return g
+}
+
+func (obj featureSelector) gradient_(data []ConstVector, theta []float64) []float64 {
   lr := logisticRegression{}
   lr.Theta        = theta
   lr.ClassWeights = obj.ClassWeights
+  lr.Weights      = obj.Weights
+  lr.Regression   = obj.Regression
+  lr.Responses    = obj.Responses
+  lr.Lambda2      = obj.Lambda2
   lr.Cooccurrence = obj.Cooccurrence
   lr.Pool         = obj.Pool
   lr.Transform    = obj.Transform
@@ -361,3 +520,7 @@ func (obj *featureSelection) Features() FeatureIndices {
 func (obj *featureSelection) Transform() Transform {
   return obj.transform
 }
+
+func (obj *featureSelection) Groups() featureGroups {
+  return obj.featureSelector.Groups.Select(obj.b)
+}
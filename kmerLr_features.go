@@ -120,6 +120,19 @@ func (obj FeatureIndices) String(kmers KmerClassList) string {
   return buffer.String()
 }
 
+// Names of features, where co-occurrences are named `kmer1&kmer2'
+func (obj FeatureIndices) Names(kmers KmerClassList) []string {
+  r := make([]string, len(obj))
+  for i, feature := range obj {
+    if feature[0] == feature[1] {
+      r[i] = kmers[feature[0]].String()
+    } else {
+      r[i] = fmt.Sprintf("%v&%v", kmers[feature[0]], kmers[feature[1]])
+    }
+  }
+  return r
+}
+
 /* -------------------------------------------------------------------------- */
 
 type KmerLrEquivalence struct {
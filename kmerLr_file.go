@@ -0,0 +1,123 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "bytes"
+import   "compress/gzip"
+import   "io"
+import   "os"
+import   "strings"
+
+/* -------------------------------------------------------------------------- */
+
+type gzipReadCloser struct {
+  *gzip.Reader
+  f *os.File
+}
+
+func (obj gzipReadCloser) Close() error {
+  obj.Reader.Close()
+  return obj.f.Close()
+}
+
+type gzipWriteCloser struct {
+  *gzip.Writer
+  f *os.File
+}
+
+func (obj gzipWriteCloser) Close() error {
+  if err := obj.Writer.Close(); err != nil {
+    obj.f.Close()
+    return err
+  }
+  return obj.f.Close()
+}
+
+/* -------------------------------------------------------------------------- */
+
+func is_gzip_file(f *os.File) (bool, error) {
+  b    := make([]byte, 2)
+  n, _ := io.ReadFull(f, b)
+  if _, err := f.Seek(0, io.SeekStart); err != nil {
+    return false, err
+  }
+  return n == 2 && b[0] == 31 && b[1] == 139, nil
+}
+
+// Open file for reading, gzip and bgzip compressed files are detected by
+// their magic bytes and decompressed on the fly
+func open_file(filename string) (io.ReadCloser, error) {
+  f, err := os.Open(filename)
+  if err != nil {
+    return nil, err
+  }
+  if ok, err := is_gzip_file(f); err != nil {
+    f.Close()
+    return nil, err
+  } else if ok {
+    // gzip.Reader reads all members of bgzip files
+    g, err := gzip.NewReader(f)
+    if err != nil {
+      f.Close()
+      return nil, err
+    }
+    return gzipReadCloser{g, f}, nil
+  } else {
+    if strings.HasSuffix(filename, ".gz") {
+      f.Close()
+      return nil, gzip.ErrHeader
+    }
+    return f, nil
+  }
+}
+
+// Read (possibly compressed) file into memory
+func read_file(filename string) (*bytes.Reader, error) {
+  f, err := open_file(filename)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  b, err := io.ReadAll(f)
+  if err != nil {
+    return nil, err
+  }
+  return bytes.NewReader(b), nil
+}
+
+// Create file for writing, which is gzip compressed if the filename
+// has suffix `.gz'
+func create_file(filename string) (io.WriteCloser, error) {
+  f, err := os.Create(filename)
+  if err != nil {
+    return nil, err
+  }
+  if strings.HasSuffix(filename, ".gz") {
+    return gzipWriteCloser{gzip.NewWriter(f), f}, nil
+  }
+  return f, nil
+}
+
+// Append suffix `.gz' to output filenames if --compress is given
+func compress_filename(config Config, filename string) string {
+  if config.Compress && filename != "" && !strings.HasSuffix(filename, ".gz") {
+    return filename + ".gz"
+  }
+  return filename
+}
@@ -0,0 +1,228 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "bufio"
+import   "fmt"
+import   "log"
+import   "math"
+import   "strings"
+
+import . "github.com/pbenner/gonetics"
+
+/* -------------------------------------------------------------------------- */
+
+// Partition of features into groups for the group lasso, where
+// Groups[j] is the group of feature j and Sizes[g] the number of
+// features in group g
+type featureGroups struct {
+  Groups []int
+  Sizes  []int
+}
+
+/* -------------------------------------------------------------------------- */
+
+func newFeatureGroups(groups []int) featureGroups {
+  n := 0
+  for _, g := range groups {
+    if g+1 > n {
+      n = g+1
+    }
+  }
+  sizes := make([]int, n)
+  for _, g := range groups {
+    sizes[g]++
+  }
+  return featureGroups{Groups: groups, Sizes: sizes}
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj featureGroups) Nil() bool {
+  return len(obj.Groups) == 0
+}
+
+func (obj featureGroups) Weight(g int) float64 {
+  return math.Sqrt(float64(obj.Sizes[g]))
+}
+
+// Reduce groups to the subset of selected features, where b[0]
+// refers to the intercept
+func (obj featureGroups) Select(b []bool) featureGroups {
+  if obj.Nil() {
+    return obj
+  }
+  m := make(map[int]int)
+  r := []int{}
+  for j := 1; j < len(b); j++ {
+    if b[j] {
+      g := obj.Groups[j-1]
+      if _, ok := m[g]; !ok {
+        m[g] = len(m)
+      }
+      r = append(r, m[g])
+    }
+  }
+  return newFeatureGroups(r)
+}
+
+// Evaluate the group lasso penalty sum_g sqrt(|g|) ||theta_g||_2,
+// where theta[0] is the intercept
+func (obj featureGroups) Penalty(theta []float64) float64 {
+  s := make([]float64, len(obj.Sizes))
+  for j := 1; j < len(theta); j++ {
+    s[obj.Groups[j-1]] += theta[j]*theta[j]
+  }
+  r := 0.0
+  for g := 0; g < len(s); g++ {
+    r += obj.Weight(g)*math.Sqrt(s[g])
+  }
+  return r
+}
+
+// Proximal operator of the group lasso penalty (block soft-thresholding),
+// where x[0] is the intercept, which is not regularized
+func (obj featureGroups) Shrink(x []float64, lambda float64) {
+  s := make([]float64, len(obj.Sizes))
+  for j := 1; j < len(x); j++ {
+    s[obj.Groups[j-1]] += x[j]*x[j]
+  }
+  for g := 0; g < len(s); g++ {
+    if t := math.Sqrt(s[g]); t > 0.0 {
+      s[g] = math.Max(1.0 - lambda*obj.Weight(g)/t, 0.0)
+    }
+  }
+  for j := 1; j < len(x); j++ {
+    x[j] *= s[obj.Groups[j-1]]
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func kmer_groups(config Config, rel KmerLrEquivalence, kmers KmerClassList) featureGroups {
+  switch config.GroupLasso {
+  case "length":
+    return kmer_groups_length(kmers)
+  case "graph":
+    return kmer_groups_graph(rel, kmers)
+  case "file":
+    return kmer_groups_file(config.GroupFile, kmers)
+  default:
+    return featureGroups{}
+  }
+}
+
+func kmer_groups_length(kmers KmerClassList) featureGroups {
+  m := make(map[int]int)
+  r := make([]int, len(kmers))
+  for i, kmer := range kmers {
+    if _, ok := m[kmer.K]; !ok {
+      m[kmer.K] = len(m)
+    }
+    r[i] = m[kmer.K]
+  }
+  return newFeatureGroups(r)
+}
+
+// Group k-mers by connected components of the k-mer graph
+func kmer_groups_graph(rel_ KmerLrEquivalence, kmers KmerClassList) featureGroups {
+  rel, err := NewKmerEquivalenceRelation(rel_.M, rel_.N, rel_.Complement, rel_.Reverse, rel_.Revcomp, rel_.MaxAmbiguous, rel_.Alphabet)
+  if err != nil {
+    log.Fatal(err)
+  }
+  graph := NewKmerGraph(kmers, rel)
+  index := make(map[KmerClassId]int)
+  for i, kmer := range kmers {
+    index[kmer.KmerClassId] = i
+  }
+  r := make([]int, len(kmers))
+  for i := 0; i < len(r); i++ {
+    r[i] = -1
+  }
+  n := 0
+  for i, kmer := range kmers {
+    if r[i] != -1 {
+      continue
+    }
+    // depth-first search starting at kmer
+    stack := []*KmerGraphNode{graph.GetNode(kmer.Elements[0])}
+    r[i]   = n
+    for len(stack) > 0 {
+      node := stack[len(stack)-1]
+      stack = stack[0:len(stack)-1]
+      if node == nil {
+        continue
+      }
+      for _, nodes := range [][]*KmerGraphNode{node.Infra, node.Supra, node.Intra} {
+        for _, neighbor := range nodes {
+          if j, ok := index[neighbor.Kmer.KmerClassId]; ok && r[j] == -1 {
+            r[j]  = n
+            stack = append(stack, neighbor)
+          }
+        }
+      }
+    }
+    n++
+  }
+  return newFeatureGroups(r)
+}
+
+// Read groups from a file with one k-mer and group name per line,
+// k-mers that are not listed form groups of size one
+func kmer_groups_file(filename string, kmers KmerClassList) featureGroups {
+  f, err := open_file(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer f.Close()
+  names   := make(map[string]string)
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    fields := strings.Fields(scanner.Text())
+    if len(fields) == 0 {
+      continue
+    }
+    if len(fields) != 2 {
+      log.Fatal(fmt.Errorf("invalid group file `%s'", filename))
+    }
+    names[strings.ToLower(fields[0])] = fields[1]
+  }
+  if err := scanner.Err(); err != nil {
+    log.Fatal(err)
+  }
+  m := make(map[string]int)
+  r := make([]int, len(kmers))
+  for i, kmer := range kmers {
+    name := ""
+    for _, elem := range kmer.Elements {
+      if s, ok := names[strings.ToLower(elem)]; ok {
+        name = s; break
+      }
+    }
+    if name == "" {
+      // use k-mer as group name
+      name = fmt.Sprintf("kmer:%s", kmer.Elements[0])
+    }
+    if _, ok := m[name]; !ok {
+      m[name] = len(m)
+    }
+    r[i] = m[name]
+  }
+  return newFeatureGroups(r)
+}
@@ -50,6 +50,7 @@ func ImportKmerLrEnsemble(config Config, filename string) *KmerLrEnsemble {
 /* -------------------------------------------------------------------------- */
 
 func SaveCrossvalidation(config Config, filename string, cvr CVResult) {
+  filename = compress_filename(config, filename)
   PrintStderr(config, 1, "Exporting cross-validation results to `%s'... ", filename)
   if err := saveCrossvalidation(filename, cvr); err != nil {
     PrintStderr(config, 1, "failed\n")
@@ -61,6 +62,7 @@ func SaveCrossvalidation(config Config, filename string, cvr CVResult) {
 /* -------------------------------------------------------------------------- */
 
 func SaveCrossvalidationLoss(config Config, filename string, cvr CVResult) {
+  filename = compress_filename(config, filename)
   PrintStderr(config, 1, "Exporting cross-validation loss to `%s'... ", filename)
   if err := saveCrossvalidationLoss(filename, cvr); err != nil {
     PrintStderr(config, 1, "failed\n")
@@ -69,9 +71,20 @@ func SaveCrossvalidationLoss(config Config, filename string, cvr CVResult) {
   PrintStderr(config, 1, "done\n")
 }
 
+func SaveCrossvalidationFolds(config Config, filename string, cvr CVResult) {
+  filename = compress_filename(config, filename)
+  PrintStderr(config, 1, "Exporting cross-validation fold summary to `%s'... ", filename)
+  if err := saveCrossvalidationFolds(filename, cvr); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+}
+
 /* -------------------------------------------------------------------------- */
 
 func SaveTrace(config Config, filename string, trace Trace) {
+  filename = compress_filename(config, filename)
   PrintStderr(config, 1, "Exporting trace to `%s'... ", filename)
   if err := trace.Export(filename); err != nil {
     PrintStderr(config, 1, "failed\n")
@@ -83,6 +96,7 @@ func SaveTrace(config Config, filename string, trace Trace) {
 /* -------------------------------------------------------------------------- */
 
 func SaveKmerPath(config Config, filename string, path KmerRegularizationPath) {
+  filename = compress_filename(config, filename)
   PrintStderr(config, 1, "Exporting regularization path to `%s'... ", filename)
   if err := path.Export(filename); err != nil {
     PrintStderr(config, 1, "failed\n")
@@ -92,6 +106,7 @@ func SaveKmerPath(config Config, filename string, path KmerRegularizationPath) {
 }
 
 func SaveScoresPath(config Config, filename string, path ScoresRegularizationPath) {
+  filename = compress_filename(config, filename)
   PrintStderr(config, 1, "Exporting regularization path to `%s'... ", filename)
   if err := path.Export(filename); err != nil {
     PrintStderr(config, 1, "failed\n")
@@ -0,0 +1,118 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "log"
+
+import . "github.com/pbenner/gonetics"
+
+/* -------------------------------------------------------------------------- */
+
+// Occurrence of a k-mer at a given position of the sequence, where Index
+// refers to the list of k-mers of the scanner
+type kmerOccurrence struct {
+  K     int
+  Index int
+  Count int
+}
+
+// Locate k-mers of a model within sequences. Counting k-mers of each position
+// separately gives the same counts as the k-mer counter of the model.
+type kmerScanner struct {
+  M, N       int
+  // one k-mer counter for each k-mer length
+  counters []*KmerCounter
+  index      map[KmerClassId]int
+  n          int
+  // k-mers of the model matching each observed sequence
+  cache    []map[string][]kmerOccurrence
+}
+
+func newKmerScanner(features KmerLrFeatures) *kmerScanner {
+  r := kmerScanner{M: features.M, N: features.N, n: len(features.Kmers)}
+  r.index    = make(map[KmerClassId]int)
+  r.counters = make([]*KmerCounter, features.N-features.M+1)
+  r.cache    = make([]map[string][]kmerOccurrence, features.N-features.M+1)
+  for i, kmer := range features.Kmers {
+    r.index[kmer.KmerClassId] = i
+  }
+  for k := features.M; k <= features.N; k++ {
+    kmers := KmerClassList{}
+    for _, kmer := range features.Kmers {
+      if kmer.K == k {
+        kmers = append(kmers, kmer)
+      }
+    }
+    maxAmbiguous := features.MaxAmbiguous
+    if len(maxAmbiguous) > 1 {
+      maxAmbiguous = []int{maxAmbiguous[k-features.M]}
+    }
+    if counter, err := NewKmerCounter(k, k, features.Complement, features.Reverse, features.Revcomp, maxAmbiguous, features.Alphabet, kmers...); err != nil {
+      log.Fatal(err)
+    } else {
+      // the counter must not learn new k-mers, even if no
+      // k-mer of this length is part of the model
+      counter.Freeze()
+      r.counters[k-features.M] = counter
+      r.cache   [k-features.M] = make(map[string][]kmerOccurrence)
+    }
+  }
+  return &r
+}
+
+// K-mers of the model with length k starting at position i of the sequence
+func (obj *kmerScanner) ScanAt(r []kmerOccurrence, sequence []byte, i, k int) []kmerOccurrence {
+  if i < 0 || i+k > len(sequence) {
+    return r
+  }
+  if occurrences, ok := obj.cache[k-obj.M][string(sequence[i:i+k])]; ok {
+    return append(r, occurrences...)
+  }
+  occurrences := []kmerOccurrence{}
+  counts      := obj.counters[k-obj.M].CountKmers(sequence[i:i+k])
+  for id, c := range counts.Counts {
+    if j, ok := obj.index[id]; ok {
+      occurrences = append(occurrences, kmerOccurrence{k, j, c})
+    }
+  }
+  obj.cache[k-obj.M][string(sequence[i:i+k])] = occurrences
+  return append(r, occurrences...)
+}
+
+// K-mers of the model starting at each position of the sequence
+func (obj *kmerScanner) Scan(sequence []byte) [][]kmerOccurrence {
+  r := make([][]kmerOccurrence, len(sequence))
+  for i := 0; i < len(sequence); i++ {
+    for k := obj.M; k <= obj.N; k++ {
+      r[i] = obj.ScanAt(r[i], sequence, i, k)
+    }
+  }
+  return r
+}
+
+// Total number of occurrences of each k-mer
+func (obj *kmerScanner) Counts(occurrences [][]kmerOccurrence) []int {
+  r := make([]int, obj.n)
+  for _, list := range occurrences {
+    for _, o := range list {
+      r[o.Index] += o.Count
+    }
+  }
+  return r
+}
@@ -25,6 +25,7 @@ import   "os"
 import   "sort"
 import   "strconv"
 import   "strings"
+import   "time"
 
 import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/gonetics"
@@ -34,20 +35,59 @@ import   "github.com/pborman/getopt"
 
 /* -------------------------------------------------------------------------- */
 
-func learn_parameters(config Config, classifier *KmerLrEnsemble, data_train, data_val, data_test KmerDataSet, icv int, basename_out string) ([]*KmerLrEnsemble, [][]float64, []float64, []float64) {
-  estimator := NewKmerLrEnsembleEstimator(config, classifier, icv)
+func learn_filename(basename_out string, icv int) string {
+  if icv != -1 {
+    return fmt.Sprintf("%s_cv%d", basename_out, icv+1)
+  } else {
+    return basename_out
+  }
+}
 
-  classifiers, predictions, loss_train, loss_test := estimator.Estimate(config, data_train, data_val, data_test)
+func learn_model_filenames(config Config, filename_json string, n int) []string {
+  if n == 1 {
+    return []string{filename_json+".json"}
+  }
+  r := make([]string, n)
+  for i := 0; i < n; i++ {
+    r[i] = fmt.Sprintf("%s_%d.json", filename_json, config.LambdaAuto[i])
+  }
+  return r
+}
 
-  filename_json  := basename_out
-  filename_trace := basename_out
-  filename_path  := basename_out
+// Import models of a complete cross-validation fold, returns nil if
+// not all models were exported
+func learn_resume(config Config, checkpoint *Checkpoint, filename_json string) []*KmerLrEnsemble {
+  filenames := learn_model_filenames(config, filename_json, len(checkpoint.Lambda))
+  for _, filename := range filenames {
+    if _, err := os.Stat(filename); err != nil {
+      return nil
+    }
+  }
+  classifiers := make([]*KmerLrEnsemble, len(filenames))
+  for i, filename := range filenames {
+    classifiers[i] = ImportKmerLrEnsemble(config, filename)
+  }
+  return classifiers
+}
 
-  if icv != -1 {
-    filename_json  = fmt.Sprintf("%s_cv%d", filename_json , icv+1)
-    filename_trace = fmt.Sprintf("%s_cv%d", filename_trace, icv+1)
-    filename_path  = fmt.Sprintf("%s_cv%d", filename_path , icv+1)
+func learn_parameters(config Config, classifier *KmerLrEnsemble, data_train, data_val, data_test KmerDataSet, icv int, basename_out string) ([]*KmerLrEnsemble, [][]float64, []float64, []float64, []float64) {
+  filename_json  := learn_filename(basename_out, icv)
+  filename_trace := learn_filename(basename_out, icv)
+  filename_path  := learn_filename(basename_out, icv)
+
+  checkpoint := NewCheckpoint(config, filename_json+".checkpoint")
+  if checkpoint != nil && checkpoint.Done {
+    if classifiers := learn_resume(config, checkpoint, filename_json); classifiers != nil {
+      PrintStderr(config, 1, "Skipping estimation of complete models...\n")
+      return evaluate_classifiers(config, classifiers, checkpoint.Lambda, data_train, data_val, data_test)
+    }
+    checkpoint.Reset(config)
   }
+  estimator := NewKmerLrEnsembleEstimator(config, classifier, icv)
+  estimator.SetCheckpoint(checkpoint)
+
+  classifiers, predictions, loss_train, loss_test, lambda := estimator.Estimate(config, data_train, data_val, data_test)
+
   // export trace
   if config.SaveTrace {
     SaveTrace(config, filename_trace+".trace", estimator.GetTrace())
@@ -56,45 +96,112 @@ func learn_parameters(config Config, classifier *KmerLrEnsemble, data_train, dat
   if config.SavePath {
     SaveKmerPath(config, filename_path+".path", estimator.GetPath())
   }
-  if len(classifiers) == 1 {
-    // export models
-    SaveModel(config, filename_json+".json", classifiers[0])
-  } else {
-    for i, classifier := range classifiers {
-      // export models
-      SaveModel(config, fmt.Sprintf("%s_%d.json", filename_json, config.LambdaAuto[i]), classifier)
+  // export models
+  for i, filename := range learn_model_filenames(config, filename_json, len(classifiers)) {
+    // models are incomplete if the estimation was interrupted
+    if classifiers[i].EnsembleSize() > 0 {
+      SaveModel(config, filename, classifiers[i])
     }
   }
-  return classifiers, predictions, loss_train, loss_test
+  if !checkpointStopRequested() {
+    checkpoint.Finish(config, lambda)
+  }
+  return classifiers, predictions, loss_train, loss_test, lambda
 }
 
 func learn_cv(config Config, classifier *KmerLrEnsemble, data KmerDataSet, basename_out string) {
-  learnAndTestClassifiers := func(i int, data_train, data_val, data_test KmerDataSet) ([][]float64, []float64, []float64) {
-    _, predictions, loss_train, loss_test := learn_parameters(config, classifier, data_train, data_val, data_test, i, basename_out)
-    return predictions, loss_train, loss_test
+  learnAndTestClassifiers := func(i int, data_train, data_val, data_test KmerDataSet) ([][]float64, []float64, []float64, []int, []float64) {
+    classifiers, predictions, loss_train, loss_test, lambda := learn_parameters(config, classifier, data_train, data_val, data_test, i, basename_out)
+    nonzero := make([]int, len(classifiers))
+    for j, classifier := range classifiers {
+      nonzero[j] = len(classifier.Features)
+    }
+    return predictions, loss_train, loss_test, nonzero, lambda
   }
   cvrs := crossvalidation(config, data, learnAndTestClassifiers)
 
+  if checkpointStopRequested() {
+    return
+  }
   if len(cvrs) == 1 {
     SaveCrossvalidation    (config, fmt.Sprintf("%s.table"     , basename_out), cvrs[0])
     SaveCrossvalidationLoss(config, fmt.Sprintf("%s_loss.table", basename_out), cvrs[0])
+    SaveCrossvalidationFolds(config, fmt.Sprintf("%s_folds.table", basename_out), cvrs[0])
   } else {
     for i, cvr := range cvrs {
       SaveCrossvalidation    (config, fmt.Sprintf("%s_%d.table"     , basename_out, config.LambdaAuto[i]), cvr)
       SaveCrossvalidationLoss(config, fmt.Sprintf("%s_%d_loss.table", basename_out, config.LambdaAuto[i]), cvr)
+      SaveCrossvalidationFolds(config, fmt.Sprintf("%s_%d_folds.table", basename_out, config.LambdaAuto[i]), cvr)
     }
   }
 }
 
+func learn_nested_cv(config Config, classifier *KmerLrEnsemble, data KmerDataSet, basename_out string) {
+  selected := make([]int, getCvNumberOfFolds(config, data.Groups))
+  learnAndTestClassifiers := func(i int, data_train, data_val, data_test KmerDataSet) ([][]float64, []float64, []float64, []int, []float64) {
+    // skip inner cross-validation if the outer fold is already complete
+    if filename := learn_filename(basename_out, i)+".checkpoint"; config.Resume && config.Checkpoint != 0 {
+      if _, err := os.Stat(filename); err == nil {
+        if checkpoint := ImportCheckpoint(config, filename); checkpoint.Done && len(checkpoint.LambdaAuto) == 1 {
+          config_outer := config
+          config_outer.LambdaAuto = checkpoint.LambdaAuto
+          classifiers, predictions, loss_train, loss_test, lambda := learn_parameters(config_outer, classifier, data_train, data_val, data_test, i, basename_out)
+          selected[i] = checkpoint.LambdaAuto[0]
+          return predictions, loss_train, loss_test, []int{len(classifiers[0].Features)}, lambda
+        }
+      }
+    }
+    // inner cross-validation loop for selecting the number of features
+    config_inner := nestedCvInnerConfig(config)
+    cvrs := crossvalidation(config_inner, data_train, func(j int, data_train, data_val, data_test KmerDataSet) ([][]float64, []float64, []float64, []int, []float64) {
+      estimator := NewKmerLrEnsembleEstimator(config_inner, classifier, j)
+      classifiers, predictions, loss_train, loss_test, lambda := estimator.Estimate(config_inner, data_train, data_val, data_test)
+      return predictions, loss_train, loss_test, make([]int, len(classifiers)), lambda
+    })
+    if checkpointStopRequested() {
+      return nil, nil, nil, nil, nil
+    }
+    k := nestedCvSelect(config, cvrs)
+    // estimate classifier with selected number of features
+    config_outer := config
+    config_outer.LambdaAuto = []int{config.LambdaAuto[k]}
+    classifiers, predictions, loss_train, loss_test, lambda := learn_parameters(config_outer, classifier, data_train, data_val, data_test, i, basename_out)
+    selected[i] = config.LambdaAuto[k]
+    return predictions, loss_train, loss_test, []int{len(classifiers[0].Features)}, lambda
+  }
+  cvrs := crossvalidation(config, data, learnAndTestClassifiers)
+  if checkpointStopRequested() {
+    return
+  }
+  cvrs[0].LambdaAuto = selected
+
+  SaveCrossvalidation     (config, fmt.Sprintf("%s.table"      , basename_out), cvrs[0])
+  SaveCrossvalidationLoss (config, fmt.Sprintf("%s_loss.table" , basename_out), cvrs[0])
+  SaveCrossvalidationFolds(config, fmt.Sprintf("%s_folds.table", basename_out), cvrs[0])
+
+  // refit final classifier on all data
+  config.LambdaAuto = []int{nestedCvConsensus(selected)}
+  PrintStderr(config, 1, "Estimating final classifier with consensus number of features (%d)...\n", config.LambdaAuto[0])
+  learn_parameters(config, classifier, data, KmerDataSet{}, KmerDataSet{}, -1, basename_out)
+}
+
 func learn(config Config, classifier *KmerLrEnsemble, filename_json, filename_fg, filename_bg, basename_out string) {
   if filename_json != "" {
     classifier = ImportKmerLrEnsemble(config, filename_json)
+    if classifier.Regression != config.Regression {
+      log.Fatalf("model `%s' does not match the regression model given by option --regression", filename_json)
+    }
   }
   // do not use classifier.GetKmerCounter() since we do not want to fix the set of kmers!
   kmersCounter, err := NewKmerCounter(classifier.M, classifier.N, classifier.Complement, classifier.Reverse, classifier.Revcomp, classifier.MaxAmbiguous, classifier.Alphabet); if err != nil {
     log.Fatal(err)
   }
-  data := compile_training_data(config, kmersCounter, nil, nil, true, classifier.Binarize, filename_fg, filename_bg)
+  data := KmerDataSet{}
+  if config.Regression != "" {
+    data = compile_training_data_regression(config, kmersCounter, nil, nil, true, classifier.Binarize, filename_fg)
+  } else {
+    data = compile_training_data(config, kmersCounter, nil, nil, true, classifier.Binarize, filename_fg, filename_bg)
+  }
   kmersCounter = nil
 
   if len(data.Data) == 0 {
@@ -104,7 +211,21 @@ func learn(config Config, classifier *KmerLrEnsemble, filename_json, filename_fg
   for i, _ := range data.Data {
     data.Data[i].(SparseConstFloat64Vector).CreateIndex()
   }
-  learn_cv(config, classifier, data, basename_out)
+  // remove signal handlers and reset stop requests once learning is done
+  defer checkpointHandleSignals(config)()
+
+  if config.NestedCV > 1 {
+    learn_nested_cv(config, classifier, data, basename_out)
+  } else {
+    learn_cv(config, classifier, data, basename_out)
+  }
+  if checkpointStopRequested() {
+    if config.Checkpoint != 0 {
+      log.Fatal("Estimation interrupted, continue with --resume")
+    } else {
+      log.Fatal("Estimation interrupted")
+    }
+  }
 }
 
 /* -------------------------------------------------------------------------- */
@@ -122,7 +243,16 @@ func main_learn(config Config, args []string) {
   optRevcomp         := options.   BoolLong("revcomp",            0 ,               "consider reverse complement sequences")
   // other options
   optBalance         := options.   BoolLong("balance",            0 ,               "set class weights so that the data set is balanced")
+  optClassWeights    := options. StringLong("class-weights",      0 ,           "", "class weights w0,w1 of background and foreground samples")
+  optWeightsFile     := options. StringLong("weights-file",       0 ,           "", "file with one sample weight per line (foreground samples first)")
+  optWeightsField    := options.    IntLong("weights-field",      0 ,            0, "field of the fasta headers containing sample weights (fields are separated by white space or `|', the first field is the sequence name)")
+  optRegression      := options. StringLong("regression",         0 ,           "", "estimate a regression model for responses given by --response-field or --response-file instead of a classifier [linear, poisson]")
+  optResponseField   := options.    IntLong("response-field",     0 ,            0, "field of the fasta headers containing responses (fields are separated by white space or `|', the first field is the sequence name)")
+  optResponseFile    := options. StringLong("response-file",      0 ,           "", "file with one response per line")
   optLambda          := options. StringLong("lambda",             0 ,        "NaN", "set fixed regularization strength")
+  optAlpha           := options. StringLong("alpha",              0 ,        "1.0", "elastic-net mixing parameter in (0,1], where the L1 penalty has strength alpha*lambda and the L2 penalty (1-alpha)*lambda [1.0 (default, lasso)]")
+  optGroupLasso      := options. StringLong("group-lasso",        0 ,       "none", "select and penalize groups of k-mers (group lasso) [none (default), length (k-mers of equal length), graph (connected components of the graph of related k-mers), file (groups given by --group-file)]")
+  optGroupFile       := options. StringLong("group-file",         0 ,           "", "file with one k-mer and group name per line for --group-lasso=file (k-mers that are not listed form separate groups)")
   optLambdaAuto      := options. StringLong("lambda-auto",        0 ,          "0", "comma separated list of integers specifying the number of features to select; for each value a separate classifier is estimated")
   optMaxFeatures     := options.    IntLong("max-features",       0 ,            0, "maximum number of features when a fixed lambda is set")
   optCopreselection  := options.    IntLong("co-preselection",    0 ,            0, "pre-select a subset of k-mers for co-occurrence modeling")
@@ -131,6 +261,8 @@ func main_learn(config Config, args []string) {
   optMaxEpochs       := options.    IntLong("max-epochs",         0 ,            0, "maximum number of epochs")
   optMaxIterations   := options.    IntLong("max-iterations",     0 ,            0, "maximum number of iterations")
   optMaxSamples      := options.    IntLong("max-samples",        0 ,            0, "maximum number of samples")
+  optMinQuality      := options.    IntLong("min-quality",        0 ,            0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optAggregateReads  := options.   BoolLong("aggregate-reads",    0 ,               "each fasta/fastq file is a single sample with k-mer counts aggregated over all reads, where several files are joined with `+' (e.g. a.fq+b.fq)")
   optEpsilon         := options. StringLong("epsilon",            0 ,       "0e-0", "optimization tolerance level for parameters")
   optEpsilonLambda   := options. StringLong("epsilon-lambda",     0 ,       "0e-0", "optimization tolerance level for lambda parameter")
   optEpsilonLoss     := options. StringLong("epsilon-loss",       0 ,       "1e-8", "optimization tolerance level for loss function")
@@ -140,20 +272,41 @@ func main_learn(config Config, args []string) {
   optDataTransform   := options. StringLong("data-transform",     0 ,          "",  "transform data before training classifier [none (default), standardizer (preferred for dense data), variance-scaler (preferred for sparse data), max-abs-scaler, mean-scaler]")
   optKFoldCV         := options.    IntLong("k-fold-cv",          0 ,            1, "perform k-fold cross-validation")
   optValidationSize  := options. StringLong("validation-size",    0 ,        "0.0", "fraction of training data that should be used for validation [0.0 (default)]")
+  optCVScheme        := options. StringLong("cv-scheme",          0 ,     "random", "cross-validation scheme [random (default), stratified, grouped, chromosome]")
+  optCVGroupField    := options.    IntLong("cv-group-field",     0 ,            0, "field of the fasta headers containing group ids for grouped cross-validation (fields are separated by white space or `|', the first field is the sequence name)")
+  optCVGroups        := options. StringLong("cv-groups",          0 ,           "", "file with one group id per line (foreground samples first) for grouped cross-validation")
+  optCVBed           := options. StringLong("cv-bed",             0 ,           "", "bed file with one region per sample (foreground samples first) for leave-one-chromosome-out cross-validation")
+  optCVChromosomes   := options. StringLong("cv-chromosomes",     0 ,           "", "chromosome groups for leave-one-chromosome-out cross-validation, e.g. chr1+chr2,chr3 [default: one group per chromosome]")
+  optNestedCV        := options.    IntLong("nested-cv",          0 ,            0, "number of folds of the inner cross-validation loop for selecting the number of features among --lambda-auto values")
+  optNestedCVMetric  := options. StringLong("nested-cv-metric",   0 ,       "loss", "metric for selecting the number of features in nested cross-validation [loss (default), auc, 1se]")
+  optOptimizer       := options. StringLong("optimizer",          0 ,       "saga", "optimization algorithm [saga (default), coordinate (coordinate descent), proximal (proximal gradient descent)], regression models are only supported by proximal (default for regression models)")
   optScaleStepSize   := options. StringLong("scale-step-size",    0 ,        "1.0", "scale standard step-size")
   optPenaltyFree     := options.   BoolLong("penalty-free",       0 ,               "re-estimate parameters without penalty after feature selection")
+  optCheckpoint      := options. StringLong("checkpoint",         0 ,           "", "periodically save the state of all estimators, where the argument is the minimum time between checkpoints, e.g. 30m")
+  optResume          := options.   BoolLong("resume",             0 ,               "resume estimation from the checkpoints of a previous run and skip cross-validation folds whose models are complete (requires --checkpoint)")
+  optMaxTime         := options. StringLong("max-time",           0 ,           "", "save current models and checkpoints and exit after the given time, e.g. 47h30m")
   optAdaptStepSize   := options.   BoolLong("adaptive-step-size", 0 ,               "adaptive step size during optimization")
   optThreadsCV       := options.    IntLong("threads-cv",         0 ,            1, "number of threads for cross-validation")
   optThreadsSaga     := options.    IntLong("threads-saga",       0 ,            1, "number of threads for SAGA algorithm")
   optThreadsLR       := options.    IntLong("threads-lr",         0 ,            1, "number of threads for evaluating the logistic loss and gradient")
+  optThreadsPath     := options.    IntLong("threads-path",       0 ,            1, "number of threads for estimating classifiers of different --lambda-auto values independently (by default classifiers are estimated sequentially, each warm-started with the solution of the next smaller one)")
   optHelp            := options.   BoolLong("help",              'h',               "print help")
 
-  options.SetParameters("<<M> <N>|<MODEL.json>> <FOREGROUND.fa> <BACKGROUND.fa> <BASENAME_RESULT>")
+  options.SetParameters("<<M> <N>|<MODEL.json>> <FOREGROUND.fa> <BACKGROUND.fa> <BASENAME_RESULT>\n" +
+    "       <<M> <N>|<MODEL.json>> <SEQUENCES.fa> <BASENAME_RESULT> (with --regression)")
   options.Parse(args)
 
   // parse arguments
   //////////////////////////////////////////////////////////////////////////////
-  if len(options.Args()) != 4 && len(options.Args()) != 5 {
+  if err := check_regression(*optRegression); err != nil {
+    log.Fatal(err)
+  }
+  // regression models are estimated from a single sequence file
+  k := 0
+  if *optRegression != "" {
+    k = 1
+  }
+  if len(options.Args()) != 4-k && len(options.Args()) != 5-k {
     options.PrintUsage(os.Stdout)
     os.Exit(0)
   }
@@ -162,7 +315,7 @@ func main_learn(config Config, args []string) {
   filename_fg  := ""
   filename_bg  := ""
   basename_out := ""
-  if len(options.Args()) == 5 {
+  if len(options.Args()) == 5-k {
     if m, err := strconv.ParseInt(options.Args()[0], 10, 64); err != nil {
       options.PrintUsage(os.Stderr)
       os.Exit(1)
@@ -180,13 +333,17 @@ func main_learn(config Config, args []string) {
       os.Exit(1)
     }
     filename_fg  = options.Args()[2]
-    filename_bg  = options.Args()[3]
-    basename_out = options.Args()[4]
+    if k == 0 {
+      filename_bg  = options.Args()[3]
+    }
+    basename_out = options.Args()[4-k]
   } else {
     filename_in  = options.Args()[0]
     filename_fg  = options.Args()[1]
-    filename_bg  = options.Args()[2]
-    basename_out = options.Args()[3]
+    if k == 0 {
+      filename_bg  = options.Args()[2]
+    }
+    basename_out = options.Args()[3-k]
   }
   // parse classifier options
   //////////////////////////////////////////////////////////////////////////////
@@ -257,6 +414,14 @@ func main_learn(config Config, args []string) {
   } else {
     config.Lambda = s
   }
+  if s, err := strconv.ParseFloat(*optAlpha, 64); err != nil {
+    log.Fatal(err)
+  } else {
+    if s <= 0.0 || s > 1.0 {
+      log.Fatal("option --alpha must be in the interval (0,1]")
+    }
+    config.Alpha = s
+  }
   if fields := strings.Split(*optLambdaAuto, ","); len(fields) == 0 {
     options.PrintUsage(os.Stderr)
     os.Exit(1)
@@ -290,6 +455,70 @@ func main_learn(config Config, args []string) {
     }
     config.ValidationSize = s
   }
+  switch *optCVScheme {
+  case "random":
+  case "stratified":
+  case "grouped":
+  case "chromosome":
+  default:
+    log.Fatalf("invalid cross-validation scheme `%s'", *optCVScheme)
+  }
+  if *optCVGroupField < 0 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(1)
+  }
+  if *optCVScheme == "grouped" && *optCVGroupField == 0 && *optCVGroups == "" {
+    log.Fatal("grouped cross-validation requires option --cv-group-field or --cv-groups")
+  }
+  if *optCVChromosomes != "" {
+    for _, group := range strings.Split(*optCVChromosomes, ",") {
+      config.CVChromosomes = append(config.CVChromosomes, strings.Split(group, "+"))
+    }
+  }
+  switch *optOptimizer {
+  case "saga":
+  case "coordinate":
+  case "proximal":
+  default:
+    log.Fatalf("invalid optimizer `%s'", *optOptimizer)
+  }
+  switch *optGroupLasso {
+  case "none":
+  case "length":
+  case "graph":
+  case "file":
+    if *optGroupFile == "" {
+      log.Fatal("option --group-lasso=file requires option --group-file")
+    }
+  default:
+    log.Fatalf("invalid group lasso mode `%s'", *optGroupLasso)
+  }
+  if *optGroupLasso != "none" && *optCooccurrence {
+    log.Fatal("options --group-lasso and --co-occurrence are incompatible")
+  }
+  if *optGroupLasso != "none" && *optOptimizer == "coordinate" {
+    log.Fatal("option --group-lasso is not supported by the coordinate descent optimizer")
+  }
+  if *optGroupLasso != "none" {
+    config.GroupLasso = *optGroupLasso
+  }
+  switch *optNestedCVMetric {
+  case "loss":
+  case "auc":
+  case "1se":
+  default:
+    log.Fatalf("invalid nested cross-validation metric `%s'", *optNestedCVMetric)
+  }
+  if *optNestedCV < 0 || *optNestedCV == 1 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(1)
+  }
+  if *optNestedCV > 1 && len(config.LambdaAuto) < 2 {
+    log.Fatal("nested cross-validation requires multiple --lambda-auto values")
+  }
+  if *optNestedCV > 1 && *optKFoldCV < 2 && *optCVScheme != "chromosome" {
+    log.Fatal("nested cross-validation requires option --k-fold-cv")
+  }
   if *optEnsembleSize < 1 {
     options.PrintUsage(os.Stdout)
     os.Exit(1)
@@ -303,17 +532,91 @@ func main_learn(config Config, args []string) {
   if *optThreadsLR > 1 {
     config.PoolLR = threadpool.New(*optThreadsLR, 100)
   }
+  if *optThreadsPath > 1 {
+    config.PoolPath = threadpool.New(*optThreadsPath, 100)
+  }
   config.AdaptStepSize   = *optAdaptStepSize
   config.Balance         = *optBalance
+  if *optClassWeights != "" {
+    if *optBalance {
+      log.Fatal("options --balance and --class-weights cannot be used together")
+    }
+    if w, err := parse_class_weights(*optClassWeights); err != nil {
+      log.Fatal(err)
+    } else {
+      config.ClassWeights = w
+    }
+  }
+  if *optRegression != "" {
+    if *optBalance || *optClassWeights != "" {
+      log.Fatal("options --balance and --class-weights cannot be used with regression models")
+    }
+    if *optCVScheme == "stratified" {
+      log.Fatal("stratified cross-validation is not supported for regression models")
+    }
+    if options.IsSet("optimizer") && *optOptimizer != "proximal" {
+      log.Fatalf("optimizer `%s' is not supported for regression models, which are estimated with proximal gradient descent", *optOptimizer)
+    }
+    if *optNestedCVMetric == "auc" {
+      log.Fatal("nested cross-validation metric `auc' is not supported for regression models")
+    }
+    if *optResponseField <= 0 && *optResponseFile == "" {
+      log.Fatal("regression models require option --response-field or --response-file")
+    }
+  } else {
+    if *optResponseField != 0 || *optResponseFile != "" {
+      log.Fatal("options --response-field and --response-file require option --regression")
+    }
+  }
+  if *optResponseField < 0 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(1)
+  }
+  config.Regression      = *optRegression
+  config.ResponseField   = *optResponseField
+  config.ResponseFile    = *optResponseFile
+  config.WeightsFile     = *optWeightsFile
+  config.WeightsField    = *optWeightsField
   config.Copreselection  = *optCopreselection
   config.EnsembleSize    = *optEnsembleSize
   config.EvalLoss        = *optEvalLoss
   config.KFoldCV         = *optKFoldCV
+  config.CVScheme        = *optCVScheme
+  config.CVGroupField    = *optCVGroupField
+  config.CVGroupsFile    = *optCVGroups
+  config.CVBedFile       = *optCVBed
+  config.NestedCV        = *optNestedCV
+  config.NestedCVMetric  = *optNestedCVMetric
   config.MaxFeatures     = *optMaxFeatures
   config.MaxEpochs       = *optMaxEpochs
   config.MaxIterations   = *optMaxIterations
   config.MaxSamples      = *optMaxSamples
+  config.MinQuality      = *optMinQuality
+  config.AggregateReads  = *optAggregateReads
+  config.Optimizer       = *optOptimizer
+  if *optRegression != "" {
+    config.Optimizer     = "proximal"
+  }
+  config.GroupFile       = *optGroupFile
   config.PenaltyFree     = *optPenaltyFree
+  if *optCheckpoint != "" {
+    if d, err := time.ParseDuration(*optCheckpoint); err != nil || d <= 0 {
+      log.Fatalf("invalid checkpoint interval `%s'", *optCheckpoint)
+    } else {
+      config.Checkpoint = d
+    }
+  }
+  if *optMaxTime != "" {
+    if d, err := time.ParseDuration(*optMaxTime); err != nil || d <= 0 {
+      log.Fatalf("invalid time budget `%s'", *optMaxTime)
+    } else {
+      config.MaxTime = d
+    }
+  }
+  if *optResume && *optCheckpoint == "" {
+    log.Fatal("option --resume requires option --checkpoint")
+  }
+  config.Resume          = *optResume
   config.SaveTrace       = *optSaveTrace
   config.SavePath        = *optSavePath
   config.DataTransform   = *optDataTransform
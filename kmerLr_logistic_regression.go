@@ -30,7 +30,17 @@ import   "github.com/pbenner/threadpool"
 type logisticRegression struct {
   Theta         []float64
   ClassWeights [2]float64
+  // optional weight of each sample
+  Weights       []float64
+  // regression model (linear or poisson) with responses for
+  // each sample, the logistic loss is used if not set
+  Regression      string
+  Responses     []float64
+  // strength of the L1 and L2 penalty
   Lambda          float64
+  Lambda2         float64
+  // evaluate L1 penalty on groups of coefficients
+  Groups          featureGroups
   Cooccurrence    bool
   // apply transform on the fly because training data
   // does not include co-occurrences
@@ -148,6 +158,26 @@ func (obj logisticRegression) LogPdf(v SparseConstFloat64Vector) float64 {
   return obj.ClassLogPdf(v, true)
 }
 
+// Weight of the i-th sample with label y
+func (obj logisticRegression) weight(i int, y bool) float64 {
+  w := obj.ClassWeights[0]
+  if y {
+    w = obj.ClassWeights[1]
+  }
+  if obj.Weights != nil {
+    w *= obj.Weights[i]
+  }
+  return w
+}
+
+// Weight of the i-th sample in regression mode (class weights are ignored)
+func (obj logisticRegression) sampleWeight(i int) float64 {
+  if obj.Weights != nil {
+    return obj.Weights[i]
+  }
+  return 1.0
+}
+
 func (obj logisticRegression) Gradient(g []float64, data []ConstVector, labels []bool) []float64 {
   if len(data) == 0 {
     return nil
@@ -165,16 +195,21 @@ func (obj logisticRegression) Gradient(g []float64, data []ConstVector, labels [
   }
   for i_ := 0; i_ < len(data); i_++ {
     w := 0.0
-    r := obj.LogPdf(data[i_].(SparseConstFloat64Vector))
     i := data[i_].(SparseConstFloat64Vector).GetSparseIndices()
     v := data[i_].(SparseConstFloat64Vector).GetSparseValues ()
     n := data[i_].Dim()-1
     q := len(i)
 
-    if labels[i_] {
-      w = 1.0/float64(len(data))*obj.ClassWeights[1]*(math.Exp(r) - 1.0)
+    if obj.Regression != "" {
+      r := obj.LinearPdf(data[i_].(SparseConstFloat64Vector))
+      w  = 1.0/float64(len(data))*obj.sampleWeight(i_)*(regression_mean(obj.Regression, r) - obj.Responses[i_])
     } else {
-      w = 1.0/float64(len(data))*obj.ClassWeights[0]*(math.Exp(r))
+      r := obj.LogPdf(data[i_].(SparseConstFloat64Vector))
+      if labels[i_] {
+        w = 1.0/float64(len(data))*obj.weight(i_, true )*(math.Exp(r) - 1.0)
+      } else {
+        w = 1.0/float64(len(data))*obj.weight(i_, false)*(math.Exp(r))
+      }
     }
     if obj.Transform.Nil() {
       for j := 0; j < q; j++ {
@@ -244,6 +279,11 @@ func (obj logisticRegression) Gradient(g []float64, data []ConstVector, labels [
       }
     }
   }
+  if !math.IsNaN(obj.Lambda2) && obj.Lambda2 != 0.0 {
+    for j := 1; j < len(obj.Theta); j++ {
+      g[j] += obj.Lambda2*obj.Theta[j]
+    }
+  }
   return g
 }
 
@@ -256,16 +296,25 @@ func (obj logisticRegression) Loss(data []ConstVector, c []bool) float64 {
   r := 0.0
 
   for i := 0; i < n; i++ {
-    if c[i] {
-      r -= obj.ClassWeights[1]*obj.ClassLogPdf(data[i].(SparseConstFloat64Vector), c[i])
+    if obj.Regression != "" {
+      r += obj.sampleWeight(i)*regression_loss(obj.Regression, obj.LinearPdf(data[i].(SparseConstFloat64Vector)), obj.Responses[i])
     } else {
-      r -= obj.ClassWeights[0]*obj.ClassLogPdf(data[i].(SparseConstFloat64Vector), c[i])
+      r -= obj.weight(i, c[i])*obj.ClassLogPdf(data[i].(SparseConstFloat64Vector), c[i])
     }
   }
   r = r/float64(len(data))
   if !math.IsNaN(obj.Lambda) && obj.Lambda != 0.0 {
+    if obj.Groups.Nil() {
+      for j := 1; j < m; j++ {
+        r += obj.Lambda*math.Abs(obj.Theta[j])
+      }
+    } else {
+      r += obj.Lambda*obj.Groups.Penalty(obj.Theta[0:m])
+    }
+  }
+  if !math.IsNaN(obj.Lambda2) && obj.Lambda2 != 0.0 {
     for j := 1; j < m; j++ {
-      r += obj.Lambda*math.Abs(obj.Theta[j])
+      r += obj.Lambda2/2.0*obj.Theta[j]*obj.Theta[j]
     }
   }
   return r
@@ -34,7 +34,7 @@ func saveLoss(filename string, loss float64) {
   if filename == "" {
     writer = os.Stdout
   } else {
-    f, err := os.Create(filename)
+    f, err := create_file(filename)
     if err != nil {
       panic(err)
     }
@@ -52,11 +52,14 @@ func saveLoss(filename string, loss float64) {
 
 func loss_(config Config, filename_json, filename_fg, filename_bg string) float64 {
   classifier := ImportKmerLrEnsemble(config, filename_json)
+  if classifier.Regression != "" {
+    log.Fatalf("model `%s' is a regression model, use the cross-validation results of the learn command instead", filename_json)
+  }
   counter    := classifier.GetKmerCounter()
   data       := compile_training_data(config, counter, classifier.Kmers, classifier.Features, false, classifier.Binarize, filename_fg, filename_bg)
   classifier.Transform.Apply(config, data.Data)
 
-  return classifier.Loss(config, data.Data, data.Labels)
+  return classifier.Loss(config, data.Data, data.Labels, data.Weights)
 }
 
 func loss(config Config, filename_json, filename_fg, filename_bg, filename_out string) {
@@ -68,9 +71,15 @@ func loss(config Config, filename_json, filename_fg, filename_bg, filename_out s
 func main_loss(config Config, args []string) {
   options := getopt.New()
 
-  optBalance := options.  BoolLong("balance", 0 ,        "set class weights so that the data set is balanced")
-  optLambda  := options.StringLong("lambda",  0 , "0.0", "regularization strength (L1)")
-  optHelp    := options.  BoolLong("help",   'h',        "print help")
+  optBalance        := options.  BoolLong("balance",         0 ,        "set class weights so that the data set is balanced")
+  optClassWeights   := options.StringLong("class-weights",   0 ,    "", "class weights w0,w1 of background and foreground samples")
+  optWeightsFile    := options.StringLong("weights-file",    0 ,    "", "file with one sample weight per line (foreground samples first)")
+  optWeightsField   := options.   IntLong("weights-field",   0 ,     0, "field of the fasta headers containing sample weights (fields are separated by white space or `|', the first field is the sequence name)")
+  optLambda         := options.StringLong("lambda",          0 , "0.0", "regularization strength")
+  optAlpha          := options.StringLong("alpha",           0 , "1.0", "elastic-net mixing parameter, where the L1 penalty has strength alpha*lambda and the L2 penalty (1-alpha)*lambda")
+  optMinQuality     := options.   IntLong("min-quality",     0 ,     0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optAggregateReads := options.  BoolLong("aggregate-reads", 0 ,        "each fasta/fastq file is a single sample with k-mer counts aggregated over all reads, where several files are joined with `+' (e.g. a.fq+b.fq)")
+  optHelp           := options.  BoolLong("help",           'h',        "print help")
 
   options.SetParameters("<MODEL.json> <FOREGROUND.fa> <BACKGROUND.fa> [RESULT.table]")
   options.Parse(args)
@@ -92,7 +101,29 @@ func main_loss(config Config, args []string) {
   } else {
     config.Lambda = v
   }
-  config.Balance = *optBalance
+  if v, err := strconv.ParseFloat(*optAlpha, 64); err != nil {
+    log.Fatal(err)
+  } else {
+    if v <= 0.0 || v > 1.0 {
+      log.Fatal("option --alpha must be in the interval (0,1]")
+    }
+    config.Alpha = v
+  }
+  config.Balance        = *optBalance
+  if *optClassWeights != "" {
+    if *optBalance {
+      log.Fatal("options --balance and --class-weights cannot be used together")
+    }
+    if w, err := parse_class_weights(*optClassWeights); err != nil {
+      log.Fatal(err)
+    } else {
+      config.ClassWeights = w
+    }
+  }
+  config.WeightsFile    = *optWeightsFile
+  config.WeightsField   = *optWeightsField
+  config.MinQuality     = *optMinQuality
+  config.AggregateReads = *optAggregateReads
 
   filename_json := options.Args()[0]
   filename_fg   := options.Args()[1]
@@ -0,0 +1,655 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "io"
+import   "log"
+import   "math"
+import   "os"
+import   "sort"
+import   "strconv"
+import   "strings"
+
+import . "github.com/pbenner/gonetics"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+// Position frequency matrix of a motif database, where columns are given in
+// the order of databaseBases
+type databaseMotif struct {
+  Id     string
+  Name   string
+  P  [][]float64
+}
+
+// Motifs of the database and background frequencies
+type motifDatabase struct {
+  Motifs     []databaseMotif
+  Background []float64
+}
+
+const databaseBases = "acgt"
+
+// Pseudocount added to database frequencies, which avoids infinite scores
+const databasePseudocount = 0.01
+
+// Bin width used for discretizing scores when computing p-values
+const matchBinWidth = 0.01
+
+/* -------------------------------------------------------------------------- */
+
+func motif_normalize(p []float64) ([]float64, error) {
+  s := sum_float64(p)
+  if s <= 0.0 {
+    return nil, fmt.Errorf("invalid column with non-positive sum")
+  }
+  r := make([]float64, len(p))
+  for i, v := range p {
+    if v < 0.0 {
+      return nil, fmt.Errorf("invalid negative frequency")
+    }
+    r[i] = (v/s + databasePseudocount)/(1.0 + float64(len(p))*databasePseudocount)
+  }
+  return r, nil
+}
+
+func parse_float64_fields(fields []string) ([]float64, error) {
+  r := make([]float64, len(fields))
+  for i, field := range fields {
+    if v, err := strconv.ParseFloat(field, 64); err != nil {
+      return nil, err
+    } else {
+      r[i] = v
+    }
+  }
+  return r, nil
+}
+
+func import_motif_database_meme(lines []string) (motifDatabase, error) {
+  r := motifDatabase{}
+  for i := 0; i < len(lines); i++ {
+    fields := strings.Fields(lines[i])
+    switch {
+    case len(fields) == 0:
+    case strings.HasPrefix(fields[0], "ALPHABET"):
+      if alphabet := strings.TrimSpace(strings.TrimPrefix(lines[i], "ALPHABET=")); strings.ToLower(alphabet) != databaseBases {
+        return r, fmt.Errorf("line %d: unsupported alphabet `%s'", i+1, alphabet)
+      }
+    case fields[0] == "Background":
+      // frequencies might span several lines
+      values := map[byte]float64{}
+      for i++; i < len(lines) && len(values) < len(databaseBases); i++ {
+        f := strings.Fields(lines[i])
+        for j := 0; j+1 < len(f); j += 2 {
+          v, err := strconv.ParseFloat(f[j+1], 64)
+          if err != nil || len(f[j]) != 1 || strings.IndexByte(databaseBases, strings.ToLower(f[j])[0]) == -1 {
+            return r, fmt.Errorf("line %d: invalid background frequencies", i+1)
+          }
+          values[strings.ToLower(f[j])[0]] = v
+        }
+      }
+      i--
+      r.Background = make([]float64, len(databaseBases))
+      for j := 0; j < len(databaseBases); j++ {
+        r.Background[j] = values[databaseBases[j]]
+      }
+    case fields[0] == "MOTIF":
+      if len(fields) < 2 {
+        return r, fmt.Errorf("line %d: motif without identifier", i+1)
+      }
+      motif := databaseMotif{Id: fields[1], Name: fields[1]}
+      if len(fields) > 2 {
+        motif.Name = fields[2]
+      }
+      r.Motifs = append(r.Motifs, motif)
+    case strings.HasPrefix(lines[i], "letter-probability matrix"):
+      if len(r.Motifs) == 0 {
+        return r, fmt.Errorf("line %d: matrix without motif", i+1)
+      }
+      w := -1
+      for j := 0; j+1 < len(fields); j++ {
+        if fields[j] == "w=" {
+          w, _ = strconv.Atoi(fields[j+1])
+        }
+      }
+      if w < 1 {
+        return r, fmt.Errorf("line %d: invalid motif width", i+1)
+      }
+      motif := &r.Motifs[len(r.Motifs)-1]
+      for i++; i < len(lines) && len(motif.P) < w; i++ {
+        if strings.TrimSpace(lines[i]) == "" {
+          continue
+        }
+        p, err := parse_float64_fields(strings.Fields(lines[i]))
+        if err != nil || len(p) != len(databaseBases) {
+          return r, fmt.Errorf("line %d: invalid matrix row", i+1)
+        }
+        if p, err = motif_normalize(p); err != nil {
+          return r, fmt.Errorf("line %d: %v", i+1, err)
+        }
+        motif.P = append(motif.P, p)
+      }
+      i--
+      if len(motif.P) != w {
+        return r, fmt.Errorf("motif `%s' has invalid number of rows", motif.Id)
+      }
+    }
+  }
+  return r, nil
+}
+
+// Rows are either labeled with bases (e.g. `A [ 1 2 3 ]') or given in the
+// order A, C, G, T
+func import_motif_database_jaspar(lines []string) (motifDatabase, error) {
+  r    := motifDatabase{}
+  rows := [][]float64{}
+  done := func() error {
+    if len(r.Motifs) == 0 {
+      return nil
+    }
+    motif := &r.Motifs[len(r.Motifs)-1]
+    if len(rows) != len(databaseBases) || len(rows[0]) == 0 {
+      return fmt.Errorf("motif `%s' has invalid number of rows", motif.Id)
+    }
+    for i := 0; i < len(rows[0]); i++ {
+      p := make([]float64, len(databaseBases))
+      for j := 0; j < len(rows); j++ {
+        if len(rows[j]) != len(rows[0]) {
+          return fmt.Errorf("motif `%s' has rows of different lengths", motif.Id)
+        }
+        p[j] = rows[j][i]
+      }
+      if p, err := motif_normalize(p); err != nil {
+        return fmt.Errorf("motif `%s': %v", motif.Id, err)
+      } else {
+        motif.P = append(motif.P, p)
+      }
+    }
+    rows = [][]float64{}
+    return nil
+  }
+  for i := 0; i < len(lines); i++ {
+    line := strings.TrimSpace(lines[i])
+    if line == "" {
+      continue
+    }
+    if line[0] == '>' {
+      if err := done(); err != nil {
+        return r, err
+      }
+      fields := strings.Fields(line[1:])
+      if len(fields) == 0 {
+        return r, fmt.Errorf("line %d: motif without identifier", i+1)
+      }
+      motif := databaseMotif{Id: fields[0], Name: fields[0]}
+      if len(fields) > 1 {
+        motif.Name = fields[1]
+      }
+      r.Motifs = append(r.Motifs, motif)
+      continue
+    }
+    if len(r.Motifs) == 0 {
+      return r, fmt.Errorf("line %d: matrix without motif", i+1)
+    }
+    fields := strings.Fields(strings.NewReplacer("[", " ", "]", " ").Replace(line))
+    j      := len(rows)
+    if len(fields) > 0 && strings.IndexByte("ACGTacgt", fields[0][0]) != -1 {
+      j      = strings.IndexByte(databaseBases, strings.ToLower(fields[0])[0])
+      fields = fields[1:]
+    }
+    if j != len(rows) {
+      return r, fmt.Errorf("line %d: rows must be given in the order A, C, G, T", i+1)
+    }
+    if p, err := parse_float64_fields(fields); err != nil {
+      return r, fmt.Errorf("line %d: invalid matrix row", i+1)
+    } else {
+      rows = append(rows, p)
+    }
+  }
+  return r, done()
+}
+
+// Read MEME or JASPAR files with DNA motifs, the format is detected from the
+// content of the file
+func import_motif_database(config Config, filename string) motifDatabase {
+  f, err := open_file(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer f.Close()
+
+  PrintStderr(config, 1, "Reading motif database from `%s'... ", filename)
+  lines   := []string{}
+  scanner := bufio.NewScanner(f)
+  scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+  for scanner.Scan() {
+    lines = append(lines, scanner.Text())
+  }
+  if err := scanner.Err(); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  var r motifDatabase
+  for _, line := range lines {
+    if line = strings.TrimSpace(line); line == "" {
+      continue
+    }
+    if strings.HasPrefix(line, "MEME version") {
+      r, err = import_motif_database_meme(lines)
+    } else if line[0] == '>' {
+      r, err = import_motif_database_jaspar(lines)
+    } else {
+      err = fmt.Errorf("unknown motif database format")
+    }
+    break
+  }
+  if err == nil && len(r.Motifs) == 0 {
+    err = fmt.Errorf("motif database is empty")
+  }
+  if err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatalf("reading motif database `%s' failed: %v", filename, err)
+  }
+  if r.Background == nil {
+    r.Background = make([]float64, len(databaseBases))
+    for j := 0; j < len(r.Background); j++ {
+      r.Background[j] = 1.0/float64(len(databaseBases))
+    }
+  } else if r.Background, err = motif_normalize(r.Background); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatalf("reading motif database `%s' failed: background: %v", filename, err)
+  }
+  PrintStderr(config, 1, "done\n")
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Transformation of database motifs according to the symmetries of the k-mer
+// model, i.e. the reverse complement is only considered if the model does not
+// distinguish between both strands
+type motifStrand struct {
+  Name       byte
+  Reverse    bool
+  Complement bool
+}
+
+func motif_strands(classifier *KmerLrEnsemble) []motifStrand {
+  r := []motifStrand{{'+', false, false}}
+  if classifier.Revcomp {
+    r = append(r, motifStrand{'-', true, true})
+  }
+  if classifier.Complement {
+    r = append(r, motifStrand{'c', false, true})
+  }
+  if classifier.Reverse {
+    r = append(r, motifStrand{'r', true, false})
+  }
+  return r
+}
+
+// Columns are complemented by reversing the order A, C, G, T
+func (obj motifStrand) Apply(p [][]float64) [][]float64 {
+  r := make([][]float64, len(p))
+  for i := 0; i < len(p); i++ {
+    j := i
+    if obj.Reverse {
+      j = len(p)-i-1
+    }
+    r[j] = make([]float64, len(p[i]))
+    for k := 0; k < len(p[i]); k++ {
+      if obj.Complement {
+        r[j][len(p[i])-k-1] = p[i][k]
+      } else {
+        r[j][k] = p[i][k]
+      }
+    }
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Discretized score distribution, where P[i] is the probability of score
+// (Min+i)*matchBinWidth
+type scoreDistribution struct {
+  Min int
+  P []float64
+}
+
+func (obj scoreDistribution) Convolve(b scoreDistribution) scoreDistribution {
+  r := scoreDistribution{Min: obj.Min+b.Min, P: make([]float64, len(obj.P)+len(b.P)-1)}
+  for i, p := range obj.P {
+    if p == 0.0 {
+      continue
+    }
+    for j, q := range b.P {
+      r.P[i+j] += p*q
+    }
+  }
+  return r
+}
+
+// Probability of observing a score of at least x
+func (obj scoreDistribution) Tail(x int) float64 {
+  r := 0.0
+  for i := len(obj.P)-1; i >= 0 && obj.Min+i >= x; i-- {
+    r += obj.P[i]
+  }
+  return math.Min(r, 1.0)
+}
+
+func match_score_bin(x float64) int {
+  return int(math.Round(x/matchBinWidth))
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Query motif, which is either a single k-mer of the model or a motif
+// derived from k-mers
+type motifQuery struct {
+  Name        string
+  Coefficient float64
+  P       [][]float64
+}
+
+type motifMatch struct {
+  Query      int
+  Target     int
+  Score      float64
+  PValue     float64
+  Offset     int
+  Strand     byte
+}
+
+// Compare queries to database motifs similar to Tomtom, i.e. columns are
+// compared by the expected log-odds score of the database motif, where the
+// expectation is taken with respect to the query column. P-values are
+// computed under the null hypothesis that database columns are drawn
+// independently from all columns of the database. All alignments are
+// considered where the shorter motif is fully contained in the longer one.
+type motifMatcher struct {
+  database   motifDatabase
+  strands  []motifStrand
+  // database motifs transformed with respect to each strand
+  targets  [][][][]float64
+  // all columns of the database
+  columns  [][]float64
+}
+
+func newMotifMatcher(database motifDatabase, strands []motifStrand) *motifMatcher {
+  r := motifMatcher{database: database, strands: strands}
+  r.targets = make([][][][]float64, len(database.Motifs))
+  for i, motif := range database.Motifs {
+    r.targets[i] = make([][][]float64, len(strands))
+    for s, strand := range strands {
+      r.targets[i][s] = strand.Apply(motif.P)
+      r.columns       = append(r.columns, r.targets[i][s]...)
+    }
+  }
+  return &r
+}
+
+func (obj *motifMatcher) columnScore(q, p []float64) float64 {
+  r := 0.0
+  for b := 0; b < len(q); b++ {
+    if q[b] > 0.0 {
+      r += q[b]*math.Log(p[b]/obj.database.Background[b])
+    }
+  }
+  return r
+}
+
+// Null distribution of column scores for each query column
+func (obj *motifMatcher) columnDistributions(query [][]float64) []scoreDistribution {
+  r := make([]scoreDistribution, len(query))
+  for i := 0; i < len(query); i++ {
+    bins := make([]int, len(obj.columns))
+    min, max := 0, 0
+    for j, p := range obj.columns {
+      bins[j] = match_score_bin(obj.columnScore(query[i], p))
+      if j == 0 || bins[j] < min {
+        min = bins[j]
+      }
+      if j == 0 || bins[j] > max {
+        max = bins[j]
+      }
+    }
+    r[i] = scoreDistribution{Min: min, P: make([]float64, max-min+1)}
+    for _, b := range bins {
+      r[i].P[b-min] += 1.0/float64(len(bins))
+    }
+  }
+  return r
+}
+
+// Best match of the query with each database motif
+func (obj *motifMatcher) Match(query [][]float64) []motifMatch {
+  columns := obj.columnDistributions(query)
+  // null distributions of query columns [i, j) are computed on demand
+  null    := make(map[[2]int]scoreDistribution)
+  tail    := func(i, j, x int) float64 {
+    if _, ok := null[[2]int{i, j}]; !ok {
+      d := columns[i]
+      for k := i+1; k < j; k++ {
+        d = d.Convolve(columns[k])
+      }
+      null[[2]int{i, j}] = d
+    }
+    return null[[2]int{i, j}].Tail(x)
+  }
+  r := make([]motifMatch, len(obj.targets))
+  for t := 0; t < len(obj.targets); t++ {
+    n := 0
+    for s := 0; s < len(obj.strands); s++ {
+      target := obj.targets[t][s]
+      w      := len(query)
+      if len(target) < w {
+        w = len(target)
+      }
+      // offset of the query relative to the target, which is negative if
+      // the target is contained in the query
+      from, to := len(target)-len(query), 0
+      if from > to {
+        from, to = to, from
+      }
+      for offset := from; offset <= to; offset++ {
+        score := 0.0
+        bins  := 0
+        for k := 0; k < w; k++ {
+          i, j := k, k
+          if offset < 0 {
+            i -= offset
+          } else {
+            j += offset
+          }
+          c     := obj.columnScore(query[i], target[j])
+          score += c
+          bins  += match_score_bin(c)
+        }
+        i := 0
+        if offset < 0 {
+          i = -offset
+        }
+        p := tail(i, i+w, bins)
+        if n == 0 || p < r[t].PValue || (p == r[t].PValue && score > r[t].Score) {
+          r[t] = motifMatch{Target: t, Score: score, PValue: p, Offset: offset, Strand: obj.strands[s].Name}
+        }
+        n++
+      }
+    }
+    // correct for the number of alignments
+    r[t].PValue = -math.Expm1(float64(n)*math.Log1p(-r[t].PValue))
+  }
+  sort.SliceStable(r, func(i, j int) bool {
+    if r[i].PValue != r[j].PValue {
+      return r[i].PValue < r[j].PValue
+    }
+    return r[i].Score > r[j].Score
+  })
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Query matrix of a k-mer, where ambiguous letters are distributed uniformly
+// over all matching bases
+func match_kmer_query(alphabet ComplementableAlphabet, element string) [][]float64 {
+  r := make([][]float64, len(element))
+  for i := 0; i < len(element); i++ {
+    bases, err := alphabet.Bases(element[i])
+    if err != nil {
+      log.Fatal(err)
+    }
+    r[i] = make([]float64, len(databaseBases))
+    for _, b := range bases {
+      r[i][strings.IndexByte(databaseBases, b)] += 1.0/float64(len(bases))
+    }
+  }
+  return r
+}
+
+func match_queries(config Config, classifier *KmerLrEnsemble, use_motifs bool, filename_fg string, min_overlap, max_mismatches, min_kmers int) []motifQuery {
+  if string(mutagenesis_bases(classifier)) != databaseBases {
+    log.Fatal("motif databases are only supported for nucleotide models")
+  }
+  r := []motifQuery{}
+  if use_motifs {
+    for _, motif := range derive_motifs(config, classifier, filename_fg, min_overlap, max_mismatches, min_kmers) {
+      r = append(r, motifQuery{motif.Name, float64(motif.Sign)*motif.Weight, motif.Probabilities()})
+    }
+  } else {
+    for i, v := range motifs_kmer_coefficients(classifier) {
+      if v != 0.0 {
+        kmer := classifier.Kmers[i]
+        r = append(r, motifQuery{kmer.String(), v, match_kmer_query(classifier.Alphabet, kmer.Elements[0])})
+      }
+    }
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func saveMotifMatches(writer io.Writer, database motifDatabase, queries []motifQuery, matches [][]motifMatch) {
+  fmt.Fprintf(writer, "%s\t%15s\t%4s\t%s\t%s\t%15s\t%15s\t%6s\t%6s\n", "query", "coefficient", "rank", "target_id", "target_name", "score", "p-value", "offset", "strand")
+  for i, query := range queries {
+    for k, m := range matches[i] {
+      target := database.Motifs[m.Target]
+      fmt.Fprintf(writer, "%s\t%15e\t%4d\t%s\t%s\t%15e\t%15e\t%6d\t%6c\n", query.Name, query.Coefficient, k+1, target.Id, target.Name, m.Score, m.PValue, m.Offset, m.Strand)
+    }
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func match_motifs(config Config, filename_json, filename_db, filename_out string, use_motifs bool, filename_fg string, min_overlap, max_mismatches, min_kmers, top int, max_pvalue float64) {
+  if is_multinomial_model(filename_json) {
+    log.Fatal("motif matching is not supported for multinomial models")
+  }
+  classifier := ImportKmerLrEnsemble(config, filename_json)
+  database   := import_motif_database(config, filename_db)
+  queries    := match_queries(config, classifier, use_motifs, filename_fg, min_overlap, max_mismatches, min_kmers)
+  matcher    := newMotifMatcher(database, motif_strands(classifier))
+  matches    := make([][]motifMatch, len(queries))
+
+  PrintStderr(config, 1, "Matching %d queries against %d motifs... ", len(queries), len(database.Motifs))
+  for i, query := range queries {
+    for _, m := range matcher.Match(query.P) {
+      if len(matches[i]) >= top || m.PValue > max_pvalue {
+        break
+      }
+      m.Query    = i
+      matches[i] = append(matches[i], m)
+    }
+  }
+  PrintStderr(config, 1, "done\n")
+
+  var writer io.Writer
+  if filename_out == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(compress_filename(config, filename_out))
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  saveMotifMatches(writer, database, queries, matches)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_match_motifs(config Config, args []string) {
+  options := getopt.New()
+
+  optMotifs        := options.  BoolLong("motifs",          0 ,      "match motifs derived from k-mers instead of single k-mers")
+  optForeground    := options.StringLong("foreground",      0 ,  "", "weight k-mers by occurrences in foreground sequences when deriving motifs")
+  optMinOverlap    := options.   IntLong("min-overlap",     0 ,   3, "minimal number of matching positions when aligning a k-mer to a motif [default: 3]")
+  optMaxMismatches := options.   IntLong("max-mismatches",  0 ,   0, "maximal number of mismatches when aligning a k-mer to a motif [default: 0]")
+  optMinKmers      := options.   IntLong("min-kmers",       0 ,   1, "minimal number of k-mers of a motif [default: 1]")
+  optTop           := options.   IntLong("top",             0 ,   3, "number of reported matches for each query [default: 3]")
+  optMaxPValue     := options.StringLong("max-p-value",     0 , "1", "report only matches with p-value smaller or equal [default: 1]")
+  optHelp          := options.  BoolLong("help",          'h',       "print help")
+
+  options.SetParameters("<MODEL.json> <DATABASE.meme|DATABASE.jaspar> [RESULT.table]")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if *optMinOverlap < 1 {
+    log.Fatalf("invalid minimal overlap `%d'", *optMinOverlap)
+  }
+  if *optMaxMismatches < 0 {
+    log.Fatalf("invalid maximal number of mismatches `%d'", *optMaxMismatches)
+  }
+  if *optTop < 1 {
+    log.Fatalf("invalid number of matches `%d'", *optTop)
+  }
+  max_pvalue, err := strconv.ParseFloat(*optMaxPValue, 64)
+  if err != nil || max_pvalue < 0.0 || max_pvalue > 1.0 {
+    log.Fatalf("invalid maximal p-value `%s'", *optMaxPValue)
+  }
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) != 2 && len(options.Args()) != 3 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  filename_json := options.Args()[0]
+  filename_db   := options.Args()[1]
+  filename_out  := ""
+  if len(options.Args()) == 3 {
+    filename_out = options.Args()[2]
+  }
+  match_motifs(config, filename_json, filename_db, filename_out, *optMotifs, *optForeground, *optMinOverlap, *optMaxMismatches, *optMinKmers, *optTop, max_pvalue)
+}
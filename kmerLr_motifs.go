@@ -0,0 +1,471 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "io"
+import   "log"
+import   "math"
+import   "os"
+import   "sort"
+import   "strings"
+
+import . "github.com/pbenner/gonetics"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+// K-mer of a motif, where Element is the member of the k-mer class (e.g.
+// the reverse complement) that is aligned at the given offset
+type alignedKmer struct {
+  Kmer     KmerClass
+  Element  string
+  Offset   int
+  Weight   float64
+}
+
+// Motif given by a cluster of aligned k-mers with positive (Sign = 1) or
+// negative (Sign = -1) coefficients. Counts contains the weighted frequency
+// of each base at each position.
+type kmerMotif struct {
+  Name     string
+  Sign     int
+  Weight   float64
+  Kmers  []alignedKmer
+  Counts [][]float64
+  alphabet ComplementableAlphabet
+  bases  []byte
+}
+
+func newKmerMotif(alphabet ComplementableAlphabet, bases []byte, sign int) *kmerMotif {
+  return &kmerMotif{Sign: sign, alphabet: alphabet, bases: bases}
+}
+
+func (obj *kmerMotif) Width() int {
+  return len(obj.Counts)
+}
+
+// Bases matching letter c of a k-mer, which returns nil for wildcards
+func (obj *kmerMotif) matching(c byte) []byte {
+  if bases, err := obj.alphabet.Bases(c); err != nil {
+    log.Fatal(err)
+    return nil
+  } else {
+    if len(bases) >= len(obj.bases) {
+      return nil
+    }
+    return bases
+  }
+}
+
+// Index of the most frequent base at position i
+func (obj *kmerMotif) consensus(i int) int {
+  r := 0
+  for j := 1; j < len(obj.bases); j++ {
+    if obj.Counts[i][j] > obj.Counts[i][r] {
+      r = j
+    }
+  }
+  return r
+}
+
+func (obj *kmerMotif) Consensus() string {
+  r := make([]byte, obj.Width())
+  for i := 0; i < len(r); i++ {
+    if s := sum_float64(obj.Counts[i]); s == 0.0 {
+      r[i] = 'n'
+    } else {
+      r[i] = obj.bases[obj.consensus(i)]
+    }
+  }
+  return string(r)
+}
+
+func (obj *kmerMotif) Probabilities() [][]float64 {
+  r := make([][]float64, obj.Width())
+  for i := 0; i < len(r); i++ {
+    r[i] = make([]float64, len(obj.bases))
+    s   := sum_float64(obj.Counts[i])
+    for j := 0; j < len(obj.bases); j++ {
+      if s == 0.0 {
+        r[i][j] = 1.0/float64(len(obj.bases))
+      } else {
+        r[i][j] = obj.Counts[i][j]/s
+      }
+    }
+  }
+  return r
+}
+
+// Add k-mer element at the given offset, which may extend the motif at
+// both ends
+func (obj *kmerMotif) Add(kmer KmerClass, element string, offset int, weight float64) {
+  if offset < 0 {
+    counts := make([][]float64, -offset)
+    for i := 0; i < len(counts); i++ {
+      counts[i] = make([]float64, len(obj.bases))
+    }
+    obj.Counts = append(counts, obj.Counts...)
+    for i := 0; i < len(obj.Kmers); i++ {
+      obj.Kmers[i].Offset -= offset
+    }
+    offset = 0
+  }
+  for len(obj.Counts) < offset+len(element) {
+    obj.Counts = append(obj.Counts, make([]float64, len(obj.bases)))
+  }
+  for i := 0; i < len(element); i++ {
+    if bases := obj.matching(element[i]); bases != nil {
+      for _, b := range bases {
+        obj.Counts[offset+i][strings.IndexByte(string(obj.bases), b)] += weight/float64(len(bases))
+      }
+    }
+  }
+  obj.Kmers   = append(obj.Kmers, alignedKmer{kmer, element, offset, weight})
+  obj.Weight += weight
+}
+
+// Find the best alignment of a k-mer element with the consensus of the
+// motif. The overlap is the number of matching positions, excluding
+// wildcards.
+func (obj *kmerMotif) Match(element string, min_overlap, max_mismatches int) (int, int, int, bool) {
+  n := 0
+  for i := 0; i < len(element); i++ {
+    if obj.matching(element[i]) != nil {
+      n++
+    }
+  }
+  if n < min_overlap {
+    min_overlap = n
+  }
+  best_offset     := 0
+  best_overlap    := 0
+  best_mismatches := 0
+  ok := false
+  for offset := -len(element)+1; offset < obj.Width(); offset++ {
+    overlap    := 0
+    mismatches := 0
+    for i := 0; i < len(element); i++ {
+      if offset+i < 0 || offset+i >= obj.Width() {
+        continue
+      }
+      bases := obj.matching(element[i])
+      if bases == nil || sum_float64(obj.Counts[offset+i]) == 0.0 {
+        continue
+      }
+      if strings.IndexByte(string(bases), obj.bases[obj.consensus(offset+i)]) >= 0 {
+        overlap++
+      } else {
+        mismatches++
+      }
+    }
+    if overlap == 0 || overlap < min_overlap || mismatches > max_mismatches {
+      continue
+    }
+    if !ok || overlap > best_overlap || (overlap == best_overlap && mismatches < best_mismatches) {
+      best_offset, best_overlap, best_mismatches, ok = offset, overlap, mismatches, true
+    }
+  }
+  return best_offset, best_overlap, best_mismatches, ok
+}
+
+/* -------------------------------------------------------------------------- */
+
+type weightedKmer struct {
+  Kmer   KmerClass
+  Weight float64
+}
+
+// Greedy clustering of k-mers, where the k-mer with largest weight that is
+// not yet part of a motif is used as seed and all k-mers that align with the
+// motif are added until no further k-mer matches
+func cluster_kmers(alphabet ComplementableAlphabet, bases []byte, kmers []weightedKmer, sign, min_overlap, max_mismatches int) []*kmerMotif {
+  sort.SliceStable(kmers, func(i, j int) bool {
+    return kmers[i].Weight > kmers[j].Weight
+  })
+  r := []*kmerMotif{}
+  for len(kmers) > 0 {
+    motif := newKmerMotif(alphabet, bases, sign)
+    motif.Add(kmers[0].Kmer, kmers[0].Kmer.Elements[0], 0, kmers[0].Weight)
+    kmers  = kmers[1:]
+    for changed := true; changed; {
+      changed = false
+      for i := 0; i < len(kmers); i++ {
+        best_element    := ""
+        best_offset     := 0
+        best_overlap    := 0
+        best_mismatches := 0
+        for _, element := range kmers[i].Kmer.Elements {
+          if offset, overlap, mismatches, ok := motif.Match(element, min_overlap, max_mismatches); ok {
+            if best_element == "" || overlap > best_overlap || (overlap == best_overlap && mismatches < best_mismatches) {
+              best_element, best_offset, best_overlap, best_mismatches = element, offset, overlap, mismatches
+            }
+          }
+        }
+        if best_element != "" {
+          motif.Add(kmers[i].Kmer, best_element, best_offset, kmers[i].Weight)
+          kmers   = append(kmers[0:i], kmers[i+1:]...)
+          changed = true
+          i--
+        }
+      }
+    }
+    r = append(r, motif)
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func sum_float64(x []float64) float64 {
+  r := 0.0
+  for _, v := range x {
+    r += v
+  }
+  return r
+}
+
+func motifs_write(config Config, filename string, f func(io.Writer)) {
+  file, err := create_file(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer file.Close()
+
+  w := bufio.NewWriter(file)
+  defer w.Flush()
+
+  PrintStderr(config, 1, "Writing motifs to `%s'... ", filename)
+  f(w)
+  PrintStderr(config, 1, "done\n")
+}
+
+func saveMotifsMeme(writer io.Writer, motifs []*kmerMotif, bases []byte, revcomp bool) {
+  fmt.Fprintf(writer, "MEME version 4\n\n")
+  fmt.Fprintf(writer, "ALPHABET= %s\n\n", strings.ToUpper(string(bases)))
+  if revcomp {
+    fmt.Fprintf(writer, "strands: + -\n\n")
+  } else {
+    fmt.Fprintf(writer, "strands: +\n\n")
+  }
+  fmt.Fprintf(writer, "Background letter frequencies\n")
+  for i, b := range bases {
+    if i > 0 {
+      fmt.Fprintf(writer, " ")
+    }
+    fmt.Fprintf(writer, "%c %.3f", b-'a'+'A', 1.0/float64(len(bases)))
+  }
+  fmt.Fprintf(writer, "\n\n")
+  for _, motif := range motifs {
+    fmt.Fprintf(writer, "MOTIF %s %s\n", motif.Name, strings.ToUpper(motif.Consensus()))
+    fmt.Fprintf(writer, "letter-probability matrix: alength= %d w= %d nsites= %d E= 0\n", len(bases), motif.Width(), len(motif.Kmers))
+    for _, p := range motif.Probabilities() {
+      for j, v := range p {
+        if j > 0 {
+          fmt.Fprintf(writer, " ")
+        }
+        fmt.Fprintf(writer, "%.6f", v)
+      }
+      fmt.Fprintf(writer, "\n")
+    }
+    fmt.Fprintf(writer, "\n")
+  }
+}
+
+// Frequencies are scaled to the number of k-mers of each motif
+func saveMotifsJaspar(writer io.Writer, motifs []*kmerMotif, bases []byte) {
+  for _, motif := range motifs {
+    p := motif.Probabilities()
+    fmt.Fprintf(writer, ">%s %s\n", motif.Name, strings.ToUpper(motif.Consensus()))
+    for j, b := range bases {
+      fmt.Fprintf(writer, "%c [", b-'a'+'A')
+      for i := 0; i < len(p); i++ {
+        fmt.Fprintf(writer, " %8.3f", p[i][j]*float64(len(motif.Kmers)))
+      }
+      fmt.Fprintf(writer, " ]\n")
+    }
+  }
+}
+
+func saveMotifsTransfac(writer io.Writer, motifs []*kmerMotif, bases []byte) {
+  for _, motif := range motifs {
+    p := motif.Probabilities()
+    fmt.Fprintf(writer, "AC  %s\nXX\nID  %s\nXX\nDE  %s\n", motif.Name, motif.Name, strings.ToUpper(motif.Consensus()))
+    fmt.Fprintf(writer, "P0")
+    for _, b := range bases {
+      fmt.Fprintf(writer, " %8c", b-'a'+'A')
+    }
+    fmt.Fprintf(writer, "\n")
+    for i := 0; i < len(p); i++ {
+      fmt.Fprintf(writer, "%02d", i+1)
+      for j, _ := range bases {
+        fmt.Fprintf(writer, " %8.3f", p[i][j]*float64(len(motif.Kmers)))
+      }
+      fmt.Fprintf(writer, " %8c\n", motif.bases[motif.consensus(i)]-'a'+'A')
+    }
+    fmt.Fprintf(writer, "XX\n//\n")
+  }
+}
+
+// Summary of all motifs with one line per motif
+func saveMotifsTable(writer io.Writer, motifs []*kmerMotif) {
+  fmt.Fprintf(writer, "%s\t%4s\t%15s\t%s\t%s\n", "name", "sign", "weight", "consensus", "kmers")
+  for _, motif := range motifs {
+    kmers := make([]string, len(motif.Kmers))
+    for i, kmer := range motif.Kmers {
+      kmers[i] = fmt.Sprintf("%s@%d:%e", kmer.Element, kmer.Offset, kmer.Weight)
+    }
+    fmt.Fprintf(writer, "%s\t%4d\t%15e\t%s\t%s\n", motif.Name, motif.Sign, motif.Weight, motif.Consensus(), strings.Join(kmers, ","))
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Coefficient of each k-mer (averaged over ensemble members and scaled to
+// untransformed counts), where co-occurrence features are split in equal
+// parts between both k-mers
+func motifs_kmer_coefficients(classifier *KmerLrEnsemble) []float64 {
+  theta := attribution_coefficients(classifier)
+  r     := make([]float64, len(classifier.Kmers))
+  for j, feature := range classifier.Features {
+    v := theta[j+1]
+    if len(classifier.Transform.Scale) > 0 {
+      v *= classifier.Transform.Scale[j+1]
+    }
+    if feature[0] == feature[1] {
+      r[feature[0]] += v
+    } else {
+      r[feature[0]] += 0.5*v
+      r[feature[1]] += 0.5*v
+    }
+  }
+  return r
+}
+
+// Cluster k-mers with positive and negative coefficients into motifs
+func derive_motifs(config Config, classifier *KmerLrEnsemble, filename_fg string, min_overlap, max_mismatches, min_kmers int) []*kmerMotif {
+  coefficients := motifs_kmer_coefficients(classifier)
+  bases        := mutagenesis_bases(classifier)
+
+  // weight k-mers by their number of occurrences in the foreground data
+  if filename_fg != "" {
+    _, sequences := import_fasta(config, filename_fg)
+    scanner := newKmerScanner(classifier.KmerLrFeatures)
+    counts  := make([]int, len(classifier.Kmers))
+    for _, sequence := range sequences {
+      for i, c := range scanner.Counts(scanner.Scan([]byte(sequence))) {
+        counts[i] += c
+      }
+    }
+    for i := 0; i < len(coefficients); i++ {
+      coefficients[i] *= float64(counts[i])
+    }
+  }
+  r := []*kmerMotif{}
+  for _, sign := range []int{1, -1} {
+    kmers := []weightedKmer{}
+    for i, v := range coefficients {
+      if v*float64(sign) > 0.0 {
+        kmers = append(kmers, weightedKmer{classifier.Kmers[i], math.Abs(v)})
+      }
+    }
+    clusters := cluster_kmers(classifier.Alphabet, bases, kmers, sign, min_overlap, max_mismatches)
+    sort.SliceStable(clusters, func(i, j int) bool {
+      return clusters[i].Weight > clusters[j].Weight
+    })
+    n := 0
+    for _, motif := range clusters {
+      if len(motif.Kmers) < min_kmers {
+        continue
+      }
+      n++
+      if sign == 1 {
+        motif.Name = fmt.Sprintf("pos_%d", n)
+      } else {
+        motif.Name = fmt.Sprintf("neg_%d", n)
+      }
+      r = append(r, motif)
+    }
+  }
+  return r
+}
+
+func motifs(config Config, filename_json, filename_fg, basename string, min_overlap, max_mismatches, min_kmers int) {
+  if is_multinomial_model(filename_json) {
+    log.Fatal("motifs are not supported for multinomial models")
+  }
+  classifier := ImportKmerLrEnsemble(config, filename_json)
+  bases      := mutagenesis_bases(classifier)
+  r          := derive_motifs(config, classifier, filename_fg, min_overlap, max_mismatches, min_kmers)
+
+  motifs_write(config, compress_filename(config, basename+".meme"), func(writer io.Writer) {
+    saveMotifsMeme(writer, r, bases, classifier.Revcomp)
+  })
+  motifs_write(config, compress_filename(config, basename+".jaspar"), func(writer io.Writer) {
+    saveMotifsJaspar(writer, r, bases)
+  })
+  motifs_write(config, compress_filename(config, basename+".transfac"), func(writer io.Writer) {
+    saveMotifsTransfac(writer, r, bases)
+  })
+  motifs_write(config, compress_filename(config, basename+".table"), func(writer io.Writer) {
+    saveMotifsTable(writer, r)
+  })
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_motifs(config Config, args []string) {
+  options := getopt.New()
+
+  optMinOverlap    := options. IntLong("min-overlap",     0 , 3, "minimal number of matching positions when aligning a k-mer to a motif [default: 3]")
+  optMaxMismatches := options. IntLong("max-mismatches",  0 , 0, "maximal number of mismatches when aligning a k-mer to a motif [default: 0]")
+  optMinKmers      := options. IntLong("min-kmers",       0 , 1, "minimal number of k-mers of a motif [default: 1]")
+  optHelp          := options.BoolLong("help",          'h',    "print help")
+
+  options.SetParameters("<MODEL.json> [FOREGROUND.fa] <BASENAME>")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if *optMinOverlap < 1 {
+    log.Fatalf("invalid minimal overlap `%d'", *optMinOverlap)
+  }
+  if *optMaxMismatches < 0 {
+    log.Fatalf("invalid maximal number of mismatches `%d'", *optMaxMismatches)
+  }
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) != 2 && len(options.Args()) != 3 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  filename_json := options.Args()[0]
+  filename_fg   := ""
+  basename      := options.Args()[len(options.Args())-1]
+  if len(options.Args()) == 3 {
+    filename_fg = options.Args()[1]
+  }
+  motifs(config, filename_json, filename_fg, basename, *optMinOverlap, *optMaxMismatches, *optMinKmers)
+}
@@ -0,0 +1,298 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "log"
+import   "math"
+
+import . "github.com/pbenner/autodiff"
+import . "github.com/pbenner/autodiff/logarithmetic"
+import . "github.com/pbenner/autodiff/statistics"
+import . "github.com/pbenner/gonetics"
+
+/* -------------------------------------------------------------------------- */
+
+// Multinomial (softmax) logistic regression, where the coefficients of each
+// k-mer are penalized jointly across classes (group lasso) so that all classes
+// share the same set of features
+type multinomialRegression struct {
+  // coefficients of each class, the first coefficient is the intercept
+  Theta        [][]float64
+  ClassWeights   []float64
+  // optional weight of each sample
+  Weights        []float64
+  // strength of the group penalty
+  Lambda         float64
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Log probabilities of all classes
+func (obj multinomialRegression) ClassLogPdf(r []float64, x SparseConstFloat64Vector) []float64 {
+  i := x.GetSparseIndices()
+  v := x.GetSparseValues ()
+  if len(r) != len(obj.Theta) {
+    r = make([]float64, len(obj.Theta))
+  }
+  for k := 0; k < len(obj.Theta); k++ {
+    r[k] = 0.0
+    for j := 0; j < len(i); j++ {
+      r[k] += obj.Theta[k][i[j]]*v[j]
+    }
+  }
+  z := math.Inf(-1)
+  for k := 0; k < len(r); k++ {
+    z = LogAdd(z, r[k])
+  }
+  for k := 0; k < len(r); k++ {
+    r[k] -= z
+  }
+  return r
+}
+
+// Weight of the i-th sample with class y
+func (obj multinomialRegression) weight(i, y int) float64 {
+  w := 1.0
+  if len(obj.ClassWeights) > 0 {
+    w = obj.ClassWeights[y]
+  }
+  if obj.Weights != nil {
+    w *= obj.Weights[i]
+  }
+  return w
+}
+
+func (obj multinomialRegression) Gradient(g [][]float64, data []ConstVector, labels []int) [][]float64 {
+  if len(g) != len(obj.Theta) {
+    g = make([][]float64, len(obj.Theta))
+  }
+  for k := 0; k < len(obj.Theta); k++ {
+    if len(g[k]) != len(obj.Theta[k]) {
+      g[k] = make([]float64, len(obj.Theta[k]))
+    } else {
+      for j := 0; j < len(g[k]); j++ {
+        g[k][j] = 0.0
+      }
+    }
+  }
+  r := make([]float64, len(obj.Theta))
+  for i_ := 0; i_ < len(data); i_++ {
+    x := data[i_].(SparseConstFloat64Vector)
+    i := x.GetSparseIndices()
+    v := x.GetSparseValues ()
+    r  = obj.ClassLogPdf(r, x)
+    w := obj.weight(i_, labels[i_])/float64(len(data))
+    for k := 0; k < len(obj.Theta); k++ {
+      p := math.Exp(r[k])
+      if k == labels[i_] {
+        p -= 1.0
+      }
+      for j := 0; j < len(i); j++ {
+        g[k][i[j]] += w*p*v[j]
+      }
+    }
+  }
+  return g
+}
+
+// Loss without penalty
+func (obj multinomialRegression) LossSmooth(data []ConstVector, labels []int) float64 {
+  r := make([]float64, len(obj.Theta))
+  s := 0.0
+  for i := 0; i < len(data); i++ {
+    r  = obj.ClassLogPdf(r, data[i].(SparseConstFloat64Vector))
+    s -= obj.weight(i, labels[i])*r[labels[i]]
+  }
+  return s/float64(len(data))
+}
+
+// Group lasso penalty, where each group contains the coefficients of a
+// single feature across all classes
+func (obj multinomialRegression) Penalty() float64 {
+  if math.IsNaN(obj.Lambda) || obj.Lambda == 0.0 {
+    return 0.0
+  }
+  r := 0.0
+  for j := 1; j < len(obj.Theta[0]); j++ {
+    r += obj.Lambda*multinomialGroupNorm(obj.Theta, j)
+  }
+  return r
+}
+
+func (obj multinomialRegression) Loss(data []ConstVector, labels []int) float64 {
+  return obj.LossSmooth(data, labels) + obj.Penalty()
+}
+
+/* -------------------------------------------------------------------------- */
+
+func multinomialGroupNorm(theta [][]float64, j int) float64 {
+  r := 0.0
+  for k := 0; k < len(theta); k++ {
+    r += theta[k][j]*theta[k][j]
+  }
+  return math.Sqrt(r)
+}
+
+// Class weights such that the data set is balanced
+func compute_class_weights_multinomial(labels []int, n int) []float64 {
+  r := make([]float64, n)
+  c := make([]float64, n)
+  for _, k := range labels {
+    c[k]++
+  }
+  for k := 0; k < n; k++ {
+    if c[k] > 0 {
+      r[k] = float64(len(labels))/(float64(n)*c[k])
+    }
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+type KmerLrMultinomial struct {
+  KmerLrFeatures
+  Classes []string
+  // coefficients of each class, the first coefficient is the intercept
+  Theta [][]float64
+  Lambda  float64
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj *KmerLrMultinomial) Predict(config Config, data []ConstVector) [][]float64 {
+  lr := multinomialRegression{Theta: obj.Theta}
+  r  := make([][]float64, len(data))
+  for i := 0; i < len(data); i++ {
+    r[i] = lr.ClassLogPdf(nil, data[i].(SparseConstFloat64Vector))
+    for k := 0; k < len(r[i]); k++ {
+      r[i][k] = math.Exp(r[i][k])
+    }
+  }
+  return r
+}
+
+func (obj *KmerLrMultinomial) Loss(config Config, data []ConstVector, labels []int, weights []float64) float64 {
+  lr := multinomialRegression{Theta: obj.Theta, Weights: weights, Lambda: config.Lambda}
+  if config.Balance {
+    lr.ClassWeights = compute_class_weights_multinomial(labels, len(obj.Classes))
+  }
+  return lr.Loss(data, labels)
+}
+
+func (obj *KmerLrMultinomial) Nonzero() int {
+  n := 0
+  for j := 1; j < len(obj.Theta[0]); j++ {
+    if multinomialGroupNorm(obj.Theta, j) != 0.0 {
+      n++
+    }
+  }
+  return n
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj *KmerLrMultinomial) GetKmerCounter() *KmerCounter {
+  if counter, err := NewKmerCounter(obj.M, obj.N, obj.Complement, obj.Reverse, obj.Revcomp, obj.MaxAmbiguous, obj.Alphabet, obj.Kmers...); err != nil {
+    log.Fatal(err)
+    return nil
+  } else {
+    return counter
+  }
+}
+
+func (obj *KmerLrMultinomial) SelectData(config Config, data KmerDataSet) []ConstVector {
+  r := KmerLr{KmerLrFeatures: obj.KmerLrFeatures}
+  return r.SelectData(config, data)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj *KmerLrMultinomial) ImportConfig(config ConfigDistribution, t ScalarType) error {
+  if config.Name != "kmerLrMultinomial" {
+    return fmt.Errorf("wrong classifier type")
+  }
+  if len(config.Distributions) < 3 {
+    return fmt.Errorf("invalid config file")
+  }
+  classes, ok := config.GetNamedParametersAsStrings("Classes"); if !ok {
+    return fmt.Errorf("invalid config file")
+  }
+  lambda, ok := config.GetNamedParameterAsFloat("Lambda"); if !ok {
+    lambda = math.NaN()
+  }
+  if len(classes) != len(config.Distributions)-1 {
+    return fmt.Errorf("invalid config file")
+  }
+  if err := obj.KmerLrFeatures.ImportConfig(config.Distributions[0], t); err != nil {
+    return err
+  }
+  obj.Classes = classes
+  obj.Lambda  = lambda
+  obj.Theta   = make([][]float64, len(classes))
+  for k := 0; k < len(classes); k++ {
+    if theta, ok := config.Distributions[k+1].GetParametersAsFloats(); !ok {
+      return fmt.Errorf("invalid config file")
+    } else {
+      obj.Theta[k] = theta
+    }
+    if len(obj.Theta[k]) != len(obj.Features)+1 {
+      return fmt.Errorf("invalid config file")
+    }
+  }
+  return nil
+}
+
+func (obj *KmerLrMultinomial) ExportConfig() ConfigDistribution {
+  parameters := struct{
+    Classes []string
+    Lambda    float64
+  }{obj.Classes, obj.Lambda}
+  if math.IsNaN(parameters.Lambda) {
+    parameters.Lambda = 0.0
+  }
+  distributions := []ConfigDistribution{obj.KmerLrFeatures.ExportConfig()}
+  for k := 0; k < len(obj.Theta); k++ {
+    distributions = append(distributions, NewConfigDistribution("theta", obj.Theta[k]))
+  }
+  return NewConfigDistribution("kmerLrMultinomial", parameters, distributions...)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func ImportKmerLrMultinomial(config Config, filename string) *KmerLrMultinomial {
+  classifier := new(KmerLrMultinomial)
+  PrintStderr(config, 1, "Importing distribution from `%s'... ", filename)
+  if err := ImportDistribution(filename, classifier, Float64Type); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+  return classifier
+}
+
+// Check if the model file contains a multinomial classifier
+func is_multinomial_model(filename string) bool {
+  config := ConfigDistribution{}
+  if err := config.ImportJson(filename); err != nil {
+    return false
+  }
+  return config.Name == "kmerLrMultinomial"
+}
@@ -0,0 +1,380 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "math"
+import   "sort"
+
+import . "github.com/pbenner/autodiff"
+import . "github.com/pbenner/gonetics"
+
+/* -------------------------------------------------------------------------- */
+
+// Accelerated proximal gradient descent (FISTA) with backtracking line search
+// and adaptive restarts for the multinomial logistic regression with group
+// lasso penalty
+func estimate_multinomial(config Config, lr multinomialRegression, data []ConstVector, labels []int) [][]float64 {
+  max_iterations := config.MaxIterations
+  if max_iterations == 0 {
+    max_iterations = 100000
+  }
+  // theta0: previous estimate, theta1: current estimate, y: extrapolated point
+  theta0 := make([][]float64, len(lr.Theta))
+  theta1 := make([][]float64, len(lr.Theta))
+  y      := make([][]float64, len(lr.Theta))
+  for k := 0; k < len(lr.Theta); k++ {
+    theta0[k] = append([]float64{}, lr.Theta[k]...)
+    theta1[k] = append([]float64{}, lr.Theta[k]...)
+    y     [k] = append([]float64{}, lr.Theta[k]...)
+  }
+  lr_y := lr; lr_y.Theta = y
+  lr_1 := lr; lr_1.Theta = theta1
+  g    := [][]float64(nil)
+  t    := 1.0
+  m    := 1.0
+  l0   := lr_1.Loss(data, labels)
+  for iter := 0; iter < max_iterations; iter++ {
+    fy := lr_y.LossSmooth(data, labels)
+    g   = lr_y.Gradient(g, data, labels)
+    // save current estimate
+    for k := 0; k < len(theta1); k++ {
+      copy(theta0[k], theta1[k])
+    }
+    f1 := 0.0
+    for {
+      // gradient step
+      for k := 0; k < len(y); k++ {
+        for j := 0; j < len(y[k]); j++ {
+          theta1[k][j] = y[k][j] - t*g[k][j]
+        }
+      }
+      // proximal operator of the group penalty (intercepts are not penalized)
+      if !math.IsNaN(lr.Lambda) && lr.Lambda != 0.0 {
+        for j := 1; j < len(theta1[0]); j++ {
+          if r := multinomialGroupNorm(theta1, j); r <= t*lr.Lambda {
+            for k := 0; k < len(theta1); k++ {
+              theta1[k][j] = 0.0
+            }
+          } else {
+            for k := 0; k < len(theta1); k++ {
+              theta1[k][j] *= 1.0 - t*lr.Lambda/r
+            }
+          }
+        }
+      }
+      // check sufficient decrease
+      f1  = lr_1.LossSmooth(data, labels)
+      s  := 0.0
+      d2 := 0.0
+      for k := 0; k < len(y); k++ {
+        for j := 0; j < len(y[k]); j++ {
+          d   := theta1[k][j] - y[k][j]
+          s  += g[k][j]*d
+          d2 += d*d
+        }
+      }
+      if f1 <= fy + s + d2/(2.0*t) || t < 1e-20 {
+        break
+      }
+      t *= 0.5
+    }
+    l1 := f1 + lr_1.Penalty()
+    // check convergence
+    delta := 0.0
+    for k := 0; k < len(theta1); k++ {
+      for j := 0; j < len(theta1[k]); j++ {
+        delta = math.Max(delta, math.Abs(theta1[k][j] - theta0[k][j]))
+      }
+    }
+    if delta <= config.Epsilon || math.Abs(l1 - l0) <= config.EpsilonLoss*math.Abs(l1) {
+      break
+    }
+    // restart momentum if the loss increased
+    if l1 > l0 {
+      m = 1.0
+    }
+    m_ := (1.0 + math.Sqrt(1.0 + 4.0*m*m))/2.0
+    for k := 0; k < len(y); k++ {
+      for j := 0; j < len(y[k]); j++ {
+        y[k][j] = theta1[k][j] + (m - 1.0)/m_*(theta1[k][j] - theta0[k][j])
+      }
+    }
+    m  = m_
+    l0 = l1
+  }
+  return theta1
+}
+
+/* -------------------------------------------------------------------------- */
+
+type KmerLrMultinomialEstimator struct {
+  KmerLrFeatures
+  Classes   []string
+  // current estimate, restricted to the selected k-mers
+  Theta     [][]float64
+  Lambda      float64
+  // indices of selected k-mers in the training data
+  selection []int
+}
+
+/* -------------------------------------------------------------------------- */
+
+func NewKmerLrMultinomialEstimator(config Config, classifier *KmerLrMultinomial) *KmerLrMultinomialEstimator {
+  r := &KmerLrMultinomialEstimator{}
+  r.KmerLrFeatures = classifier.KmerLrFeatures
+  r.Classes        = classifier.Classes
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Restrict data to the selected k-mers
+func (obj *KmerLrMultinomialEstimator) selectData(data []ConstVector, selection []int) []ConstVector {
+  index := make(map[int]int)
+  // selection is sorted, so that indices of the resulting
+  // sparse vectors are also sorted
+  for j, i := range selection {
+    index[i+1] = j+1
+  }
+  r := make([]ConstVector, len(data))
+  for i_ := 0; i_ < len(data); i_++ {
+    x := data[i_].(SparseConstFloat64Vector)
+    i := []int    {0}
+    v := []float64{x.Float64At(0)}
+    for it := x.ConstIteratorFrom(1); it.Ok(); it.Next() {
+      if j, ok := index[it.Index()]; ok {
+        i = append(i, j)
+        v = append(v, it.GetConst().GetFloat64())
+      }
+    }
+    r[i_] = UnsafeSparseConstFloat64Vector(i, v, len(selection)+1)
+  }
+  return r
+}
+
+// Group norms of the gradient at the current estimate for all k-mers in the
+// training data
+func (obj *KmerLrMultinomialEstimator) gradientNorms(lr multinomialRegression, data KmerDataSet) []float64 {
+  m     := data.Data[0].Dim()
+  theta := make([][]float64, len(obj.Theta))
+  for k := 0; k < len(theta); k++ {
+    theta[k]    = make([]float64, m)
+    theta[k][0] = obj.Theta[k][0]
+    for j, i := range obj.selection {
+      theta[k][i+1] = obj.Theta[k][j+1]
+    }
+  }
+  lr.Theta = theta
+  g := lr.Gradient(nil, data.Data, data.Classes)
+  r := make([]float64, m-1)
+  for j := 1; j < m; j++ {
+    r[j-1] = multinomialGroupNorm(g, j)
+  }
+  return r
+}
+
+// Compute new selection of k-mers, where n is the target number of features
+// (zero if lambda is fixed). Returns the new selection and lambda.
+func (obj *KmerLrMultinomialEstimator) selectFeatures(config Config, g []float64, n int) ([]int, float64) {
+  active   := []int{}
+  inactive := AbsFloatInt{}
+  for j, i := range obj.selection {
+    if multinomialGroupNorm(obj.Theta, j+1) != 0.0 {
+      active = append(active, i)
+    }
+  }
+  is_active := make(map[int]bool)
+  for _, i := range active {
+    is_active[i] = true
+  }
+  for i := 0; i < len(g); i++ {
+    if !is_active[i] {
+      inactive.a = append(inactive.a, g[i])
+      inactive.b = append(inactive.b, i)
+    }
+  }
+  inactive.SortReverse()
+  lambda := obj.Lambda
+  if n == 0 {
+    // fixed lambda, add all features that violate the optimality conditions
+    for k := 0; k < inactive.Len() && inactive.a[k] > lambda; k++ {
+      if config.MaxFeatures > 0 && len(active) >= config.MaxFeatures {
+        break
+      }
+      active = append(active, inactive.b[k])
+    }
+  } else {
+    if len(active) > n {
+      // remove features with smallest coefficients
+      r := AbsFloatInt{}
+      for j, i := range obj.selection {
+        if v := multinomialGroupNorm(obj.Theta, j+1); v != 0.0 {
+          r.a = append(r.a, v)
+          r.b = append(r.b, i)
+        }
+      }
+      r.SortReverse()
+      active = r.b[0:n]
+      // lambda must be large enough so that pruned features satisfy the
+      // optimality conditions, i.e. it is bounded by the group norms of
+      // their gradients
+      for _, i := range r.b[n:] {
+        lambda = math.Max(lambda, g[i])
+      }
+    } else {
+      k := 0
+      for ; k < inactive.Len() && len(active) < n; k++ {
+        active = append(active, inactive.b[k])
+      }
+      // smallest lambda such that all remaining features are zero
+      if k < inactive.Len() {
+        lambda = inactive.a[k]
+      } else {
+        lambda = 0.0
+      }
+    }
+  }
+  sort.Ints(active)
+  return active, lambda
+}
+
+func (obj *KmerLrMultinomialEstimator) nonzero() int {
+  r := 0
+  for j := 1; j < len(obj.Theta[0]); j++ {
+    if multinomialGroupNorm(obj.Theta, j) != 0.0 {
+      r++
+    }
+  }
+  return r
+}
+
+func (obj *KmerLrMultinomialEstimator) equalSelection(a []int) bool {
+  if len(a) != len(obj.selection) {
+    return false
+  }
+  for i := 0; i < len(a); i++ {
+    if a[i] != obj.selection[i] {
+      return false
+    }
+  }
+  return true
+}
+
+// Expand current estimate to a new selection of k-mers
+func (obj *KmerLrMultinomialEstimator) setSelection(selection []int) {
+  index := make(map[int]int)
+  for j, i := range obj.selection {
+    index[i] = j+1
+  }
+  theta := make([][]float64, len(obj.Classes))
+  for k := 0; k < len(theta); k++ {
+    theta[k] = make([]float64, len(selection)+1)
+    if len(obj.Theta) > 0 {
+      theta[k][0] = obj.Theta[k][0]
+      for j, i := range selection {
+        if j_, ok := index[i]; ok {
+          theta[k][j+1] = obj.Theta[k][j_]
+        }
+      }
+    }
+  }
+  obj.Theta     = theta
+  obj.selection = selection
+}
+
+// Initialize intercepts with the log class frequencies
+func (obj *KmerLrMultinomialEstimator) initialize(lr multinomialRegression, data KmerDataSet) {
+  obj.selection = []int{}
+  obj.Theta     = make([][]float64, len(obj.Classes))
+  c := make([]float64, len(obj.Classes))
+  for i, k := range data.Classes {
+    c[k] += lr.weight(i, k)
+  }
+  for k := 0; k < len(obj.Classes); k++ {
+    obj.Theta[k] = []float64{math.Log(math.Max(c[k], 1e-8))}
+  }
+}
+
+func (obj *KmerLrMultinomialEstimator) getEstimate(data KmerDataSet) *KmerLrMultinomial {
+  r := &KmerLrMultinomial{}
+  r.KmerLrEquivalence = obj.KmerLrEquivalence
+  r.Classes  = obj.Classes
+  r.Lambda   = obj.Lambda
+  r.Kmers    = make(KmerClassList , len(obj.selection))
+  r.Features = make(FeatureIndices, len(obj.selection))
+  r.Theta    = make([][]float64, len(obj.Theta))
+  for j, i := range obj.selection {
+    r.Kmers   [j] = data.Kmers[i]
+    r.Features[j] = [2]int{j, j}
+  }
+  for k := 0; k < len(obj.Theta); k++ {
+    r.Theta[k] = append([]float64{}, obj.Theta[k]...)
+  }
+  return r
+}
+
+// Estimate classifier with n non-zero features, or with fixed lambda if n
+// is zero
+func (obj *KmerLrMultinomialEstimator) estimate_loop(config Config, lr multinomialRegression, data KmerDataSet, n int) *KmerLrMultinomial {
+  for epoch := 0; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+    g := obj.gradientNorms(lr, data)
+    selection, lambda := obj.selectFeatures(config, g, n)
+    // stop if the selection did not change, unless the target number of
+    // features is not yet reached and lambda can be decreased further
+    if epoch > 0 && obj.equalSelection(selection) && (n == 0 || obj.nonzero() >= n || lambda >= obj.Lambda) {
+      break
+    }
+    obj.setSelection(selection)
+    obj.Lambda = lambda
+
+    PrintStderr(config, 1, "Estimating parameters with lambda=%e and %d features...\n", lambda, len(selection))
+    lr.Theta   = obj.Theta
+    lr.Lambda  = lambda
+    obj.Theta  = estimate_multinomial(config, lr, obj.selectData(data.Data, selection), data.Classes)
+  }
+  return obj.getEstimate(data)
+}
+
+// Estimate classifiers for all values of --lambda-auto, where sizes are
+// processed in increasing order and each estimation is warm-started with
+// the previous solution
+func (obj *KmerLrMultinomialEstimator) Estimate(config Config, data KmerDataSet) ([]*KmerLrMultinomial, []float64) {
+  lr := multinomialRegression{Weights: data.Weights}
+  if config.Balance {
+    lr.ClassWeights = compute_class_weights_multinomial(data.Classes, len(obj.Classes))
+  }
+  obj.initialize(lr, data)
+  if !math.IsNaN(config.Lambda) {
+    obj.Lambda = config.Lambda
+    return []*KmerLrMultinomial{obj.estimate_loop(config, lr, data, 0)}, []float64{config.Lambda}
+  }
+  classifiers := make([]*KmerLrMultinomial, len(config.LambdaAuto))
+  lambda      := make([]float64, len(config.LambdaAuto))
+  // --lambda-auto values are sorted in increasing order
+  for i, _ := range config.LambdaAuto {
+    n := config.LambdaAuto[i]
+    if n == 0 || n > len(data.Kmers) {
+      n = len(data.Kmers)
+    }
+    PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", n)
+    classifiers[i] = obj.estimate_loop(config, lr, data, n)
+    lambda     [i] = obj.Lambda
+  }
+  return classifiers, lambda
+}
@@ -0,0 +1,418 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "log"
+import   "math"
+import   "os"
+import   "path/filepath"
+import   "sort"
+import   "strconv"
+import   "strings"
+
+import . "github.com/pbenner/autodiff"
+import . "github.com/pbenner/gonetics"
+import   "github.com/pbenner/threadpool"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+type MultinomialCVResult struct {
+  Classes     []string
+  // results for each test sample
+  Predictions [][]float64
+  Labels      []int
+  Groups      []string
+  Folds       []int
+  Seqnames    []string
+  Seqindex    []int
+  // results for each fold
+  LossTest    []float64
+  LossTrain   []float64
+  Nonzero     []int
+  Lambda      []float64
+}
+
+// Fold summaries are identical to binary classifiers
+func (obj MultinomialCVResult) cvResult() CVResult {
+  return CVResult{LossTest: obj.LossTest, LossTrain: obj.LossTrain, Nonzero: obj.Nonzero, Lambda: obj.Lambda}
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Assign samples to folds, where stratification takes all classes into account
+func getCvGroupsMultinomial(config Config, classes []int, n int, ids []string, fold int, val_ratio float64, seed int64) ([]int, []int) {
+  if config.CVScheme == "stratified" {
+    return getCvGroupsStratifiedClasses(classes, n, fold, val_ratio, seed)
+  }
+  return getCvGroups(config, make([]bool, len(classes)), ids, fold, val_ratio, seed)
+}
+
+func crossvalidation_multinomial(config Config, data KmerDataSet, classes []string,
+  learnAndTestClassifiers func(i int, data_train, data_test KmerDataSet) ([][][]float64, []float64, []float64, []int, []float64)) []MultinomialCVResult {
+  config.KFoldCV = getCvNumberOfFolds(config, data.Groups)
+
+  groups, _ := getCvGroupsMultinomial(config, data.Classes, len(classes), data.Groups, config.KFoldCV, 0.0, config.Seed)
+
+  r_predictions := make([][][][]float64, config.KFoldCV)
+  r_data        := make(  []KmerDataSet, config.KFoldCV)
+  r_loss_train  := make(    [][]float64, config.KFoldCV)
+  r_loss_test   := make(    [][]float64, config.KFoldCV)
+  r_nonzero     := make(    [][]int,     config.KFoldCV)
+  r_lambda      := make(    [][]float64, config.KFoldCV)
+
+  config.PoolCV.RangeJob(0, config.KFoldCV, func(i int, pool threadpool.ThreadPool, erf func() error) error {
+    config := config; config.PoolCV = pool
+    i_     := i
+
+    if config.KFoldCV <= 1 {
+      i_ = -1
+    }
+    data_train, _, data_test := filterCvGroup(data, groups, nil, i)
+
+    predictions, loss_train, loss_test, nonzero, lambda := learnAndTestClassifiers(i_, data_train, data_test)
+
+    r_data       [i] = data_test
+    r_predictions[i] = predictions
+    r_loss_train [i] = loss_train
+    r_loss_test  [i] = loss_test
+    r_nonzero    [i] = nonzero
+    r_lambda     [i] = lambda
+    return nil
+  })
+  // join results
+  result := []MultinomialCVResult{}
+  for i := 0; config.KFoldCV > 1 && i < config.KFoldCV; i++ {
+    if len(result) == 0 {
+      result = make([]MultinomialCVResult, len(r_predictions[i]))
+    }
+    for j := 0; j < len(r_predictions[i]); j++ {
+      result[j].Classes     = classes
+      result[j].Predictions = append(result[j].Predictions, r_predictions[i][j]...)
+      result[j].Labels      = append(result[j].Labels     , r_data       [i].Classes ...)
+      result[j].Groups      = append(result[j].Groups     , r_data       [i].Groups  ...)
+      result[j].Seqnames    = append(result[j].Seqnames   , r_data       [i].Seqnames...)
+      result[j].Seqindex    = append(result[j].Seqindex   , r_data       [i].Seqindex...)
+      result[j].LossTrain   = append(result[j].LossTrain  , r_loss_train [i][j])
+      result[j].LossTest    = append(result[j].LossTest   , r_loss_test  [i][j])
+      result[j].Nonzero     = append(result[j].Nonzero    , r_nonzero    [i][j])
+      result[j].Lambda      = append(result[j].Lambda     , r_lambda     [i][j])
+      for k := 0; k < len(r_predictions[i][j]); k++ {
+        result[j].Folds = append(result[j].Folds, i+1)
+      }
+    }
+  }
+  return result
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Save class probabilities of all test samples, the predicted class is the
+// one with largest probability
+func saveMultinomialCrossvalidation(filename string, cvr MultinomialCVResult) error {
+  f, err := create_file(filename)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  w := bufio.NewWriter(f)
+  defer w.Flush()
+
+  for _, class := range cvr.Classes {
+    fmt.Fprintf(w, "%15s\t", class)
+  }
+  fmt.Fprintf(w, "%10s\t%10s\t%4s\t%8s", "prediction", "label", "fold", "index")
+  if len(cvr.Seqnames) > 0 {
+    fmt.Fprintf(w, "\t%s", "name")
+  }
+  if len(cvr.Groups) > 0 {
+    fmt.Fprintf(w, "\t%s", "group")
+  }
+  fmt.Fprintf(w, "\n")
+  for i := 0; i < len(cvr.Predictions); i++ {
+    k := 0
+    for j, p := range cvr.Predictions[i] {
+      fmt.Fprintf(w, "%15e\t", p)
+      if p > cvr.Predictions[i][k] {
+        k = j
+      }
+    }
+    fmt.Fprintf(w, "%10s\t%10s\t%4d\t%8d", cvr.Classes[k], cvr.Classes[cvr.Labels[i]], cvr.Folds[i], cvr.Seqindex[i])
+    if len(cvr.Seqnames) > 0 {
+      fmt.Fprintf(w, "\t%s", cvr.Seqnames[i])
+    }
+    if len(cvr.Groups) > 0 {
+      fmt.Fprintf(w, "\t%s", cvr.Groups[i])
+    }
+    fmt.Fprintf(w, "\n")
+  }
+  return nil
+}
+
+func SaveMultinomialCrossvalidation(config Config, filename string, cvr MultinomialCVResult) {
+  filename = compress_filename(config, filename)
+  PrintStderr(config, 1, "Exporting cross-validation results to `%s'... ", filename)
+  if err := saveMultinomialCrossvalidation(filename, cvr); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+}
+
+/* -------------------------------------------------------------------------- */
+
+func learn_multinomial_parameters(config Config, classifier *KmerLrMultinomial, data_train, data_test KmerDataSet, icv int, basename_out string) ([][][]float64, []float64, []float64, []int, []float64) {
+  estimator := NewKmerLrMultinomialEstimator(config, classifier)
+
+  classifiers, lambda := estimator.Estimate(config, data_train)
+
+  predictions := make([][][]float64, len(classifiers))
+  loss_train  := make(    []float64, len(classifiers))
+  loss_test   := make(    []float64, len(classifiers))
+  nonzero     := make(    []int    , len(classifiers))
+  for i, filename := range learn_model_filenames(config, learn_filename(basename_out, icv), len(classifiers)) {
+    SaveModel(config, filename, classifiers[i])
+    data_test_    := classifiers[i].SelectData(config, data_test)
+    data_train_   := classifiers[i].SelectData(config, data_train)
+    predictions[i] = classifiers[i].Predict(config, data_test_)
+    loss_train [i] = classifiers[i].Loss   (config, data_train_, data_train.Classes, data_train.Weights)
+    loss_test  [i] = classifiers[i].Loss   (config, data_test_ , data_test .Classes, data_test .Weights)
+    nonzero    [i] = classifiers[i].Nonzero()
+  }
+  return predictions, loss_train, loss_test, nonzero, lambda
+}
+
+func learn_multinomial(config Config, classifier *KmerLrMultinomial, filenames []string, basename_out string) {
+  // do not use classifier.GetKmerCounter() since we do not want to fix the set of kmers!
+  kmersCounter, err := NewKmerCounter(classifier.M, classifier.N, classifier.Complement, classifier.Reverse, classifier.Revcomp, classifier.MaxAmbiguous, classifier.Alphabet); if err != nil {
+    log.Fatal(err)
+  }
+  data := compile_training_data_multinomial(config, kmersCounter, nil, nil, true, classifier.Binarize, filenames)
+  kmersCounter = nil
+
+  if len(data.Data) == 0 {
+    log.Fatal("Error: no training data given")
+  }
+  // create index for sparse data
+  for i, _ := range data.Data {
+    data.Data[i].(SparseConstFloat64Vector).CreateIndex()
+  }
+  cvrs := crossvalidation_multinomial(config, data, classifier.Classes, func(i int, data_train, data_test KmerDataSet) ([][][]float64, []float64, []float64, []int, []float64) {
+    return learn_multinomial_parameters(config, classifier, data_train, data_test, i, basename_out)
+  })
+  if len(cvrs) == 1 {
+    SaveMultinomialCrossvalidation(config, fmt.Sprintf("%s.table"      , basename_out), cvrs[0])
+    SaveCrossvalidationLoss       (config, fmt.Sprintf("%s_loss.table" , basename_out), cvrs[0].cvResult())
+    SaveCrossvalidationFolds      (config, fmt.Sprintf("%s_folds.table", basename_out), cvrs[0].cvResult())
+  } else {
+    for i, cvr := range cvrs {
+      SaveMultinomialCrossvalidation(config, fmt.Sprintf("%s_%d.table"      , basename_out, config.LambdaAuto[i]), cvr)
+      SaveCrossvalidationLoss       (config, fmt.Sprintf("%s_%d_loss.table" , basename_out, config.LambdaAuto[i]), cvr.cvResult())
+      SaveCrossvalidationFolds      (config, fmt.Sprintf("%s_%d_folds.table", basename_out, config.LambdaAuto[i]), cvr.cvResult())
+    }
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Default class names are the basenames of the input files
+func multinomial_class_names(filenames []string) []string {
+  r := make([]string, len(filenames))
+  for i, filename := range filenames {
+    r[i] = filepath.Base(filename)
+    for _, suffix := range []string{".gz", ".fa", ".fasta", ".fq", ".fastq"} {
+      r[i] = strings.TrimSuffix(r[i], suffix)
+    }
+  }
+  return r
+}
+
+func main_learn_multinomial(config Config, args []string) {
+  options := getopt.New()
+
+  // alphabet options
+  optAlphabet        := options. StringLong("alphabet",           0 , "nucleotide", "nucleotide, gapped-nucleotide, or iupac-nucleotide")
+  optBinarize        := options.   BoolLong("binarize",           0 ,               "binarize k-mer counts")
+  optComplement      := options.   BoolLong("complement",         0 ,               "consider complement sequences")
+  optMaxAmbiguous    := options. StringLong("max-ambiguous",      0 ,         "-1", "maxum number of ambiguous positions (either a scalar to set a global maximum or a comma separated list of length MAX-K-MER-LENGTH-MIN-K-MER-LENGTH+1)")
+  optReverse         := options.   BoolLong("reverse",            0 ,               "consider reverse sequences")
+  optRevcomp         := options.   BoolLong("revcomp",            0 ,               "consider reverse complement sequences")
+  // other options
+  optClasses         := options. StringLong("classes",            0 ,           "", "comma separated list of class names [default: basenames of the input files]")
+  optBalance         := options.   BoolLong("balance",            0 ,               "set class weights so that the data set is balanced")
+  optWeightsFile     := options. StringLong("weights-file",       0 ,           "", "file with one sample weight per line (samples ordered as the input files)")
+  optWeightsField    := options.    IntLong("weights-field",      0 ,            0, "field of the fasta headers containing sample weights (fields are separated by white space or `|', the first field is the sequence name)")
+  optLambda          := options. StringLong("lambda",             0 ,        "NaN", "set fixed regularization strength")
+  optLambdaAuto      := options. StringLong("lambda-auto",        0 ,          "0", "comma separated list of integers specifying the number of features to select; for each value a separate classifier is estimated")
+  optMaxFeatures     := options.    IntLong("max-features",       0 ,            0, "maximum number of features when a fixed lambda is set")
+  optMaxEpochs       := options.    IntLong("max-epochs",         0 ,            0, "maximum number of epochs")
+  optMaxIterations   := options.    IntLong("max-iterations",     0 ,            0, "maximum number of iterations")
+  optMinQuality      := options.    IntLong("min-quality",        0 ,            0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optEpsilon         := options. StringLong("epsilon",            0 ,       "0e-0", "optimization tolerance level for parameters")
+  optEpsilonLoss     := options. StringLong("epsilon-loss",       0 ,       "1e-8", "optimization tolerance level for loss function")
+  optKFoldCV         := options.    IntLong("k-fold-cv",          0 ,            1, "perform k-fold cross-validation")
+  optCVScheme        := options. StringLong("cv-scheme",          0 ,     "random", "cross-validation scheme [random (default), stratified, grouped, chromosome]")
+  optCVGroupField    := options.    IntLong("cv-group-field",     0 ,            0, "field of the fasta headers containing group ids for grouped cross-validation (fields are separated by white space or `|', the first field is the sequence name)")
+  optCVGroups        := options. StringLong("cv-groups",          0 ,           "", "file with one group id per line (samples ordered as the input files) for grouped cross-validation")
+  optCVChromosomes   := options. StringLong("cv-chromosomes",     0 ,           "", "chromosome groups for leave-one-chromosome-out cross-validation, e.g. chr1+chr2,chr3 [default: one group per chromosome]")
+  optThreadsCV       := options.    IntLong("threads-cv",         0 ,            1, "number of threads for cross-validation")
+  optHelp            := options.   BoolLong("help",              'h',               "print help")
+
+  options.SetParameters("<M> <N> <CLASS_1.fa> <CLASS_2.fa> [<CLASS_3.fa>...] <BASENAME_RESULT>")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) < 5 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  classifier   := &KmerLrMultinomial{}
+  filenames    := options.Args()[2:len(options.Args())-1]
+  basename_out := options.Args()[len(options.Args())-1]
+  if m, err := strconv.ParseInt(options.Args()[0], 10, 64); err != nil {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    classifier.M = int(m)
+  }
+  if n, err := strconv.ParseInt(options.Args()[1], 10, 64); err != nil {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    classifier.N = int(n)
+  }
+  if classifier.M < 1 || classifier.N < classifier.M {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  // parse classifier options
+  //////////////////////////////////////////////////////////////////////////////
+  classifier.Binarize   = *optBinarize
+  classifier.Complement = *optComplement
+  classifier.Reverse    = *optReverse
+  classifier.Revcomp    = *optRevcomp
+  if alphabet, err := alphabet_from_string(*optAlphabet); err != nil {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    classifier.Alphabet = alphabet
+  }
+  if fields := strings.Split(*optMaxAmbiguous, ","); len(fields) == 1 || len(fields) == int(classifier.M-classifier.N+1) {
+    classifier.MaxAmbiguous = make([]int, len(fields))
+    for i := 0; i < len(fields); i++ {
+      if t, err := strconv.ParseInt(fields[i], 10, 64); err != nil {
+        options.PrintUsage(os.Stderr)
+        os.Exit(1)
+      } else {
+        classifier.MaxAmbiguous[i] = int(t)
+      }
+    }
+  } else {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  if *optClasses != "" {
+    classifier.Classes = strings.Split(*optClasses, ",")
+    if len(classifier.Classes) != len(filenames) {
+      log.Fatalf("number of class names (%d) does not match number of input files (%d)", len(classifier.Classes), len(filenames))
+    }
+  } else {
+    classifier.Classes = multinomial_class_names(filenames)
+  }
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if s, err := strconv.ParseFloat(*optEpsilon, 64); err != nil {
+    log.Fatal(err)
+  } else {
+    config.Epsilon = s
+  }
+  if s, err := strconv.ParseFloat(*optEpsilonLoss, 64); err != nil {
+    log.Fatal(err)
+  } else {
+    config.EpsilonLoss = s
+  }
+  if s, err := strconv.ParseFloat(*optLambda, 64); err != nil {
+    log.Fatal(err)
+  } else {
+    config.Lambda = s
+  }
+  if fields := strings.Split(*optLambdaAuto, ","); len(fields) == 0 {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  } else {
+    for _, str := range fields {
+      if n, err := strconv.ParseInt(str, 10, 64); err != nil {
+        log.Fatal(err)
+      } else {
+        config.LambdaAuto = append(config.LambdaAuto, int(n))
+      }
+    }
+    sort.Ints(config.LambdaAuto)
+  }
+  if !math.IsNaN(config.Lambda) && (len(config.LambdaAuto) != 1 || config.LambdaAuto[0] != 0) {
+    log.Fatal("options --lambda and --lambda-auto are incompatible")
+  }
+  if *optKFoldCV < 1 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(1)
+  }
+  switch *optCVScheme {
+  case "random":
+  case "stratified":
+  case "grouped":
+  case "chromosome":
+  default:
+    log.Fatalf("invalid cross-validation scheme `%s'", *optCVScheme)
+  }
+  if *optCVScheme == "grouped" && *optCVGroupField == 0 && *optCVGroups == "" {
+    log.Fatal("grouped cross-validation requires option --cv-group-field or --cv-groups")
+  }
+  if *optCVChromosomes != "" {
+    for _, group := range strings.Split(*optCVChromosomes, ",") {
+      config.CVChromosomes = append(config.CVChromosomes, strings.Split(group, "+"))
+    }
+  }
+  if *optThreadsCV > 1 {
+    config.PoolCV = threadpool.New(*optThreadsCV, 100)
+  }
+  config.Balance       = *optBalance
+  config.WeightsFile   = *optWeightsFile
+  config.WeightsField  = *optWeightsField
+  config.MaxFeatures   = *optMaxFeatures
+  config.MaxEpochs     = *optMaxEpochs
+  config.MaxIterations = *optMaxIterations
+  config.MinQuality    = *optMinQuality
+  config.KFoldCV       = *optKFoldCV
+  config.CVScheme      = *optCVScheme
+  config.CVGroupField  = *optCVGroupField
+  config.CVGroupsFile  = *optCVGroups
+
+  learn_multinomial(config, classifier, filenames, basename_out)
+}
@@ -0,0 +1,360 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "io"
+import   "log"
+import   "os"
+import   "strings"
+
+import . "github.com/pbenner/autodiff/logarithmetic"
+import   "github.com/pbenner/threadpool"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+// Score sequence variants by updating the k-mer counts of a reference
+// sequence. Only k-mers overlapping a variant change, so that the linear
+// predictor of the variant is obtained by updating the features of these
+// k-mers.
+type variantScorer struct {
+  classifier   *KmerLrEnsemble
+  scanner      *kmerScanner
+  // features of each k-mer
+  kmerFeatures [][]int
+  // coefficients of each ensemble member including the scale of the
+  // transform, where the first entry is the intercept
+  theta        [][]float64
+}
+
+func newVariantScorer(classifier *KmerLrEnsemble) *variantScorer {
+  r := variantScorer{classifier: classifier}
+  r.scanner      = newKmerScanner(classifier.KmerLrFeatures)
+  r.kmerFeatures = make([][]int, len(classifier.Kmers))
+  for j, feature := range classifier.Features {
+    r.kmerFeatures[feature[0]] = append(r.kmerFeatures[feature[0]], j)
+    if feature[0] != feature[1] {
+      r.kmerFeatures[feature[1]] = append(r.kmerFeatures[feature[1]], j)
+    }
+  }
+  r.theta = make([][]float64, len(classifier.Theta))
+  for i, theta := range classifier.Theta {
+    r.theta[i] = append([]float64{}, theta...)
+    if len(classifier.Transform.Scale) > 0 {
+      for j := 0; j < len(theta); j++ {
+        r.theta[i][j] *= classifier.Transform.Scale[j]
+      }
+    }
+  }
+  return &r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Value of feature j given k-mer counts n and changes of counts delta
+// (same as convert_counts)
+func (obj *variantScorer) featureValue(j int, n []int, delta map[int]int) float64 {
+  g := func(i int) int {
+    c := n[i] + delta[i]
+    if obj.classifier.Binarize && c > 1 {
+      c = 1
+    }
+    return c
+  }
+  i1, i2 := obj.classifier.Features[j][0], obj.classifier.Features[j][1]
+  if i1 == i2 {
+    return float64(g(i1))
+  } else {
+    return float64(g(i1)*g(i2))
+  }
+}
+
+// Linear predictor of each ensemble member given k-mer counts n
+func (obj *variantScorer) linearPredictor(n []int) []float64 {
+  r := make([]float64, len(obj.theta))
+  for i, theta := range obj.theta {
+    for j := 0; j < len(theta); j++ {
+      v := 1.0
+      if j > 0 {
+        v = obj.featureValue(j-1, n, nil)
+      }
+      if len(obj.classifier.Transform.Offset) > 0 {
+        v -= obj.classifier.Transform.Offset[j]
+      }
+      r[i] += theta[j]*v
+    }
+  }
+  return r
+}
+
+// Update the linear predictor eta given changes of k-mer counts, where
+// offsets of the transform cancel
+func (obj *variantScorer) update(eta []float64, n []int, delta map[int]int) []float64 {
+  r    := append([]float64{}, eta...)
+  done := make(map[int]struct{})
+  for i, d := range delta {
+    if d == 0 {
+      continue
+    }
+    for _, j := range obj.kmerFeatures[i] {
+      if _, ok := done[j]; ok {
+        continue
+      }
+      done[j] = struct{}{}
+      v := obj.featureValue(j, n, delta) - obj.featureValue(j, n, nil)
+      for c := 0; c < len(r); c++ {
+        r[c] += obj.theta[c][j+1]*v
+      }
+    }
+  }
+  return r
+}
+
+// Prediction on the log-odds scale for classifiers and on the scale of
+// the response for regression models
+func (obj *variantScorer) prediction(config Config, eta []float64) float64 {
+  t := make([]float64, len(eta))
+  for i := 0; i < len(eta); i++ {
+    if obj.classifier.Regression != "" {
+      t[i] = regression_mean(obj.classifier.Regression, eta[i])
+    } else {
+      t[i] = -LogAdd(0.0, -eta[i])
+    }
+  }
+  if obj.classifier.Regression != "" {
+    return obj.classifier.Summarize(config, t)
+  } else {
+    return scale_prediction("logit", obj.classifier.Summarize(config, t))
+  }
+}
+
+// Changes of k-mer counts when replacing sequence[from:to] by alt, where
+// occurrences are the k-mers of the reference sequence. Insertions and
+// deletions are given by from == to and empty alt.
+func (obj *variantScorer) countChanges(sequence []byte, occurrences [][]kmerOccurrence, from, to int, alt []byte) map[int]int {
+  delta := make(map[int]int)
+  // lost k-mers overlapping the replaced region
+  for q := from-obj.scanner.N+1; q < to; q++ {
+    if q < 0 {
+      continue
+    }
+    for _, o := range occurrences[q] {
+      if q+o.K > from {
+        delta[o.Index] -= o.Count
+      }
+    }
+  }
+  // gained k-mers overlapping the inserted sequence
+  m := obj.scanner.N-1
+  l := from - m; if l < 0 { l = 0 }
+  r := to   + m; if r > len(sequence) { r = len(sequence) }
+  mut := make([]byte, 0, r-l+len(alt))
+  mut  = append(mut, sequence[l:from]...)
+  mut  = append(mut, alt...)
+  mut  = append(mut, sequence[to:r]...)
+  list := []kmerOccurrence{}
+  for k := obj.scanner.M; k <= obj.scanner.N; k++ {
+    for q := 0; q+k <= len(mut); q++ {
+      // k-mer must overlap the inserted sequence
+      if q+k <= from-l || q >= from-l+len(alt) {
+        continue
+      }
+      list = obj.scanner.ScanAt(list[:0], mut, q, k)
+      for _, o := range list {
+        delta[o.Index] += o.Count
+      }
+    }
+  }
+  return delta
+}
+
+/* -------------------------------------------------------------------------- */
+
+type mutagenesisResult struct {
+  Reference  float64
+  // change of the prediction for each position and base of the alphabet
+  Deltas   [][]float64
+}
+
+func (obj *variantScorer) Mutagenesis(config Config, sequence []byte, bases []byte) mutagenesisResult {
+  occurrences := obj.scanner.Scan(sequence)
+  n           := obj.scanner.Counts(occurrences)
+  eta         := obj.linearPredictor(n)
+  ref         := obj.prediction(config, eta)
+  r := mutagenesisResult{Reference: ref, Deltas: make([][]float64, len(sequence))}
+  for p := 0; p < len(sequence); p++ {
+    r.Deltas[p] = make([]float64, len(bases))
+    for i, b := range bases {
+      if b == sequence[p] {
+        continue
+      }
+      delta := obj.countChanges(sequence, occurrences, p, p+1, []byte{b})
+      r.Deltas[p][i] = obj.prediction(config, obj.update(eta, n, delta)) - ref
+    }
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Unambiguous letters of the model's alphabet
+func mutagenesis_bases(classifier *KmerLrEnsemble) []byte {
+  r := []byte{}
+  for i := 0; i < classifier.Alphabet.LengthUnambiguous(); i++ {
+    if b, err := classifier.Alphabet.Decode(byte(i)); err != nil {
+      log.Fatal(err)
+    } else {
+      r = append(r, b)
+    }
+  }
+  return r
+}
+
+type mutagenesisRecord struct {
+  Name         string          `json:"name,omitempty"`
+  Index        int             `json:"index"`
+  Reference    jsonFloat64     `json:"reference"`
+  Bases        string          `json:"bases"`
+  Sequence     string          `json:"sequence"`
+  Deltas   [][]jsonFloat64     `json:"deltas"`
+}
+
+func saveMutagenesis(config Config, filename string, bases []byte, names []string, sequences []string, results []mutagenesisResult) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  if config.OutputFormat == "json" {
+    records := make([]mutagenesisRecord, len(results))
+    for i, result := range results {
+      records[i].Name      = names[i]
+      records[i].Index     = i
+      records[i].Reference = jsonFloat64(result.Reference)
+      records[i].Bases     = string(bases)
+      records[i].Sequence  = sequences[i]
+      records[i].Deltas    = make([][]jsonFloat64, len(result.Deltas))
+      for p, deltas := range result.Deltas {
+        records[i].Deltas[p] = make([]jsonFloat64, len(deltas))
+        for j, d := range deltas {
+          records[i].Deltas[p][j] = jsonFloat64(d)
+        }
+      }
+    }
+    writePredictionsJson(writer, records)
+    return
+  }
+  fmt.Fprintf(writer, "%8s\t%s\t%8s\t%3s\t%15s", "index", "name", "position", "ref", "reference")
+  for _, b := range bases {
+    fmt.Fprintf(writer, "\t%15c", b)
+  }
+  fmt.Fprintf(writer, "\n")
+  for i, result := range results {
+    for p, deltas := range result.Deltas {
+      fmt.Fprintf(writer, "%8d\t%s\t%8d\t%3c\t%15e", i, names[i], p, sequences[i][p], result.Reference)
+      for _, d := range deltas {
+        fmt.Fprintf(writer, "\t%15e", d)
+      }
+      fmt.Fprintf(writer, "\n")
+    }
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func mutagenesis(config Config, filename_json, filename_in, filename_out string) {
+  if is_multinomial_model(filename_json) {
+    log.Fatal("mutagenesis is not supported for multinomial models")
+  }
+  classifier := ImportKmerLrEnsemble(config, filename_json)
+  headers, sequences := import_fasta(config, filename_in)
+
+  bases   := mutagenesis_bases(classifier)
+  results := make([]mutagenesisResult, len(sequences))
+  // create one scorer for each thread
+  scorers := make([]*variantScorer, config.Pool.NumberOfThreads())
+  for i := 0; i < len(scorers); i++ {
+    scorers[i] = newVariantScorer(classifier)
+  }
+  PrintStderr(config, 1, "Computing mutagenesis scores... ")
+  if err := config.Pool.RangeJob(0, len(sequences), func(i int, pool threadpool.ThreadPool, erf func() error) error {
+    config := config; config.Pool = pool
+    results[i] = scorers[pool.GetThreadId()].Mutagenesis(config, []byte(strings.ToLower(sequences[i])), bases)
+    return nil
+  }); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+
+  names := make([]string, len(headers))
+  for i, header := range headers {
+    names[i] = fasta_header_name(header)
+  }
+  saveMutagenesis(config, compress_filename(config, filename_out), bases, names, sequences, results)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_mutagenesis(config Config, args []string) {
+  options := getopt.New()
+
+  optOutputFormat := options.StringLong("output-format",  0 , "table", "output format [table (default), json]")
+  optHelp         := options.  BoolLong("help",          'h',          "print help")
+
+  options.SetParameters("<MODEL.json> <SEQUENCES.fa> [RESULT.table]")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if err := check_output_format(*optOutputFormat); err != nil {
+    log.Fatal(err)
+  }
+  config.OutputFormat = *optOutputFormat
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) != 2 && len(options.Args()) != 3 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  filename_json := options.Args()[0]
+  filename_in   := options.Args()[1]
+  filename_out  := ""
+  if len(options.Args()) == 3 {
+    filename_out = options.Args()[2]
+  }
+  mutagenesis(config, filename_json, filename_in, filename_out)
+}
@@ -0,0 +1,86 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "math"
+
+import . "github.com/pbenner/autodiff/statistics"
+
+/* -------------------------------------------------------------------------- */
+
+// Elastic-net penalty
+//   Lambda*(Alpha*|theta|_1 + (1-Alpha)/2*|theta|_2^2)
+// where Alpha = 1 corresponds to the lasso
+type Penalty struct {
+  Lambda float64
+  Alpha  float64
+}
+
+/* -------------------------------------------------------------------------- */
+
+func NewPenalty(config Config, lambda float64) Penalty {
+  r := Penalty{Lambda: lambda, Alpha: 1.0}
+  if config.Alpha != 0.0 {
+    r.Alpha = config.Alpha
+  }
+  return r
+}
+
+// Penalty with a given strength of the L1 penalty, i.e. the
+// strength selected by the feature selection
+func NewPenaltyL1(config Config, l1 float64) Penalty {
+  r := NewPenalty(config, 0.0)
+  r.Lambda = l1/r.Alpha
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj Penalty) L1() float64 {
+  if math.IsNaN(obj.Lambda) {
+    return obj.Lambda
+  }
+  return obj.Alpha*obj.Lambda
+}
+
+func (obj Penalty) L2() float64 {
+  if math.IsNaN(obj.Lambda) {
+    return obj.Lambda
+  }
+  return (1.0-obj.Alpha)*obj.Lambda
+}
+
+/* -------------------------------------------------------------------------- */
+
+func (obj *Penalty) ImportConfig(config ConfigDistribution) error {
+  lambda, ok := config.GetNamedParameterAsFloat("Lambda"); if !ok {
+    return fmt.Errorf("invalid config file")
+  }
+  alpha, ok := config.GetNamedParameterAsFloat("Alpha"); if !ok {
+    return fmt.Errorf("invalid config file")
+  }
+  obj.Lambda = lambda
+  obj.Alpha  = alpha
+  return nil
+}
+
+func (obj Penalty) ExportConfig() ConfigDistribution {
+  return NewConfigDistribution("penalty", obj)
+}
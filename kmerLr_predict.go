@@ -18,122 +18,71 @@ package main
 
 /* -------------------------------------------------------------------------- */
 
-import   "fmt"
-import   "bufio"
-import   "io"
+import   "log"
+import   "math"
 import   "os"
 
-import . "github.com/pbenner/autodiff"
-import . "github.com/pbenner/gonetics"
-import   "github.com/pbenner/threadpool"
-
 import   "github.com/pborman/getopt"
 
 /* -------------------------------------------------------------------------- */
 
-func saveWindowPredictions(filename string, predictions [][]float64) {
-  var writer io.Writer
-  if filename == "" {
-    writer = os.Stdout
-  } else {
-    f, err := os.Create(filename)
-    if err != nil {
-      panic(err)
-    }
-    defer f.Close()
-
-    w := bufio.NewWriter(f)
-    defer w.Flush()
-
-    writer = w
-  }
-  fmt.Fprintf(writer, "%15s\n", "prediction")
-  for i := 0; i < len(predictions); i++ {
-    for j := 0; j < len(predictions[i]); j++ {
-      if j == 0 {
-        fmt.Fprintf(writer, "%15e", predictions[i][j])
-      } else {
-        fmt.Fprintf(writer, " %15e", predictions[i][j])
-      }
-    }
-    fmt.Fprintf(writer, "\n")
-  }
-}
-
-/* -------------------------------------------------------------------------- */
-
-func savePredictions(filename string, predictions []float64) {
-  var writer io.Writer
-  if filename == "" {
-    writer = os.Stdout
-  } else {
-    f, err := os.Create(filename)
-    if err != nil {
-      panic(err)
-    }
-    defer f.Close()
-
-    w := bufio.NewWriter(f)
-    defer w.Flush()
-
-    writer = w
-  }
-  fmt.Fprintf(writer, "%15s\n", "prediction")
-  for i := 0; i < len(predictions); i++ {
-    fmt.Fprintf(writer, "%15e\n", predictions[i])
-  }
-}
-
-/* -------------------------------------------------------------------------- */
-
 func predict_window(config Config, filename_json, filename_in, filename_out string, window_size, window_step int) {
   classifier  := ImportKmerLrEnsemble(config, filename_json)
-  sequences   := import_fasta(config, filename_in)
-  predictions := make([][]float64, len(sequences))
-  counters    := make([]*KmerCounter, config.Pool.NumberOfThreads())
-  for i := 0; i < len(counters); i++ {
-    counters[i] = classifier.GetKmerCounter()
+  headers, sequences := import_fasta(config, filename_in)
+  predictions := predict_windows(config, []*KmerLrEnsemble{classifier}, sequences, window_size, window_step)
+
+  names := make([]string, len(headers))
+  index := make([]int   , len(headers))
+  for i, header := range headers {
+    names[i] = fasta_header_name(header)
+    index[i] = i
+    scale_predictions(config, classifier.Regression, predictions[i])
   }
-  for i, sequence := range sequences {
-    if n := len(sequence)-window_size; n > 0 {
-      predictions[i] = make([]float64, n)
-    }
-  }
-  job_group := config.Pool.NewJobGroup()
-  for i, _ := range sequences {
-    i        := i
-    sequence := sequences[i]
-    for j := 0; j < len(sequence)-window_size; j += window_step {
-      j := j
-      config.Pool.AddJob(job_group, func(pool threadpool.ThreadPool, erf func() error) error {
-        config := config; config.Pool = pool
-        counts := scan_sequence(config, counters[pool.GetThreadId()], classifier.Binarize, []byte(sequence[j:j+window_size]))
-        counts.SetKmers(classifier.Kmers)
-        data   := convert_counts(config, counts, classifier.Features, false)
-        predictions[i][j] = classifier.Predict(config, []ConstVector{data})[0]
-        return nil
-      })
-    }
-  }
-  config.Pool.Wait(job_group)
-
-  saveWindowPredictions(filename_out, predictions)
+  saveWindowPredictions(config, compress_filename(config, filename_out), names, index, predictions)
 }
 
 /* -------------------------------------------------------------------------- */
 
 func predict_(config Config, filename_json, filename_in string) []float64 {
+  predictions, _, _ := predict_data(config, filename_json, filename_in)
+  return predictions
+}
+
+// Returns predictions on the output scale, the test data (for sequence
+// names) and the regression model of the classifier
+func predict_data(config Config, filename_json, filename_in string) ([]float64, KmerDataSet, string) {
   classifier  := ImportKmerLrEnsemble(config, filename_json)
   counter     := classifier.GetKmerCounter()
   data        := compile_test_data(config, counter, classifier.Kmers, classifier.Features, false, classifier.Binarize, filename_in)
   classifier.Transform.Apply(config, data.Data)
   predictions := classifier.Predict(config, data.Data)
 
-  return predictions
+  return scale_predictions(config, classifier.Regression, predictions), data, classifier.Regression
+}
+
+func predict_multinomial(config Config, filename_json, filename_in, filename_out string) {
+  classifier  := ImportKmerLrMultinomial(config, filename_json)
+  counter     := classifier.GetKmerCounter()
+  data        := compile_test_data(config, counter, classifier.Kmers, classifier.Features, false, classifier.Binarize, filename_in)
+  predictions := classifier.Predict(config, data.Data)
+  // multinomial models predict probabilities by default
+  if scale := config.OutputScale; scale != "" && scale != "prob" {
+    for i := 0; i < len(predictions); i++ {
+      for k := 0; k < len(predictions[i]); k++ {
+        predictions[i][k] = scale_prediction(scale, math.Log(predictions[i][k]))
+      }
+    }
+  }
+  saveMultinomialPredictions(config, compress_filename(config, filename_out), classifier.Classes, data.Seqnames, data.Seqindex, predictions)
 }
 
 func predict(config Config, filename_json, filename_in, filename_out string) {
-  savePredictions(filename_out, predict_(config, filename_json, filename_in))
+  if is_multinomial_model(filename_json) {
+    predict_multinomial(config, filename_json, filename_in, filename_out)
+    return
+  }
+  predictions, data, _ := predict_data(config, filename_json, filename_in)
+  savePredictions(config, compress_filename(config, filename_out), data.Seqnames, data.Seqindex, predictions)
 }
 
 /* -------------------------------------------------------------------------- */
@@ -142,7 +91,12 @@ func main_predict(config Config, args []string) {
   options := getopt.New()
 
   optSlidingWindow     := options.   IntLong("sliding-window",       0 ,        0, "make predictions by sliding a window along the sequence")
-  optSlidingWindowStep := options.   IntLong("sliding-window-step",  0 ,        0, "step size for sliding window")
+  optSlidingWindowStep := options.   IntLong("sliding-window-step",  0 ,        1, "step size for sliding window")
+  optMinQuality        := options.   IntLong("min-quality",          0 ,        0, "skip k-mers covering bases with a Phred quality below the given threshold (fastq input only)")
+  optAggregateReads    := options.  BoolLong("aggregate-reads",      0 ,           "each fasta/fastq file is a single sample with k-mer counts aggregated over all reads, where several files are joined with `+' (e.g. a.fq+b.fq)")
+  optOutputScale       := options.StringLong("output-scale",         0 ,       "", "scale of predictions [logprob (default), prob, logit], multinomial models predict probabilities by default and predictions of regression models are on the scale of the response")
+  optOutputFormat      := options.StringLong("output-format",        0 ,  "table", "output format [table (default), json]")
+  optThreshold         := options.StringLong("threshold",            0 ,    "NaN", "call classes by applying a threshold to predictions on the output scale")
   optHelp              := options.  BoolLong("help",                'h',           "print help")
 
   options.SetParameters("<MODEL.json> <SEQUENCES.fa> [RESULT.table]")
@@ -166,9 +120,27 @@ func main_predict(config Config, args []string) {
   if len(options.Args()) == 3 {
     filename_out = options.Args()[2]
   }
+  if err := parse_output_options(&config, *optOutputScale, *optOutputFormat, *optThreshold); err != nil {
+    log.Fatal(err)
+  }
+  config.MinQuality     = *optMinQuality
+  config.AggregateReads = *optAggregateReads
+
   if *optSlidingWindow > 0 {
+    if config.AggregateReads {
+      log.Fatal("option --aggregate-reads cannot be used with sliding window predictions")
+    }
+    if is_multinomial_model(filename_json) {
+      log.Fatal("sliding window predictions are not supported for multinomial models")
+    }
+    if *optSlidingWindowStep < 1 {
+      log.Fatal("option --sliding-window requires a positive --sliding-window-step")
+    }
     predict_window(config, filename_json, filename_in, filename_out, *optSlidingWindow, *optSlidingWindowStep)
   } else {
+    if !math.IsNaN(config.Threshold) && is_multinomial_model(filename_json) {
+      log.Fatal("option --threshold is not supported for multinomial models")
+    }
     predict(config, filename_json, filename_in, filename_out)
   }
 }
@@ -26,9 +26,7 @@ import   "math"
 import   "os"
 import   "strings"
 
-import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/gonetics"
-import   "github.com/pbenner/threadpool"
 
 import   "github.com/pborman/getopt"
 
@@ -39,7 +37,7 @@ func saveWindowPredictionsWiggle(filename string, regions GRanges, predictions [
   if filename == "" {
     writer = os.Stdout
   } else {
-    f, err := os.Create(filename)
+    f, err := create_file(filename)
     if err != nil {
       panic(err)
     }
@@ -113,61 +111,16 @@ func extractFasta(config Config, filenameFasta string, regions GRanges) []string
 
 /* -------------------------------------------------------------------------- */
 
-type genomicKmerLr struct {
-  classifiers   []*KmerLrEnsemble
-  counters    [][]*KmerCounter
-}
-
-func importGenomicKmerLr(config Config, filenames []string) genomicKmerLr {
-  counters    := make([][]*KmerCounter   , len(filenames))
-  classifiers := make(  []*KmerLrEnsemble, len(filenames))
-  for i, filename := range filenames {
-    classifiers[i] = ImportKmerLrEnsemble(config, filename)
-    counters   [i] = make([]*KmerCounter, config.Pool.NumberOfThreads())
-    for j := 0; j < config.Pool.NumberOfThreads(); j++ {
-      counters[i][j] = classifiers[i].GetKmerCounter()
-    }
-  }
-  return genomicKmerLr{classifiers, counters}
-}
-
-func (obj genomicKmerLr) Predict(config Config, subseq []byte, j int) float64 {
-  r := 0.0
-  for i, _ := range obj.classifiers {
-    counts := scan_sequence(config, obj.counters[i][j], obj.classifiers[i].Binarize, subseq)
-    counts.SetKmers(obj.classifiers[i].Kmers)
-    data   := convert_counts(config, counts, obj.classifiers[i].Features, false)
-    r      += obj.classifiers[i].Predict(config, []ConstVector{data})[0]
-  }
-  return r
-}
-
-/* -------------------------------------------------------------------------- */
-
 func predict_window_genomic(config Config, filename_json []string, filename_fa, filename_bed, filename_out, track_name string, window_size, window_step int) {
-  classifier  := importGenomicKmerLr(config, filename_json)
-  regions     := importBed3         (config, filename_bed )
-  sequences   := extractFasta       (config, filename_fa, regions)
-  predictions := make([][]float64, len(sequences))
-  for i, sequence := range sequences {
-    if n := len(sequence)-window_size; n > 0 {
-      predictions[i] = make([]float64, n/window_step+1)
-    }
-  }
-  job_group := config.Pool.NewJobGroup()
-  for i, _ := range sequences {
-    for j := 0; j < len(sequences[i])-window_size; j += window_step {
-      i := i
-      j := j
-      config.Pool.AddJob(job_group, func(pool threadpool.ThreadPool, erf func() error) error {
-        predictions[i][j/window_step] = classifier.Predict(config, []byte(sequences[i][j:j+window_size]), pool.GetThreadId())
-        return nil
-      })
-    }
+  classifiers := make([]*KmerLrEnsemble, len(filename_json))
+  for i, filename := range filename_json {
+    classifiers[i] = ImportKmerLrEnsemble(config, filename)
   }
-  config.Pool.Wait(job_group)
+  regions     := importBed3  (config, filename_bed )
+  sequences   := extractFasta(config, filename_fa, regions)
+  predictions := predict_windows(config, classifiers, sequences, window_size, window_step)
 
-  saveWindowPredictionsWiggle(filename_out, regions, predictions, track_name, window_size, window_step)
+  saveWindowPredictionsWiggle(compress_filename(config, filename_out), regions, predictions, track_name, window_size, window_step)
 }
 
 /* -------------------------------------------------------------------------- */
@@ -0,0 +1,320 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "encoding/json"
+import   "io"
+import   "log"
+import   "math"
+import   "os"
+import   "strconv"
+
+/* -------------------------------------------------------------------------- */
+
+func check_output_scale(scale string) error {
+  switch scale {
+  case "":
+  case "logprob":
+  case "prob":
+  case "logit":
+  default:
+    return fmt.Errorf("invalid output scale `%s'", scale)
+  }
+  return nil
+}
+
+func check_output_format(format string) error {
+  switch format {
+  case "":
+  case "table":
+  case "json":
+  default:
+    return fmt.Errorf("invalid output format `%s'", format)
+  }
+  return nil
+}
+
+// Convert a log-probability to the given output scale
+func scale_prediction(scale string, logp float64) float64 {
+  switch scale {
+  case "", "logprob":
+    return logp
+  case "prob":
+    return math.Exp(logp)
+  case "logit":
+    return logp - math.Log1p(-math.Exp(logp))
+  default:
+    panic("internal error")
+  }
+}
+
+// Convert predictions of a binary classifier (log-probabilities) to the
+// output scale given by --output-scale, predictions of regression models
+// are always on the scale of the response
+func scale_predictions(config Config, regression string, predictions []float64) []float64 {
+  if regression != "" {
+    if config.OutputScale != "" {
+      log.Fatal("option --output-scale is not supported for regression models")
+    }
+    return predictions
+  }
+  for i := 0; i < len(predictions); i++ {
+    predictions[i] = scale_prediction(config.OutputScale, predictions[i])
+  }
+  return predictions
+}
+
+// Class call of a prediction given by --threshold
+func predicted_class(config Config, prediction float64) int {
+  if prediction >= config.Threshold {
+    return 1
+  } else {
+    return 0
+  }
+}
+
+func parse_output_options(config *Config, scale, format, threshold string) error {
+  if err := check_output_scale(scale); err != nil {
+    return err
+  }
+  if err := check_output_format(format); err != nil {
+    return err
+  }
+  if t, err := strconv.ParseFloat(threshold, 64); err != nil {
+    return fmt.Errorf("invalid threshold `%s'", threshold)
+  } else {
+    config.Threshold = t
+  }
+  config.OutputScale  = scale
+  config.OutputFormat = format
+  return nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+// JSON has no representation for NaN and infinite values, which are
+// exported as null
+type jsonFloat64 float64
+
+func (x jsonFloat64) MarshalJSON() ([]byte, error) {
+  if v := float64(x); math.IsNaN(v) || math.IsInf(v, 0) {
+    return []byte("null"), nil
+  } else {
+    return []byte(strconv.FormatFloat(v, 'e', -1, 64)), nil
+  }
+}
+
+type predictionRecord struct {
+  Name          string             `json:"name,omitempty"`
+  Index         int                `json:"index"`
+  Prediction    jsonFloat64        `json:"prediction"`
+  Class        *int                `json:"class,omitempty"`
+}
+
+type windowPredictionRecord struct {
+  Name          string             `json:"name,omitempty"`
+  Index         int                `json:"index"`
+  Predictions []jsonFloat64        `json:"predictions"`
+  Classes     []int                `json:"classes,omitempty"`
+}
+
+type multinomialPredictionRecord struct {
+  Name          string             `json:"name,omitempty"`
+  Index         int                `json:"index"`
+  Predictions   map[string]jsonFloat64 `json:"predictions"`
+}
+
+func writePredictionsJson(writer io.Writer, records interface{}) {
+  if b, err := json.MarshalIndent(records, "", "  "); err != nil {
+    log.Fatal(err)
+  } else {
+    writer.Write(b)
+    fmt.Fprintf(writer, "\n")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Save predictions of binary classifiers and regression models, where names
+// are the sequence names (if available) and index the position of each sample
+// within the input file
+func savePredictions(config Config, filename string, names []string, index []int, predictions []float64) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  threshold := !math.IsNaN(config.Threshold)
+  if config.OutputFormat == "json" {
+    records := make([]predictionRecord, len(predictions))
+    for i := 0; i < len(predictions); i++ {
+      records[i].Index      = index[i]
+      records[i].Prediction = jsonFloat64(predictions[i])
+      if len(names) > 0 {
+        records[i].Name = names[i]
+      }
+      if threshold {
+        c := predicted_class(config, predictions[i])
+        records[i].Class = &c
+      }
+    }
+    writePredictionsJson(writer, records)
+    return
+  }
+  fmt.Fprintf(writer, "%15s", "prediction")
+  if threshold {
+    fmt.Fprintf(writer, "\t%5s", "class")
+  }
+  fmt.Fprintf(writer, "\t%8s", "index")
+  if len(names) > 0 {
+    fmt.Fprintf(writer, "\t%s", "name")
+  }
+  fmt.Fprintf(writer, "\n")
+  for i := 0; i < len(predictions); i++ {
+    fmt.Fprintf(writer, "%15e", predictions[i])
+    if threshold {
+      fmt.Fprintf(writer, "\t%5d", predicted_class(config, predictions[i]))
+    }
+    fmt.Fprintf(writer, "\t%8d", index[i])
+    if len(names) > 0 {
+      fmt.Fprintf(writer, "\t%s", names[i])
+    }
+    fmt.Fprintf(writer, "\n")
+  }
+}
+
+// Save sliding window predictions with one line (table) or record (json)
+// per sequence
+func saveWindowPredictions(config Config, filename string, names []string, index []int, predictions [][]float64) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  threshold := !math.IsNaN(config.Threshold)
+  if config.OutputFormat == "json" {
+    records := make([]windowPredictionRecord, len(predictions))
+    for i := 0; i < len(predictions); i++ {
+      records[i].Index       = index[i]
+      records[i].Name        = names[i]
+      records[i].Predictions = make([]jsonFloat64, len(predictions[i]))
+      for j := 0; j < len(predictions[i]); j++ {
+        records[i].Predictions[j] = jsonFloat64(predictions[i][j])
+      }
+      if threshold {
+        records[i].Classes = make([]int, len(predictions[i]))
+        for j := 0; j < len(predictions[i]); j++ {
+          records[i].Classes[j] = predicted_class(config, predictions[i][j])
+        }
+      }
+    }
+    writePredictionsJson(writer, records)
+    return
+  }
+  fmt.Fprintf(writer, "%8s\t%s\t%s", "index", "name", "prediction")
+  if threshold {
+    fmt.Fprintf(writer, "\t%s", "class")
+  }
+  fmt.Fprintf(writer, "\n")
+  for i := 0; i < len(predictions); i++ {
+    fmt.Fprintf(writer, "%8d\t%s\t", index[i], names[i])
+    for j := 0; j < len(predictions[i]); j++ {
+      if j == 0 {
+        fmt.Fprintf(writer, "%15e", predictions[i][j])
+      } else {
+        fmt.Fprintf(writer, " %15e", predictions[i][j])
+      }
+    }
+    if threshold {
+      fmt.Fprintf(writer, "\t")
+      for j := 0; j < len(predictions[i]); j++ {
+        if j == 0 {
+          fmt.Fprintf(writer, "%d", predicted_class(config, predictions[i][j]))
+        } else {
+          fmt.Fprintf(writer, " %d", predicted_class(config, predictions[i][j]))
+        }
+      }
+    }
+    fmt.Fprintf(writer, "\n")
+  }
+}
+
+// Save class probabilities of multinomial models with one column per class
+func saveMultinomialPredictions(config Config, filename string, classes, names []string, index []int, predictions [][]float64) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  if config.OutputFormat == "json" {
+    records := make([]multinomialPredictionRecord, len(predictions))
+    for i := 0; i < len(predictions); i++ {
+      records[i].Index       = index[i]
+      records[i].Name        = names[i]
+      records[i].Predictions = make(map[string]jsonFloat64)
+      for j, class := range classes {
+        records[i].Predictions[class] = jsonFloat64(predictions[i][j])
+      }
+    }
+    writePredictionsJson(writer, records)
+    return
+  }
+  for _, class := range classes {
+    fmt.Fprintf(writer, "%15s\t", class)
+  }
+  fmt.Fprintf(writer, "%8s\t%s\n", "index", "name")
+  for i := 0; i < len(predictions); i++ {
+    for j := 0; j < len(predictions[i]); j++ {
+      fmt.Fprintf(writer, "%15e\t", predictions[i][j])
+    }
+    fmt.Fprintf(writer, "%8d\t%s\n", index[i], names[i])
+  }
+}
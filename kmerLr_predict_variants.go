@@ -0,0 +1,358 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "bufio"
+import   "io"
+import   "log"
+import   "os"
+import   "sort"
+import   "strconv"
+import   "strings"
+
+import . "github.com/pbenner/gonetics"
+import   "github.com/pbenner/threadpool"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+// A single alternative allele of a VCF record, multiallelic sites are split
+// into one variant per allele
+type vcfVariant struct {
+  Chrom  string
+  // zero-based position of the reference allele
+  Pos    int
+  Id     string
+  Ref    string
+  Alt    string
+  // index of the alternative allele (starting at 1)
+  Allele int
+}
+
+func import_vcf(config Config, filename string) []vcfVariant {
+  f, err := open_file(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer f.Close()
+
+  PrintStderr(config, 1, "Reading variants from `%s'... ", filename)
+  r       := []vcfVariant{}
+  skipped := 0
+  scanner := bufio.NewScanner(f)
+  scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+  for i := 1; scanner.Scan(); i++ {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    fields := strings.Split(line, "\t")
+    if len(fields) < 5 {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("invalid VCF record in line %d", i)
+    }
+    pos, err := strconv.Atoi(fields[1])
+    if err != nil || pos < 1 {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("invalid position in line %d", i)
+    }
+    for j, alt := range strings.Split(fields[4], ",") {
+      // skip symbolic alleles, breakends and missing alleles
+      if strings.ContainsAny(alt, "<>[]*.") || strings.ContainsAny(fields[3], "<>[]*.") {
+        skipped++
+        continue
+      }
+      r = append(r, vcfVariant{
+        Chrom : fields[0],
+        Pos   : pos-1,
+        Id    : fields[2],
+        Ref   : strings.ToLower(fields[3]),
+        Alt   : strings.ToLower(alt),
+        Allele: j+1 })
+    }
+  }
+  if err := scanner.Err(); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+  if skipped > 0 {
+    PrintStderr(config, 1, "Skipped %d symbolic or missing alleles\n", skipped)
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+func revcomp_sequence(alphabet ComplementableAlphabet, sequence []byte) []byte {
+  r := make([]byte, len(sequence))
+  for i, c := range sequence {
+    if b, err := alphabet.Complement(c); err != nil {
+      // keep ambiguous letters that have no complement
+      r[len(sequence)-i-1] = c
+    } else {
+      r[len(sequence)-i-1] = b
+    }
+  }
+  return r
+}
+
+// Score of the reference and alternative allele within a window of the
+// reference genome, where sequence[from:to] is the reference allele
+type variantScore struct {
+  Ref, Alt     float64
+  Gained, Lost map[string]int
+}
+
+func (obj *variantScorer) ScoreVariant(config Config, sequence []byte, from, to int, alt []byte, strand byte) variantScore {
+  if strand == '-' {
+    sequence = revcomp_sequence(obj.classifier.Alphabet, sequence)
+    alt      = revcomp_sequence(obj.classifier.Alphabet, alt)
+    from, to = len(sequence)-to, len(sequence)-from
+  }
+  occurrences := obj.scanner.Scan(sequence)
+  n           := obj.scanner.Counts(occurrences)
+  eta         := obj.linearPredictor(n)
+  delta       := obj.countChanges(sequence, occurrences, from, to, alt)
+  r := variantScore{Gained: make(map[string]int), Lost: make(map[string]int)}
+  r.Ref = obj.prediction(config, eta)
+  r.Alt = obj.prediction(config, obj.update(eta, n, delta))
+  for i, d := range delta {
+    if d > 0 {
+      r.Gained[obj.classifier.Kmers[i].String()] += d
+    }
+    if d < 0 {
+      r.Lost  [obj.classifier.Kmers[i].String()] -= d
+    }
+  }
+  return r
+}
+
+/* -------------------------------------------------------------------------- */
+
+type variantResult struct {
+  vcfVariant
+  Strand byte
+  variantScore
+}
+
+type variantRecord struct {
+  Chrom    string         `json:"chrom"`
+  Pos      int            `json:"pos"`
+  Id       string         `json:"id"`
+  Ref      string         `json:"ref"`
+  Alt      string         `json:"alt"`
+  Allele   int            `json:"allele"`
+  Strand   string         `json:"strand"`
+  RefScore jsonFloat64    `json:"ref_score"`
+  AltScore jsonFloat64    `json:"alt_score"`
+  Delta    jsonFloat64    `json:"delta"`
+  Gained   map[string]int `json:"gained"`
+  Lost     map[string]int `json:"lost"`
+}
+
+func format_kmer_changes(m map[string]int) string {
+  if len(m) == 0 {
+    return "-"
+  }
+  kmers := []string{}
+  for kmer, _ := range m {
+    kmers = append(kmers, kmer)
+  }
+  sort.Strings(kmers)
+  for i, kmer := range kmers {
+    kmers[i] = fmt.Sprintf("%s:%d", kmer, m[kmer])
+  }
+  return strings.Join(kmers, ",")
+}
+
+func saveVariantPredictions(config Config, filename string, results []variantResult) {
+  var writer io.Writer
+  if filename == "" {
+    writer = os.Stdout
+  } else {
+    f, err := create_file(filename)
+    if err != nil {
+      panic(err)
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    defer w.Flush()
+
+    writer = w
+  }
+  if config.OutputFormat == "json" {
+    records := make([]variantRecord, len(results))
+    for i, r := range results {
+      records[i] = variantRecord{
+        Chrom   : r.Chrom,
+        Pos     : r.Pos+1,
+        Id      : r.Id,
+        Ref     : r.vcfVariant.Ref,
+        Alt     : r.vcfVariant.Alt,
+        Allele  : r.Allele,
+        Strand  : string(r.Strand),
+        RefScore: jsonFloat64(r.variantScore.Ref),
+        AltScore: jsonFloat64(r.variantScore.Alt),
+        Delta   : jsonFloat64(r.variantScore.Alt - r.variantScore.Ref),
+        Gained  : r.Gained,
+        Lost    : r.Lost }
+    }
+    writePredictionsJson(writer, records)
+    return
+  }
+  fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%15s\t%15s\t%15s\t%s\t%s\n", "chrom", "pos", "id", "ref", "alt", "allele", "strand", "ref_score", "alt_score", "delta", "gained", "lost")
+  for _, r := range results {
+    fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%d\t%c\t%15e\t%15e\t%15e\t%s\t%s\n", r.Chrom, r.Pos+1, r.Id, r.vcfVariant.Ref, r.vcfVariant.Alt, r.Allele, r.Strand,
+      r.variantScore.Ref, r.variantScore.Alt, r.variantScore.Alt - r.variantScore.Ref, format_kmer_changes(r.Gained), format_kmer_changes(r.Lost))
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func predict_variants(config Config, filename_json []string, filename_fa, filename_vcf, filename_out string, window_size int, strands []byte) {
+  classifiers := make([]*KmerLrEnsemble, len(filename_json))
+  for i, filename := range filename_json {
+    if is_multinomial_model(filename) {
+      log.Fatal("variant predictions are not supported for multinomial models")
+    }
+    classifiers[i] = ImportKmerLrEnsemble(config, filename)
+  }
+  variants := import_vcf(config, filename_vcf)
+  genome   := importFasta(config, filename_fa)
+
+  // create one set of scorers for each thread
+  scorers := make([][]*variantScorer, config.Pool.NumberOfThreads())
+  for i := 0; i < len(scorers); i++ {
+    scorers[i] = make([]*variantScorer, len(classifiers))
+    for j, classifier := range classifiers {
+      scorers[i][j] = newVariantScorer(classifier)
+    }
+  }
+  results := make([]variantResult, len(variants)*len(strands))
+  PrintStderr(config, 1, "Scoring variants... ")
+  if err := config.Pool.RangeJob(0, len(variants), func(i int, pool threadpool.ThreadPool, erf func() error) error {
+    config  := config; config.Pool = pool
+    variant := variants[i]
+    chrom, ok := genome[variant.Chrom]
+    if !ok {
+      return fmt.Errorf("sequence `%s' not found in fasta file", variant.Chrom)
+    }
+    if variant.Pos+len(variant.Ref) > len(chrom) || !strings.EqualFold(string(chrom[variant.Pos:variant.Pos+len(variant.Ref)]), variant.Ref) {
+      return fmt.Errorf("reference allele `%s' of variant at %s:%d does not match the genome", variant.Ref, variant.Chrom, variant.Pos+1)
+    }
+    // window centered at the reference allele
+    from := variant.Pos - window_size/2
+    to   := variant.Pos + len(variant.Ref) + window_size/2
+    if from < 0 {
+      from = 0
+    }
+    if to > len(chrom) {
+      to = len(chrom)
+    }
+    sequence := []byte(strings.ToLower(string(chrom[from:to])))
+    for k, strand := range strands {
+      r := variantResult{vcfVariant: variant, Strand: strand}
+      r.Gained = make(map[string]int)
+      r.Lost   = make(map[string]int)
+      // sum scores of all models
+      for _, scorer := range scorers[pool.GetThreadId()] {
+        s := scorer.ScoreVariant(config, sequence, variant.Pos-from, variant.Pos-from+len(variant.Ref), []byte(variant.Alt), strand)
+        r.variantScore.Ref += s.Ref
+        r.variantScore.Alt += s.Alt
+        // k-mers shared by several models are reported once
+        for kmer, c := range s.Gained {
+          if c > r.Gained[kmer] {
+            r.Gained[kmer] = c
+          }
+        }
+        for kmer, c := range s.Lost {
+          if c > r.Lost[kmer] {
+            r.Lost[kmer] = c
+          }
+        }
+      }
+      results[i*len(strands)+k] = r
+    }
+    return nil
+  }); err != nil {
+    PrintStderr(config, 1, "failed\n")
+    log.Fatal(err)
+  }
+  PrintStderr(config, 1, "done\n")
+
+  saveVariantPredictions(config, compress_filename(config, filename_out), results)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_predict_variants(config Config, args []string) {
+  options := getopt.New()
+
+  optDelimiter    := options.StringLong("delimiter",      0 ,     ",", "use STR instead of COMMA for model field delimiter")
+  optWindowSize   := options.   IntLong("window-size",    0 ,     200, "number of reference bases around each variant that are scored [default: 200]")
+  optStrand       := options.StringLong("strand",         0 ,     "+", "score the forward (+) or reverse (-) strand, or both strands [+ (default), -, both]")
+  optOutputFormat := options.StringLong("output-format",  0 , "table", "output format [table (default), json]")
+  optHelp         := options.  BoolLong("help",          'h',          "print help")
+
+  options.SetParameters("<MODEL1.json,MODEL2.json,...> <GENOME.fa> <VARIANTS.vcf> [RESULT.table]")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if *optWindowSize < 0 {
+    log.Fatalf("invalid window size `%d'", *optWindowSize)
+  }
+  strands := []byte{}
+  switch *optStrand {
+  case "+":
+    strands = []byte{'+'}
+  case "-":
+    strands = []byte{'-'}
+  case "both":
+    strands = []byte{'+', '-'}
+  default:
+    log.Fatalf("invalid strand `%s'", *optStrand)
+  }
+  if err := check_output_format(*optOutputFormat); err != nil {
+    log.Fatal(err)
+  }
+  config.OutputFormat = *optOutputFormat
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) != 3 && len(options.Args()) != 4 {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  filename_json := strings.Split(options.Args()[0], *optDelimiter)
+  filename_fa   := options.Args()[1]
+  filename_vcf  := options.Args()[2]
+  filename_out  := ""
+  if len(options.Args()) == 4 {
+    filename_out = options.Args()[3]
+  }
+  predict_variants(config, filename_json, filename_fa, filename_vcf, filename_out, *optWindowSize, strands)
+}
@@ -0,0 +1,246 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "log"
+
+import . "github.com/pbenner/autodiff"
+import . "github.com/pbenner/autodiff/logarithmetic"
+import . "github.com/pbenner/gonetics"
+import   "github.com/pbenner/threadpool"
+
+/* -------------------------------------------------------------------------- */
+
+// Number of windows of a sequence of length n, where windows start at
+// positions 0, step, 2*step, ... < n-window_size
+func window_count(n, window_size, window_step int) int {
+  if n -= window_size; n > 0 {
+    return (n-1)/window_step+1
+  }
+  return 0
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Sliding window predictions, where the linear predictor of each window is
+// computed incrementally from the previous window. Since the linear predictor
+// is additive over k-mers, only the k-mers that leave or enter the window have
+// to be considered at each step. This is not possible with co-occurrence
+// features or transforms with offsets, in which case the k-mers of each
+// window are counted separately.
+type windowPredictor struct {
+  classifier   *KmerLrEnsemble
+  // k-mer counter for the full range of k-mer lengths (fallback)
+  counter      *KmerCounter
+  scanner      *kmerScanner
+  // map k-mers to features (-1 if a k-mer is not a feature)
+  features   []int
+  // coefficients of each ensemble member including the scale of the
+  // transform, where the first entry is the intercept
+  theta      [][]float64
+  incremental  bool
+}
+
+func newWindowPredictor(classifier *KmerLrEnsemble) *windowPredictor {
+  r := windowPredictor{classifier: classifier, counter: classifier.GetKmerCounter()}
+  r.incremental = len(classifier.Transform.Offset) == 0
+  for _, feature := range classifier.Features {
+    if feature[0] != feature[1] {
+      r.incremental = false
+    }
+  }
+  if !r.incremental {
+    return &r
+  }
+  r.scanner  = newKmerScanner(classifier.KmerLrFeatures)
+  r.features = make([]int, len(classifier.Kmers))
+  for i := 0; i < len(r.features); i++ {
+    r.features[i] = -1
+  }
+  for j, feature := range classifier.Features {
+    r.features[feature[0]] = j
+  }
+  r.theta = make([][]float64, len(classifier.Theta))
+  for i, theta := range classifier.Theta {
+    r.theta[i] = append([]float64{}, theta...)
+    if len(classifier.Transform.Scale) > 0 {
+      for j := 0; j < len(theta); j++ {
+        r.theta[i][j] *= classifier.Transform.Scale[j]
+      }
+    }
+  }
+  return &r
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Features of all k-mers starting at each position of the sequence
+func (obj *windowPredictor) scan(sequence []byte) [][]kmerOccurrence {
+  r := obj.scanner.Scan(sequence)
+  for i, list := range r {
+    r[i] = list[:0]
+    for _, o := range list {
+      if j := obj.features[o.Index]; j != -1 {
+        r[i] = append(r[i], kmerOccurrence{o.K, j, o.Count})
+      }
+    }
+  }
+  return r
+}
+
+// Prediction given the linear predictor of each ensemble member
+func (obj *windowPredictor) summarize(config Config, eta, t []float64) float64 {
+  for i := 0; i < len(eta); i++ {
+    if obj.classifier.Regression != "" {
+      t[i] = regression_mean(obj.classifier.Regression, eta[i])
+    } else {
+      t[i] = -LogAdd(0.0, -eta[i])
+    }
+  }
+  return obj.classifier.Summarize(config, t)
+}
+
+func (obj *windowPredictor) predictIncremental(config Config, sequence []byte, window_size, window_step int) []float64 {
+  r      := make([]float64, window_count(len(sequence), window_size, window_step))
+  kmers  := obj.scan(sequence)
+  counts := make([]int, len(obj.classifier.Features))
+  eta    := make([]float64, len(obj.theta))
+  t      := make([]float64, len(obj.theta))
+  for i := 0; i < len(obj.theta); i++ {
+    eta[i] = obj.theta[i][0]
+  }
+  // update count of feature j and the linear predictor
+  update := func(j, delta int) {
+    c0 := counts[j]
+    c1 := counts[j]+delta
+    counts[j] = c1
+    if obj.classifier.Binarize {
+      if c0 > 1 { c0 = 1 }
+      if c1 > 1 { c1 = 1 }
+    }
+    if c0 != c1 {
+      for i := 0; i < len(eta); i++ {
+        eta[i] += float64(c1-c0)*obj.theta[i][j+1]
+      }
+    }
+  }
+  // initialize first window
+  for p := 0; p < window_size && p < len(sequence); p++ {
+    for _, kmer := range kmers[p] {
+      if p+kmer.K <= window_size {
+        update(kmer.Index, kmer.Count)
+      }
+    }
+  }
+  for w := 0; w < len(sequence)-window_size; w++ {
+    if w % window_step == 0 {
+      r[w/window_step] = obj.summarize(config, eta, t)
+    }
+    // remove k-mers starting at position w
+    for _, kmer := range kmers[w] {
+      if kmer.K <= window_size {
+        update(kmer.Index, -kmer.Count)
+      }
+    }
+    // add k-mers ending at position w+window_size
+    for k := obj.classifier.M; k <= obj.classifier.N && k <= window_size; k++ {
+      for _, kmer := range kmers[w+window_size+1-k] {
+        if kmer.K == k {
+          update(kmer.Index, kmer.Count)
+        }
+      }
+    }
+  }
+  return r
+}
+
+// Count k-mers of each window separately
+func (obj *windowPredictor) predictFull(config Config, sequence []byte, window_size, window_step int) []float64 {
+  r := make([]float64, window_count(len(sequence), window_size, window_step))
+  // do not report normalization of each window
+  config.Verbose = 0
+  for j := 0; j < len(sequence)-window_size; j += window_step {
+    counts := scan_sequence(config, obj.counter, obj.classifier.Binarize, sequence[j:j+window_size], nil)
+    counts.SetKmers(obj.classifier.Kmers)
+    data   := []ConstVector{convert_counts(config, counts, obj.classifier.Features, false)}
+    obj.classifier.Transform.Apply(config, data)
+    r[j/window_step] = obj.classifier.Predict(config, data)[0]
+  }
+  return r
+}
+
+func (obj *windowPredictor) Predict(config Config, sequence []byte, window_size, window_step int) []float64 {
+  if obj.incremental {
+    return obj.predictIncremental(config, sequence, window_size, window_step)
+  } else {
+    return obj.predictFull(config, sequence, window_size, window_step)
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Number of windows that are scored incrementally by a single job
+const windowBlockSize = 10000
+
+// Sliding window predictions summed over all classifiers. Long sequences are
+// split into blocks of windows, so that jobs can be distributed over threads
+// even if there are only a few sequences.
+func predict_windows(config Config, classifiers []*KmerLrEnsemble, sequences []string, window_size, window_step int) [][]float64 {
+  if window_size < 1 {
+    log.Fatalf("invalid window size `%d'", window_size)
+  }
+  if window_step < 1 {
+    log.Fatalf("invalid window step `%d'", window_step)
+  }
+  // create one set of predictors for each thread
+  predictors := make([][]*windowPredictor, config.Pool.NumberOfThreads())
+  for i := 0; i < len(predictors); i++ {
+    predictors[i] = make([]*windowPredictor, len(classifiers))
+    for j, classifier := range classifiers {
+      predictors[i][j] = newWindowPredictor(classifier)
+    }
+  }
+  r := make([][]float64, len(sequences))
+  for i, sequence := range sequences {
+    r[i] = make([]float64, window_count(len(sequence), window_size, window_step))
+  }
+  job_group := config.Pool.NewJobGroup()
+  for i, _ := range sequences {
+    for b := 0; b < len(r[i]); b += windowBlockSize {
+      i := i
+      b := b
+      config.Pool.AddJob(job_group, func(pool threadpool.ThreadPool, erf func() error) error {
+        config := config; config.Pool = pool
+        from   := b*window_step
+        to     := from+windowBlockSize*window_step+window_size
+        if to > len(sequences[i]) {
+          to = len(sequences[i])
+        }
+        for _, predictor := range predictors[pool.GetThreadId()] {
+          for k, x := range predictor.Predict(config, []byte(sequences[i][from:to]), window_size, window_step) {
+            r[i][b+k] += x
+          }
+        }
+        return nil
+      })
+    }
+  }
+  config.Pool.Wait(job_group)
+  return r
+}
@@ -0,0 +1,316 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "bufio"
+import   "fmt"
+import   "log"
+import   "math"
+import   "strconv"
+import   "strings"
+
+import . "github.com/pbenner/autodiff"
+import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
+
+/* -------------------------------------------------------------------------- */
+
+func check_regression(regression string) error {
+  switch regression {
+  case "":
+  case "linear":
+  case "poisson":
+  default:
+    return fmt.Errorf("invalid regression model `%s'", regression)
+  }
+  return nil
+}
+
+// Mean of the response given the linear predictor eta
+func regression_mean(regression string, eta float64) float64 {
+  switch regression {
+  case "linear":
+    return eta
+  case "poisson":
+    return math.Exp(eta)
+  default:
+    panic("internal error")
+  }
+}
+
+// Negative log-likelihood of response y given the linear predictor eta (up
+// to terms that do not depend on eta)
+func regression_loss(regression string, eta, y float64) float64 {
+  switch regression {
+  case "linear":
+    return 0.5*(eta - y)*(eta - y)
+  case "poisson":
+    return math.Exp(eta) - y*eta
+  default:
+    panic("internal error")
+  }
+}
+
+// Unit deviance of response y given the mean mu
+func regression_deviance(regression string, mu, y float64) float64 {
+  switch regression {
+  case "linear":
+    return (y - mu)*(y - mu)
+  case "poisson":
+    if y == 0.0 {
+      return 2.0*mu
+    }
+    return 2.0*(y*math.Log(y/mu) - (y - mu))
+  default:
+    panic("internal error")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Coefficient of determination
+func eval_r2(predictions, responses []float64) float64 {
+  if len(responses) == 0 {
+    return math.NaN()
+  }
+  m := 0.0
+  for _, y := range responses {
+    m += y
+  }
+  m /= float64(len(responses))
+  ss_res := 0.0
+  ss_tot := 0.0
+  for i, y := range responses {
+    ss_res += (y - predictions[i])*(y - predictions[i])
+    ss_tot += (y - m)*(y - m)
+  }
+  return 1.0 - ss_res/ss_tot
+}
+
+// Pearson correlation between predictions and responses
+func eval_correlation(predictions, responses []float64) float64 {
+  if len(responses) == 0 {
+    return math.NaN()
+  }
+  n  := float64(len(responses))
+  m1 := 0.0
+  m2 := 0.0
+  for i, y := range responses {
+    m1 += predictions[i]
+    m2 += y
+  }
+  m1 /= n
+  m2 /= n
+  s12 := 0.0
+  s11 := 0.0
+  s22 := 0.0
+  for i, y := range responses {
+    s12 += (predictions[i] - m1)*(y - m2)
+    s11 += (predictions[i] - m1)*(predictions[i] - m1)
+    s22 += (y - m2)*(y - m2)
+  }
+  return s12/math.Sqrt(s11*s22)
+}
+
+// Mean deviance of predicted means
+func eval_deviance(regression string, predictions, responses []float64) float64 {
+  if len(responses) == 0 {
+    return math.NaN()
+  }
+  r := 0.0
+  for i, y := range responses {
+    r += regression_deviance(regression, predictions[i], y)
+  }
+  return r/float64(len(responses))
+}
+
+/* -------------------------------------------------------------------------- */
+
+func check_response(regression string, y float64) error {
+  if math.IsNaN(y) || math.IsInf(y, 0) || (regression == "poisson" && y < 0.0) {
+    return fmt.Errorf("invalid response `%v'", y)
+  }
+  return nil
+}
+
+func parse_response(regression, s string) (float64, error) {
+  if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+    return 0.0, err
+  } else {
+    if err := check_response(regression, v); err != nil {
+      return 0.0, fmt.Errorf("invalid response `%s'", s)
+    }
+    return v, nil
+  }
+}
+
+func import_responses(config Config, headers []string, n int) []float64 {
+  if config.ResponseFile != "" {
+    f, err := open_file(config.ResponseFile)
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer f.Close()
+
+    PrintStderr(config, 1, "Reading responses from `%s'... ", config.ResponseFile)
+    responses := []float64{}
+    scanner   := bufio.NewScanner(f)
+    for scanner.Scan() {
+      if line := strings.TrimSpace(scanner.Text()); line != "" {
+        if y, err := parse_response(config.Regression, line); err != nil {
+          PrintStderr(config, 1, "failed\n")
+          log.Fatal(err)
+        } else {
+          responses = append(responses, y)
+        }
+      }
+    }
+    if err := scanner.Err(); err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal(err)
+    }
+    if len(responses) != n {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("number of responses (%d) does not match number of samples (%d)", len(responses), n)
+    }
+    PrintStderr(config, 1, "done\n")
+    return responses
+  }
+  if config.ResponseField > 0 {
+    if len(headers) != n {
+      log.Fatal("responses can only be extracted from fasta headers")
+    }
+    responses := make([]float64, n)
+    for i, header := range headers {
+      if s, err := fasta_header_field(header, config.ResponseField); err != nil {
+        log.Fatal(err)
+      } else {
+        if responses[i], err = parse_response(config.Regression, s); err != nil {
+          log.Fatalf("invalid response in fasta header `%s': %v", header, err)
+        }
+      }
+    }
+    return responses
+  }
+  log.Fatal("regression models require responses (see --response-field and --response-file)")
+  return nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Accelerated proximal gradient descent (FISTA) with backtracking line search
+// and adaptive restarts for linear and poisson regression. A fixed step size
+// as in estimate_proximal cannot be used, since the gradient of the poisson
+// loss is not Lipschitz continuous.
+func estimate_proximal_regression(config Config, estimator *vectorEstimator.LogisticRegression, data []ConstVector, responses []float64, weights []float64, groups featureGroups) []float64 {
+  n      := float64(len(data))
+  // theta0: previous estimate, theta1: current estimate, y: extrapolated point
+  theta0 := make([]float64, estimator.Theta.Dim())
+  theta1 := make([]float64, estimator.Theta.Dim())
+  y      := make([]float64, estimator.Theta.Dim())
+  for k := 0; k < len(theta1); k++ {
+    theta1[k] = estimator.Theta.Float64At(k)
+    y     [k] = theta1[k]
+  }
+  lr := logisticRegression{}
+  lr.Regression   = config.Regression
+  lr.Responses    = responses
+  lr.Weights      = weights
+  lr.Pool         = config.PoolLR
+  loss := func(theta []float64) float64 {
+    lr.Theta = theta
+    return lr.Loss(data, nil)
+  }
+  penalty := func(theta []float64) float64 {
+    r := 0.0
+    if groups.Nil() {
+      for k := 1; k < len(theta); k++ {
+        r += estimator.L1Reg/n*math.Abs(theta[k])
+      }
+    } else {
+      r += estimator.L1Reg/n*groups.Penalty(theta)
+    }
+    for k := 1; k < len(theta); k++ {
+      r += estimator.L2Reg/n/2.0*theta[k]*theta[k]
+    }
+    return r
+  }
+  g  := make([]float64, len(theta1))
+  t  := 1.0
+  m  := 1.0
+  l0 := loss(theta1) + penalty(theta1)
+  for i := 0; i < estimator.MaxIterations; i++ {
+    fy := loss(y)
+    g   = lr.Gradient(g, data, nil)
+    copy(theta0, theta1)
+    f1 := 0.0
+    for {
+      for k := 0; k < len(theta1); k++ {
+        theta1[k] = y[k] - t*g[k]
+        if k > 0 && groups.Nil() {
+          if theta1[k] >= 0.0 {
+            theta1[k] =  math.Max(math.Abs(theta1[k]) - t*estimator.L1Reg/n, 0.0)
+          } else {
+            theta1[k] = -math.Max(math.Abs(theta1[k]) - t*estimator.L1Reg/n, 0.0)
+          }
+          theta1[k] /= 1.0 + t*estimator.L2Reg/n
+        }
+      }
+      if !groups.Nil() {
+        groups.Shrink(theta1, t*estimator.L1Reg/n)
+        for k := 1; k < len(theta1); k++ {
+          theta1[k] /= 1.0 + t*estimator.L2Reg/n
+        }
+      }
+      // check sufficient decrease
+      f1  = loss(theta1)
+      s  := 0.0
+      d2 := 0.0
+      for k := 0; k < len(theta1); k++ {
+        d   := theta1[k] - y[k]
+        s  += g[k]*d
+        d2 += d*d
+      }
+      if f1 <= fy + s + d2/(2.0*t) || t < 1e-20 {
+        break
+      }
+      t *= 0.5
+    }
+    l1 := f1 + penalty(theta1)
+    // check convergence
+    if stop, delta := eval_stopping(estimator.Epsilon, theta0, theta1); stop {
+      break
+    } else {
+      // execute hook if available
+      if estimator.Hook != nil && estimator.Hook(DenseFloat64Vector(theta1), ConstFloat64(delta), ConstFloat64(estimator.L1Reg), i) {
+        break
+      }
+    }
+    // restart momentum if the objective increased
+    if l1 > l0 {
+      m = 1.0
+    }
+    m_ := (1.0 + math.Sqrt(1.0 + 4.0*m*m))/2.0
+    for k := 0; k < len(y); k++ {
+      y[k] = theta1[k] + (m - 1.0)/m_*(theta1[k] - theta0[k])
+    }
+    m  = m_
+    l0 = l1
+  }
+  return theta1
+}
@@ -38,7 +38,7 @@ func write_similarity_matrix(config Config, similarities [][]float64, filenameOu
   if filenameOut == "" {
     writer = os.Stdout
   } else {
-    f, err := os.Create(filenameOut)
+    f, err := create_file(filenameOut)
     if err != nil {
       log.Fatal(err)
     }
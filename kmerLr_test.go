@@ -18,11 +18,17 @@ package main
 
 /* -------------------------------------------------------------------------- */
 
-//import   "fmt"
+import   "fmt"
+import   "bytes"
+import   "encoding/json"
 import   "math"
 import   "os"
+import   "strings"
 import   "testing"
+import   "time"
 
+import . "github.com/pbenner/autodiff"
+import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
 import . "github.com/pbenner/gonetics"
 
 /* -------------------------------------------------------------------------- */
@@ -266,3 +272,942 @@ func TestKmers6(test *testing.T) {
   }
   os.Remove("kmerLr_test_co.json")
 }
+
+func TestEvaluate1(test *testing.T) {
+  predictions := []float64{
+    math.Log(0.9), math.Log(0.8), math.Log(0.7), math.Log(0.6), math.Log(0.3) }
+  labels := []bool{
+    true, false, true, false, false }
+
+  if v := eval_roc_auc(predictions, labels); math.Abs(v - 5.0/6.0) > 1e-10 {
+    test.Error("test failed")
+  }
+  if v := eval_pr_auc(predictions, labels); math.Abs(v - 5.0/6.0) > 1e-10 {
+    test.Error("test failed")
+  }
+  if a, p, r, f := eval_confusion(predictions, labels, 0.5); a != 0.6 || p != 0.5 || r != 1.0 || math.Abs(f - 2.0/3.0) > 1e-10 {
+    test.Error("test failed")
+  }
+  // ties must be counted as half
+  if v := eval_roc_auc([]float64{-1.0, -1.0}, []bool{true, false}); v != 0.5 {
+    test.Error("test failed")
+  }
+  // no sample is called positive
+  if a, p, r, f := eval_confusion(predictions, labels, 1.1); a != 0.6 || p != 0.0 || r != 0.0 || f != 0.0 {
+    test.Error("test failed")
+  }
+}
+
+func TestCvGroups1(test *testing.T) {
+  config := Config{}
+  config.CVScheme = "stratified"
+
+  labels := make([]bool, 40)
+  for i := 0; i < 8; i++ {
+    labels[i] = true
+  }
+  groups, _ := getCvGroups(config, labels, nil, 4, 0.0, 1)
+  n1 := make([]int, 4)
+  n  := make([]int, 4)
+  for i, k := range groups {
+    n[k]++
+    if labels[i] {
+      n1[k]++
+    }
+  }
+  for k := 0; k < 4; k++ {
+    if n1[k] != 2 || n[k] != 10 {
+      test.Error("test failed")
+    }
+  }
+  config.CVScheme = "grouped"
+
+  ids := []string{"a", "a", "b", "c", "c", "c", "d", "e", "e", "f"}
+  groups, _ = getCvGroups(config, make([]bool, len(ids)), ids, 3, 0.0, 1)
+  for i := 0; i < len(ids); i++ {
+    for j := 0; j < len(ids); j++ {
+      if ids[i] == ids[j] && groups[i] != groups[j] {
+        test.Error("test failed")
+      }
+    }
+  }
+}
+
+func TestCvGroups2(test *testing.T) {
+  config := Config{}
+  config.CVScheme      = "chromosome"
+  config.CVChromosomes = [][]string{[]string{"chr1", "chr2"}, []string{"chr3"}}
+
+  ids := []string{"chr1", "chr2", "chr3", "chr4", "chr1", "chr3"}
+  r   := []int{0, 0, 1, -1, 0, 1}
+  if n := getCvNumberOfFolds(config, ids); n != 2 {
+    test.Error("test failed")
+  }
+  groups, _ := getCvGroups(config, make([]bool, len(ids)), ids, 2, 0.0, 1)
+  for i := 0; i < len(ids); i++ {
+    if groups[i] != r[i] {
+      test.Error("test failed")
+    }
+  }
+  // validation samples must be selected chromosome-wise
+  _, validation := getCvGroups(config, make([]bool, len(ids)), ids, 2, 0.3, 1)
+  n := 0
+  for i := 0; i < len(ids); i++ {
+    n += validation[i]
+    for j := 0; j < len(ids); j++ {
+      if ids[i] == ids[j] && validation[i] != validation[j] {
+        test.Error("test failed")
+      }
+    }
+  }
+  if n == 0 {
+    test.Error("test failed")
+  }
+  // a single fold (ensemble estimation) uses all samples for training
+  groups, _ = getCvGroups(config, make([]bool, len(ids)), ids, 1, 0.0, 1)
+  for i := 0; i < len(ids); i++ {
+    if groups[i] != -1 {
+      test.Error("test failed")
+    }
+  }
+}
+
+func TestNestedCv1(test *testing.T) {
+  config := Config{}
+  config.LambdaAuto = []int{1, 2, 3}
+
+  cvrs := []CVResult{
+    CVResult{LossTest: []float64{0.70, 0.72}},
+    CVResult{LossTest: []float64{0.50, 0.60}},
+    CVResult{LossTest: []float64{0.46, 0.62}} }
+  config.NestedCVMetric = "loss"
+  if i := nestedCvSelect(config, cvrs); i != 2 {
+    test.Error("test failed")
+  }
+  config.NestedCVMetric = "1se"
+  if i := nestedCvSelect(config, cvrs); i != 1 {
+    test.Error("test failed")
+  }
+  // model sizes are not required to be sorted
+  config.LambdaAuto = []int{3, 2, 1}
+  if i := nestedCvSelect(config, []CVResult{cvrs[2], cvrs[1], cvrs[0]}); i != 1 {
+    test.Error("test failed")
+  }
+  // model sizes with undefined loss are ignored
+  config.LambdaAuto = []int{1, 2, 3}
+  cvrs[1] = CVResult{LossTest: []float64{math.NaN(), math.NaN()}}
+  if i := nestedCvSelect(config, cvrs); i != 2 {
+    test.Error("test failed")
+  }
+  // fall back to the loss if the AUC is undefined for all model sizes
+  config.NestedCVMetric = "auc"
+  for i, _ := range cvrs {
+    cvrs[i].Predictions = []float64{-0.5, -0.2}
+    cvrs[i].Labels      = []bool{true, true}
+  }
+  if i := nestedCvSelect(config, cvrs); i != 2 {
+    test.Error("test failed")
+  }
+  if n := nestedCvConsensus([]int{3, 1, 3, 1, 2}); n != 1 {
+    test.Error("test failed")
+  }
+}
+
+// Small logistic regression problem with n columns (including the intercept)
+// for comparing optimizers
+func test_optimizer_problem(n int, l1, l2 float64) ([]ConstVector, []bool, *vectorEstimator.LogisticRegression) {
+  x := [][]float64{
+    []float64{1.0, 0.5, 2.0, 0.1},
+    []float64{1.0, 1.5, 0.0, 1.0},
+    []float64{1.0, 2.0, 1.0, 0.3},
+    []float64{1.0, 0.0, 1.5, 0.0},
+    []float64{1.0, 1.0, 0.5, 0.8},
+    []float64{1.0, 0.2, 0.3, 0.1} }
+  c := []bool{false, true, true, false, true, false}
+  data := make([]ConstVector, len(x))
+  for i := 0; i < len(x); i++ {
+    data[i] = AsSparseConstFloat64Vector(NewDenseFloat64Vector(x[i][0:n]))
+  }
+  estimator, _ := vectorEstimator.NewLogisticRegression(n, true)
+  estimator.L1Reg         = l1*float64(len(data))
+  estimator.L2Reg         = l2*float64(len(data))
+  estimator.Epsilon       = 1e-10
+  estimator.MaxIterations = 1000000
+  return data, c, estimator
+}
+
+// Sample weights for the samples of test_optimizer_problem
+func test_optimizer_weights() []float64 {
+  return []float64{2.0, 1.0, 0.5, 1.0, 1.0, 0.5}
+}
+
+// Classes for the samples of test_optimizer_problem (multinomial regression)
+func test_optimizer_classes() []int {
+  return []int{0, 1, 2, 0, 1, 2}
+}
+
+// Responses for the samples of test_optimizer_problem (poisson and linear
+// regression)
+func test_optimizer_responses() []float64 {
+  return []float64{3.0, 0.0, 1.0, 2.0, 4.0, 1.0}
+}
+
+func TestOptimizer1(test *testing.T) {
+  config := Config{}
+  data, c, estimator := test_optimizer_problem(3, 0.1, 0.0)
+  r1 := estimate_coordinate(config, estimator, data, c, nil)
+  r2 := estimate_proximal  (config, estimator, data, c, nil, featureGroups{})
+  for j := 0; j < len(r1); j++ {
+    if math.Abs(r1[j] - r2[j]) > 1e-4 {
+      test.Error("test failed")
+    }
+  }
+}
+
+func TestElasticNet1(test *testing.T) {
+  config := Config{}
+  config.Alpha = 0.5
+  penalty := NewPenalty(config, 0.2)
+  data, c, estimator := test_optimizer_problem(3, penalty.L1(), penalty.L2())
+  r1 := estimate_saga    (config, estimator.Clone(), data, c, nil, featureGroups{})
+  r2 := estimate_proximal(config, estimator.Clone(), data, c, nil, featureGroups{})
+  for j := 0; j < len(r1); j++ {
+    if math.Abs(r1[j] - r2[j]) > 1e-4 {
+      test.Error("test failed")
+    }
+  }
+  if r := NewPenaltyL1(config, penalty.L1()); math.Abs(r.Lambda - penalty.Lambda) > 1e-12 {
+    test.Error("test failed")
+  }
+}
+
+func TestGroupLasso1(test *testing.T) {
+  config := Config{}
+  groups := newFeatureGroups([]int{0, 1, 0})
+  data, c, estimator := test_optimizer_problem(4, 0.05, 0.0)
+  r1 := estimate_saga    (config, estimator.Clone(), data, c, nil, groups)
+  r2 := estimate_proximal(config, estimator.Clone(), data, c, nil, groups)
+  for j := 0; j < len(r1); j++ {
+    if math.Abs(r1[j] - r2[j]) > 1e-4 {
+      test.Error("test failed")
+    }
+  }
+  // coefficients of the same group are either all zero or all non-zero
+  if (r1[1] == 0.0) != (r1[3] == 0.0) {
+    test.Error("test failed")
+  }
+}
+
+func TestPath1(test *testing.T) {
+  cache := gradientCache{}
+  cache.Set([]float64{1.0, 0.0, 2.0}, 0.0, []float64{0.1, 0.2, 0.3})
+  if g := cache.Get([]float64{1.0, 0.0, 2.0}, 0.0); len(g) != 3 || g[2] != 0.3 {
+    test.Error("test failed")
+  }
+  if g := cache.Get([]float64{1.0, 0.5, 2.0}, 0.0); g != nil {
+    test.Error("test failed")
+  }
+  if g := cache.Get([]float64{1.0, 0.0, 2.0}, 0.1); g != nil {
+    test.Error("test failed")
+  }
+}
+
+func TestCheckpoint1(test *testing.T) {
+  config := Config{}
+  config.LambdaAuto   = []int{2, 4}
+  config.EnsembleSize = 2
+  config.Checkpoint   = time.Hour
+
+  filename := "kmerLr_test.checkpoint"
+
+  c1 := NewCheckpoint(config, filename)
+  c1.Update(config, 1, 1, &CheckpointState{L1Reg: 0.5, Epoch: 3}, true)
+  // resume from checkpoint
+  config.Resume = true
+  c2 := NewCheckpoint(config, filename)
+  if s := c2.Get(1, 1); s == nil || s.Epoch != 3 || s.L1Reg != 0.5 {
+    test.Error("test failed")
+  }
+  if s := c2.Get(0, 1); s != nil {
+    test.Error("test failed")
+  }
+  c2.Finish(config, []float64{0.1, 0.2})
+  c3 := NewCheckpoint(config, filename)
+  if !c3.Done || len(c3.Lambda) != 2 || c3.Lambda[1] != 0.2 || c3.Get(1, 1) != nil {
+    test.Error("test failed")
+  }
+  config.LambdaAuto = []int{2}
+  if c3.matches(config) {
+    test.Error("test failed")
+  }
+  os.Remove(filename)
+}
+
+func TestCheckpoint2(test *testing.T) {
+  config := Config{}
+  config.Verbose = 0
+  config.MaxTime = time.Millisecond
+
+  release := checkpointHandleSignals(config)
+  time.Sleep(50*time.Millisecond)
+  if !checkpointStopRequested() {
+    test.Error("test failed")
+  }
+  // releasing the handler resets the stop request
+  release()
+  if checkpointStopRequested() {
+    test.Error("test failed")
+  }
+  // timers of released handlers must not fire
+  config.MaxTime = 20*time.Millisecond
+  checkpointHandleSignals(config)()
+  time.Sleep(50*time.Millisecond)
+  if checkpointStopRequested() {
+    test.Error("test failed")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func TestCache1(test *testing.T) {
+  config := Config{}
+
+  kmersCounter, err := NewKmerCounter(2, 4, false, false, true, nil, NucleotideAlphabet{}); if err != nil {
+    test.Error(err); return
+  }
+  filename := "kmerLr_test.kc"
+
+  // split counting into two chunks and merge the resulting caches
+  c1 := count_kmers(config, kmersCounter, false, true, true , "kmerLr_test_fg.fa")
+  c2 := count_kmers(config, kmersCounter, false, true, false, "kmerLr_test_bg.fa")
+  if err := c1.Append(c2); err != nil {
+    test.Error(err); return
+  }
+  SaveKmerCache(config, filename, c1)
+  defer os.Remove(filename)
+
+  if !is_kmer_cache(filename) || is_kmer_cache("kmerLr_test_fg.fa") {
+    test.Error("test failed")
+  }
+  data1 := compile_training_data(config, kmersCounter, nil, nil, true, false, "kmerLr_test_fg.fa", "kmerLr_test_bg.fa")
+  data2 := compile_training_data(config, kmersCounter, nil, nil, true, false, filename, filename)
+
+  if len(data1.Data) != len(data2.Data) || !data1.Kmers.Equals(data2.Kmers) {
+    test.Error("test failed"); return
+  }
+  for i := 0; i < len(data1.Data); i++ {
+    if data1.Labels[i] != data2.Labels[i] || data1.Seqnames[i] != data2.Seqnames[i] || data1.Seqindex[i] != data2.Seqindex[i] {
+      test.Error("test failed")
+    }
+    for j := 0; j < data1.Data[i].Dim(); j++ {
+      if data1.Data[i].Float64At(j) != data2.Data[i].Float64At(j) {
+        test.Error("test failed")
+      }
+    }
+  }
+  // caches must match the k-mer equivalence relation of the model
+  cache := ImportKmerCache(config, filename)
+  if err := cache.Matches(kmersCounter, true); err == nil {
+    test.Error("test failed")
+  }
+  if kmersCounter, err := NewKmerCounter(2, 5, false, false, true, nil, NucleotideAlphabet{}); err != nil {
+    test.Error(err)
+  } else {
+    if err := cache.Matches(kmersCounter, false); err == nil {
+      test.Error("test failed")
+    }
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func TestExport1(test *testing.T) {
+  m := ExportMatrix{}
+  m.Data    = []ConstVector{
+    UnsafeSparseConstFloat64Vector([]int{0, 2   }, []float64{1.0, 3.0     }, 4),
+    UnsafeSparseConstFloat64Vector([]int{0, 1, 3}, []float64{1.0, 1.0, 2.0}, 4) }
+  m.Labels  = []bool{true, false}
+  m.Columns = []string{"a", "b", "c"}
+  m.Integer = true
+
+  r := map[string]string{
+    "table" : "a,b,c\n0,3,0\n1,0,2\n",
+    "mtx"   : "%%MatrixMarket matrix coordinate integer general\n2 3 3\n1 2 3\n2 1 1\n2 3 2\n",
+    "libsvm": "1 2:3\n0 1:1 3:2\n" }
+  for format, result := range r {
+    var buffer bytes.Buffer
+    if err := m.Write(&buffer, format); err != nil {
+      test.Error(err)
+    } else if buffer.String() != result {
+      test.Errorf("test failed for format `%s'", format)
+    }
+  }
+  // samples of the first file are labeled as foreground
+  counter, err := NewKmerCounter(2, 3, false, false, true, nil, NucleotideAlphabet{})
+  if err != nil {
+    panic(err)
+  }
+  data := compile_data(Config{}, counter, nil, nil, true, false, []string{"kmerLr_test_fg.fa", "kmerLr_test_bg.fa"})
+  for i, label := range []string{"1 ", "0 "} {
+    var buffer bytes.Buffer
+    if err := (ExportMatrix{Data: data[i].Data, Labels: data[i].Labels, Integer: true}).Write(&buffer, "libsvm"); err != nil {
+      test.Error(err)
+    }
+    lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+    if len(lines) != 11 {
+      test.Error("test failed")
+    }
+    for _, line := range lines {
+      if !strings.HasPrefix(line, label) {
+        test.Error("test failed")
+      }
+    }
+  }
+  var buffer bytes.Buffer
+  if err := write_npy(&buffer, "<i8", "(2,)", []int64{1, 2}); err != nil {
+    test.Error(err)
+  }
+  if (buffer.Len()-16) % 64 != 0 || !bytes.HasPrefix(buffer.Bytes(), []byte("\x93NUMPY")) {
+    test.Error("test failed")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func TestFile1(test *testing.T) {
+  filename := "kmerLr_test.fa.gz"
+
+  headers1, sequences1, _, err := import_sequences_file("kmerLr_test_fg.fa")
+  if err != nil {
+    test.Error(err); return
+  }
+  // write gzip compressed copy of the fasta file
+  if f, err := create_file(filename); err != nil {
+    test.Error(err); return
+  } else {
+    for i := 0; i < len(headers1); i++ {
+      f.Write([]byte(">" + headers1[i] + "\n" + sequences1[i] + "\n"))
+    }
+    f.Close()
+  }
+  defer os.Remove(filename)
+
+  if f, err := os.Open(filename); err != nil {
+    test.Error(err)
+  } else {
+    if ok, _ := is_gzip_file(f); !ok {
+      test.Error("test failed")
+    }
+    f.Close()
+  }
+  headers2, sequences2, _, err := import_sequences_file(filename)
+  if err != nil {
+    test.Error(err); return
+  }
+  if len(headers1) != len(headers2) {
+    test.Error("test failed"); return
+  }
+  for i := 0; i < len(headers1); i++ {
+    if headers1[i] != headers2[i] || sequences1[i] != sequences2[i] {
+      test.Error("test failed")
+    }
+  }
+  if compress_filename(Config{Compress: true}, "a.table") != "a.table.gz" || compress_filename(Config{Compress: true}, "a.gz") != "a.gz" {
+    test.Error("test failed")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func TestFastq1(test *testing.T) {
+  config := Config{}
+
+  kmersCounter, err := NewKmerCounter(2, 2, false, false, false, nil, NucleotideAlphabet{}); if err != nil {
+    test.Error(err); return
+  }
+  headers, sequences, qualities, err := read_sequences(strings.NewReader("@r1 a\nacgtac\n+\nIIII#I\n@r2\nacgt\n+r2\nIIII\n"))
+  if err != nil {
+    test.Error(err); return
+  }
+  if len(headers) != 2 || headers[0] != "r1 a" || sequences[1] != "acgt" || qualities[0] != "IIII#I" {
+    test.Error("test failed"); return
+  }
+  // without quality threshold all dimers are counted
+  if c := scan_sequence(config, kmersCounter, false, []byte(sequences[0]), []byte(qualities[0])); c.N() != 4 || c.GetCount(kmersCounter.KmerCatalogue.GetKmerClass("ac")) != 2 {
+    test.Error("test failed")
+  }
+  // the fifth base has quality 2, which removes dimers `ta' and `ac' at
+  // the end of the sequence
+  config.MinQuality = 20
+  c := scan_sequence(config, kmersCounter, false, []byte(sequences[0]), []byte(qualities[0]))
+  if c.N() != 3 || c.GetCount(kmersCounter.KmerCatalogue.GetKmerClass("ac")) != 1 {
+    test.Error("test failed")
+  }
+  // aggregate reads
+  r := merge_kmer_counts(false, scan_sequences(config, kmersCounter, false, sequences, qualities)...)
+  if r.N() != 3 || r.GetCount(kmersCounter.KmerCatalogue.GetKmerClass("cg")) != 2 {
+    test.Error("test failed")
+  }
+  if _, _, _, err := read_sequences(strings.NewReader("@r1\nacgt\n+\nIII\n")); err == nil {
+    test.Error("test failed")
+  }
+}
+
+func TestFastq2(test *testing.T) {
+  config := Config{}
+  config.MinQuality = 20
+
+  kmersCounter, err := NewKmerCounter(2, 4, false, false, false, nil, NucleotideAlphabet{}); if err != nil {
+    test.Error(err); return
+  }
+  _, sequences, qualities, err := read_sequences(strings.NewReader("@r1\nacgta\n+\nIII#I\n"))
+  if err != nil {
+    test.Error(err); return
+  }
+  // the only high-quality segment `acg' is shorter than the maximal k-mer
+  // length, but k-mers `ac', `cg' and `acg' must still be counted
+  c := scan_sequence(config, kmersCounter, false, []byte(sequences[0]), []byte(qualities[0]))
+  for _, kmer := range []string{"ac", "cg", "acg"} {
+    if c.GetCount(kmersCounter.KmerCatalogue.GetKmerClass(kmer)) != 1 {
+      test.Error("test failed")
+    }
+  }
+  if c.GetCount(kmersCounter.KmerCatalogue.GetKmerClass("gt")) != 0 {
+    test.Error("test failed")
+  }
+}
+
+func TestWeights1(test *testing.T) {
+  config := Config{}
+  data, c, estimator := test_optimizer_problem(3, 0.05, 0.0)
+  w := test_optimizer_weights()
+  // a sample with weight two is equivalent to a duplicated sample
+  lr1 := logisticRegression{Theta: []float64{-0.5, 1.0, -0.3}, ClassWeights: [2]float64{1.0, 1.0}, Weights: []float64{2.0, 1.0, 1.0, 1.0, 1.0, 1.0}}
+  lr2 := logisticRegression{Theta: []float64{-0.5, 1.0, -0.3}, ClassWeights: [2]float64{1.0, 1.0}}
+  if math.Abs(6.0*lr1.Loss(data, c) - 7.0*lr2.Loss(append(data, data[0]), append(c, c[0]))) > 1e-10 {
+    test.Error("test failed")
+  }
+  // all optimizers must agree on weighted data
+  r1 := estimate_coordinate(config, estimator, data, c, w)
+  r2 := estimate_proximal  (config, estimator, data, c, w, featureGroups{})
+  r3 := estimate_saga      (config, estimator, data, c, w, featureGroups{})
+  for j := 0; j < len(r1); j++ {
+    if math.Abs(r1[j] - r2[j]) > 1e-4 || math.Abs(r1[j] - r3[j]) > 1e-4 {
+      test.Error("test failed")
+    }
+  }
+  if r, err := parse_class_weights("0.5,2"); err != nil || r[0] != 0.5 || r[1] != 2.0 {
+    test.Error("test failed")
+  }
+  if _, err := parse_class_weights("1,-1"); err == nil {
+    test.Error("test failed")
+  }
+}
+
+func TestMultinomial1(test *testing.T) {
+  data, _, _ := test_optimizer_problem(3, 0.0, 0.0)
+  c := test_optimizer_classes()
+  lr := multinomialRegression{Theta: [][]float64{
+    []float64{-0.5,  1.0, -0.3},
+    []float64{ 0.2, -0.4,  0.1},
+    []float64{ 0.0,  0.3,  0.7} }, Weights: test_optimizer_weights()}
+  // compare gradient with finite differences
+  g := lr.Gradient(nil, data, c)
+  for k := 0; k < len(lr.Theta); k++ {
+    for j := 0; j < len(lr.Theta[k]); j++ {
+      lr.Theta[k][j] += 1e-6
+      f1 := lr.LossSmooth(data, c)
+      lr.Theta[k][j] -= 2e-6
+      f2 := lr.LossSmooth(data, c)
+      lr.Theta[k][j] += 1e-6
+      if math.Abs(g[k][j] - (f1-f2)/2e-6) > 1e-6 {
+        test.Error("test failed")
+      }
+    }
+  }
+  // the estimator must not modify coefficients of the caller
+  config := Config{}
+  config.Epsilon = 1e-8
+  lr.Lambda      = 0.01
+  theta := [][]float64{}
+  for k := 0; k < len(lr.Theta); k++ {
+    theta = append(theta, append([]float64{}, lr.Theta[k]...))
+  }
+  estimate_multinomial(config, lr, data, c)
+  for k := 0; k < len(lr.Theta); k++ {
+    for j := 0; j < len(lr.Theta[k]); j++ {
+      if lr.Theta[k][j] != theta[k][j] {
+        test.Error("test failed")
+      }
+    }
+  }
+  // pruning features must set lambda to the largest gradient norm of
+  // pruned features
+  estimator := KmerLrMultinomialEstimator{Lambda: 0.1, selection: []int{0, 1}, Theta: [][]float64{
+    []float64{0.0,  1.0, 0.5},
+    []float64{0.0, -1.0, 0.2} }}
+  if selection, lambda := estimator.selectFeatures(config, []float64{0.3, 0.2, 0.7}, 1); len(selection) != 1 || selection[0] != 0 || lambda != 0.2 {
+    test.Error("test failed")
+  }
+}
+
+func TestMultinomial2(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  main_learn_multinomial(config, []string{"learn-multinomial", "--lambda-auto=4", "--classes=a,b,c", "1", "3", "kmerLr_test_fg.fa", "kmerLr_test_bg.fa", "kmerLr_test_co_fg.fa", "kmerLr_test_multinomial"})
+  defer os.Remove("kmerLr_test_multinomial.json")
+
+  if !is_multinomial_model("kmerLr_test_multinomial.json") {
+    test.Error("test failed"); return
+  }
+  classifier := ImportKmerLrMultinomial(config, "kmerLr_test_multinomial.json")
+  if len(classifier.Classes) != 3 || classifier.Classes[2] != "c" || classifier.Nonzero() != 4 {
+    test.Error("test failed"); return
+  }
+  data := compile_test_data(config, classifier.GetKmerCounter(), classifier.Kmers, classifier.Features, false, classifier.Binarize, "kmerLr_test.fa")
+  for _, p := range classifier.Predict(config, data.Data) {
+    if len(p) != 3 || math.Abs(p[0]+p[1]+p[2] - 1.0) > 1e-8 {
+      test.Error("test failed")
+    }
+  }
+}
+
+func TestRegression1(test *testing.T) {
+  config := Config{}
+  data, _, estimator := test_optimizer_problem(3, 0.0, 0.0)
+  y := test_optimizer_responses()
+  w := test_optimizer_weights()
+  // compare gradient with finite differences
+  for _, regression := range []string{"linear", "poisson"} {
+    lr := logisticRegression{Theta: []float64{-0.5, 1.0, -0.3}, Weights: w, Regression: regression, Responses: y}
+    g  := lr.Gradient(nil, data, nil)
+    for j := 0; j < len(lr.Theta); j++ {
+      lr.Theta[j] += 1e-6
+      f1 := lr.Loss(data, nil)
+      lr.Theta[j] -= 2e-6
+      f2 := lr.Loss(data, nil)
+      lr.Theta[j] += 1e-6
+      if math.Abs(g[j] - (f1-f2)/2e-6) > 1e-6 {
+        test.Error("test failed")
+      }
+    }
+  }
+  // recover parameters of a noise-free linear model
+  theta := []float64{0.5, 2.0, -1.0}
+  for i := 0; i < len(data); i++ {
+    y[i] = 0.0
+    for j := 0; j < len(theta); j++ {
+      y[i] += theta[j]*data[i].Float64At(j)
+    }
+  }
+  config.Regression = "linear"
+  estimator.Epsilon = 1e-12
+  r := estimate_proximal_regression(config, estimator, data, y, nil, featureGroups{})
+  for j := 0; j < len(r); j++ {
+    if math.Abs(r[j] - theta[j]) > 1e-4 {
+      test.Error("test failed")
+    }
+  }
+  if v := eval_r2(y, y); math.Abs(v - 1.0) > 1e-10 {
+    test.Error("test failed")
+  }
+}
+
+func TestRegression2(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  // use the number of `a' nucleotides as response
+  f, err := os.Create("kmerLr_test_responses.txt")
+  if err != nil {
+    panic(err)
+  }
+  _, sequences := import_fasta(config, "kmerLr_test_fg.fa")
+  for _, sequence := range sequences {
+    fmt.Fprintf(f, "%d\n", strings.Count(strings.ToLower(sequence), "a")/100)
+  }
+  f.Close()
+  defer os.Remove("kmerLr_test_responses.txt")
+
+  main_learn(config, []string{"learn", "--regression=poisson", "--response-file=kmerLr_test_responses.txt", "--lambda-auto=2", "--k-fold-cv=2", "1", "2", "kmerLr_test_fg.fa", "kmerLr_test_regression"})
+  defer os.Remove("kmerLr_test_regression_cv1.json")
+  defer os.Remove("kmerLr_test_regression_cv2.json")
+  defer os.Remove("kmerLr_test_regression.table")
+  defer os.Remove("kmerLr_test_regression_loss.table")
+  defer os.Remove("kmerLr_test_regression_folds.table")
+
+  classifier := ImportKmerLrEnsemble(config, "kmerLr_test_regression_cv1.json")
+  if classifier.Regression != "poisson" || classifier.GetComponent(0).Nonzero() != 2 {
+    test.Error("test failed"); return
+  }
+  if b, err := os.ReadFile("kmerLr_test_regression_folds.table"); err != nil || !strings.Contains(string(b), "correlation") {
+    test.Error("test failed")
+  }
+}
+
+func TestPredictOutput1(test *testing.T) {
+  config := Config{}
+  config.Threshold    = 0.5
+  config.OutputScale  = "prob"
+  config.OutputFormat = "json"
+
+  if v := scale_prediction("logit", math.Log(0.2)); math.Abs(v - math.Log(0.25)) > 1e-10 {
+    test.Error("test failed")
+  }
+  predictions := scale_predictions(config, "", []float64{math.Log(0.2), math.Log(0.7), math.Inf(-1)})
+
+  savePredictions(config, "kmerLr_test_predictions.json", []string{"a", "b", "a"}, []int{0, 1, 2}, predictions)
+  defer os.Remove("kmerLr_test_predictions.json")
+
+  b, err := os.ReadFile("kmerLr_test_predictions.json")
+  if err != nil {
+    panic(err)
+  }
+  r := []struct{
+    Name       string
+    Index      int
+    Prediction float64
+    Class      int
+  }{}
+  if err := json.Unmarshal(b, &r); err != nil {
+    test.Error(err); return
+  }
+  if len(r) != 3 || r[1].Name != "b" || r[2].Index != 2 || math.Abs(r[1].Prediction - 0.7) > 1e-10 || r[0].Class != 0 || r[1].Class != 1 {
+    test.Error("test failed")
+  }
+}
+
+func TestPredictWindow1(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  main_learn(config, []string{"learn", "--lambda-auto=8", "--revcomp", "1", "4", "kmerLr_test_fg.fa", "kmerLr_test_bg.fa", "kmerLr_test_window"})
+  defer os.Remove("kmerLr_test_window.json")
+
+  classifier := ImportKmerLrEnsemble(config, "kmerLr_test_window.json")
+  classifier.Transform.Scale = make([]float64, len(classifier.Features)+1)
+  for j := 0; j < len(classifier.Transform.Scale); j++ {
+    classifier.Transform.Scale[j] = 1.0 + float64(j)/10.0
+  }
+  _, sequences := import_fasta(config, "kmerLr_test_fg.fa")
+  // compare incremental window predictions with counting k-mers in each window
+  for _, binarize := range []bool{false, true} {
+    classifier.Binarize = binarize
+    predictor := newWindowPredictor(classifier)
+    if !predictor.incremental {
+      test.Error("test failed"); return
+    }
+    for _, sequence := range sequences[0:5] {
+      r1 := predictor.predictIncremental(config, []byte(sequence), 20, 3)
+      r2 := predictor.predictFull       (config, []byte(sequence), 20, 3)
+      if len(r1) != len(r2) || len(r1) != window_count(len(sequence), 20, 3) {
+        test.Error("test failed"); return
+      }
+      for j := 0; j < len(r1); j++ {
+        if math.Abs(r1[j] - r2[j]) > 1e-8 {
+          test.Error("test failed")
+        }
+      }
+    }
+  }
+}
+
+func TestAttribute1(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  main_learn(config, []string{"learn", "--lambda-auto=4", "--binarize", "--revcomp", "--co-occurrence", "2", "4", "kmerLr_test_co_fg.fa", "kmerLr_test_co_bg.fa", "kmerLr_test_attribute"})
+  defer os.Remove("kmerLr_test_attribute.json")
+
+  classifier := ImportKmerLrEnsemble(config, "kmerLr_test_attribute.json")
+  theta      := attribution_coefficients(classifier)
+  data       := compile_test_data(config, classifier.GetKmerCounter(), classifier.Kmers, classifier.Features, false, classifier.Binarize, "kmerLr_test_co_fg.fa")
+  _, sequences := import_fasta(config, "kmerLr_test_co_fg.fa")
+
+  scores, biases := attribute_sequences(config, classifier, sequences)
+  lr := logisticRegression{Theta: theta}
+  for i := 0; i < len(sequences); i++ {
+    // without transform the bias is given by the intercept
+    if math.Abs(biases[i] - theta[0]) > 1e-10 {
+      test.Error("test failed")
+    }
+    r := biases[i]
+    for _, s := range scores[i] {
+      r += s
+    }
+    if math.Abs(r - lr.LinearPdf(data.Data[i].(SparseConstFloat64Vector))) > 1e-8 {
+      test.Error("test failed")
+    }
+  }
+}
+
+func TestMutagenesis1(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  main_learn(config, []string{"learn", "--lambda-auto=4", "--revcomp", "--co-occurrence", "2", "4", "kmerLr_test_co_fg.fa", "kmerLr_test_co_bg.fa", "kmerLr_test_mutagenesis"})
+  defer os.Remove("kmerLr_test_mutagenesis.json")
+
+  classifier := ImportKmerLrEnsemble(config, "kmerLr_test_mutagenesis.json")
+  classifier.Transform.Offset = make([]float64, len(classifier.Features)+1)
+  classifier.Transform.Scale  = make([]float64, len(classifier.Features)+1)
+  for j := 0; j < len(classifier.Transform.Scale); j++ {
+    classifier.Transform.Offset[j] = float64(j)/10.0
+    classifier.Transform.Scale [j] = 1.0 + float64(j)/10.0
+  }
+  counter := classifier.GetKmerCounter()
+  predict := func(sequence []byte) float64 {
+    counts := scan_sequence(config, counter, classifier.Binarize, sequence, nil)
+    counts.SetKmers(classifier.Kmers)
+    data   := []ConstVector{convert_counts(config, counts, classifier.Features, false)}
+    classifier.Transform.Apply(config, data)
+    return scale_prediction("logit", classifier.Predict(config, data)[0])
+  }
+  scorer := newVariantScorer(classifier)
+  bases  := mutagenesis_bases(classifier)
+  _, sequences := import_fasta(config, "kmerLr_test_co_fg.fa")
+  for _, binarize := range []bool{false, true} {
+    classifier.Binarize = binarize
+    sequence := []byte(strings.ToLower(sequences[0]))
+    result   := scorer.Mutagenesis(config, sequence, bases)
+    if math.Abs(result.Reference - predict(sequence)) > 1e-8 {
+      test.Error("test failed")
+    }
+    for p := 0; p < len(sequence); p += 3 {
+      for i, b := range bases {
+        mut := append([]byte{}, sequence...)
+        mut[p] = b
+        if math.Abs(result.Deltas[p][i] - (predict(mut) - result.Reference)) > 1e-8 {
+          test.Error("test failed")
+        }
+      }
+    }
+  }
+}
+
+func TestPredictVariants1(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  main_learn(config, []string{"learn", "--lambda-auto=4", "--co-occurrence", "2", "4", "kmerLr_test_co_fg.fa", "kmerLr_test_co_bg.fa", "kmerLr_test_variants"})
+  defer os.Remove("kmerLr_test_variants.json")
+
+  f, err := os.Create("kmerLr_test_variants.vcf")
+  if err != nil {
+    panic(err)
+  }
+  fmt.Fprintf(f, "##fileformat=VCFv4.2\n")
+  fmt.Fprintf(f, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+  fmt.Fprintf(f, "chr1\t10\trs1\tA\tC,GT,<DEL>\t.\t.\t.\n")
+  fmt.Fprintf(f, "chr1\t20\t.\tACG\tA\t.\t.\t.\n")
+  f.Close()
+  defer os.Remove("kmerLr_test_variants.vcf")
+
+  variants := import_vcf(config, "kmerLr_test_variants.vcf")
+  if len(variants) != 3 || variants[1].Alt != "gt" || variants[1].Allele != 2 || variants[2].Pos != 19 {
+    test.Error("test failed"); return
+  }
+  classifier := ImportKmerLrEnsemble(config, "kmerLr_test_variants.json")
+  counter    := classifier.GetKmerCounter()
+  predict    := func(sequence []byte) float64 {
+    counts := scan_sequence(config, counter, classifier.Binarize, sequence, nil)
+    counts.SetKmers(classifier.Kmers)
+    data   := []ConstVector{convert_counts(config, counts, classifier.Features, false)}
+    return scale_prediction("logit", classifier.Predict(config, data)[0])
+  }
+  scorer := newVariantScorer(classifier)
+  _, sequences := import_fasta(config, "kmerLr_test_co_fg.fa")
+  sequence := []byte(strings.ToLower(sequences[1]))
+  // substitution, insertion and deletion
+  for _, v := range []struct{from, to int; alt string}{{10, 11, "c"}, {30, 31, "gtt"}, {19, 22, "a"}, {20, 20, "ac"}, {20, 24, ""}} {
+    mut := append(append(append([]byte{}, sequence[:v.from]...), v.alt...), sequence[v.to:]...)
+    for _, strand := range []byte{'+', '-'} {
+      r := scorer.ScoreVariant(config, sequence, v.from, v.to, []byte(v.alt), strand)
+      s := sequence
+      t := mut
+      if strand == '-' {
+        s = revcomp_sequence(classifier.Alphabet, sequence)
+        t = revcomp_sequence(classifier.Alphabet, mut)
+      }
+      if math.Abs(r.Ref - predict(s)) > 1e-8 || math.Abs(r.Alt - predict(t)) > 1e-8 {
+        test.Error("test failed")
+      }
+    }
+  }
+}
+
+func TestMotifs1(test *testing.T) {
+  rel, err := NewKmerEquivalenceRelation(4, 5, false, false, true, []int{0}, NucleotideAlphabet{})
+  if err != nil {
+    panic(err)
+  }
+  kmers := []weightedKmer{
+    weightedKmer{rel.EquivalenceClass("acgta"), 2.0},
+    weightedKmer{rel.EquivalenceClass("cgtag"), 1.0},
+    // reverse complement of ttacg, which extends the motif to the left
+    weightedKmer{rel.EquivalenceClass("cgtaa"), 0.5},
+    weightedKmer{rel.EquivalenceClass("gggg" ), 0.1} }
+  motifs := cluster_kmers(NucleotideAlphabet{}, []byte("acgt"), kmers, 1, 3, 0)
+  if len(motifs) != 2 {
+    test.Error("test failed"); return
+  }
+  if motifs[0].Consensus() != "ttacgtag" || len(motifs[0].Kmers) != 3 || motifs[0].Weight != 3.5 {
+    test.Error("test failed")
+  }
+  if p := motifs[0].Probabilities(); p[0][3] != 1.0 || p[7][2] != 1.0 || motifs[0].Kmers[0].Offset != 2 {
+    test.Error("test failed")
+  }
+}
+
+func TestMatchMotifs1(test *testing.T) {
+  lines := strings.Split(`MEME version 4
+
+ALPHABET= ACGT
+
+MOTIF M1 ACGTAC
+letter-probability matrix: alength= 4 w= 6
+0.97 0.01 0.01 0.01
+0.01 0.97 0.01 0.01
+0.01 0.01 0.97 0.01
+0.01 0.01 0.01 0.97
+0.97 0.01 0.01 0.01
+0.01 0.97 0.01 0.01
+
+MOTIF M2 GGGG
+letter-probability matrix: alength= 4 w= 4
+0.1 0.1 0.7 0.1
+0.1 0.1 0.7 0.1
+0.1 0.1 0.7 0.1
+0.1 0.1 0.7 0.1
+`, "\n")
+  database, err := import_motif_database_meme(lines)
+  if err != nil {
+    panic(err)
+  }
+  database.Background = []float64{0.25, 0.25, 0.25, 0.25}
+  single := []motifStrand{{'+', false, false}}
+  both   := []motifStrand{{'+', false, false}, {'-', true, true}}
+  // cgta matches the forward strand of M1
+  for _, strands := range [][]motifStrand{single, both} {
+    matches := newMotifMatcher(database, strands).Match(match_kmer_query(NucleotideAlphabet{}, "cgta"))
+    if len(matches) != 2 || matches[0].Target != 0 || matches[0].Offset != 1 || matches[0].Strand != '+' {
+      test.Error("test failed")
+    }
+  }
+  // tacg is only contained in the reverse complement of M1
+  m1 := newMotifMatcher(database, single).Match(match_kmer_query(NucleotideAlphabet{}, "tacg"))
+  m2 := newMotifMatcher(database,   both).Match(match_kmer_query(NucleotideAlphabet{}, "tacg"))
+  if m2[0].Target != 0 || m2[0].Offset != 1 || m2[0].Strand != '-' {
+    test.Error("test failed")
+  }
+  if m1[0].Target == 0 && m1[0].PValue <= m2[0].PValue {
+    test.Error("test failed")
+  }
+}
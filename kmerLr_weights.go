@@ -0,0 +1,149 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "bufio"
+import   "fmt"
+import   "log"
+import   "math"
+import   "strconv"
+import   "strings"
+
+import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
+
+/* -------------------------------------------------------------------------- */
+
+// Class weights are either given explicitly (--class-weights), computed
+// such that the data set is balanced (--balance), or equal to one
+func class_weights(config Config, c []bool) [2]float64 {
+  if len(config.ClassWeights) == 2 {
+    return [2]float64{config.ClassWeights[0], config.ClassWeights[1]}
+  }
+  if config.Balance {
+    return compute_class_weights(c)
+  }
+  return [2]float64{1.0, 1.0}
+}
+
+func set_class_weights(config Config, estimator *vectorEstimator.LogisticRegression, c []bool) {
+  estimator.SetLabels(c)
+  if len(config.ClassWeights) == 2 {
+    estimator.ClassWeights[0] = config.ClassWeights[0]
+    estimator.ClassWeights[1] = config.ClassWeights[1]
+  }
+}
+
+func parse_class_weights(s string) ([]float64, error) {
+  fields := strings.Split(s, ",")
+  if len(fields) != 2 {
+    return nil, fmt.Errorf("invalid class weights `%s'", s)
+  }
+  r := make([]float64, 2)
+  for i := 0; i < 2; i++ {
+    if v, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 64); err != nil || v <= 0.0 || math.IsInf(v, 0) {
+      return nil, fmt.Errorf("invalid class weights `%s'", s)
+    } else {
+      r[i] = v
+    }
+  }
+  return r, nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+func parse_sample_weight(s string) (float64, error) {
+  if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+    return 0.0, err
+  } else {
+    if v < 0.0 || math.IsNaN(v) || math.IsInf(v, 0) {
+      return 0.0, fmt.Errorf("invalid sample weight `%s'", s)
+    }
+    return v, nil
+  }
+}
+
+// Sample weights are scaled to have mean one, so that the strength of the
+// penalty is comparable to unweighted data
+func normalize_sample_weights(weights []float64) []float64 {
+  sum := 0.0
+  for _, w := range weights {
+    sum += w
+  }
+  if sum == 0.0 {
+    log.Fatal("sample weights sum to zero")
+  }
+  for i := 0; i < len(weights); i++ {
+    weights[i] *= float64(len(weights))/sum
+  }
+  return weights
+}
+
+// Import sample weights, either from a file with one weight per line
+// (foreground samples first) or from a field of the fasta headers. Returns
+// nil if no weights are given.
+func import_sample_weights(config Config, headers []string, n int) []float64 {
+  if config.WeightsFile != "" {
+    f, err := open_file(config.WeightsFile)
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer f.Close()
+
+    PrintStderr(config, 1, "Reading sample weights from `%s'... ", config.WeightsFile)
+    weights := []float64{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+      if line := strings.TrimSpace(scanner.Text()); line != "" {
+        if w, err := parse_sample_weight(line); err != nil {
+          PrintStderr(config, 1, "failed\n")
+          log.Fatal(err)
+        } else {
+          weights = append(weights, w)
+        }
+      }
+    }
+    if err := scanner.Err(); err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal(err)
+    }
+    if len(weights) != n {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("number of sample weights (%d) does not match number of samples (%d)", len(weights), n)
+    }
+    PrintStderr(config, 1, "done\n")
+    return normalize_sample_weights(weights)
+  }
+  if config.WeightsField > 0 {
+    if len(headers) != n {
+      log.Fatal("sample weights can only be extracted from fasta headers")
+    }
+    weights := make([]float64, n)
+    for i, header := range headers {
+      if s, err := fasta_header_field(header, config.WeightsField); err != nil {
+        log.Fatal(err)
+      } else {
+        if weights[i], err = parse_sample_weight(s); err != nil {
+          log.Fatalf("invalid sample weight in fasta header `%s': %v", header, err)
+        }
+      }
+    }
+    return normalize_sample_weights(weights)
+  }
+  return nil
+}
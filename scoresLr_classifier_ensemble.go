@@ -34,7 +34,12 @@ type ScoresLrEnsemble struct {
   ScoresLrFeatures
   Theta     [][]float64
   Transform     Transform
+  // penalty used for estimating each component
+  Penalty     []Penalty
   Summary       string
+  // regression model (linear or poisson), empty for
+  // logistic regression
+  Regression    string
 }
 
 /* -------------------------------------------------------------------------- */
@@ -47,7 +52,9 @@ func NewScoresLrEnsemble(summary string) *ScoresLrEnsemble {
 
 func (obj *ScoresLrEnsemble) Clone() *ScoresLrEnsemble {
   r := ScoresLrEnsemble{}
-  r.Theta = make([][]float64, len(obj.Theta))
+  r.Theta   = make([][]float64, len(obj.Theta))
+  r.Penalty = make([]Penalty, len(obj.Penalty))
+  copy(r.Penalty, obj.Penalty)
   for i := 0; i < len(obj.Theta); i++ {
     r.Theta[i] = make([]float64, len(obj.Theta[i]))
     for j := 0; j < len(obj.Theta); j++ {
@@ -56,6 +63,7 @@ func (obj *ScoresLrEnsemble) Clone() *ScoresLrEnsemble {
   }
   r.ScoresLrFeatures = obj.ScoresLrFeatures.Clone()
   r.Transform        = obj.Transform       .Clone()
+  r.Regression       = obj.Regression
   return &r
 }
 
@@ -104,16 +112,13 @@ func (obj *ScoresLrEnsemble) Summarize(config Config, x []float64) float64 {
   return r
 }
 
-func (obj *ScoresLrEnsemble) Loss(config Config, data []ConstVector, c []bool) float64 {
+func (obj *ScoresLrEnsemble) Loss(config Config, data []ConstVector, c []bool, weights []float64) float64 {
   lr := logisticRegression{}
-  lr.Lambda = config.Lambda
+  lr.Lambda  = NewPenalty(config, config.Lambda).L1()
+  lr.Lambda2 = NewPenalty(config, config.Lambda).L2()
   lr.Pool   = config.PoolLR
-  if config.Balance {
-    lr.ClassWeights = compute_class_weights(c)
-  } else {
-    lr.ClassWeights[0] = 1.0
-    lr.ClassWeights[1] = 1.0
-  }
+  lr.ClassWeights = class_weights(config, c)
+  lr.Weights      = weights
   r := make([]float64, len(obj.Theta))
   for j, _ := range obj.Theta {
     lr.Theta = obj.Theta[j]
@@ -122,6 +127,32 @@ func (obj *ScoresLrEnsemble) Loss(config Config, data []ConstVector, c []bool) f
   return obj.Summarize(config, r)
 }
 
+// Loss of a regression model given the responses y
+func (obj *ScoresLrEnsemble) LossRegression(config Config, data []ConstVector, y []float64, weights []float64) float64 {
+  lr := logisticRegression{}
+  lr.Lambda     = NewPenalty(config, config.Lambda).L1()
+  lr.Lambda2    = NewPenalty(config, config.Lambda).L2()
+  lr.Pool       = config.PoolLR
+  lr.Weights    = weights
+  lr.Regression = obj.Regression
+  lr.Responses  = y
+  r := make([]float64, len(obj.Theta))
+  for j, _ := range obj.Theta {
+    lr.Theta = obj.Theta[j]
+    r[j] = lr.Loss(data, nil)
+  }
+  return obj.Summarize(config, r)
+}
+
+// Loss on a data set, which uses either labels or responses depending
+// on the type of the model
+func (obj *ScoresLrEnsemble) LossDataSet(config Config, data []ConstVector, dataset ScoresDataSet) float64 {
+  if obj.Regression != "" {
+    return obj.LossRegression(config, data, dataset.Responses, dataset.Weights)
+  }
+  return obj.Loss(config, data, dataset.Labels, dataset.Weights)
+}
+
 func (obj *ScoresLrEnsemble) Predict(config Config, data []ConstVector) []float64 {
   lr := logisticRegression{}
   lr.Lambda = config.Lambda
@@ -131,7 +162,11 @@ func (obj *ScoresLrEnsemble) Predict(config Config, data []ConstVector) []float6
   for i, _ := range data {
     for j, _ := range obj.Theta {
       lr.Theta = obj.Theta[j]
-      t[j] = lr.LogPdf(data[i].(SparseConstFloat64Vector))
+      if obj.Regression != "" {
+        t[j] = regression_mean(obj.Regression, lr.LinearPdf(data[i].(SparseConstFloat64Vector)))
+      } else {
+        t[j] = lr.LogPdf(data[i].(SparseConstFloat64Vector))
+      }
     }
     r[i] = obj.Summarize(config, t)
   }
@@ -151,8 +186,12 @@ func (obj *ScoresLrEnsemble) GetComponent(i int) *ScoresLr {
   } else {
     r.Theta = obj.Theta[i]
   }
+  if i < len(obj.Penalty) {
+    r.Penalty = obj.Penalty[i]
+  }
   r.ScoresLrFeatures = obj.ScoresLrFeatures
   r.Transform        = obj.Transform
+  r.Regression       = obj.Regression
   return &r
 }
 
@@ -165,6 +204,7 @@ func (obj *ScoresLrEnsemble) Mean() *ScoresLrEnsemble {
   t := &ScoresLr{}
   t.ScoresLrFeatures = obj.ScoresLrFeatures
   t.Transform        = obj.Transform
+  t.Regression       = obj.Regression
   t.Theta = make([]float64, len(obj.Theta[0]))
   for j := 0; j < len(obj.Theta[0]); j++ {
     for i := 0; i < len(obj.Theta); i++ {
@@ -184,6 +224,7 @@ func (obj *ScoresLrEnsemble) Min() *ScoresLrEnsemble {
   t := &ScoresLr{}
   t.ScoresLrFeatures = obj.ScoresLrFeatures
   t.Transform        = obj.Transform
+  t.Regression       = obj.Regression
   t.Theta = make([]float64, len(obj.Theta[0]))
   for j := 0; j < len(obj.Theta[0]); j++ {
     t.Theta[j] += obj.Theta[0][j]
@@ -205,6 +246,7 @@ func (obj *ScoresLrEnsemble) Max() *ScoresLrEnsemble {
   t := &ScoresLr{}
   t.ScoresLrFeatures = obj.ScoresLrFeatures
   t.Transform        = obj.Transform
+  t.Regression       = obj.Regression
   t.Theta = make([]float64, len(obj.Theta[0]))
   for j := 0; j < len(obj.Theta[0]); j++ {
     t.Theta[j] += obj.Theta[0][j]
@@ -224,10 +266,14 @@ func (obj *ScoresLrEnsemble) Max() *ScoresLrEnsemble {
 func (obj *ScoresLrEnsemble) AddScoresLr(classifier *ScoresLr) error {
   if len(obj.Theta) == 0 {
     obj.Cooccurrence = classifier.Cooccurrence
+    obj.Regression   = classifier.Regression
   }
   if obj.Cooccurrence != classifier.Cooccurrence {
     return fmt.Errorf("co-occurrence is not consistent across classifiers")
   }
+  if obj.Regression != classifier.Regression {
+    return fmt.Errorf("regression model is not consistent across classifiers")
+  }
   if !obj.Transform.Nil() && !obj.Transform.EqualsScores(classifier.Transform, obj.Features, classifier.Features, obj.Index, classifier.Index) {
     return fmt.Errorf("data transform is not consistent across classifiers")
   }
@@ -335,6 +381,7 @@ func (obj *ScoresLrEnsemble) AddScoresLr(classifier *ScoresLr) error {
   obj.ScoresLrFeatures.Index    = index
   obj.ScoresLrFeatures.Names    = names
   obj.Theta                     = coefficients
+  obj.Penalty                   = append(obj.Penalty, classifier.Penalty)
   obj.Transform                 = transform
   return nil
 }
@@ -357,7 +404,7 @@ func (obj *ScoresLrEnsemble) AddScoresLrEnsemble(classifier *ScoresLrEnsemble) e
 /* -------------------------------------------------------------------------- */
 
 func (obj *ScoresLrEnsemble) SelectData(config Config, data ScoresDataSet) []ConstVector {
-  r := ScoresLr{obj.ScoresLrFeatures, nil, obj.Transform}
+  r := ScoresLr{ScoresLrFeatures: obj.ScoresLrFeatures, Transform: obj.Transform}
   return r.SelectData(config, data)
 }
 
@@ -373,6 +420,37 @@ func (obj *ScoresLrEnsemble) ImportConfig(config ConfigDistribution, t ScalarTyp
   }
   lr := vectorDistribution.LogisticRegression{}
   n  := len(config.Distributions)
+  // the regression model is stored last (optional, logistic
+  // regression if missing)
+  obj.Regression = ""
+  if n > 2 && config.Distributions[n-1].Name == "regression" {
+    if regression, ok := config.Distributions[n-1].GetNamedParameterAsString("Model"); !ok {
+      return fmt.Errorf("invalid config file")
+    } else {
+      if err := check_regression(regression); err != nil {
+        return err
+      }
+      obj.Regression = regression
+    }
+    n--
+  }
+  m := n
+  // penalties are stored after the transform (optional for
+  // backward compatibility)
+  obj.Penalty = nil
+  for n > 2 && config.Distributions[n-1].Name == "penalty" {
+    n--
+  }
+  for j := n; j < m; j++ {
+    penalty := Penalty{}
+    if err := penalty.ImportConfig(config.Distributions[j]); err != nil {
+      return err
+    }
+    obj.Penalty = append(obj.Penalty, penalty)
+  }
+  if obj.Penalty != nil && len(obj.Penalty) != n-1 {
+    return fmt.Errorf("invalid config file")
+  }
   obj.Theta = make([][]float64, n-1)
   for j := 0; j < n-1; j++ {
     if err := lr.ImportConfig(config.Distributions[j], t); err != nil {
@@ -409,6 +487,14 @@ func (obj *ScoresLrEnsemble) ExportConfig() ConfigDistribution {
     }
   }
   distributions = append(distributions, obj.Transform.ExportConfig())
+  if len(obj.Penalty) == len(obj.Theta) {
+    for j := 0; j < len(obj.Penalty); j++ {
+      distributions = append(distributions, obj.Penalty[j].ExportConfig())
+    }
+  }
+  if obj.Regression != "" {
+    distributions = append(distributions, NewConfigDistribution("regression", struct{ Model string }{obj.Regression}))
+  }
   config := obj.ScoresLrFeatures.ExportConfig()
   if obj.Summary == "" {
     config.Name = fmt.Sprintf("scoresLr")
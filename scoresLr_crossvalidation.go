@@ -20,65 +20,63 @@ package main
 
 //import   "fmt"
 
-import . "github.com/pbenner/autodiff"
 import   "github.com/pbenner/threadpool"
 
 /* -------------------------------------------------------------------------- */
 
 func scoresFilterCvGroup(data ScoresDataSet, groups, validation []int, i int) (ScoresDataSet, ScoresDataSet, ScoresDataSet) {
-  r_train        := []ConstVector{}
-  r_train_labels := []bool{}
-  r_val          := []ConstVector{}
-  r_val_labels   := []bool{}
-  r_test         := []ConstVector{}
-  r_test_labels  := []bool{}
-  for j := 0; j < len(data.Data); j++ {
-    if groups[j] == i {
-      r_test        = append(r_test        , data.Data  [j])
-      r_test_labels = append(r_test_labels , data.Labels[j])
-    } else {
-      if len(validation) > 0 && validation[j] == 1 {
-        r_val        = append(r_val       , data.Data  [j])
-        r_val_labels = append(r_val_labels, data.Labels[j])
-      } else {
-        r_train        = append(r_train       , data.Data  [j])
-        r_train_labels = append(r_train_labels, data.Labels[j])
-      }
-    }
-  }
-  data_train := ScoresDataSet{r_train, r_train_labels, data.Index, data.Names}
-  data_val   := ScoresDataSet{r_val  , r_val_labels  , data.Index, data.Names}
-  data_test  := ScoresDataSet{r_test , r_test_labels , data.Index, data.Names}
-  return data_train, data_val, data_test
+  i_train, i_val, i_test := filterCvGroupIndex(groups, validation, i)
+  return data.Subset(i_train), data.Subset(i_val), data.Subset(i_test)
 }
 
 func scoresCrossvalidation(config Config, data ScoresDataSet,
-  learnAndTestClassifiers func(i int, data_train, data_val, data_test ScoresDataSet) ([][]float64, []float64, []float64)) []CVResult {
-  groups, validation := getCvGroups(len(data.Data), config.KFoldCV, config.ValidationSize, config.Seed)
+  learnAndTestClassifiers func(i int, data_train, data_val, data_test ScoresDataSet) ([][]float64, []float64, []float64, []int, []float64)) []CVResult {
+  config.KFoldCV = getCvNumberOfFolds(config, data.Groups)
+
+  groups, validation := getCvGroups(config, data.cvLabels(), data.Groups, config.KFoldCV, config.ValidationSize, config.Seed)
 
   r_predictions := make([][][]float64, config.KFoldCV)
   r_labels      := make(  [][]bool,    config.KFoldCV)
+  r_responses   := make(  [][]float64, config.KFoldCV)
+  r_groups      := make(  [][]string,  config.KFoldCV)
+  r_seqnames    := make(  [][]string,  config.KFoldCV)
+  r_seqindex    := make(  [][]int,     config.KFoldCV)
   r_loss_train  := make(  [][]float64, config.KFoldCV)
   r_loss_test   := make(  [][]float64, config.KFoldCV)
+  r_nonzero     := make(  [][]int,     config.KFoldCV)
+  r_lambda      := make(  [][]float64, config.KFoldCV)
 
   config.PoolCV.RangeJob(0, config.KFoldCV, func(i int, pool threadpool.ThreadPool, erf func() error) error {
     config := config; config.PoolCV = pool
     i_     := i
 
+    if checkpointStopRequested() {
+      return nil
+    }
+
     if config.KFoldCV <= 1 {
       i_ = -1
     }
 
     data_train, data_val, data_test := scoresFilterCvGroup(data, groups, validation, i)
 
-    predictions, loss_train, loss_test := learnAndTestClassifiers(i_, data_train, data_val, data_test)
+    predictions, loss_train, loss_test, nonzero, lambda := learnAndTestClassifiers(i_, data_train, data_val, data_test)
 
     r_labels     [i] = data_test.Labels
+    r_responses  [i] = data_test.Responses
+    r_groups     [i] = data_test.Groups
+    r_seqnames   [i] = data_test.Seqnames
+    r_seqindex   [i] = data_test.Seqindex
     r_predictions[i] = predictions
     r_loss_train [i] = loss_train
     r_loss_test  [i] = loss_test
+    r_nonzero    [i] = nonzero
+    r_lambda     [i] = lambda
     return nil
   })
+  if checkpointStopRequested() {
+    return nil
+  }
   // join results
   result := []CVResult{}
   for i := 0; config.KFoldCV > 1 && i < config.KFoldCV; i++ {
@@ -91,8 +89,18 @@ func scoresCrossvalidation(config Config, data ScoresDataSet,
     for j := 0; j < len(r_predictions[i]); j++ {
       result[j].Predictions = append(result[j].Predictions, r_predictions[i][j]...)
       result[j].Labels      = append(result[j].Labels     , r_labels     [i]   ...)
-      result[j].LossTrain   = append(result[j].LossTrain  , r_loss_train [i]   ...)
-      result[j].LossTest    = append(result[j].LossTest   , r_loss_test  [i]   ...)
+      result[j].Responses   = append(result[j].Responses  , r_responses  [i]   ...)
+      result[j].Regression  = config.Regression
+      result[j].Groups      = append(result[j].Groups     , r_groups     [i]   ...)
+      result[j].Seqnames    = append(result[j].Seqnames   , r_seqnames   [i]   ...)
+      result[j].Seqindex    = append(result[j].Seqindex   , r_seqindex   [i]   ...)
+      result[j].LossTrain   = append(result[j].LossTrain  , r_loss_train [i][j])
+      result[j].LossTest    = append(result[j].LossTest   , r_loss_test  [i][j])
+      result[j].Nonzero     = append(result[j].Nonzero    , r_nonzero    [i][j])
+      result[j].Lambda      = append(result[j].Lambda     , r_lambda     [i][j])
+      for k := 0; k < len(r_predictions[i][j]); k++ {
+        result[j].Folds = append(result[j].Folds, i+1)
+      }
     }
   }
   return result
@@ -22,7 +22,7 @@ import   "fmt"
 import   "bufio"
 import   "io"
 import   "log"
-import   "os"
+import   "math"
 import   "strconv"
 import   "strings"
 
@@ -36,6 +36,68 @@ type ScoresDataSet struct {
   Labels []bool
   Index  []int
   Names  []string
+  Groups []string
+  // sample names and index of each sample within its input file
+  Seqnames []string
+  Seqindex []int
+  // optional sample weights
+  Weights  []float64
+  // response of each sample for regression models
+  Responses []float64
+}
+
+func (obj ScoresDataSet) Subset(index []int) ScoresDataSet {
+  r := ScoresDataSet{Index: obj.Index, Names: obj.Names}
+  r.Data   = make([]ConstVector, len(index))
+  for i, j := range index {
+    r.Data[i] = obj.Data[j]
+  }
+  if len(obj.Labels) > 0 {
+    r.Labels = make([]bool, len(index))
+    for i, j := range index {
+      r.Labels[i] = obj.Labels[j]
+    }
+  }
+  if len(obj.Groups) > 0 {
+    r.Groups = make([]string, len(index))
+    for i, j := range index {
+      r.Groups[i] = obj.Groups[j]
+    }
+  }
+  if len(obj.Seqnames) > 0 {
+    r.Seqnames = make([]string, len(index))
+    for i, j := range index {
+      r.Seqnames[i] = obj.Seqnames[j]
+    }
+  }
+  if len(obj.Seqindex) > 0 {
+    r.Seqindex = make([]int, len(index))
+    for i, j := range index {
+      r.Seqindex[i] = obj.Seqindex[j]
+    }
+  }
+  if len(obj.Weights) > 0 {
+    r.Weights = make([]float64, len(index))
+    for i, j := range index {
+      r.Weights[i] = obj.Weights[j]
+    }
+  }
+  if len(obj.Responses) > 0 {
+    r.Responses = make([]float64, len(index))
+    for i, j := range index {
+      r.Responses[i] = obj.Responses[j]
+    }
+  }
+  return r
+}
+
+// Labels used for assigning samples to cross-validation folds, regression
+// data sets have no labels
+func (obj ScoresDataSet) cvLabels() []bool {
+  if obj.Labels == nil {
+    return make([]bool, len(obj.Data))
+  }
+  return obj.Labels
 }
 
 /* -------------------------------------------------------------------------- */
@@ -99,6 +161,57 @@ func read_scores_table(config Config, r io.Reader) ([]string, [][]float64, error
   return names, entry, nil
 }
 
+// Remove a column from a scores table and return its values
+func split_column(names []string, data [][]float64, column string) ([]string, [][]float64, []float64, error) {
+  j := -1
+  for i, name := range names {
+    if strings.TrimSpace(name) == column {
+      j = i
+    }
+  }
+  if j == -1 {
+    return names, data, nil, fmt.Errorf("scores table has no column `%s' (option --header required)", column)
+  }
+  values := make([]float64, len(data))
+  for i := 0; i < len(data); i++ {
+    if len(data[i]) != len(names) {
+      return names, data, nil, fmt.Errorf("number of features does not match header")
+    }
+    values[i] = data[i][j]
+    data  [i] = append(data[i][0:j:j], data[i][j+1:]...)
+  }
+  names = append(names[0:j:j], names[j+1:]...)
+  return names, data, values, nil
+}
+
+// Remove the column of sample weights from a scores table
+func split_weights_column(config Config, names []string, data [][]float64) ([]string, [][]float64, []float64, error) {
+  names, data, weights, err := split_column(names, data, config.WeightsColumn)
+  if err != nil {
+    return names, data, nil, err
+  }
+  for _, w := range weights {
+    if w < 0.0 || math.IsNaN(w) || math.IsInf(w, 0) {
+      return names, data, nil, fmt.Errorf("invalid sample weight `%v'", w)
+    }
+  }
+  return names, data, weights, nil
+}
+
+// Remove the column of responses from a scores table
+func split_response_column(config Config, names []string, data [][]float64) ([]string, [][]float64, []float64, error) {
+  names, data, responses, err := split_column(names, data, config.ResponseColumn)
+  if err != nil {
+    return names, data, nil, err
+  }
+  for _, y := range responses {
+    if err := check_response(config.Regression, y); err != nil {
+      return names, data, nil, err
+    }
+  }
+  return names, data, responses, nil
+}
+
 /* -------------------------------------------------------------------------- */
 
 func convert_scores(config Config, scores []float64, index []int, features FeatureIndices, generate_features bool) ConstVector {
@@ -146,20 +259,52 @@ func convert_scores(config Config, scores []float64, index []int, features Featu
 
 /* -------------------------------------------------------------------------- */
 
-func import_scores(config Config, filename string, index []int, names []string, features FeatureIndices, generate_features bool, dim int) ([]ConstVector, []int, []string, int) {
-  f, err := os.Open(filename)
+// Import scores from a GRanges table, a comma separated table, a libsvm file or
+// a NumPy npy/npz file. Labels are returned if the file contains labels
+// (libsvm and npz only), sample weights and responses if a weights or response
+// column is given (comma separated tables only)
+func import_scores(config Config, filename string, index []int, names []string, features FeatureIndices, generate_features bool, dim int) ([]ConstVector, []bool, []float64, []float64, []int, []string, int) {
+  if format := scores_file_format(filename); format != "table" {
+    PrintStderr(config, 1, "Reading scores from `%s' (%s)... ", filename, format)
+    if config.WeightsColumn != "" {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("sample weights cannot be read from `%s' (%s)", filename, format)
+    }
+    if config.ResponseColumn != "" {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("responses cannot be read from `%s' (%s)", filename, format)
+    }
+    scores, labels, index, dim, err := import_scores_sparse(config, filename, format, index, features, generate_features, dim)
+    if err != nil {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatalf("reading scores from `%s' failed: %v", filename, err)
+    }
+    PrintStderr(config, 1, "done\n")
+    return scores, labels, nil, nil, index, names, dim
+  }
+  // compressed files cannot be rewound, hence the file is read into memory
+  f, err := read_file(filename)
   if err != nil {
     log.Fatal(err)
   }
-  defer f.Close()
 
-  scores  := []ConstVector{}
-  granges := GRanges{}
+  scores    := []ConstVector{}
+  weights   := []float64(nil)
+  responses := []float64(nil)
+  granges   := GRanges{}
   PrintStderr(config, 1, "Reading scores from `%s'... ", filename)
   if err := granges.ReadTable(f, []string{"counts"}, []string{"[][]float64"}); err == nil {
     // scores are in GRanges format
+    if config.WeightsColumn != "" {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal("sample weights cannot be read from GRanges tables")
+    }
+    if config.ResponseColumn != "" {
+      PrintStderr(config, 1, "failed\n")
+      log.Fatal("responses cannot be read from GRanges tables")
+    }
     if granges.Length() == 0 {
-      return scores, index, names, dim
+      return scores, nil, nil, nil, index, names, dim
     }
     data := granges.GetMeta("counts").([][]float64)
     for _, c := range data {
@@ -186,6 +331,18 @@ func import_scores(config Config, filename string, index []int, names []string,
       PrintStderr(config, 1, "failed\n")
       log.Fatal(err)
     } else {
+      if config.WeightsColumn != "" {
+        if names_data, data, weights, err = split_weights_column(config, names_data, data); err != nil {
+          PrintStderr(config, 1, "failed\n")
+          log.Fatal(err)
+        }
+      }
+      if config.ResponseColumn != "" {
+        if names_data, data, responses, err = split_response_column(config, names_data, data); err != nil {
+          PrintStderr(config, 1, "failed\n")
+          log.Fatal(err)
+        }
+      }
       for _, c := range data {
         if dim == -1 {
           dim = len(c)
@@ -222,7 +379,7 @@ func import_scores(config Config, filename string, index []int, names []string,
     }
   }
   PrintStderr(config, 1, "done\n")
-  return scores, index, names, dim
+  return scores, nil, weights, responses, index, names, dim
 }
 
 /* -------------------------------------------------------------------------- */
@@ -245,21 +402,120 @@ func reduce_samples_scores(config Config, fg, bg []ConstVector) ([]ConstVector,
 
 /* -------------------------------------------------------------------------- */
 
+// Compile training data from foreground and background files or, if no
+// background file is given, from a single file that contains labels. In
+// the latter case, cross-validation groups are given in the order of
+// samples in the file.
 func compile_training_data_scores(config Config, index []int, names []string, features FeatureIndices, generate_features bool, filename_fg, filename_bg string) ScoresDataSet {
-  scores_fg, index, names, dim := import_scores(config, filename_fg, index, names, features, generate_features, -1)
-  scores_bg,     _,     _,   _ := import_scores(config, filename_bg, index, names, features, generate_features, dim)
-  scores_fg, scores_bg   = reduce_samples_scores(config, scores_fg, scores_bg)
+  var scores_fg, scores_bg     []ConstVector
+  var seqindex_fg, seqindex_bg []int
+  var groups_fg, groups_bg     []string
+  var weights_fg, weights_bg   []float64
+  if filename_bg == "" {
+    scores, labels, weights, _, index_, names_, _ := import_scores(config, filename_fg, index, names, features, generate_features, -1)
+    if labels == nil {
+      log.Fatalf("scores file `%s' contains no labels", filename_fg)
+    }
+    index, names = index_, names_
+    groups := import_cv_groups(config, nil, len(scores))
+    if weights == nil {
+      weights = import_sample_weights(config, nil, len(scores))
+    }
+    for i := 0; i < len(scores); i++ {
+      if labels[i] {
+        scores_fg   = append(scores_fg  , scores[i])
+        seqindex_fg = append(seqindex_fg, i)
+        if groups != nil {
+          groups_fg = append(groups_fg, groups[i])
+        }
+        if weights != nil {
+          weights_fg = append(weights_fg, weights[i])
+        }
+      } else {
+        scores_bg   = append(scores_bg  , scores[i])
+        seqindex_bg = append(seqindex_bg, i)
+        if groups != nil {
+          groups_bg = append(groups_bg, groups[i])
+        }
+        if weights != nil {
+          weights_bg = append(weights_bg, weights[i])
+        }
+      }
+    }
+  } else {
+    var dim int
+    scores_fg, _, weights_fg, _, index, names, dim = import_scores(config, filename_fg, index, names, features, generate_features, -1)
+    scores_bg, _, weights_bg, _,     _,     _,   _ = import_scores(config, filename_bg, index, names, features, generate_features, dim)
+    if groups := import_cv_groups(config, nil, len(scores_fg)+len(scores_bg)); groups != nil {
+      groups_fg = groups[0:len(scores_fg)]
+      groups_bg = groups[len(scores_fg):]
+    }
+    if weights := import_sample_weights(config, nil, len(scores_fg)+len(scores_bg)); weights != nil {
+      weights_fg = weights[0:len(scores_fg)]
+      weights_bg = weights[len(scores_fg):]
+    }
+    seqindex_fg = make([]int, len(scores_fg))
+    seqindex_bg = make([]int, len(scores_bg))
+    for i := 0; i < len(scores_fg); i++ {
+      seqindex_fg[i] = i
+    }
+    for i := 0; i < len(scores_bg); i++ {
+      seqindex_bg[i] = i
+    }
+  }
+  scores_fg, scores_bg = reduce_samples_scores(config, scores_fg, scores_bg)
+  groups := []string(nil)
+  if groups_fg != nil || groups_bg != nil {
+    groups = append(append([]string{}, groups_fg[0:len(scores_fg)]...), groups_bg[0:len(scores_bg)]...)
+  }
+  weights := []float64(nil)
+  if weights_fg != nil || weights_bg != nil {
+    weights = normalize_sample_weights(append(append([]float64{}, weights_fg[0:len(scores_fg)]...), weights_bg[0:len(scores_bg)]...))
+  }
+  seqindex := append(append([]int{}, seqindex_fg[0:len(scores_fg)]...), seqindex_bg[0:len(scores_bg)]...)
   // define labels (assign foreground regions a label of 1)
   labels := make([]bool, len(scores_fg)+len(scores_bg))
   for i := 0; i < len(scores_fg); i++ {
     labels[i] = true
   }
-  return ScoresDataSet{append(scores_fg, scores_bg...), labels, index, names}
+  return ScoresDataSet{Data: append(scores_fg, scores_bg...), Labels: labels, Index: index, Names: names, Groups: groups, Seqindex: seqindex, Weights: weights}
+}
+
+// Compile training data for regression models from a single file, where
+// responses are given by a column of the scores table or a separate file
+func compile_training_data_scores_regression(config Config, index []int, names []string, features FeatureIndices, generate_features bool, filename string) ScoresDataSet {
+  scores, _, weights, responses, index, names, _ := import_scores(config, filename, index, names, features, generate_features, -1)
+  groups := import_cv_groups(config, nil, len(scores))
+  if weights == nil {
+    weights = import_sample_weights(config, nil, len(scores))
+  }
+  if responses == nil {
+    responses = import_responses(config, nil, len(scores))
+  }
+  if config.MaxSamples != 0 && len(scores) > config.MaxSamples {
+    PrintStderr(config, 1, "Reduced training data from %d to %d samples\n", len(scores), config.MaxSamples)
+    scores    = scores   [0:config.MaxSamples]
+    responses = responses[0:config.MaxSamples]
+    if groups != nil {
+      groups  = groups [0:config.MaxSamples]
+    }
+    if weights != nil {
+      weights = weights[0:config.MaxSamples]
+    }
+  }
+  if weights != nil {
+    weights = normalize_sample_weights(weights)
+  }
+  seqindex := make([]int, len(scores))
+  for i := 0; i < len(scores); i++ {
+    seqindex[i] = i
+  }
+  return ScoresDataSet{Data: scores, Index: index, Names: names, Groups: groups, Seqindex: seqindex, Weights: weights, Responses: responses}
 }
 
 func compile_test_data_scores(config Config, index []int, names []string, features FeatureIndices, generate_features bool, filename string) ScoresDataSet {
-  scores, index, names, _ := import_scores(config, filename, index, names, features, generate_features, -1)
-  return ScoresDataSet{scores, nil, index, names}
+  scores, _, _, _, index, names, _ := import_scores(config, filename, index, names, features, generate_features, -1)
+  return ScoresDataSet{Data: scores, Index: index, Names: names}
 }
 
 /* -------------------------------------------------------------------------- */
@@ -268,7 +524,7 @@ func compile_data_scores(config Config, index []int, names []string, features Fe
   dim := -1
   r := make([][]ConstVector, len(filenames))
   for i, filename := range filenames {
-    r[i], index, names, dim = import_scores(config, filename, index, names, features, generate_features, dim)
+    r[i], _, _, _, index, names, dim = import_scores(config, filename, index, names, features, generate_features, dim)
   }
   return r, names
 }
@@ -0,0 +1,565 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "archive/zip"
+import   "bufio"
+import   "encoding/binary"
+import   "fmt"
+import   "io"
+import   "io/ioutil"
+import   "math"
+import   "sort"
+import   "strconv"
+import   "strings"
+
+import . "github.com/pbenner/autodiff"
+
+/* -------------------------------------------------------------------------- */
+
+// Sparse rows of a data matrix with zero-based column indices
+type scoresRows struct {
+  Index  [][]int
+  Value  [][]float64
+  // optional labels
+  Labels   []bool
+  // number of columns, which is only a lower bound for libsvm files
+  Dim        int
+  Exact      bool
+}
+
+func (obj *scoresRows) append(index []int, value []float64) {
+  obj.Index = append(obj.Index, index)
+  obj.Value = append(obj.Value, value)
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Detect format of a scores file, i.e. npy, npz, libsvm or table
+func scores_file_format(filename string) string {
+  f, err := open_file(filename)
+  if err != nil {
+    return "table"
+  }
+  defer f.Close()
+  reader := bufio.NewReader(f)
+  if b, err := reader.Peek(6); err == nil {
+    if string(b) == "\x93NUMPY" {
+      return "npy"
+    }
+    if string(b[0:4]) == "PK\x03\x04" {
+      return "npz"
+    }
+  }
+  // libsvm files contain index:value pairs separated by white space
+  for {
+    l, err := bufioReadLine(reader)
+    if err != nil {
+      return "table"
+    }
+    if l = strings.TrimSpace(l); len(l) == 0 || l[0] == '#' {
+      continue
+    }
+    if strings.Contains(l, ":") && !strings.Contains(l, ",") {
+      return "libsvm"
+    }
+    return "table"
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Read libsvm/svmlight file with one-based feature indices. Labels are
+// optional, where positive values are interpreted as foreground samples.
+func read_scores_libsvm(r io.Reader) (scoresRows, error) {
+  reader := bufio.NewReader(r)
+  rows   := scoresRows{}
+  for i_ := 1;; i_++ {
+    l, err := bufioReadLine(reader)
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return rows, err
+    }
+    // remove comments
+    if j := strings.Index(l, "#"); j != -1 {
+      l = l[0:j]
+    }
+    fields := strings.Fields(l)
+    if len(fields) == 0 {
+      continue
+    }
+    if !strings.Contains(fields[0], ":") {
+      if v, err := strconv.ParseFloat(fields[0], 64); err != nil {
+        return rows, fmt.Errorf("parsing label failed at line `%d': %v", i_, err)
+      } else {
+        if len(rows.Labels) != len(rows.Index) {
+          return rows, fmt.Errorf("missing labels before line `%d'", i_)
+        }
+        rows.Labels = append(rows.Labels, v > 0.0)
+      }
+      fields = fields[1:]
+    } else {
+      if len(rows.Labels) != 0 {
+        return rows, fmt.Errorf("missing label at line `%d'", i_)
+      }
+    }
+    index := make([]int    , 0, len(fields))
+    value := make([]float64, 0, len(fields))
+    for _, field := range fields {
+      if strings.HasPrefix(field, "qid:") {
+        continue
+      }
+      j := strings.Index(field, ":")
+      if j == -1 {
+        return rows, fmt.Errorf("invalid feature `%s' at line `%d'", field, i_)
+      }
+      k, err := strconv.ParseInt(field[0:j], 10, 64)
+      if err != nil || k < 1 {
+        return rows, fmt.Errorf("invalid feature index `%s' at line `%d'", field[0:j], i_)
+      }
+      v, err := strconv.ParseFloat(field[j+1:], 64)
+      if err != nil {
+        return rows, fmt.Errorf("parsing scores failed at line `%d': %v", i_, err)
+      }
+      index = append(index, int(k-1))
+      value = append(value, v)
+      if int(k) > rows.Dim {
+        rows.Dim = int(k)
+      }
+    }
+    rows.append(index, value)
+  }
+  return rows, nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+type npyArray struct {
+  Descr         string
+  FortranOrder  bool
+  Shape       []int
+  Data        []byte
+}
+
+func read_npy(r io.Reader) (npyArray, error) {
+  a := npyArray{}
+  b := make([]byte, 8)
+  if _, err := io.ReadFull(r, b); err != nil {
+    return a, err
+  }
+  if string(b[0:6]) != "\x93NUMPY" {
+    return a, fmt.Errorf("invalid npy file")
+  }
+  n := 0
+  if b[6] == 1 {
+    var m uint16
+    if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+      return a, err
+    }
+    n = int(m)
+  } else {
+    var m uint32
+    if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+      return a, err
+    }
+    n = int(m)
+  }
+  header := make([]byte, n)
+  if _, err := io.ReadFull(r, header); err != nil {
+    return a, err
+  }
+  if err := a.parseHeader(string(header)); err != nil {
+    return a, err
+  }
+  if data, err := ioutil.ReadAll(r); err != nil {
+    return a, err
+  } else {
+    a.Data = data
+  }
+  if len(a.Data) < a.Len()*a.itemSize() {
+    return a, fmt.Errorf("npy array is truncated")
+  }
+  return a, nil
+}
+
+func (obj *npyArray) parseHeader(header string) error {
+  value := func(key string) (string, error) {
+    i := strings.Index(header, fmt.Sprintf("'%s':", key))
+    if i == -1 {
+      return "", fmt.Errorf("npy header has no entry `%s'", key)
+    }
+    return strings.TrimSpace(header[i+len(key)+3:]), nil
+  }
+  if s, err := value("descr"); err != nil {
+    return err
+  } else {
+    if len(s) < 2 || s[0] != '\'' || strings.Index(s[1:], "'") == -1 {
+      return fmt.Errorf("invalid npy header")
+    }
+    obj.Descr = s[1:1+strings.Index(s[1:], "'")]
+  }
+  if s, err := value("fortran_order"); err != nil {
+    return err
+  } else {
+    obj.FortranOrder = strings.HasPrefix(s, "True")
+  }
+  if s, err := value("shape"); err != nil {
+    return err
+  } else {
+    if len(s) < 2 || s[0] != '(' || strings.Index(s, ")") == -1 {
+      return fmt.Errorf("invalid npy header")
+    }
+    obj.Shape = []int{}
+    for _, field := range strings.Split(s[1:strings.Index(s, ")")], ",") {
+      if field = strings.TrimSpace(field); field == "" {
+        continue
+      }
+      if k, err := strconv.ParseInt(field, 10, 64); err != nil {
+        return fmt.Errorf("invalid npy header")
+      } else {
+        obj.Shape = append(obj.Shape, int(k))
+      }
+    }
+  }
+  if obj.itemSize() == 0 {
+    return fmt.Errorf("unsupported npy data type `%s'", obj.Descr)
+  }
+  return nil
+}
+
+func (obj npyArray) itemSize() int {
+  if len(obj.Descr) < 3 || obj.Descr[0] == '>' {
+    return 0
+  }
+  switch obj.Descr[1:] {
+  case "f8", "i8", "u8":
+    return 8
+  case "f4", "i4", "u4":
+    return 4
+  case "i2", "u2":
+    return 2
+  case "i1", "u1", "b1":
+    return 1
+  }
+  if obj.Descr[1] == 'S' || obj.Descr[1] == 'U' {
+    if n, err := strconv.ParseInt(obj.Descr[2:], 10, 64); err == nil {
+      if obj.Descr[1] == 'U' {
+        return 4*int(n)
+      }
+      return int(n)
+    }
+  }
+  return 0
+}
+
+func (obj npyArray) Len() int {
+  n := 1
+  for _, k := range obj.Shape {
+    n *= k
+  }
+  return n
+}
+
+// Returns the k-th element (in memory order) as float64
+func (obj npyArray) Float64At(k int) float64 {
+  b := obj.Data[k*obj.itemSize():]
+  switch obj.Descr[1:] {
+  case "f8": return math.Float64frombits(binary.LittleEndian.Uint64(b))
+  case "f4": return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+  case "i8": return float64(int64(binary.LittleEndian.Uint64(b)))
+  case "i4": return float64(int32(binary.LittleEndian.Uint32(b)))
+  case "i2": return float64(int16(binary.LittleEndian.Uint16(b)))
+  case "i1": return float64(int8(b[0]))
+  case "u8": return float64(binary.LittleEndian.Uint64(b))
+  case "u4": return float64(binary.LittleEndian.Uint32(b))
+  case "u2": return float64(binary.LittleEndian.Uint16(b))
+  case "u1", "b1": return float64(b[0])
+  default:
+    panic("internal error")
+  }
+}
+
+// Returns the array as string (for string scalars)
+func (obj npyArray) String() string {
+  if obj.Descr[1] == 'U' {
+    // UTF-32 encoded, which is ASCII for all relevant values
+    r := []byte{}
+    for i := 0; i < len(obj.Data); i += 4 {
+      r = append(r, obj.Data[i])
+    }
+    return strings.TrimRight(string(r), "\x00")
+  }
+  return strings.TrimRight(string(obj.Data), "\x00")
+}
+
+func (obj npyArray) labels() ([]bool, error) {
+  if len(obj.Shape) != 1 {
+    return nil, fmt.Errorf("labels must be a one-dimensional array")
+  }
+  r := make([]bool, obj.Len())
+  for i := 0; i < len(r); i++ {
+    r[i] = obj.Float64At(i) > 0.0
+  }
+  return r, nil
+}
+
+// Convert a dense two-dimensional array to sparse rows
+func (obj npyArray) rows() (scoresRows, error) {
+  rows := scoresRows{Exact: true}
+  if len(obj.Shape) != 2 {
+    return rows, fmt.Errorf("data must be a two-dimensional array")
+  }
+  n, m := obj.Shape[0], obj.Shape[1]
+  rows.Dim = m
+  for i := 0; i < n; i++ {
+    index := []int{}
+    value := []float64{}
+    for j := 0; j < m; j++ {
+      k := i*m+j
+      if obj.FortranOrder {
+        k = j*n+i
+      }
+      if v := obj.Float64At(k); v != 0.0 {
+        index = append(index, j)
+        value = append(value, v)
+      }
+    }
+    rows.append(index, value)
+  }
+  return rows, nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+func read_scores_npy(r io.Reader) (scoresRows, error) {
+  if a, err := read_npy(r); err != nil {
+    return scoresRows{}, err
+  } else {
+    return a.rows()
+  }
+}
+
+// Read npz file that either contains a CSR matrix in the layout of
+// scipy.sparse.save_npz or a single dense array. Labels are optionally
+// given by an array named `labels'.
+func read_scores_npz(filename string) (scoresRows, error) {
+  z, err := zip.OpenReader(filename)
+  if err != nil {
+    return scoresRows{}, err
+  }
+  defer z.Close()
+  arrays := make(map[string]npyArray)
+  for _, file := range z.File {
+    f, err := file.Open()
+    if err != nil {
+      return scoresRows{}, err
+    }
+    a, err := read_npy(f); f.Close()
+    if err != nil {
+      return scoresRows{}, fmt.Errorf("reading array `%s' failed: %v", file.Name, err)
+    }
+    arrays[strings.TrimSuffix(file.Name, ".npy")] = a
+  }
+  rows := scoresRows{}
+  if format, ok := arrays["format"]; ok {
+    if format.String() != "csr" {
+      return rows, fmt.Errorf("unsupported sparse matrix format `%s'", format.String())
+    }
+    if rows, err = npz_csr_rows(arrays); err != nil {
+      return rows, err
+    }
+  } else {
+    names := []string{}
+    for name, _ := range arrays {
+      if name != "labels" {
+        names = append(names, name)
+      }
+    }
+    if len(names) != 1 {
+      return rows, fmt.Errorf("npz file must contain a single data array")
+    }
+    if rows, err = arrays[names[0]].rows(); err != nil {
+      return rows, err
+    }
+  }
+  if labels, ok := arrays["labels"]; ok {
+    if rows.Labels, err = labels.labels(); err != nil {
+      return rows, err
+    }
+    if len(rows.Labels) != len(rows.Index) {
+      return rows, fmt.Errorf("number of labels does not match number of samples")
+    }
+  }
+  return rows, nil
+}
+
+func npz_csr_rows(arrays map[string]npyArray) (scoresRows, error) {
+  rows := scoresRows{Exact: true}
+  for _, name := range []string{"shape", "data", "indices", "indptr"} {
+    if _, ok := arrays[name]; !ok {
+      return rows, fmt.Errorf("CSR matrix has no array `%s'", name)
+    }
+  }
+  shape   := arrays["shape"]
+  data    := arrays["data"]
+  indices := arrays["indices"]
+  indptr  := arrays["indptr"]
+  if shape.Len() != 2 || indptr.Len() != int(shape.Float64At(0))+1 || indices.Len() != data.Len() {
+    return rows, fmt.Errorf("invalid CSR matrix")
+  }
+  rows.Dim = int(shape.Float64At(1))
+  for i := 0; i < indptr.Len()-1; i++ {
+    k1 := int(indptr.Float64At(i))
+    k2 := int(indptr.Float64At(i+1))
+    if k1 < 0 || k1 > k2 || k2 > data.Len() {
+      return rows, fmt.Errorf("invalid CSR matrix")
+    }
+    index := make([]int    , k2-k1)
+    value := make([]float64, k2-k1)
+    for k := k1; k < k2; k++ {
+      index[k-k1] = int(indices.Float64At(k))
+      value[k-k1] = data   .Float64At(k)
+      if index[k-k1] < 0 || index[k-k1] >= rows.Dim {
+        return rows, fmt.Errorf("invalid CSR matrix")
+      }
+    }
+    rows.append(index, value)
+  }
+  return rows, nil
+}
+
+/* -------------------------------------------------------------------------- */
+
+type sortIntFloat64 struct {
+  a []int
+  b []float64
+}
+
+func (obj sortIntFloat64) Len() int {
+  return len(obj.a)
+}
+
+func (obj sortIntFloat64) Less(i, j int) bool {
+  return obj.a[i] < obj.a[j]
+}
+
+func (obj sortIntFloat64) Swap(i, j int) {
+  obj.a[i], obj.a[j] = obj.a[j], obj.a[i]
+  obj.b[i], obj.b[j] = obj.b[j], obj.b[i]
+}
+
+// Same as convert_scores, but for sparse rows with zero-based column indices
+func convert_sparse_scores(config Config, index_row []int, value_row []float64, dim int, index []int, features FeatureIndices, generate_features bool) ConstVector {
+  n := 0
+  i := []int    {0  }
+  v := []float64{1.0}
+  if len(features) == 0 && generate_features {
+    n = dim+1
+    if !sort.IsSorted(sortIntFloat64{index_row, value_row}) {
+      sort.Sort(sortIntFloat64{index_row, value_row})
+    }
+    for k, j := range index_row {
+      if value_row[k] != 0.0 {
+        i = append(i, j+1)
+        v = append(v, value_row[k])
+      }
+    }
+  } else {
+    n = len(features)+1
+    scores := make(map[int]float64, len(index_row))
+    for k, j := range index_row {
+      scores[j] = value_row[k]
+    }
+    for j, feature := range features {
+      i1 := feature[0]
+      i2 := feature[1]
+      if i1 == i2 {
+        c := scores[index[i1]]
+        if c != 0.0 {
+          i = append(i, j+1)
+          v = append(v, float64(c))
+        }
+      } else {
+        c1 := scores[index[i1]]
+        c2 := scores[index[i2]]
+        if c1 != 0.0 && c2 != 0.0 {
+          i = append(i, j+1)
+          v = append(v, float64(c1*c2))
+        }
+      }
+    }
+  }
+  // resize slice and restrict capacity
+  i = append([]int    {}, i[0:len(i)]...)
+  v = append([]float64{}, v[0:len(v)]...)
+  return UnsafeSparseConstFloat64Vector(i, v, n)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func read_scores_rows(filename, format string) (scoresRows, error) {
+  switch format {
+  case "npz":
+    return read_scores_npz(filename)
+  }
+  f, err := open_file(filename)
+  if err != nil {
+    return scoresRows{}, err
+  }
+  defer f.Close()
+  switch format {
+  case "npy":
+    return read_scores_npy(bufio.NewReader(f))
+  case "libsvm":
+    return read_scores_libsvm(f)
+  default:
+    return scoresRows{}, fmt.Errorf("invalid format `%s'", format)
+  }
+}
+
+// Import scores from a libsvm, npy or npz file
+func import_scores_sparse(config Config, filename, format string, index []int, features FeatureIndices, generate_features bool, dim int) ([]ConstVector, []bool, []int, int, error) {
+  rows, err := read_scores_rows(filename, format)
+  if err != nil {
+    return nil, nil, index, dim, err
+  }
+  if dim == -1 {
+    dim = rows.Dim
+  } else {
+    if rows.Dim > dim || (rows.Exact && rows.Dim != dim) {
+      return nil, nil, index, dim, fmt.Errorf("data has variable number of features")
+    }
+  }
+  if len(index) == 0 {
+    index = make([]int, dim)
+    for i := 0; i < dim; i++ {
+      index[i] = i
+    }
+  }
+  scores := make([]ConstVector, len(rows.Index))
+  for i := 0; i < len(rows.Index); i++ {
+    scores[i] = convert_sparse_scores(config, rows.Index[i], rows.Value[i], dim, index, features, generate_features)
+    // free memory
+    rows.Index[i] = nil
+    rows.Value[i] = nil
+  }
+  return scores, rows.Labels, index, dim, nil
+}
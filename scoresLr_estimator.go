@@ -19,6 +19,7 @@ package main
 /* -------------------------------------------------------------------------- */
 
 //import   "fmt"
+import   "encoding/json"
 import   "log"
 import   "math"
 
@@ -26,6 +27,7 @@ import . "github.com/pbenner/autodiff"
 import . "github.com/pbenner/autodiff/statistics"
 import   "github.com/pbenner/autodiff/statistics/vectorDistribution"
 import   "github.com/pbenner/autodiff/statistics/vectorEstimator"
+import   "github.com/pbenner/threadpool"
 
 import . "github.com/pbenner/gonetics"
 
@@ -37,7 +39,14 @@ type ScoresLrEstimator struct {
   EpsilonLoss  float64
   reduced_data ScoresDataSet
   trace        Trace
+  // selected lambda for each estimated classifier
+  lambda     []float64
   path         ScoresRegularizationPath
+  // cross-validation fold
+  icv          int
+  // checkpoint and index of this estimator within the ensemble
+  checkpoint  *Checkpoint
+  member       int
 }
 
 /* -------------------------------------------------------------------------- */
@@ -56,6 +65,7 @@ func NewScoresLrEstimator(config Config, classifier *ScoresLr, icv int) *ScoresL
     r.LogisticRegression.Epsilon        = config.Epsilon
     r.LogisticRegression.StepSizeFactor = config.StepSizeFactor
     r.LogisticRegression.Hook           = NewScoresHook(config, icv, &r)
+    r.icv                               = icv
     if config.MaxIterations != 0 {
       r.LogisticRegression.MaxIterations = config.MaxIterations
     }
@@ -107,8 +117,22 @@ func (obj *ScoresLrEstimator) reestimate(config Config, transform Transform, coo
   // reestimate parameters without penalty
   estimator      := obj.LogisticRegression.Clone()
   estimator.L1Reg = 0.0
-  if err := estimator.Estimate(nil, config.PoolSaga); err != nil {
-    log.Fatal(err)
+  estimator.L2Reg = 0.0
+  switch {
+  case config.Regression != "":
+    estimator.Theta = NewDenseFloat64Vector(estimate_proximal_regression(config, estimator, obj.reduced_data.Data, obj.reduced_data.Responses, obj.reduced_data.Weights, featureGroups{}))
+  case config.Optimizer == "coordinate":
+    estimator.Theta = NewDenseFloat64Vector(estimate_coordinate(config, estimator, obj.reduced_data.Data, obj.reduced_data.Labels, obj.reduced_data.Weights))
+  case config.Optimizer == "proximal":
+    estimator.Theta = NewDenseFloat64Vector(estimate_proximal  (config, estimator, obj.reduced_data.Data, obj.reduced_data.Labels, obj.reduced_data.Weights, featureGroups{}))
+  default:
+    if obj.reduced_data.Weights != nil {
+      estimator.Theta = NewDenseFloat64Vector(estimate_saga(config, estimator, obj.reduced_data.Data, obj.reduced_data.Labels, obj.reduced_data.Weights, featureGroups{}))
+    } else {
+      if err := estimator.Estimate(nil, config.PoolSaga); err != nil {
+        log.Fatal(err)
+      }
+    }
   }
   if r_, err := estimator.GetEstimate(); err != nil {
     log.Fatal(err)
@@ -119,17 +143,32 @@ func (obj *ScoresLrEstimator) reestimate(config Config, transform Transform, coo
     r.ScoresLrFeatures = obj.ScoresLrFeatures
     r.Cooccurrence     = cooccurrence
     r.Transform        = transform
+    r.Penalty          = NewPenalty(config, 0.0)
+    r.Regression       = config.Regression
     return r
   }
 }
 
 func (obj *ScoresLrEstimator) estimate(config Config, data ScoresDataSet, transform Transform, cooccurrence bool) *ScoresLr {
   transform.Apply(config, data.Data)
-  if err := obj.LogisticRegression.SetSparseData(data.Data, data.Labels, len(data.Data)); err != nil {
-    log.Fatal(err)
-  }
-  if err := obj.LogisticRegression.Estimate(nil, config.PoolSaga); err != nil {
-    log.Fatal(err)
+  switch {
+  case config.Regression != "":
+    obj.Theta = NewDenseFloat64Vector(estimate_proximal_regression(config, &obj.LogisticRegression, data.Data, data.Responses, data.Weights, featureGroups{}))
+  case config.Optimizer == "coordinate":
+    obj.Theta = NewDenseFloat64Vector(estimate_coordinate(config, &obj.LogisticRegression, data.Data, data.Labels, data.Weights))
+  case config.Optimizer == "proximal":
+    obj.Theta = NewDenseFloat64Vector(estimate_proximal  (config, &obj.LogisticRegression, data.Data, data.Labels, data.Weights, featureGroups{}))
+  default:
+    if obj.L2Reg != 0.0 || data.Weights != nil {
+      obj.Theta = NewDenseFloat64Vector(estimate_saga(config, &obj.LogisticRegression, data.Data, data.Labels, data.Weights, featureGroups{}))
+    } else {
+      if err := obj.LogisticRegression.SetSparseData(data.Data, data.Labels, len(data.Data)); err != nil {
+        log.Fatal(err)
+      }
+      if err := obj.LogisticRegression.Estimate(nil, config.PoolSaga); err != nil {
+        log.Fatal(err)
+      }
+    }
   }
   if r_, err := obj.LogisticRegression.GetEstimate(); err != nil {
     log.Fatal(err)
@@ -140,6 +179,8 @@ func (obj *ScoresLrEstimator) estimate(config Config, data ScoresDataSet, transf
     r.ScoresLrFeatures = obj.ScoresLrFeatures
     r.Cooccurrence     = cooccurrence
     r.Transform        = transform
+    r.Penalty          = NewPenaltyL1(config, obj.L1Reg/float64(len(data.Data)))
+    r.Regression       = config.Regression
     if config.SavePath {
       obj.path.Append(-1, obj.L1Reg/float64(len(data.Data)), r.ScoresLrFeatures.Index, r.Theta[1:])
     }
@@ -153,18 +194,31 @@ func (obj *ScoresLrEstimator) estimate_fixed(config Config, data ScoresDataSet,
   }
   m, _ := obj.n_params(config, data.Data, 0, obj.Cooccurrence)
   // compute class weights
-  obj.LogisticRegression.SetLabels(data.Labels)
+  set_class_weights(config, &obj.LogisticRegression, data.Labels)
   // create a copy of data arrays, from which to select subsets
-  obj.reduced_data.Data   = make([]ConstVector, len(data.Data))
-  obj.reduced_data.Labels = data.Labels
+  obj.reduced_data.Data      = make([]ConstVector, len(data.Data))
+  obj.reduced_data.Labels    = data.Labels
+  obj.reduced_data.Weights   = data.Weights
+  obj.reduced_data.Responses = data.Responses
   s := newFeatureSelector(config, KmerClassList{}, data.Index, data.Names, cooccurrence, data.Labels, transform, obj.ClassWeights, m, 0, config.EpsilonLambda)
-  r := (*ScoresLr)(nil)
-  for epoch := 0; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
-    selection, ok := s.SelectFixed(data.Data, obj.Theta, obj.Features, KmerClassList{}, obj.Index, obj.Names, config.Lambda, config.MaxFeatures)
+  s.Weights   = data.Weights
+  s.Responses = data.Responses
+  r, epoch := obj.checkpointRestore(config, 0)
+  if r != nil {
+    return r
+  }
+  penalty := NewPenalty(config, config.Lambda)
+  s.Lambda2 = penalty.L2()
+  for ; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+    if checkpointStopRequested() {
+      break
+    }
+    selection, ok := s.SelectFixed(data.Data, obj.Theta, obj.Features, KmerClassList{}, obj.Index, obj.Names, penalty.L1(), config.MaxFeatures)
     if !ok && r != nil {
       break
     }
-    obj.L1Reg    = config.Lambda*float64(len(data.Data))
+    obj.L1Reg    = penalty.L1()*float64(len(data.Data))
+    obj.L2Reg    = penalty.L2()*float64(len(data.Data))
     obj.Features = selection.Features()
     obj.Index    = selection.Index()
     obj.Names    = selection.Names()
@@ -174,24 +228,54 @@ func (obj *ScoresLrEstimator) estimate_fixed(config Config, data ScoresDataSet,
 
     PrintStderr(config, 1, "Estimating parameters with lambda=%e and %d features...\n", config.Lambda, len(obj.Features))
     r = obj.estimate(config, obj.reduced_data, selection.Transform(), cooccurrence)
+    obj.checkpointSave(config, 0, epoch+1, r, nil, false)
+  }
+  if checkpointStopRequested() {
+    obj.checkpointSave(config, 0, epoch, r, nil, true)
+  } else {
+    obj.checkpointSave(config, 0, epoch, r, r, true)
   }
   obj.reduced_data = ScoresDataSet{}
   return r
 }
 
+func (obj *ScoresLrEstimator) newFeatureSelector(config Config, data ScoresDataSet, transform TransformFull, cooccurrence bool) featureSelector {
+  m, _ := obj.n_params(config, data.Data, 0, obj.Cooccurrence)
+  // compute class weights
+  set_class_weights(config, &obj.LogisticRegression, data.Labels)
+  s := newFeatureSelector(config, KmerClassList{}, data.Index, data.Names, cooccurrence, data.Labels, transform, obj.ClassWeights, m, 0, config.EpsilonLambda)
+  s.Weights   = data.Weights
+  s.Responses = data.Responses
+  return s
+}
+
 func (obj *ScoresLrEstimator) estimate_loop(config Config, data ScoresDataSet, transform TransformFull, lambdaAuto int, cooccurrence bool) *ScoresLr {
   if len(data.Data) == 0 {
     return nil
   }
-  m, n := obj.n_params(config, data.Data, lambdaAuto, obj.Cooccurrence)
+  return obj.estimate_loop_(config, data, obj.newFeatureSelector(config, data, transform, cooccurrence), -1, lambdaAuto, cooccurrence)
+}
+
+// Estimate classifier with lambdaAuto non-zero coefficients, where i is the
+// index of lambdaAuto in the checkpoint (-1 if no checkpoint is used)
+func (obj *ScoresLrEstimator) estimate_loop_(config Config, data ScoresDataSet, s featureSelector, i, lambdaAuto int, cooccurrence bool) *ScoresLr {
+  r, epoch := obj.checkpointRestore(config, i)
+  if r != nil {
+    return r
+  }
+  _, n := obj.n_params(config, data.Data, lambdaAuto, obj.Cooccurrence)
   // compute class weights
-  obj.LogisticRegression.SetLabels(data.Labels)
+  set_class_weights(config, &obj.LogisticRegression, data.Labels)
   // create a copy of data arrays, from which to select subsets
-  obj.reduced_data.Data   = make([]ConstVector, len(data.Data))
-  obj.reduced_data.Labels = data.Labels
-  s := newFeatureSelector(config, KmerClassList{}, data.Index, data.Names, cooccurrence, data.Labels, transform, obj.ClassWeights, m, n, config.EpsilonLambda)
-  r := (*ScoresLr)(nil)
-  for epoch := 0; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+  obj.reduced_data.Data      = make([]ConstVector, len(data.Data))
+  obj.reduced_data.Labels    = data.Labels
+  obj.reduced_data.Weights   = data.Weights
+  obj.reduced_data.Responses = data.Responses
+  s.N = n
+  for ; config.MaxEpochs == 0 || epoch < config.MaxEpochs; epoch++ {
+    if checkpointStopRequested() {
+      break
+    }
     // select features on the initial data set
     if r != nil {
       d := r.Nonzero()
@@ -201,11 +285,14 @@ func (obj *ScoresLrEstimator) estimate_loop(config Config, data ScoresDataSet, t
         PrintStderr(config, 1, "Estimated classifier has %d non-zero coefficients, selecting %d new features...\n", d, n-d)
       }
     }
+    s.Lambda2 = obj.L2Reg/float64(len(data.Data))
     selection, lambda, ok := s.Select(data.Data, obj.Theta, obj.Features, KmerClassList{}, obj.Index, obj.Names, obj.L1Reg, false)
     if !ok && r != nil {
       break
     }
-    obj.L1Reg    = lambda*float64(len(data.Data))
+    penalty     := NewPenaltyL1(config, lambda)
+    obj.L1Reg    = penalty.L1()*float64(len(data.Data))
+    obj.L2Reg    = penalty.L2()*float64(len(data.Data))
     obj.Features = selection.Features()
     obj.Index    = selection.Index()
     obj.Names    = selection.Names()
@@ -213,28 +300,181 @@ func (obj *ScoresLrEstimator) estimate_loop(config Config, data ScoresDataSet, t
     // create actual training data sets
     selection.Data(config, obj.reduced_data.Data, data.Data)
 
-    PrintStderr(config, 1, "Estimating parameters with lambda=%e...\n", lambda)
+    PrintStderr(config, 1, "Estimating parameters with lambda=%e...\n", penalty.Lambda)
     r = obj.estimate(config, obj.reduced_data, selection.Transform(), cooccurrence)
+    obj.checkpointSave(config, i, epoch+1, r, nil, false)
   }
+  if checkpointStopRequested() {
+    // return current estimate without re-estimation
+    obj.checkpointSave(config, i, epoch, r, nil, true)
+    obj.reduced_data = ScoresDataSet{}
+    return r
+  }
+  result := r
   if config.PenaltyFree && r != nil {
     PrintStderr(config, 1, "Re-estimating parameters without penalty...\n")
-    r = obj.reestimate(config, r.Transform, cooccurrence)
+    result = obj.reestimate(config, r.Transform, cooccurrence)
   }
+  obj.checkpointSave(config, i, epoch, r, result, true)
   obj.reduced_data = ScoresDataSet{}
-  return r
+  return result
 }
 
 func (obj *ScoresLrEstimator) Estimate(config Config, data ScoresDataSet, transform TransformFull) []*ScoresLr {
   if !math.IsNaN(config.Lambda) {
     classifiers   := make([]*ScoresLr, 1)
     classifiers[0] = obj.estimate_fixed(config, data, transform, obj.Cooccurrence)
+    obj.lambda     = []float64{config.Lambda}
     return classifiers
   } else {
-    classifiers := make([]*ScoresLr, len(config.LambdaAuto))
-    for i, lambdaAuto := range config.LambdaAuto {
-      PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", lambdaAuto)
-      classifiers[i] = obj.estimate_loop(config, data, transform, lambdaAuto, obj.Cooccurrence)
+    if config.PoolPath.NumberOfThreads() > 1 {
+      return obj.estimate_path_parallel(config, data, transform)
+    } else {
+      return obj.estimate_path(config, data, transform)
+    }
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Estimate classifiers for all values of --lambda-auto by continuation (see
+// KmerLrEstimator.estimate_path)
+func (obj *ScoresLrEstimator) estimate_path(config Config, data ScoresDataSet, transform TransformFull) []*ScoresLr {
+  classifiers := make([]*ScoresLr, len(config.LambdaAuto))
+  obj.lambda   = make([]float64, len(config.LambdaAuto))
+  if len(data.Data) == 0 {
+    return classifiers
+  }
+  s := obj.newFeatureSelector(config, data, transform, obj.Cooccurrence)
+  // --lambda-auto values are sorted in increasing order
+  for i, _ := range config.LambdaAuto {
+    if len(obj.Features) > 0 {
+      PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients (warm-start with %d features)...\n", config.LambdaAuto[i], len(obj.Features))
+    } else {
+      PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", config.LambdaAuto[i])
+    }
+    classifiers[i] = obj.estimate_loop_(config, data, s, i, config.LambdaAuto[i], obj.Cooccurrence)
+    obj.lambda [i] = NewPenaltyL1(config, obj.L1Reg/float64(len(data.Data))).Lambda
+    if checkpointStopRequested() {
+      break
     }
+  }
+  return classifiers
+}
+
+// Estimate classifiers for all values of --lambda-auto independently and in
+// parallel, where only the gradient at the initial solution is shared
+func (obj *ScoresLrEstimator) estimate_path_parallel(config Config, data ScoresDataSet, transform TransformFull) []*ScoresLr {
+  classifiers := make([]*ScoresLr, len(config.LambdaAuto))
+  estimators  := make([]*ScoresLrEstimator, len(config.LambdaAuto))
+  obj.lambda   = make([]float64, len(config.LambdaAuto))
+  if len(data.Data) == 0 {
     return classifiers
   }
+  s := obj.newFeatureSelector(config, data, transform, obj.Cooccurrence)
+  // evaluate gradient at the initial solution, which is
+  // the same for all estimators
+  s.Lambda2   = obj.L2Reg/float64(len(data.Data))
+  t, _, _    := s.restoreNonzero(obj.Theta, obj.Features, KmerClassList{}, obj.Index)
+  s.gradient(data.Data, t)
+  for i := 0; i < len(estimators); i++ {
+    estimators[i] = obj.fork(config)
+  }
+  config.PoolPath.RangeJob(0, len(estimators), func(i int, pool threadpool.ThreadPool, erf func() error) error {
+    config := config; config.PoolPath = pool
+
+    PrintStderr(config, 1, "Estimating classifier with %d non-zero coefficients...\n", config.LambdaAuto[i])
+    classifiers[i] = estimators[i].estimate_loop_(config, data, s, i, config.LambdaAuto[i], obj.Cooccurrence)
+    return nil
+  })
+  for i, estimator := range estimators {
+    obj.lambda[i] = NewPenaltyL1(config, estimator.L1Reg/float64(len(data.Data))).Lambda
+    obj.trace.AppendTrace(estimator.trace)
+    obj.path .AppendPath (-1, estimator.path)
+  }
+  // continue with the solution of the largest model
+  if n := len(estimators); n > 0 {
+    obj.join(estimators[n-1])
+  }
+  return classifiers
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Create an independent copy of the estimator, which shares no state with
+// the original estimator
+func (obj *ScoresLrEstimator) fork(config Config) *ScoresLrEstimator {
+  r := &ScoresLrEstimator{}
+  r.ScoresLrFeatures        = obj.ScoresLrFeatures.Clone()
+  r.EpsilonLoss             = obj.EpsilonLoss
+  r.LogisticRegression      = *obj.LogisticRegression.Clone()
+  r.LogisticRegression.Hook = NewScoresHook(config, obj.icv, r)
+  r.icv                     = obj.icv
+  r.checkpoint              = obj.checkpoint
+  r.member                  = obj.member
+  return r
+}
+
+// Copy the current solution of a forked estimator
+func (obj *ScoresLrEstimator) join(estimator *ScoresLrEstimator) {
+  obj.Theta            = estimator.Theta
+  obj.L1Reg            = estimator.L1Reg
+  obj.L2Reg            = estimator.L2Reg
+  obj.ScoresLrFeatures = estimator.ScoresLrFeatures
+}
+
+/* -------------------------------------------------------------------------- */
+
+// Save state of the estimator for the i-th value of --lambda-auto (see
+// KmerLrEstimator.checkpointSave)
+func (obj *ScoresLrEstimator) checkpointSave(config Config, i, epoch int, r, result *ScoresLr, force bool) {
+  if obj.checkpoint == nil || i < 0 || r == nil {
+    return
+  }
+  state := CheckpointState{}
+  state.Model = r.exportCheckpoint()
+  state.L1Reg = obj.L1Reg
+  state.L2Reg = obj.L2Reg
+  state.Epoch = epoch
+  state.Trace = obj.trace
+  if result != nil {
+    t := result.exportCheckpoint()
+    state.Result = &t
+  }
+  if b, err := json.Marshal(obj.path); err != nil {
+    log.Fatal(err)
+  } else {
+    state.Path = b
+  }
+  obj.checkpoint.Update(config, obj.member, i, &state, force)
+}
+
+// Restore state of the estimator for the i-th value of --lambda-auto (see
+// KmerLrEstimator.checkpointRestore)
+func (obj *ScoresLrEstimator) checkpointRestore(config Config, i int) (*ScoresLr, int) {
+  state := obj.checkpoint.Get(obj.member, i)
+  if state == nil {
+    return nil, 0
+  }
+  r := importScoresLrCheckpoint(state.Model)
+  path := ScoresRegularizationPath{}
+  if err := json.Unmarshal(state.Path, &path); err != nil {
+    log.Fatal(err)
+  }
+  obj.Theta    = DenseFloat64Vector(r.Theta)
+  obj.Features = r.Features
+  obj.Index    = r.Index
+  obj.Names    = r.Names
+  obj.L1Reg    = state.L1Reg
+  obj.L2Reg    = state.L2Reg
+  obj.trace    = state.Trace
+  obj.path     = ScoresRegularizationPath{}
+  obj.path.AppendPath(-1, path)
+  if state.Result != nil {
+    PrintStderr(config, 1, "Restored classifier with %d features from checkpoint\n", len(r.Features))
+    return importScoresLrCheckpoint(*state.Result), state.Epoch
+  } else {
+    PrintStderr(config, 1, "Restored estimate with %d features from checkpoint (epoch %d)\n", len(r.Features), state.Epoch)
+    return nil, state.Epoch
+  }
 }
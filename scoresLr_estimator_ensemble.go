@@ -37,6 +37,7 @@ func NewScoresLrEnsembleEstimator(config Config, classifier *ScoresLrEnsemble, i
   estimators := make([]*ScoresLrEstimator, config.EnsembleSize)
   for i, _ := range estimators {
     estimators[i] = NewScoresLrEstimator(config, classifier.GetComponent(0).Clone(), icv)
+    estimators[i].member = i
   }
   return ScoresLrEstimatorEnsemble{estimators, classifier.Summary}
 }
@@ -53,6 +54,14 @@ func (obj ScoresLrEstimatorEnsemble) CloneVectorEstimator() VectorEstimator {
 
 /* -------------------------------------------------------------------------- */
 
+func (obj ScoresLrEstimatorEnsemble) SetCheckpoint(checkpoint *Checkpoint) {
+  for _, estimator := range obj.Estimators {
+    estimator.checkpoint = checkpoint
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
 func (obj ScoresLrEstimatorEnsemble) GetTrace() Trace {
   trace := Trace{}
   for _, estimator := range obj.Estimators {
@@ -69,10 +78,22 @@ func (obj ScoresLrEstimatorEnsemble) GetPath() ScoresRegularizationPath {
   return path
 }
 
+// Selected lambda for each estimated classifier averaged over all members
+// of the ensemble
+func (obj ScoresLrEstimatorEnsemble) GetLambda() []float64 {
+  r := make([]float64, len(obj.Estimators[0].lambda))
+  for _, estimator := range obj.Estimators {
+    for i, lambda := range estimator.lambda {
+      r[i] += lambda/float64(len(obj.Estimators))
+    }
+  }
+  return r
+}
+
 /* -------------------------------------------------------------------------- */
 
 func (obj ScoresLrEstimatorEnsemble) estimate_ensemble(config Config, data_train ScoresDataSet, transform TransformFull) []*ScoresLrEnsemble {
-  groups, _ := getCvGroups(len(data_train.Data), config.EnsembleSize, 0.0, config.Seed)
+  groups, _ := getCvGroups(config, data_train.cvLabels(), data_train.Groups, config.EnsembleSize, 0.0, config.Seed)
   result := make([]*ScoresLrEnsemble, len(config.LambdaAuto))
   for i := 0; i < len(result); i++ {
     result[i] = NewScoresLrEnsemble(obj.Summary)
@@ -87,6 +108,10 @@ func (obj ScoresLrEstimatorEnsemble) estimate_ensemble(config Config, data_train
   })
   for k := 0; k < len(classifiers); k++ {
     for i, classifier := range classifiers[k] {
+      // classifiers are missing if the estimation was interrupted
+      if classifier == nil {
+        continue
+      }
       if err := result[i].AddScoresLr(classifier); err != nil {
         panic("internal error")
       }
@@ -95,7 +120,7 @@ func (obj ScoresLrEstimatorEnsemble) estimate_ensemble(config Config, data_train
   return result
 }
 
-func (obj ScoresLrEstimatorEnsemble) Estimate(config Config, data_train, data_val, data_test ScoresDataSet) ([]*ScoresLrEnsemble, [][]float64, []float64, []float64) {
+func (obj ScoresLrEstimatorEnsemble) Estimate(config Config, data_train, data_val, data_test ScoresDataSet) ([]*ScoresLrEnsemble, [][]float64, []float64, []float64, []float64) {
   if obj.Estimators[0].Cooccurrence && config.Copreselection != 0 {
     transform := TransformFull{}
     // estimate transform on full data set so that all estimated
@@ -103,6 +128,9 @@ func (obj ScoresLrEstimatorEnsemble) Estimate(config Config, data_train, data_va
     transform.Fit(config, append(data_train.Data, data_test.Data...), false)
     // reduce data_train and data_test to pre-selected features
     r := obj.Estimators[0].estimate_loop(config, data_train, transform, config.Copreselection, false)
+    if checkpointStopRequested() {
+      return nil, nil, nil, nil, nil
+    }
     data_train.Data  = r.SelectData(config, data_train)
     data_train.Index = r.Index
     data_test .Data  = r.SelectData(config, data_test)
@@ -115,13 +143,23 @@ func (obj ScoresLrEstimatorEnsemble) Estimate(config Config, data_train, data_va
   transform := TransformFull{}
   transform.Fit(config, append(data_train.Data, data_test.Data...), obj.Estimators[0].Cooccurrence)
   classifiers := obj.estimate_ensemble(config, data_train, transform)
+  lambda      := obj.GetLambda()
+  if checkpointStopRequested() {
+    return classifiers, nil, nil, nil, lambda
+  }
+  return evaluate_scores_classifiers(config, classifiers, lambda, data_train, data_val, data_test)
+}
+
+/* -------------------------------------------------------------------------- */
+
+func evaluate_scores_classifiers(config Config, classifiers []*ScoresLrEnsemble, lambda []float64, data_train, data_val, data_test ScoresDataSet) ([]*ScoresLrEnsemble, [][]float64, []float64, []float64, []float64) {
   // if validation data is available, select best classifier...
   if len(data_val.Data) > 0 {
     i_best := 0
     v_best := math.Inf(1)
     for i, _ := range classifiers {
       d := classifiers[i].SelectData(config, data_val)
-      v := classifiers[i].Loss(config, d, data_val.Labels)
+      v := classifiers[i].LossDataSet(config, d, data_val)
       if v < v_best {
         i_best = i
         v_best = v
@@ -130,6 +168,7 @@ func (obj ScoresLrEstimatorEnsemble) Estimate(config Config, data_train, data_va
     }
     PrintStderr(config, 1, "> Selecting classifier %d\n", i_best)
     classifiers = []*ScoresLrEnsemble{classifiers[i_best]}
+    lambda      = []float64{lambda[i_best]}
   }
   // evaluate classifier(s) on test data
   predictions := make([][]float64, len(classifiers))
@@ -138,9 +177,9 @@ func (obj ScoresLrEstimatorEnsemble) Estimate(config Config, data_train, data_va
   for i, _ := range classifiers {
     data_test_    := classifiers[i].SelectData(config, data_test)
     data_train_   := classifiers[i].SelectData(config, data_train)
-    predictions[i] = classifiers[i].Predict   (config, data_test_)
-    loss_train [i] = classifiers[i].Loss      (config, data_train_, data_train.Labels)
-    loss_test  [i] = classifiers[i].Loss      (config, data_test_ , data_test .Labels)
+    predictions[i] = classifiers[i].Predict    (config, data_test_)
+    loss_train [i] = classifiers[i].LossDataSet(config, data_train_, data_train)
+    loss_test  [i] = classifiers[i].LossDataSet(config, data_test_ , data_test )
   }
-  return classifiers, predictions, loss_train, loss_test
+  return classifiers, predictions, loss_train, loss_test, lambda
 }
@@ -21,7 +21,6 @@ package main
 import   "fmt"
 import   "bufio"
 import   "math"
-import   "os"
 
 /* -------------------------------------------------------------------------- */
 
@@ -31,13 +30,13 @@ type ScoresRegularizationPath struct {
   Norm      []float64
   Theta   [][]float64
   Idx       []int
-  Index     map[int]int
+  Index     map[int]int `json:"-"`
 }
 
 /* -------------------------------------------------------------------------- */
 
 func (obj ScoresRegularizationPath) Export(filename string) error {
-  f, err := os.Create(filename)
+  f, err := create_file(filename)
   if err != nil {
     return err
   }
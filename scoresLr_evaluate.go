@@ -0,0 +1,86 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+//import   "fmt"
+import   "os"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+func evaluate_predict_scores_(config Config, filename_json, filename_fg, filename_bg string) ([]float64, []bool) {
+  classifier := ImportScoresLrEnsemble(config, filename_json)
+  data       := compile_training_data_scores(config, classifier.Index, classifier.Names, classifier.Features, false, filename_fg, filename_bg)
+  classifier.Transform.Apply(config, data.Data)
+
+  return classifier.Predict(config, data.Data), data.Labels
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_evaluate_scores(config Config, args []string) {
+  options := getopt.New()
+
+  optThreshold := options.StringLong("threshold",        0 ,  "0.5", "probability threshold for computing accuracy and F1 score [default: 0.5]")
+  optCurves    := options.StringLong("curves",           0 ,     "", "write ROC and PR curve points to file")
+  optBootstrap := options.   IntLong("bootstrap",        0 ,      0, "number of bootstrap samples for computing AUC confidence intervals [default: 0]")
+  optLevel     := options.StringLong("confidence-level", 0 , "0.95", "level of bootstrap confidence intervals [default: 0.95]")
+  optHeader    := options.  BoolLong("header",           0 ,         "input files contain a header with feature names")
+  optLabelled  := options.  BoolLong("labelled",         0 ,         "the data is given as a single file that contains labels (libsvm or npz) instead of foreground and background files")
+  optHelp      := options.  BoolLong("help",            'h',         "print help")
+
+  options.SetParameters("<<CV.table>|<MODEL.json> <<FOREGROUND.table> <BACKGROUND.table>|<DATA>>> [RESULT.table]")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  threshold, level := parse_evaluate_options(optThreshold, optLevel, optBootstrap)
+  config.Header = *optHeader
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  args = options.Args()
+  if *optLabelled && len(args) >= 2 {
+    // insert empty background filename
+    args = append(append(append([]string{}, args[0:2]...), ""), args[2:]...)
+  }
+  predictions  := []float64{}
+  labels       := []bool{}
+  filename_out := ""
+  switch len(args) {
+  case 1, 2:
+    predictions, labels = read_cv_table(config, args[0])
+    if len(args) == 2 {
+      filename_out = args[1]
+    }
+  case 3, 4:
+    predictions, labels = evaluate_predict_scores_(config, args[0], args[1], args[2])
+    if len(args) == 4 {
+      filename_out = args[3]
+    }
+  default:
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  evaluate(config, predictions, labels, filename_out, *optCurves, threshold, *optBootstrap, level)
+}
@@ -373,7 +373,7 @@ func expand_import(config Config, filenames_in []string) ([][]float64, []int, []
 func expand_export(config Config, columns [][]float64, lengths []int, names []string, basename_out string) {
   offset := 0
   for i_ := 0; i_ < len(lengths); i_++ {
-    f, err := os.Create(fmt.Sprintf("%s_%d.table", basename_out, i_))
+    f, err := create_file(fmt.Sprintf("%s_%d.table", basename_out, i_))
     if err != nil {
       panic(err)
     }
@@ -0,0 +1,113 @@
+/* Copyright (C) 2021 Philipp Benner
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+/* -------------------------------------------------------------------------- */
+
+import   "fmt"
+import   "log"
+import   "os"
+import   "strings"
+
+import . "github.com/pbenner/autodiff"
+
+import   "github.com/pborman/getopt"
+
+/* -------------------------------------------------------------------------- */
+
+func export_scores(config Config, filename_json, format string, filename_in, filename_out []string) {
+  var columns []string
+  var data    [][]ConstVector
+  if filename_json != "" {
+    // restrict data to the features of the model
+    classifier := ImportScoresLrEnsemble(config, filename_json)
+    data, _  = compile_data_scores(config, classifier.Index, classifier.Names, classifier.Features, false, filename_in...)
+    columns  = classifier.FeatureNames()
+  } else {
+    data, columns = compile_data_scores(config, nil, nil, nil, true, filename_in...)
+    if len(columns) == 0 {
+      for _, d := range data {
+        if len(d) > 0 {
+          columns = make([]string, d[0].Dim()-1)
+          for j := 0; j < len(columns); j++ {
+            columns[j] = fmt.Sprintf("%d", j+1)
+          }
+          break
+        }
+      }
+    }
+  }
+  for i, _ := range data {
+    // samples of the first file are labeled as foreground
+    labels := make([]bool, len(data[i]))
+    for j := 0; j < len(labels); j++ {
+      labels[j] = i == 0
+    }
+    export_matrix(config, filename_out[i], format, ExportMatrix{Data: data[i], Labels: labels, Columns: columns})
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func main_export_scores(config Config, args []string) {
+  options := getopt.New()
+
+  optFormat := options.StringLong("format",  0 , "table", "output format [table (dense comma separated table), mtx (MatrixMarket coordinate format), libsvm, npz (scipy.sparse CSR matrix)]; column names of sparse formats are written to OUTPUT.columns")
+  optHeader := options.  BoolLong("header",  0 ,          "input files contain a header with feature names")
+  optHelp   := options.  BoolLong("help",   'h',          "print help")
+
+  options.SetParameters("[MODEL.json] <SCORES_1.table,SCORES_2.table,...> <OUTPUT_1,OUTPUT_2,...>\n\n" +
+    " If a model is given, the output is restricted to the features of the model.\n" +
+    " Samples of the first file are labeled as foreground (1) and all others as\n" +
+    " background (0) in libsvm and npz output.\n")
+  options.Parse(args)
+
+  // parse options
+  //////////////////////////////////////////////////////////////////////////////
+  if *optHelp {
+    options.PrintUsage(os.Stdout)
+    os.Exit(0)
+  }
+  if !export_format_valid(*optFormat) {
+    log.Fatalf("invalid output format `%s'", *optFormat)
+  }
+  config.Header = *optHeader
+  // parse arguments
+  //////////////////////////////////////////////////////////////////////////////
+  if len(options.Args()) != 2 && len(options.Args()) != 3 {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  filename_json := ""
+  filename_in   := ""
+  filename_out  := ""
+  if len(options.Args()) == 3 {
+    filename_json = options.Args()[0]
+    filename_in   = options.Args()[1]
+    filename_out  = options.Args()[2]
+  } else {
+    filename_in   = options.Args()[0]
+    filename_out  = options.Args()[1]
+  }
+  filenames_1 := strings.Split(filename_in , ",")
+  filenames_2 := strings.Split(filename_out, ",")
+  if len(filenames_1) != len(filenames_2) {
+    options.PrintUsage(os.Stderr)
+    os.Exit(1)
+  }
+  export_scores(config, filename_json, *optFormat, filenames_1, filenames_2)
+}
@@ -48,6 +48,27 @@ func (obj ScoresLrFeatures) Clone() ScoresLrFeatures {
   return r
 }
 
+// Names of features, where co-occurrences are named `name1&name2' and
+// columns without names are numbered starting from one
+func (obj ScoresLrFeatures) FeatureNames() []string {
+  name := func(i int) string {
+    if len(obj.Names) == 0 {
+      return fmt.Sprintf("%d", obj.Index[i]+1)
+    } else {
+      return obj.Names[i]
+    }
+  }
+  r := make([]string, len(obj.Features))
+  for i, feature := range obj.Features {
+    if feature[0] == feature[1] {
+      r[i] = name(feature[0])
+    } else {
+      r[i] = fmt.Sprintf("%s&%s", name(feature[0]), name(feature[1]))
+    }
+  }
+  return r
+}
+
 /* -------------------------------------------------------------------------- */
 
 func (obj *ScoresLrFeatures) ImportConfig(config ConfigDistribution, t ScalarType) error {
@@ -29,10 +29,13 @@ import   "github.com/pborman/getopt"
 
 func loss_scores_(config Config, filename_json, filename_fg, filename_bg string) float64 {
   classifier := ImportScoresLrEnsemble(config, filename_json)
+  if classifier.Regression != "" {
+    log.Fatalf("model `%s' is a regression model, use the cross-validation results of the learn command instead", filename_json)
+  }
   data       := compile_training_data_scores(config, classifier.Index, classifier.Names, classifier.Features, false, filename_fg, filename_bg)
   classifier.Transform.Apply(config, data.Data)
 
-  return classifier.Loss(config, data.Data, data.Labels)
+  return classifier.Loss(config, data.Data, data.Labels, data.Weights)
 }
 
 func loss_scores(config Config, filename_json, filename_fg, filename_bg, filename_out string) {
@@ -44,12 +47,17 @@ func loss_scores(config Config, filename_json, filename_fg, filename_bg, filenam
 func main_loss_scores(config Config, args []string) {
   options := getopt.New()
 
-  optBalance := options.  BoolLong("balance", 0 ,        "set class weights so that the data set is balanced")
-  optLambda  := options.StringLong("lambda",  0 , "0.0", "regularization strength (L1)")
-  optHeader  := options.  BoolLong("header",  0 ,        "input files contain a header with feature names")
-  optHelp    := options.  BoolLong("help",   'h',        "print help")
+  optBalance       := options.  BoolLong("balance",        0 ,        "set class weights so that the data set is balanced")
+  optClassWeights  := options.StringLong("class-weights",  0 ,    "", "class weights w0,w1 of background and foreground samples")
+  optWeightsFile   := options.StringLong("weights-file",   0 ,    "", "file with one sample weight per line (foreground samples first)")
+  optWeightsColumn := options.StringLong("weights-column", 0 ,    "", "column of the scores table containing sample weights, which is removed from the features (requires --header)")
+  optLambda        := options.StringLong("lambda",         0 , "0.0", "regularization strength")
+  optAlpha         := options.StringLong("alpha",          0 , "1.0", "elastic-net mixing parameter, where the L1 penalty has strength alpha*lambda and the L2 penalty (1-alpha)*lambda")
+  optHeader        := options.  BoolLong("header",         0 ,        "input files contain a header with feature names")
+  optLabelled      := options.  BoolLong("labelled",       0 ,        "the data is given as a single file that contains labels (libsvm or npz) instead of foreground and background files")
+  optHelp          := options.  BoolLong("help",          'h',        "print help")
 
-  options.SetParameters("<MODEL.json> <FOREGROUND.table> <BACKGROUND.table> [RESULT.table]")
+  options.SetParameters("<MODEL.json> <<FOREGROUND.table> <BACKGROUND.table>|<DATA>> [RESULT.table]")
   options.Parse(args)
 
   // parse options
@@ -63,21 +71,46 @@ func main_loss_scores(config Config, args []string) {
   } else {
     config.Lambda = v
   }
+  if v, err := strconv.ParseFloat(*optAlpha, 64); err != nil {
+    log.Fatal(err)
+  } else {
+    if v <= 0.0 || v > 1.0 {
+      log.Fatal("option --alpha must be in the interval (0,1]")
+    }
+    config.Alpha = v
+  }
   config.Balance = *optBalance
   config.Header  = *optHeader
+  if *optClassWeights != "" {
+    if *optBalance {
+      log.Fatal("options --balance and --class-weights cannot be used together")
+    }
+    if w, err := parse_class_weights(*optClassWeights); err != nil {
+      log.Fatal(err)
+    } else {
+      config.ClassWeights = w
+    }
+  }
+  config.WeightsFile   = *optWeightsFile
+  config.WeightsColumn = *optWeightsColumn
   // parse arguments
   //////////////////////////////////////////////////////////////////////////////
-  if len(options.Args()) != 3 && len(options.Args()) != 4 {
+  args = options.Args()
+  if *optLabelled && len(args) >= 2 {
+    // insert empty background filename
+    args = append(append(append([]string{}, args[0:2]...), ""), args[2:]...)
+  }
+  if len(args) != 3 && len(args) != 4 {
     options.PrintUsage(os.Stdout)
     os.Exit(0)
   }
 
-  filename_json := options.Args()[0]
-  filename_fg   := options.Args()[1]
-  filename_bg   := options.Args()[2]
+  filename_json := args[0]
+  filename_fg   := args[1]
+  filename_bg   := args[2]
   filename_out  := ""
-  if len(options.Args()) == 4 {
-    filename_out = options.Args()[3]
+  if len(args) == 4 {
+    filename_out = args[3]
   }
 
   loss_scores(config, filename_json, filename_fg, filename_bg, filename_out)
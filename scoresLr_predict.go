@@ -19,6 +19,7 @@ package main
 /* -------------------------------------------------------------------------- */
 
 //import   "fmt"
+import   "log"
 import   "os"
 
 import   "github.com/pborman/getopt"
@@ -26,6 +27,12 @@ import   "github.com/pborman/getopt"
 /* -------------------------------------------------------------------------- */
 
 func predict_scores_(config Config, filename_json, filename_in string) []float64 {
+  predictions, _ := predict_scores_data(config, filename_json, filename_in)
+  return predictions
+}
+
+// Returns predictions and the regression model of the classifier
+func predict_scores_data(config Config, filename_json, filename_in string) ([]float64, string) {
   classifier := ImportScoresLrEnsemble(config, filename_json)
 
   data := compile_test_data_scores(config, classifier.Index, classifier.Names, classifier.Features, false, filename_in)
@@ -33,11 +40,18 @@ func predict_scores_(config Config, filename_json, filename_in string) []float64
 
   predictions := classifier.Predict(config, data.Data)
 
-  return predictions
+  return predictions, classifier.Regression
 }
 
 func predict_scores(config Config, filename_json, filename_in, filename_out string) {
-  savePredictions(filename_out, predict_scores_(config, filename_json, filename_in))
+  predictions, regression := predict_scores_data(config, filename_json, filename_in)
+  predictions = scale_predictions(config, regression, predictions)
+  // scores tables have no row names, samples are identified by their index
+  index := make([]int, len(predictions))
+  for i := 0; i < len(index); i++ {
+    index[i] = i
+  }
+  savePredictions(config, compress_filename(config, filename_out), nil, index, predictions)
 }
 
 /* -------------------------------------------------------------------------- */
@@ -45,8 +59,13 @@ func predict_scores(config Config, filename_json, filename_in, filename_out stri
 func main_predict_scores(config Config, args []string) {
   options := getopt.New()
 
-  optHeader := options.BoolLong("header",  0 , "input files contain a header with feature names")
-  optHelp   := options.BoolLong("help",   'h', "print help")
+  optHeader         := options.  BoolLong("header",          0 ,          "input files contain a header with feature names")
+  optWeightsColumn  := options.StringLong("weights-column",  0 ,      "", "column of sample weights, which is removed from the scores table (requires --header)")
+  optResponseColumn := options.StringLong("response-column", 0 ,      "", "column of responses of regression models, which is removed from the scores table (requires --header)")
+  optOutputScale    := options.StringLong("output-scale",    0 ,      "", "scale of predictions [logprob (default), prob, logit]")
+  optOutputFormat   := options.StringLong("output-format",   0 , "table", "output format [table (default), json]")
+  optThreshold      := options.StringLong("threshold",       0 ,   "NaN", "call classes by applying a threshold to predictions on the output scale")
+  optHelp           := options.  BoolLong("help",           'h',          "print help")
 
   options.SetParameters("<MODEL.json> <SCORES.table> [RESULT.table]")
   options.Parse(args)
@@ -57,7 +76,12 @@ func main_predict_scores(config Config, args []string) {
     options.PrintUsage(os.Stdout)
     os.Exit(0)
   }
-  config.Header = *optHeader
+  config.Header         = *optHeader
+  config.WeightsColumn  = *optWeightsColumn
+  config.ResponseColumn = *optResponseColumn
+  if err := parse_output_options(&config, *optOutputScale, *optOutputFormat, *optThreshold); err != nil {
+    log.Fatal(err)
+  }
   // parse arguments
   //////////////////////////////////////////////////////////////////////////////
   if len(options.Args()) != 2 && len(options.Args()) != 3 {
@@ -18,9 +18,11 @@ package main
 
 /* -------------------------------------------------------------------------- */
 
-//import   "fmt"
+import   "bytes"
+import   "fmt"
 import   "math"
 import   "os"
+import   "strings"
 import   "testing"
 
 /* -------------------------------------------------------------------------- */
@@ -197,3 +199,82 @@ exit:
   os.Remove("scoresLr_test_export_fg.table")
   os.Remove("scoresLr_test_export_bg.table")
 }
+
+func TestScoresRegression1(test *testing.T) {
+  config := Config{}
+  config.Seed    = 1
+  config.Verbose = 0
+
+  // responses are a linear function of the first two columns
+  f, err := os.Create("scoresLr_test_regression_data.table")
+  if err != nil {
+    panic(err)
+  }
+  fmt.Fprintf(f, "a,b,c,y\n")
+  for i := 0; i < 40; i++ {
+    a := float64(i%7)
+    b := float64(i%5)
+    c := float64(i%3)/10.0
+    fmt.Fprintf(f, "%v,%v,%v,%v\n", a, b, c, 1.0 + 2.0*a - b)
+  }
+  f.Close()
+  defer os.Remove("scoresLr_test_regression_data.table")
+
+  main_learn_scores(config, []string{"learn", "--regression=linear", "--response-column=y", "--header", "--lambda-auto=2", "--k-fold-cv=2", "scoresLr_test_regression_data.table", "scoresLr_test_regression"})
+  defer os.Remove("scoresLr_test_regression_cv1.json")
+  defer os.Remove("scoresLr_test_regression_cv2.json")
+  defer os.Remove("scoresLr_test_regression.table")
+  defer os.Remove("scoresLr_test_regression_loss.table")
+  defer os.Remove("scoresLr_test_regression_folds.table")
+
+  classifier := ImportScoresLrEnsemble(config, "scoresLr_test_regression_cv1.json")
+  if classifier.Regression != "linear" || classifier.GetComponent(0).Nonzero() != 2 {
+    test.Error("test failed"); return
+  }
+  for _, feature := range classifier.Features {
+    if name := classifier.Names[feature[0]]; name != "a" && name != "b" {
+      test.Error("test failed")
+    }
+  }
+  if b, err := os.ReadFile("scoresLr_test_regression_folds.table"); err != nil || !strings.Contains(string(b), "correlation") {
+    test.Error("test failed")
+  }
+}
+
+/* -------------------------------------------------------------------------- */
+
+func TestScoresFormats1(test *testing.T) {
+  config := Config{}
+
+  rows, err := read_scores_libsvm(strings.NewReader("+1 1:0.5 3:2 # comment\n-1 2:1.5\n"))
+  if err != nil {
+    test.Error(err); return
+  }
+  if rows.Dim != 3 || len(rows.Labels) != 2 || !rows.Labels[0] || rows.Labels[1] {
+    test.Error("test failed")
+  }
+  x := convert_sparse_scores(config, rows.Index[0], rows.Value[0], rows.Dim, nil, nil, true)
+  if x.Dim() != 4 || x.Float64At(1) != 0.5 || x.Float64At(2) != 0.0 || x.Float64At(3) != 2.0 {
+    test.Error("test failed")
+  }
+  // co-occurrence of the first and third column
+  y := convert_sparse_scores(config, rows.Index[0], rows.Value[0], rows.Dim, []int{0, 1, 2}, FeatureIndices{{0, 2}}, false)
+  if y.Dim() != 2 || y.Float64At(1) != 1.0 {
+    test.Error("test failed")
+  }
+  if _, err := read_scores_libsvm(strings.NewReader("1:0.5\n-1 2:1.5\n")); err == nil {
+    test.Error("test failed")
+  }
+  // dense npy array in C order
+  var buffer bytes.Buffer
+  if err := write_npy(&buffer, "<f8", "(2, 2)", []float64{1, 0, 0, 4}); err != nil {
+    test.Error(err); return
+  }
+  if rows, err := read_scores_npy(&buffer); err != nil {
+    test.Error(err)
+  } else {
+    if rows.Dim != 2 || len(rows.Index) != 2 || rows.Index[1][0] != 1 || rows.Value[1][0] != 4.0 {
+      test.Error("test failed")
+    }
+  }
+}